@@ -3,58 +3,249 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Application struct {
 	router *mux.Router
 	db     Database
+	// keys stores the responses to requests with an Idempotency-Key header for keyTTL, which are not stored
+	// when keys is nil.
+	keys   IdempotencyStore
+	keyTTL time.Duration
+	// readTimeout and writeTimeout bound how long requests which read and write may wait on the database, and
+	// are not applied when zero.
+	readTimeout  time.Duration
+	writeTimeout time.Duration
 }
 
 func (a *Application) initRoutes() {
+	a.router.Use(withRequestID)
+	a.router.Use(a.withTimeout)
 	a.router.HandleFunc("/live", a.health).Methods("GET")
 	a.router.HandleFunc("/ready", a.health).Methods("GET")
-	a.router.HandleFunc("/todo", a.createTodoItem).Methods("POST")
+	a.router.HandleFunc("/cache/stats", a.getCacheStats).Methods("GET")
+	a.router.HandleFunc("/todo", a.idempotent(a.createTodoItem)).Methods("POST")
 	a.router.HandleFunc("/todos", a.getAllToDoItems).Methods("GET")
+	a.router.HandleFunc("/todos", a.deleteToDoItems).Methods("DELETE")
+	a.router.HandleFunc("/todos:batchCreate", a.batchCreateToDoItems).Methods("POST")
+	a.router.HandleFunc("/todos:batchUpdate", a.batchUpdateToDoItems).Methods("PATCH")
+	a.router.HandleFunc("/todos/search", a.searchToDoItems).Methods("GET")
 	a.router.HandleFunc("/todo/{id}", a.getToDoItem).Methods("GET")
 	a.router.HandleFunc("/todo/{id}", a.updateToDoItem).Methods("PUT")
+	a.router.HandleFunc("/todo/{id}", a.patchToDoItem).Methods("PATCH")
 	a.router.HandleFunc("/todo/{id}", a.deleteToDoItem).Methods("DELETE")
+	a.router.HandleFunc("/todo/{id}/tags", a.setToDoItemTags).Methods("PUT")
+	a.router.HandleFunc("/todo/{id}/subtasks", a.createSubtask).Methods("POST")
+	a.router.HandleFunc("/todo/{id}/subtasks", a.getSubtasks).Methods("GET")
+	a.router.HandleFunc("/todo/{id}/restore", a.restoreToDoItem).Methods("POST")
+	a.router.HandleFunc("/todo/{id}/history", a.getToDoItemHistory).Methods("GET")
+	a.router.HandleFunc("/audit", a.getAuditEntries).Methods("GET")
+	a.router.HandleFunc("/trash", a.getTrash).Methods("GET")
+	a.router.HandleFunc("/trash/{id}", a.purgeToDoItem).Methods("DELETE")
+	a.router.HandleFunc("/tags", a.getAllTags).Methods("GET")
+	a.router.HandleFunc("/lists", a.createList).Methods("POST")
+	a.router.HandleFunc("/lists", a.getAllLists).Methods("GET")
+	a.router.HandleFunc("/lists/{id}", a.getList).Methods("GET")
+	a.router.HandleFunc("/lists/{id}", a.updateList).Methods("PUT")
+	a.router.HandleFunc("/lists/{id}", a.deleteList).Methods("DELETE")
+	a.router.HandleFunc("/lists/{id}/todos", a.createListToDoItem).Methods("POST")
+	a.router.HandleFunc("/lists/{id}/todos", a.getListToDoItems).Methods("GET")
 }
 
+// getToDoItem returns an item, as it was at the time given by the as_of parameter when there is one.
 func (a *Application) getToDoItem(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	item, err := a.db.getItem(vars["id"])
+	expand := r.URL.Query().Get("expand")
+	if expand != "" && expand != "subtasks" {
+		respondWithError(w, http.StatusBadRequest, "Invalid expand value, expected subtasks")
+		return
+	}
+
+	db := a.db
+	if v := r.URL.Query().Get("as_of"); v != "" {
+		asOf, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid as_of value %q, expected an RFC 3339 timestamp", v))
+			return
+		}
+		traveller, ok := a.db.(TimeTraveller)
+		if !ok {
+			respondWithError(w, http.StatusNotImplemented, "The database does not keep earlier versions of items")
+			return
+		}
+		if db, err = traveller.asOf(r.Context(), asOf); err != nil {
+			respondWithDatabaseError(w, r, err)
+			return
+		}
+	}
+
+	item, err := db.getItem(r.Context(), vars["id"])
 	var e *ErrorItemNotFound
 	if errors.As(err, &e) {
 		respondWithError(w, http.StatusNotFound, "item not found")
 		return
 	} else if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Database error occurred")
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	// Subtasks change without the version of their parent going up, so an item along with its subtasks is given
+	// no ETag and is never answered with 304 Not Modified.
+	if expand == "subtasks" {
+		if err := expandSubtasks(r.Context(), db, &item, 0); err != nil {
+			respondWithDatabaseError(w, r, err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, item)
+		return
+	}
+	if notModified(r, item) {
+		w.Header().Set("ETag", etag(item))
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
-	respondWithJSON(w, http.StatusOK, item)
+	respondWithItem(w, http.StatusOK, item)
 }
 
 func (a *Application) getAllToDoItems(w http.ResponseWriter, r *http.Request) {
-	items, err := a.db.allItems()
+	query, err := parseItemQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, paged, err := parsePageRequest(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if paged {
+		a.respondWithPage(w, r, query, page)
+		return
+	}
+
+	var items []Item
+	if query.isEmpty() {
+		items, err = a.db.allItems(r.Context())
+	} else {
+		items, err = a.db.findItems(r.Context(), query)
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Database error occurred")
+		respondWithDatabaseError(w, r, err)
 		return
 	}
 	respondWithJSON(w, http.StatusOK, items)
 }
 
+// parseItemQuery builds an ItemQuery from the query string of a GET /todos request. Overdue items are those
+// which are not completed and have a due date before now.
+func parseItemQuery(values url.Values, now time.Time) (ItemQuery, error) {
+	var query ItemQuery
+	if v := values.Get("completed"); v != "" {
+		completed, err := strconv.ParseBool(v)
+		if err != nil {
+			return ItemQuery{}, fmt.Errorf("Invalid completed value %q, expected true or false", v)
+		}
+		query.Completed = &completed
+	}
+	if v := values.Get("due_before"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return ItemQuery{}, fmt.Errorf("Invalid due_before value %q, expected an RFC 3339 timestamp", v)
+		}
+		query.DueBefore = &t
+	}
+	if v := values.Get("overdue"); v != "" {
+		overdue, err := strconv.ParseBool(v)
+		if err != nil {
+			return ItemQuery{}, fmt.Errorf("Invalid overdue value %q, expected true or false", v)
+		}
+		if overdue {
+			completed := false
+			query.Completed = &completed
+			if query.DueBefore == nil || now.Before(*query.DueBefore) {
+				query.DueBefore = &now
+			}
+		}
+	}
+	query.AllTags = values["tag"]
+	query.AnyTags = values["any_tag"]
+	if v := values.Get("filter"); v != "" {
+		filter, err := parseFilter(v)
+		if err != nil {
+			return ItemQuery{}, err
+		}
+		query.Filter = filter
+	}
+	if v := values.Get("sort"); v != "" {
+		sort, err := parseSort(v)
+		if err != nil {
+			return ItemQuery{}, err
+		}
+		query.Sort = sort
+	}
+	return query, nil
+}
+
+// parseSort parses a comma separated list of sortable fields, each optionally prefixed with "-" for descending
+// order, e.g. "priority,-due,created".
+func parseSort(v string) ([]SortField, error) {
+	var sort []SortField
+	for _, name := range strings.Split(v, ",") {
+		f := SortField{Field: name}
+		if strings.HasPrefix(name, "-") {
+			f = SortField{Field: name[1:], Descending: true}
+		}
+		if !isSortable(f.Field) {
+			return nil, fmt.Errorf("Invalid sort field %q, expected one of %s", f.Field, strings.Join(sortableFields, ", "))
+		}
+		sort = append(sort, f)
+	}
+	return sort, nil
+}
+
+func isSortable(field string) bool {
+	for _, f := range sortableFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func validateItem(item Item) error {
+	if item.Priority < PriorityNone || item.Priority > PriorityUrgent {
+		return fmt.Errorf("Invalid priority %d, expected a value from %d to %d", item.Priority, PriorityNone, PriorityUrgent)
+	}
+	return nil
+}
+
 func (a *Application) deleteToDoItem(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	err := a.db.deleteItem(vars["id"])
+	version, ok := a.ifMatch(w, r, vars["id"])
+	if !ok {
+		return
+	}
+	err := a.audited(r).deleteItem(r.Context(), vars["id"], version)
 
 	var e *ErrorItemNotFound
+	var ve *ErrorVersionMismatch
 	if errors.As(err, &e) {
 		respondWithError(w, http.StatusNotFound, "item not found")
 		return
+	} else if errors.As(err, &ve) {
+		respondWithError(w, http.StatusPreconditionFailed, "item has been modified")
+		return
 	} else if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Database error occurred")
+		respondWithDatabaseError(w, r, err)
 		return
 	}
 	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
@@ -69,17 +260,137 @@ func (a *Application) updateToDoItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
+	if err := validateItem(td); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The version is server managed, so only If-Match decides which version an update applies to.
+	version, ok := a.ifMatch(w, r, vars["id"])
+	if !ok {
+		return
+	}
+	td.Version = version
+
+	if td.ParentId != "" && !a.checkParent(w, r, vars["id"], td.ParentId) {
+		return
+	}
+
+	db := a.audited(r)
+	updatedItem, err := db.updateItem(r.Context(), vars["id"], td)
+	var e *ErrorItemNotFound
+	var ve *ErrorVersionMismatch
+	var le *ErrorListNotFound
+	if errors.As(err, &e) {
+		respondWithError(w, http.StatusNotFound, "item not found")
+		return
+	} else if errors.As(err, &ve) {
+		respondWithError(w, http.StatusPreconditionFailed, "item has been modified")
+		return
+	} else if errors.As(err, &le) {
+		respondWithError(w, http.StatusBadRequest, "list not found")
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	if err := completeParents(r.Context(), db, updatedItem); err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithItem(w, http.StatusOK, updatedItem)
+}
+
+// patchToDoItem changes only the fields of an item named by a JSON merge patch or JSON patch, depending on the
+// Content-Type of the request.
+func (a *Application) patchToDoItem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != mergePatchType && mediaType != jsonPatchType {
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		respondWithError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Unsupported Content-Type, expected %s or %s", mergePatchType, jsonPatchType))
+		return
+	}
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
 
-	updatedItem, err := a.db.updateItem(vars["id"], td)
+	version, ok := a.ifMatch(w, r, vars["id"])
+	if !ok {
+		return
+	}
+	item, err := a.db.getItem(r.Context(), vars["id"])
 	var e *ErrorItemNotFound
 	if errors.As(err, &e) {
 		respondWithError(w, http.StatusNotFound, "item not found")
 		return
 	} else if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Database error occurred")
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	if version != 0 && item.Version != version {
+		respondWithError(w, http.StatusPreconditionFailed, "item has been modified")
+		return
+	}
+	// The patch is applied to the version which was read, so a write made in the meantime is not overwritten.
+	read := item.Version
+
+	var fields []string
+	if mediaType == mergePatchType {
+		item, fields, err = applyMergePatch(item, patch)
+	} else {
+		item, fields, err = applyJSONPatch(item, patch)
+	}
+	var pe *PatchError
+	if errors.As(err, &pe) {
+		respondWithError(w, http.StatusUnprocessableEntity, pe.Error())
+		return
+	} else if errors.Is(err, errPatchTestFailed) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if err := validateItem(item); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, field := range fields {
+		if field == FieldParentId && item.ParentId != "" && !a.checkParent(w, r, vars["id"], item.ParentId) {
+			return
+		}
+	}
+
+	item.Version = read
+	db := a.audited(r)
+	patchedItem, err := db.patchItem(r.Context(), vars["id"], item, fields)
+	var ve *ErrorVersionMismatch
+	var le *ErrorListNotFound
+	if errors.As(err, &e) {
+		respondWithError(w, http.StatusNotFound, "item not found")
+		return
+	} else if errors.As(err, &ve) && version != 0 {
+		respondWithError(w, http.StatusPreconditionFailed, "item has been modified")
+		return
+	} else if errors.As(err, &ve) {
+		respondWithError(w, http.StatusConflict, "item was modified while it was being patched")
+		return
+	} else if errors.As(err, &le) {
+		respondWithError(w, http.StatusBadRequest, "list not found")
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	if err := completeParents(r.Context(), db, patchedItem); err != nil {
+		respondWithDatabaseError(w, r, err)
 		return
 	}
-	respondWithJSON(w, http.StatusOK, updatedItem)
+	respondWithItem(w, http.StatusOK, patchedItem)
 }
 
 func (a *Application) createTodoItem(w http.ResponseWriter, r *http.Request) {
@@ -90,17 +401,92 @@ func (a *Application) createTodoItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
+	if err := validateItem(td); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if td.ParentId != "" && !a.checkParent(w, r, "", td.ParentId) {
+		return
+	}
+
+	db := a.audited(r)
+	td, err := db.createItem(r.Context(), td)
+	var le *ErrorListNotFound
+	if errors.As(err, &le) {
+		respondWithError(w, http.StatusBadRequest, "list not found")
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	if err := completeParents(r.Context(), db, td); err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithItem(w, http.StatusCreated, td)
+}
+
+func (a *Application) setToDoItemTags(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var tags []string
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&tags); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	item, err := a.audited(r).setItemTags(r.Context(), vars["id"], normaliseTags(tags))
+	var e *ErrorItemNotFound
+	if errors.As(err, &e) {
+		respondWithError(w, http.StatusNotFound, "item not found")
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithItem(w, http.StatusOK, item)
+}
+
+// searchToDoItems finds the items whose description or notes contain every word of the q parameter, most
+// relevant first.
+func (a *Application) searchToDoItems(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	q := values.Get("q")
+	if len(searchTerms(q)) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Missing search query")
+		return
+	}
+	limit := defaultSearchLimit
+	if v := values.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit value %q, expected a number from 1 to %d", v, maxSearchLimit))
+			return
+		}
+	}
+
+	results, err := a.db.searchItems(r.Context(), q, limit)
+	if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, results)
+}
 
-	td, err := a.db.createItem(td)
+func (a *Application) getAllTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := a.db.allTags(r.Context())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Database error occurred")
+		respondWithDatabaseError(w, r, err)
 		return
 	}
-	respondWithJSON(w, http.StatusCreated, td)
+	respondWithJSON(w, http.StatusOK, tags)
 }
 
 func (a *Application) health(w http.ResponseWriter, r *http.Request) {
-	err := a.db.ping()
+	err := a.db.ping(r.Context())
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error occurred.")
 	}
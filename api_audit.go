@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// requestIDHeader carries the ID of a request, which is made up when the client does not send one and is sent
+	// back with the response.
+	requestIDHeader = "X-Request-Id"
+	// actorHeader names who a request is made by, as set by whatever authenticates requests in front of the API.
+	actorHeader = "X-Actor"
+)
+
+// withRequestID gives every request an ID, so that the writes it makes can be told apart in the audit log.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range []string{requestIDHeader, actorHeader} {
+			if len(r.Header.Get(header)) > maxKeyLength {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s is longer than %d characters", header, maxKeyLength))
+				return
+			}
+		}
+		if r.Header.Get(requestIDHeader) == "" {
+			b := make([]byte, 16)
+			rand.Read(b)
+			r.Header.Set(requestIDHeader, hex.EncodeToString(b))
+		}
+		w.Header().Set(requestIDHeader, r.Header.Get(requestIDHeader))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// audited returns the database to make the writes asked for by a request with, which records them in the audit
+// log as made by the actor named in the request.
+func (a *Application) audited(r *http.Request) Database {
+	if log, ok := a.db.(AuditLog); ok {
+		return log.auditedBy(r.Header.Get(actorHeader), r.Header.Get(requestIDHeader))
+	}
+	return a.db
+}
+
+// getToDoItemHistory returns every change made to an item, oldest first. The history of a deleted item is kept.
+func (a *Application) getToDoItemHistory(w http.ResponseWriter, r *http.Request) {
+	log, ok := a.db.(AuditLog)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, errNotAudited.Reason)
+		return
+	}
+	entries, err := log.itemHistory(r.Context(), mux.Vars(r)["id"])
+	var e *ErrorItemNotFound
+	if errors.As(err, &e) {
+		respondWithError(w, http.StatusNotFound, "item not found")
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, entries)
+}
+
+// getAuditEntries returns the audit log a page at a time, in the order it was recorded, starting from the entries
+// recorded at the time given by the since parameter. The Link header points at the next page.
+func (a *Application) getAuditEntries(w http.ResponseWriter, r *http.Request) {
+	log, ok := a.db.(AuditLog)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, errNotAudited.Reason)
+		return
+	}
+	values := r.URL.Query()
+	query := AuditQuery{After: values.Get("after"), Limit: defaultPageLimit}
+	if v := values.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid since value %q, expected an RFC 3339 timestamp", v))
+			return
+		}
+		query.Since = since
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit value %q, expected a number from 1 to %d", v, maxPageLimit))
+			return
+		}
+		query.Limit = limit
+	}
+
+	entries, err := log.auditEntries(r.Context(), query)
+	if errors.Is(err, errInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid after value %q", query.After))
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	if len(entries) == query.Limit {
+		values.Set("after", entries[len(entries)-1].Id)
+		values.Set("limit", strconv.Itoa(query.Limit))
+		u := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.String()))
+	}
+	respondWithJSON(w, http.StatusOK, entries)
+}
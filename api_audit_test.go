@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestApplication_getToDoItemHistory(t *testing.T) {
+	router := mux.NewRouter()
+	db := initMemoryDB()
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("POST", "/todo", strings.NewReader(`{"Description": "Buy milk"}`))
+	assert.NoError(t, err)
+	req.Header.Set("X-Actor", "alice")
+	req.Header.Set("X-Request-Id", "req-1")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "req-1", rr.Header().Get("X-Request-Id"))
+
+	req, err = http.NewRequest("PATCH", "/todo/1", strings.NewReader(`{"Description": "Buy oat milk"}`))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", mergePatchType)
+	req.Header.Set("X-Actor", "bob")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	requestId := rr.Header().Get("X-Request-Id")
+	assert.Len(t, requestId, 32, "a request ID is made up when there is none")
+
+	req, err = http.NewRequest("GET", "/todo/1/history", nil)
+	assert.NoError(t, err)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var entries []AuditEntry
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&entries))
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "alice", entries[0].Actor)
+		assert.Equal(t, "req-1", entries[0].RequestId)
+		assert.Equal(t, AuditUpdate, entries[1].Action)
+		assert.Equal(t, "bob", entries[1].Actor)
+		assert.Equal(t, requestId, entries[1].RequestId)
+		assert.Equal(t, []FieldChange{{Field: FieldDescription, Old: json.RawMessage(`"Buy milk"`), New: json.RawMessage(`"Buy oat milk"`)}}, entries[1].Changes)
+	}
+}
+
+func TestApplication_getToDoItemHistory_not_found(t *testing.T) {
+	router := mux.NewRouter()
+	app := &Application{db: initMemoryDB(), router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/todo/1/history", nil)
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, `{"error":"item not found"}`, rr.Body.String())
+}
+
+func TestApplication_getToDoItemHistory_not_audited(t *testing.T) {
+	router := mux.NewRouter()
+	app := &Application{db: new(MockDatabase), router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/todo/1/history", nil)
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+}
+
+func TestApplication_getAuditEntries(t *testing.T) {
+	router := mux.NewRouter()
+	db := initMemoryDB()
+	for _, description := range []string{"Buy milk", "Buy bread", "Buy eggs"} {
+		db.createItem(ctx, Item{Description: description})
+	}
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/audit?since=2020-01-01T00:00:00Z&limit=2", nil)
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `</audit?after=2&limit=2&since=2020-01-01T00%3A00%3A00Z>; rel="next"`, rr.Header().Get("Link"))
+	var entries []AuditEntry
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&entries))
+	assert.Len(t, entries, 2)
+
+	req, err = http.NewRequest("GET", "/audit?since=2020-01-01T00:00:00Z&after=2&limit=2", nil)
+	assert.NoError(t, err)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "", rr.Header().Get("Link"))
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&entries))
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "3", entries[0].ItemId)
+	}
+}
+
+func TestApplication_getAuditEntries_invalid(t *testing.T) {
+	router := mux.NewRouter()
+	app := &Application{db: initMemoryDB(), router: router}
+	app.initRoutes()
+
+	tests := map[string]string{
+		"/audit?since=yesterday": `{"error":"Invalid since value \"yesterday\", expected an RFC 3339 timestamp"}`,
+		"/audit?limit=0":         `{"error":"Invalid limit value \"0\", expected a number from 1 to 1000"}`,
+		"/audit?after=abc":       `{"error":"Invalid after value \"abc\""}`,
+	}
+	for url, expected := range tests {
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, url)
+		assert.Equal(t, expected, rr.Body.String(), url)
+	}
+}
+
+func TestApplication_withRequestID_too_long(t *testing.T) {
+	router := mux.NewRouter()
+	app := &Application{db: new(MockDatabase), router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("POST", "/todo", strings.NewReader(`{"Description": "Buy milk"}`))
+	assert.NoError(t, err)
+	req.Header.Set("X-Actor", strings.Repeat("a", 256))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, `{"error":"X-Actor is longer than 255 characters"}`, rr.Body.String())
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxBatchSize is the number of items which can be created or updated by a single batch request.
+const maxBatchSize = 1000
+
+// Batch modes. An atomic batch writes every item or none of them, while a best effort batch writes as many items
+// as it can and reports an error for each of the others.
+const (
+	batchAtomic     = "atomic"
+	batchBestEffort = "best_effort"
+)
+
+// batchItemResult is the outcome of one item of a batch request, in the order the items were given.
+type batchItemResult struct {
+	Status int
+	Item   *Item  `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+func errorResult(status int, message string) batchItemResult {
+	return batchItemResult{Status: status, Error: message}
+}
+
+func (a *Application) batchCreateToDoItems(w http.ResponseWriter, r *http.Request) {
+	atomic, ok := batchMode(w, r)
+	if !ok {
+		return
+	}
+	var items []Item
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&items); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	if len(items) > maxBatchSize {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Too many items, expected at most %d", maxBatchSize))
+		return
+	}
+
+	results := make([]batchItemResult, len(items))
+	var valid []Item
+	var indexes []int
+	for i, td := range items {
+		if err := validateItem(td); err != nil {
+			results[i] = errorResult(http.StatusBadRequest, err.Error())
+		} else if td.ParentId != "" {
+			if err := checkParent(r.Context(), a.db, "", td.ParentId); err != nil {
+				results[i] = errorResult(parentErrorStatus(err))
+			}
+		}
+		if results[i].Status != 0 {
+			if atomic {
+				respondWithBatchError(w, i, results[i])
+				return
+			}
+			continue
+		}
+		valid = append(valid, td)
+		indexes = append(indexes, i)
+	}
+
+	db := a.audited(r)
+	written, err := db.createItems(r.Context(), valid, atomic)
+	if !a.batchWritten(w, r, db, written, err, results, indexes, http.StatusCreated, true) {
+		return
+	}
+	code := http.StatusOK
+	if atomic {
+		code = http.StatusCreated
+	}
+	respondWithJSON(w, code, results)
+}
+
+func (a *Application) batchUpdateToDoItems(w http.ResponseWriter, r *http.Request) {
+	atomic, ok := batchMode(w, r)
+	if !ok {
+		return
+	}
+	var patches []map[string]json.RawMessage
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&patches); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	if len(patches) > maxBatchSize {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Too many items, expected at most %d", maxBatchSize))
+		return
+	}
+
+	results := make([]batchItemResult, len(patches))
+	var valid []ItemPatch
+	var indexes []int
+	for i, patch := range patches {
+		p, result := a.batchPatch(r.Context(), patch)
+		if result.Status != 0 {
+			results[i] = result
+			if atomic {
+				respondWithBatchError(w, i, result)
+				return
+			}
+			continue
+		}
+		valid = append(valid, p)
+		indexes = append(indexes, i)
+	}
+
+	db := a.audited(r)
+	written, err := db.patchItems(r.Context(), valid, atomic)
+	if !a.batchWritten(w, r, db, written, err, results, indexes, http.StatusOK, true) {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, results)
+}
+
+// batchPatch turns an element of a batch update, which is a JSON merge patch with the Id of the item to be
+// patched and optionally the Version it is expected to have, into an ItemPatch. A result with a non-zero status
+// is returned when the patch is invalid.
+func (a *Application) batchPatch(ctx context.Context, patch map[string]json.RawMessage) (ItemPatch, batchItemResult) {
+	var p ItemPatch
+	for name, value := range patch {
+		var err error
+		if strings.EqualFold(name, "Id") {
+			err = json.Unmarshal(value, &p.Id)
+		} else if strings.EqualFold(name, "Version") {
+			err = json.Unmarshal(value, &p.Item.Version)
+		} else {
+			continue
+		}
+		if err != nil {
+			return p, errorResult(http.StatusUnprocessableEntity, fmt.Sprintf("Invalid value for %s", name))
+		}
+		delete(patch, name)
+	}
+	if p.Id == "" {
+		return p, errorResult(http.StatusUnprocessableEntity, "Missing Id")
+	}
+
+	rest, _ := json.Marshal(patch)
+	item, fields, err := applyMergePatch(Item{}, rest)
+	var pe *PatchError
+	if errors.As(err, &pe) {
+		return p, errorResult(http.StatusUnprocessableEntity, pe.Error())
+	} else if err != nil {
+		return p, errorResult(http.StatusBadRequest, "Invalid request payload")
+	}
+	if err := validateItem(item); err != nil {
+		return p, errorResult(http.StatusBadRequest, err.Error())
+	}
+	for _, field := range fields {
+		if field == FieldParentId && item.ParentId != "" {
+			if err := checkParent(ctx, a.db, p.Id, item.ParentId); err != nil {
+				return p, errorResult(parentErrorStatus(err))
+			}
+		}
+	}
+	item.Version = p.Item.Version
+	p.Item = item
+	p.Fields = fields
+	return p, batchItemResult{}
+}
+
+// deleteToDoItems deletes every item matched by the query string, which takes the same parameters as GET /todos
+// and must narrow down the items deleted, a sort order alone is not enough.
+func (a *Application) deleteToDoItems(w http.ResponseWriter, r *http.Request) {
+	atomic, ok := batchMode(w, r)
+	if !ok {
+		return
+	}
+	query, err := parseItemQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if query.matchesAll() {
+		respondWithError(w, http.StatusBadRequest, "Missing query, deleting every item is not allowed")
+		return
+	}
+
+	db := a.audited(r)
+	deleted, err := db.deleteItems(r.Context(), query, atomic)
+	results := make([]batchItemResult, len(deleted))
+	if !a.batchWritten(w, r, db, deleted, err, results, nil, http.StatusOK, false) {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, results)
+}
+
+// batchMode returns whether a batch request asks for an atomic batch, which is the default.
+func batchMode(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", batchAtomic:
+		return true, true
+	case batchBestEffort:
+		return false, true
+	default:
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid mode %q, expected %s or %s", mode, batchAtomic, batchBestEffort))
+		return false, false
+	}
+}
+
+// batchWritten fills in the results of the items written by a batch, which are at the given indexes of the
+// request or in order when indexes is nil, and completes the parents of any items which have been completed using
+// db. It responds with an error and returns false when an atomic batch has failed.
+func (a *Application) batchWritten(w http.ResponseWriter, r *http.Request, db Database, written []BatchResult, err error, results []batchItemResult,
+	indexes []int, code int, complete bool) bool {
+	index := func(i int) int {
+		if indexes == nil {
+			return i
+		}
+		return indexes[i]
+	}
+	var be *BatchError
+	if errors.As(err, &be) {
+		status, message := itemErrorStatus(be.Err)
+		respondWithBatchError(w, index(be.Index), errorResult(status, message))
+		return false
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return false
+	}
+
+	for i, res := range written {
+		if res.Err != nil {
+			results[index(i)] = errorResult(itemErrorStatus(res.Err))
+			continue
+		}
+		item := res.Item
+		results[index(i)] = batchItemResult{Status: code, Item: &item}
+		if !complete {
+			continue
+		}
+		if err := completeParents(r.Context(), db, item); err != nil {
+			respondWithDatabaseError(w, r, err)
+			return false
+		}
+	}
+	return true
+}
+
+// itemErrorStatus returns the status code and message to respond with when writing an item fails.
+func itemErrorStatus(err error) (int, string) {
+	var e *ErrorItemNotFound
+	var ve *ErrorVersionMismatch
+	var le *ErrorListNotFound
+	if errors.As(err, &e) {
+		return http.StatusNotFound, "item not found"
+	} else if errors.As(err, &ve) {
+		return http.StatusPreconditionFailed, "item has been modified"
+	} else if errors.As(err, &le) {
+		return http.StatusBadRequest, "list not found"
+	}
+	return databaseErrorStatus(err)
+}
+
+// respondWithBatchError responds to an atomic batch with the error of the item at index i, which stopped every
+// item from being written.
+func respondWithBatchError(w http.ResponseWriter, i int, result batchItemResult) {
+	respondWithError(w, result.Status, fmt.Sprintf("Item %d: %s", i, result.Error))
+}
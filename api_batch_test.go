@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveBatch(t *testing.T, db *MockDatabase, method string, url string, body string) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestApplication_batchCreateToDoItems(t *testing.T) {
+	db := new(MockDatabase)
+	items := []Item{{Description: "A"}, {Description: "B"}}
+	db.On("createItems", mock.Anything, items, true).Return([]BatchResult{{Item: Item{Id: "1", Description: "A"}}, {Item: Item{Id: "2", Description: "B"}}}, nil)
+
+	rr := serveBatch(t, db, "POST", "/todos:batchCreate", `[{"Description": "A"}, {"Description": "B"}]`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	var results []batchItemResult
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&results))
+	assert.Len(t, results, 2)
+	assert.Equal(t, http.StatusCreated, results[1].Status)
+	assert.Equal(t, "2", results[1].Item.Id)
+	db.AssertExpectations(t)
+}
+
+func TestApplication_batchCreateToDoItems_atomic_error(t *testing.T) {
+	db := new(MockDatabase)
+	rr := serveBatch(t, db, "POST", "/todos:batchCreate", `[{"Description": "A"}, {"Description": "B", "Priority": 9}]`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	var e errorMessage
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&e))
+	assert.Equal(t, "Item 1: Invalid priority 9, expected a value from 0 to 4", e.Error)
+	db.AssertNotCalled(t, "createItems")
+
+	db = new(MockDatabase)
+	db.On("createItems", mock.Anything, []Item{{Description: "A"}, {Description: "B", ListId: "9"}}, true).Return(nil, &BatchError{Index: 1, Err: &ErrorListNotFound{Id: "9"}})
+	rr = serveBatch(t, db, "POST", "/todos:batchCreate?mode=atomic", `[{"Description": "A"}, {"Description": "B", "ListId": "9"}]`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&e))
+	assert.Equal(t, "Item 1: list not found", e.Error)
+}
+
+func TestApplication_batchCreateToDoItems_best_effort(t *testing.T) {
+	db := new(MockDatabase)
+	db.On("createItems", mock.Anything, []Item{{Description: "A"}, {Description: "C", ListId: "9"}}, false).Return([]BatchResult{
+		{Item: Item{Id: "1", Description: "A"}},
+		{Err: &ErrorListNotFound{Id: "9"}},
+	}, nil)
+
+	rr := serveBatch(t, db, "POST", "/todos:batchCreate?mode=best_effort",
+		`[{"Description": "A"}, {"Description": "B", "Priority": -1}, {"Description": "C", "ListId": "9"}]`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var results []batchItemResult
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&results))
+	assert.Equal(t, []int{http.StatusCreated, http.StatusBadRequest, http.StatusBadRequest},
+		[]int{results[0].Status, results[1].Status, results[2].Status})
+	assert.Equal(t, "list not found", results[2].Error)
+	db.AssertExpectations(t)
+}
+
+func TestApplication_batchUpdateToDoItems(t *testing.T) {
+	db := new(MockDatabase)
+	patches := []ItemPatch{
+		{Id: "1", Item: Item{Completed: true, Version: 3}, Fields: []string{FieldCompleted}},
+		{Id: "3", Item: Item{Priority: PriorityHigh}, Fields: []string{FieldNotes, FieldPriority}},
+	}
+	db.On("patchItems", mock.Anything, patches, false).Return([]BatchResult{
+		{Err: &ErrorVersionMismatch{Id: "1"}},
+		{Item: Item{Id: "3", Priority: PriorityHigh, Version: 2}},
+	}, nil)
+
+	rr := serveBatch(t, db, "PATCH", "/todos:batchUpdate?mode=best_effort",
+		`[{"Id": "1", "Version": 3, "Completed": true}, {"Size": 2}, {"Id": "3", "Priority": 3, "Notes": null}]`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var results []batchItemResult
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&results))
+	assert.Equal(t, http.StatusPreconditionFailed, results[0].Status)
+	assert.Equal(t, http.StatusUnprocessableEntity, results[1].Status)
+	assert.Equal(t, "Missing Id", results[1].Error)
+	assert.Equal(t, http.StatusOK, results[2].Status)
+	assert.Equal(t, 2, results[2].Item.Version)
+	db.AssertExpectations(t)
+}
+
+func TestApplication_deleteToDoItems(t *testing.T) {
+	db := new(MockDatabase)
+	completed := true
+	db.On("deleteItems", mock.Anything, ItemQuery{Completed: &completed}, true).Return([]BatchResult{{Item: Item{Id: "1", Completed: true}}}, nil)
+
+	rr := serveBatch(t, db, "DELETE", "/todos?completed=true", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var results []batchItemResult
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&results))
+	assert.Len(t, results, 1)
+	assert.Equal(t, "1", results[0].Item.Id)
+	db.AssertExpectations(t)
+}
+
+func TestApplication_deleteToDoItems_errors(t *testing.T) {
+	var deleteTests = []struct {
+		url     string
+		message string
+	}{
+		{"/todos", "Missing query, deleting every item is not allowed"},
+		{"/todos?sort=id", "Missing query, deleting every item is not allowed"},
+		{"/todos?sort=-due&mode=best_effort", "Missing query, deleting every item is not allowed"},
+		{"/todos?completed=maybe", `Invalid completed value "maybe", expected true or false`},
+		{"/todos?completed=true&mode=all", `Invalid mode "all", expected atomic or best_effort`},
+	}
+
+	for _, tt := range deleteTests {
+		t.Run(tt.url, func(t *testing.T) {
+			db := new(MockDatabase)
+			rr := serveBatch(t, db, "DELETE", tt.url, "")
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+			var e errorMessage
+			assert.NoError(t, json.NewDecoder(rr.Body).Decode(&e))
+			assert.Equal(t, tt.message, e.Error)
+			db.AssertNotCalled(t, "deleteItems")
+		})
+	}
+}
+
+func TestApplication_deleteToDoItems_not_supported(t *testing.T) {
+	db := new(MockDatabase)
+	completed := true
+	db.On("deleteItems", mock.Anything, ItemQuery{Completed: &completed}, true).Return(nil, errNoTransactions)
+
+	rr := serveBatch(t, db, "DELETE", "/todos?completed=true", "")
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+	var e errorMessage
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&e))
+	assert.Equal(t, "Atomic batches need Mongo to run as a replica set, use mode=best_effort instead", e.Error)
+	db.AssertExpectations(t)
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"net/http"
+)
+
+// getCacheStats returns how many reads have been answered by the cache since the server started.
+func (a *Application) getCacheStats(w http.ResponseWriter, r *http.Request) {
+	db, ok := a.db.(CachingDatabase)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "The database is not cached")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, db.cacheStats())
+}
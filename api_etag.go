@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag returns the entity tag of an item, which is its version.
+func etag(item Item) string {
+	return strconv.Quote(strconv.Itoa(item.Version))
+}
+
+// parseETags parses the list of entity tags in an If-Match or If-None-Match header, returning the versions they
+// name and whether the list is "*". Weak tags are only accepted for If-None-Match, and malformed tags are ignored
+// because they cannot match any version.
+func parseETags(header string, weak bool) ([]int, bool) {
+	var versions []int
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return nil, true
+		}
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		unquoted, err := strconv.Unquote(tag)
+		if err != nil || !strings.HasPrefix(tag, `"`) {
+			continue
+		}
+		if version, err := strconv.Atoi(unquoted); err == nil && version > 0 {
+			versions = append(versions, version)
+		}
+	}
+	return versions, false
+}
+
+func hasVersion(versions []int, version int) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch returns the version which the item must still have for a request to go ahead, or 0 when the request
+// has no If-Match header. The version is checked by the database as part of the write. When If-Match lists
+// several versions, the current version of the item is used if it is one of them. Otherwise the request fails
+// with 412 Precondition Failed.
+func (a *Application) ifMatch(w http.ResponseWriter, r *http.Request, id string) (int, bool) {
+	header := strings.Join(r.Header.Values("If-Match"), ",")
+	if header == "" {
+		return 0, true
+	}
+	versions, any := parseETags(header, false)
+	if any {
+		return 0, true
+	}
+	if len(versions) == 1 {
+		return versions[0], true
+	}
+	if len(versions) > 1 {
+		item, err := a.db.getItem(r.Context(), id)
+		var e *ErrorItemNotFound
+		if errors.As(err, &e) {
+			respondWithError(w, http.StatusNotFound, "item not found")
+			return 0, false
+		} else if err != nil {
+			respondWithDatabaseError(w, r, err)
+			return 0, false
+		}
+		if hasVersion(versions, item.Version) {
+			return item.Version, true
+		}
+	}
+	respondWithError(w, http.StatusPreconditionFailed, "item has been modified")
+	return 0, false
+}
+
+// notModified reports whether the item matches the If-None-Match header of a request.
+func notModified(r *http.Request, item Item) bool {
+	header := strings.Join(r.Header.Values("If-None-Match"), ",")
+	if header == "" {
+		return false
+	}
+	versions, any := parseETags(header, true)
+	return any || hasVersion(versions, item.Version)
+}
+
+// respondWithItem responds with an item along with its ETag.
+func respondWithItem(w http.ResponseWriter, code int, item Item) {
+	w.Header().Set("ETag", etag(item))
+	respondWithJSON(w, code, item)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_parseETags(t *testing.T) {
+	var etagTests = []struct {
+		header   string
+		weak     bool
+		versions []int
+		any      bool
+	}{
+		{`"3"`, false, []int{3}, false},
+		{`"3", W/"4", "abc", 5, "0"`, false, []int{3}, false},
+		{`"3", W/"4"`, true, []int{3, 4}, false},
+		{`"3", *`, false, nil, true},
+	}
+
+	for _, tt := range etagTests {
+		t.Run(tt.header, func(t *testing.T) {
+			versions, any := parseETags(tt.header, tt.weak)
+			assert.Equal(t, tt.versions, versions)
+			assert.Equal(t, tt.any, any)
+		})
+	}
+}
+
+func TestApplication_getToDoItem_etag(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("getItem", mock.Anything, "1").Return(Item{Id: "1", Description: "ABC", Version: 3}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/todo/1", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
+
+	req.Header.Set("If-None-Match", `"2", W/"3"`)
+	rr = httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
+	assert.Equal(t, 0, rr.Body.Len())
+}
+
+func TestApplication_updateToDoItem_if_match(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	item := Item{Description: "ABC", Completed: true, Version: 7}
+	db.On("updateItem", mock.Anything, "1", Item{Description: "ABC", Completed: true, Version: 2}).Return(Item{}, &ErrorVersionMismatch{Id: "1"})
+	db.On("updateItem", mock.Anything, "1", Item{Description: "ABC", Completed: true, Version: 3}).Return(Item{Id: "1", Description: "ABC", Completed: true, Version: 4}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	for _, tt := range []struct {
+		ifMatch string
+		code    int
+		etag    string
+	}{
+		{`"2"`, http.StatusPreconditionFailed, ""},
+		{`"3"`, http.StatusOK, `"4"`},
+	} {
+		b := new(bytes.Buffer)
+		json.NewEncoder(b).Encode(item)
+		req, err := http.NewRequest("PUT", "/todo/1", b)
+		assert.NoError(t, err)
+		req.Header.Set("If-Match", tt.ifMatch)
+
+		rr := httptest.NewRecorder()
+
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, tt.code, rr.Code)
+		assert.Equal(t, tt.etag, rr.Header().Get("ETag"))
+	}
+}
+
+func TestApplication_patchToDoItem_if_match(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("getItem", mock.Anything, "1").Return(Item{Id: "1", Description: "ABC", Version: 3}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("PATCH", "/todo/1", bytes.NewBufferString(`{"Completed": true}`))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", mergePatchType)
+	req.Header.Set("If-Match", `"2"`)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	db.AssertNotCalled(t, "patchItem", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestApplication_deleteToDoItem_if_match(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("getItem", mock.Anything, "1").Return(Item{Id: "1", Description: "ABC", Version: 3}, nil)
+	db.On("deleteItem", mock.Anything, "1", 3).Return(nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	for _, tt := range []struct {
+		ifMatch string
+		code    int
+	}{
+		{`"1", "2"`, http.StatusPreconditionFailed},
+		{`W/"3"`, http.StatusPreconditionFailed},
+		{`"2", "3"`, http.StatusOK},
+	} {
+		req, err := http.NewRequest("DELETE", "/todo/1", nil)
+		assert.NoError(t, err)
+		req.Header.Set("If-Match", tt.ifMatch)
+
+		rr := httptest.NewRecorder()
+
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, tt.code, rr.Code, tt.ifMatch)
+	}
+	db.AssertNumberOfCalls(t, "deleteItem", 1)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// defaultKeyTTL is how long the response to a request with an idempotency key is kept for by default.
+const defaultKeyTTL = 24 * time.Hour
+
+// maxKeyLength is the longest Idempotency-Key header accepted.
+const maxKeyLength = 255
+
+// idempotent lets clients safely retry a request by sending the same Idempotency-Key header with it. The
+// response to the first request is stored, and replayed for any repeats while the key is kept. Only successful
+// responses are stored, so a request which failed can be tried again.
+func (a *Application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || a.keys == nil {
+			next(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key is longer than %d characters", maxKeyLength))
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		ttl := a.keyTTL
+		if ttl == 0 {
+			ttl = defaultKeyTTL
+		}
+		record, err := a.keys.reserveKey(r.Context(), IdempotencyRecord{
+			Key:         key,
+			Fingerprint: fingerprint(r, body),
+			ExpiresAt:   time.Now().Add(ttl),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Database error occurred")
+			return
+		}
+		if record != nil {
+			replay(w, r, record, body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+		// The response is stored even once the request is done, so that retrying it does not find the key still
+		// in progress.
+		done := context.Background()
+		if rec.status >= 200 && rec.status < 300 {
+			err = a.keys.saveResponse(done, key, rec.status, rec.Header().Get("ETag"), rec.body.Bytes())
+		} else {
+			err = a.keys.releaseKey(done, key)
+		}
+		if err != nil {
+			// The response has already been sent, so the key is left to expire.
+			log.Printf("Unable to store the response for Idempotency-Key %q: %v", key, err)
+		}
+	}
+}
+
+// replay responds to a repeated request with the stored response to the first one.
+func replay(w http.ResponseWriter, r *http.Request, record *IdempotencyRecord, body []byte) {
+	if record.Fingerprint != fingerprint(r, body) {
+		respondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key has already been used for a different request")
+		return
+	}
+	if record.Status == 0 {
+		respondWithError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+		return
+	}
+	if record.ETag != "" {
+		w.Header().Set("ETag", record.ETag)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
+
+// fingerprint identifies a request by its method, path and body.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.Path)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the response written through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApplication_idempotent(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("createItem", mock.Anything, Item{Description: "ABC"}).Return(Item{Id: "1", Description: "ABC", Version: 1}, nil).Once()
+
+	app := &Application{db: db, router: router, keys: newMemoryKeyStore()}
+	app.initRoutes()
+
+	post := func(key string, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/todo", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := post("k1", `{"Description": "ABC"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	repeat := post("k1", `{"Description": "ABC"}`)
+	assert.Equal(t, http.StatusCreated, repeat.Code)
+	assert.Equal(t, "true", repeat.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, `"1"`, repeat.Header().Get("ETag"))
+	assert.Equal(t, first.Body.String(), repeat.Body.String())
+
+	rr := post("k1", `{"Description": "DEF"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	var e errorMessage
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&e))
+	assert.Equal(t, "Idempotency-Key has already been used for a different request", e.Error)
+	db.AssertNumberOfCalls(t, "createItem", 1)
+}
+
+func TestApplication_idempotent_failure(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("createItem", mock.Anything, Item{Description: "ABC"}).Return(Item{}, errors.New("connection lost")).Once()
+	db.On("createItem", mock.Anything, Item{Description: "ABC"}).Return(Item{Id: "1", Description: "ABC", Version: 1}, nil).Once()
+
+	app := &Application{db: db, router: router, keys: newMemoryKeyStore()}
+	app.initRoutes()
+
+	for _, code := range []int{http.StatusInternalServerError, http.StatusCreated} {
+		req, err := http.NewRequest("POST", "/todo", bytes.NewBufferString(`{"Description": "ABC"}`))
+		assert.NoError(t, err)
+		req.Header.Set("Idempotency-Key", "k1")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, code, rr.Code)
+	}
+	db.AssertExpectations(t)
+}
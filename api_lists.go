@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+)
+
+func (a *Application) getList(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	list, err := a.db.getList(r.Context(), vars["id"])
+	var e *ErrorListNotFound
+	if errors.As(err, &e) {
+		respondWithError(w, http.StatusNotFound, "list not found")
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, list)
+}
+
+func (a *Application) getAllLists(w http.ResponseWriter, r *http.Request) {
+	lists, err := a.db.allLists(r.Context())
+	if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, lists)
+}
+
+// deleteList moves the items in the list to the inbox, unless items=cascade is given in which case they are
+// deleted along with the list.
+func (a *Application) deleteList(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var cascade bool
+	switch r.URL.Query().Get("items") {
+	case "", "inbox":
+		cascade = false
+	case "cascade":
+		cascade = true
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid items value, expected inbox or cascade")
+		return
+	}
+
+	err := a.db.deleteList(r.Context(), vars["id"], cascade)
+	var e *ErrorListNotFound
+	if errors.As(err, &e) {
+		respondWithError(w, http.StatusNotFound, "list not found")
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+func (a *Application) updateList(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var l List
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&l); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	if l.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "List name is required")
+		return
+	}
+
+	updatedList, err := a.db.updateList(r.Context(), vars["id"], l)
+	var e *ErrorListNotFound
+	if errors.As(err, &e) {
+		respondWithError(w, http.StatusNotFound, "list not found")
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, updatedList)
+}
+
+func (a *Application) createList(w http.ResponseWriter, r *http.Request) {
+	var l List
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&l); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	if l.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "List name is required")
+		return
+	}
+
+	l, err := a.db.createList(r.Context(), l)
+	if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, l)
+}
+
+// getListToDoItems accepts the same query parameters as GET /todos, scoped to the items in the list.
+func (a *Application) getListToDoItems(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	query, err := parseItemQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, paged, err := parsePageRequest(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := a.db.getList(r.Context(), vars["id"]); err != nil {
+		var e *ErrorListNotFound
+		if errors.As(err, &e) {
+			respondWithError(w, http.StatusNotFound, "list not found")
+		} else {
+			respondWithDatabaseError(w, r, err)
+		}
+		return
+	}
+
+	query.ListId = vars["id"]
+	if paged {
+		a.respondWithPage(w, r, query, page)
+		return
+	}
+	items, err := a.db.findItems(r.Context(), query)
+	if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, items)
+}
+
+func (a *Application) createListToDoItem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var td Item
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&td); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	if err := validateItem(td); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	td.ListId = vars["id"]
+	td, err := a.audited(r).createItem(r.Context(), td)
+	var e *ErrorListNotFound
+	if errors.As(err, &e) {
+		respondWithError(w, http.StatusNotFound, "list not found")
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, td)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApplication_getList(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("getList", mock.Anything, "1").Return(List{Id: "1", Name: "Groceries"}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/lists/1", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	responseList := &List{}
+	err = json.NewDecoder(rr.Body).Decode(responseList)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", responseList.Id)
+	assert.Equal(t, "Groceries", responseList.Name)
+}
+
+func TestApplication_getList_not_found(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("getList", mock.Anything, "1").Return(List{}, &ErrorListNotFound{Id: "1"})
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/lists/1", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	responseItem := &errorMessage{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, "list not found", responseItem.Error)
+}
+
+func TestApplication_getAllLists(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("allLists", mock.Anything, mock.Anything).Return([]List{{Id: "1", Name: "Groceries"}, {Id: "2", Name: "Work"}}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/lists", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var responseLists []List
+	err = json.NewDecoder(rr.Body).Decode(&responseLists)
+	assert.NoError(t, err)
+	assert.Equal(t, []List{{Id: "1", Name: "Groceries"}, {Id: "2", Name: "Work"}}, responseLists)
+}
+
+func TestApplication_createList(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("createList", mock.Anything, List{Name: "Groceries"}).Return(List{Id: "1", Name: "Groceries"}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(List{Name: "Groceries"})
+	req, err := http.NewRequest("POST", "/lists", b)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	responseList := &List{}
+	err = json.NewDecoder(rr.Body).Decode(responseList)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", responseList.Id)
+}
+
+func TestApplication_createList_missing_name(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("POST", "/lists", bytes.NewBufferString(`{}`))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	responseItem := &errorMessage{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, "List name is required", responseItem.Error)
+}
+
+func TestApplication_updateList(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("updateList", mock.Anything, "1", List{Name: "Shopping"}).Return(List{Id: "1", Name: "Shopping"}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("PUT", "/lists/1", bytes.NewBufferString(`{"Name": "Shopping"}`))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	responseList := &List{}
+	err = json.NewDecoder(rr.Body).Decode(responseList)
+	assert.NoError(t, err)
+	assert.Equal(t, "Shopping", responseList.Name)
+}
+
+func TestApplication_deleteList(t *testing.T) {
+	var deleteTests = []struct {
+		url        string
+		cascade    bool
+		statusCode int
+	}{
+		{"/lists/1", false, http.StatusOK},
+		{"/lists/1?items=inbox", false, http.StatusOK},
+		{"/lists/1?items=cascade", true, http.StatusOK},
+		{"/lists/1?items=archive", false, http.StatusBadRequest},
+	}
+
+	for _, tt := range deleteTests {
+		t.Run(tt.url, func(t *testing.T) {
+			router := mux.NewRouter()
+			db := new(MockDatabase)
+			app := &Application{db: db, router: router}
+			app.initRoutes()
+			db.On("deleteList", mock.Anything, "1", tt.cascade).Return(nil)
+			req, _ := http.NewRequest("DELETE", tt.url, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, tt.statusCode, rr.Code)
+		})
+	}
+}
+
+func TestApplication_deleteList_not_found(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("deleteList", mock.Anything, "1", false).Return(&ErrorListNotFound{Id: "1"})
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("DELETE", "/lists/1", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestApplication_getListToDoItems(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	items := []Item{{Id: "1", Description: "Milk", ListId: "2"}}
+	db.On("getList", mock.Anything, "2").Return(List{Id: "2", Name: "Groceries"}, nil)
+	db.On("findItems", mock.Anything, ItemQuery{ListId: "2", Sort: []SortField{{Field: SortByPriority}}}).Return(items, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/lists/2/todos?sort=priority", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var responseItems []Item
+	err = json.NewDecoder(rr.Body).Decode(&responseItems)
+	assert.NoError(t, err)
+	assert.Equal(t, items, responseItems)
+}
+
+func TestApplication_getListToDoItems_not_found(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("getList", mock.Anything, "2").Return(List{}, &ErrorListNotFound{Id: "2"})
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/lists/2/todos", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	db.AssertNotCalled(t, "findItems", mock.Anything, mock.Anything)
+}
+
+func TestApplication_createListToDoItem(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("createItem", mock.Anything, Item{Description: "Milk", ListId: "2"}).Return(Item{Id: "1", Description: "Milk", ListId: "2"}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("POST", "/lists/2/todos", bytes.NewBufferString(`{"Description": "Milk"}`))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	responseItem := &Item{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", responseItem.ListId)
+}
+
+func TestApplication_createListToDoItem_db_error(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("createItem", mock.Anything, Item{Description: "Milk", ListId: "2"}).Return(Item{}, errors.New("db error"))
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("POST", "/lists/2/todos", bytes.NewBufferString(`{"Description": "Milk"}`))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+// parsePageRequest reads the limit, cursor and count query parameters. Items are only paged when a limit or
+// cursor is given, otherwise every matching item is returned at once.
+func parsePageRequest(values url.Values) (PageRequest, bool, error) {
+	page := PageRequest{Limit: defaultPageLimit}
+	paged := false
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return PageRequest{}, false, fmt.Errorf("Invalid limit value %q, expected a number from 1 to %d", v, maxPageLimit)
+		}
+		page.Limit = limit
+		paged = true
+	}
+	if v := values.Get("cursor"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err != nil {
+			return PageRequest{}, false, err
+		}
+		page.Cursor = &cursor
+		paged = true
+	}
+	if v := values.Get("count"); v != "" {
+		total, err := strconv.ParseBool(v)
+		if err != nil {
+			return PageRequest{}, false, fmt.Errorf("Invalid count value %q, expected true or false", v)
+		}
+		page.Total = total
+	}
+	return page, paged, nil
+}
+
+// respondWithPage responds with a page of items, linking to the pages either side of it with a Link header. The
+// total number of matching items is given in an X-Total-Count header when it was asked for.
+func (a *Application) respondWithPage(w http.ResponseWriter, r *http.Request, query ItemQuery, page PageRequest) {
+	result, err := a.db.findItemPage(r.Context(), query, page)
+	if errors.Is(err, errInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+
+	var links []string
+	if result.Next != nil {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(r, *result.Next, page.Limit)))
+	}
+	if result.Prev != nil {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(r, *result.Prev, page.Limit)))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	if page.Total {
+		w.Header().Set("X-Total-Count", strconv.Itoa(result.Total))
+	}
+	respondWithJSON(w, http.StatusOK, result.Items)
+}
+
+// pageURL returns the URL of the request, moved to the page at the cursor.
+func pageURL(r *http.Request, cursor Cursor, limit int) string {
+	values := r.URL.Query()
+	values.Set("cursor", encodeCursor(cursor))
+	values.Set("limit", strconv.Itoa(limit))
+	u := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+	return u.String()
+}
+
+func encodeCursor(cursor Cursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (Cursor, error) {
+	var cursor Cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	if err := json.Unmarshal(b, &cursor); err != nil || len(cursor.Keys) == 0 {
+		return Cursor{}, errInvalidCursor
+	}
+	return cursor, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestApplication_getAllToDoItems_paged(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+
+	two, four := "2", "4"
+	next := Cursor{Keys: []*string{&four}}
+	prev := Cursor{Keys: []*string{&two}, Before: true}
+	items := []Item{{Id: "3", Description: "C"}, {Id: "4", Description: "D"}}
+	db.On("findItemPage", mock.Anything, ItemQuery{}, PageRequest{Limit: 2, Cursor: &Cursor{Keys: []*string{&two}}, Total: true}).
+		Return(ItemPage{Items: items, Next: &next, Prev: &prev, Total: 7}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	cursor := encodeCursor(Cursor{Keys: []*string{&two}})
+	req, err := http.NewRequest("GET", "/todos?limit=2&count=true&cursor="+cursor, nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "7", rr.Header().Get("X-Total-Count"))
+	nextURL := "/todos?count=true&cursor=" + encodeCursor(next) + "&limit=2"
+	prevURL := "/todos?count=true&cursor=" + encodeCursor(prev) + "&limit=2"
+	assert.Equal(t, `<`+nextURL+`>; rel="next", <`+prevURL+`>; rel="prev"`, rr.Header().Get("Link"))
+
+	var responseItems []Item
+	err = json.NewDecoder(rr.Body).Decode(&responseItems)
+	assert.NoError(t, err)
+	assert.Equal(t, items, responseItems)
+}
+
+func TestApplication_getAllToDoItems_paged_invalid(t *testing.T) {
+	var pageTests = []string{
+		"/todos?limit=0",
+		"/todos?limit=1001",
+		"/todos?limit=ten",
+		"/todos?cursor=foo",
+		"/todos?limit=10&count=maybe",
+	}
+
+	for _, tt := range pageTests {
+		t.Run(tt, func(t *testing.T) {
+			router := mux.NewRouter()
+			db := new(MockDatabase)
+			app := &Application{db: db, router: router}
+			app.initRoutes()
+			req, _ := http.NewRequest("GET", tt, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			db.AssertNotCalled(t, "findItemPage", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestApplication_getAllToDoItems_paged_cursor_mismatch(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("findItemPage", mock.Anything, mock.Anything, mock.Anything).Return(ItemPage{}, errInvalidCursor)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	two := "2"
+	req, err := http.NewRequest("GET", "/todos?sort=priority&cursor="+encodeCursor(Cursor{Keys: []*string{&two}}), nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func Test_parsePageRequest(t *testing.T) {
+	page, paged, err := parsePageRequest(url.Values{})
+	assert.NoError(t, err)
+	assert.False(t, paged)
+
+	page, paged, err = parsePageRequest(url.Values{"limit": {"25"}})
+	assert.NoError(t, err)
+	assert.True(t, paged)
+	assert.Equal(t, PageRequest{Limit: 25}, page)
+
+	one := "1"
+	page, paged, err = parsePageRequest(url.Values{"cursor": {encodeCursor(Cursor{Keys: []*string{&one}, Before: true})}})
+	assert.NoError(t, err)
+	assert.True(t, paged)
+	assert.Equal(t, defaultPageLimit, page.Limit)
+	assert.Equal(t, &Cursor{Keys: []*string{&one}, Before: true}, page.Cursor)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// maxSubtaskDepth is the number of levels subtasks can be nested below a top level item.
+const maxSubtaskDepth = 3
+
+var errSubtaskCycle = errors.New("An item cannot be a subtask of itself or of one of its subtasks")
+var errSubtaskDepth = fmt.Errorf("Subtasks cannot be nested more than %d levels deep", maxSubtaskDepth)
+
+func (a *Application) createSubtask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var td Item
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&td); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	if err := validateItem(td); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := checkParent(r.Context(), a.db, "", vars["id"])
+	var e *ErrorItemNotFound
+	if errors.As(err, &e) {
+		respondWithError(w, http.StatusNotFound, "item not found")
+		return
+	} else if errors.Is(err, errSubtaskDepth) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+
+	db := a.audited(r)
+	td, err = db.createSubtask(r.Context(), vars["id"], td)
+	var le *ErrorListNotFound
+	if errors.As(err, &e) {
+		respondWithError(w, http.StatusNotFound, "item not found")
+		return
+	} else if errors.As(err, &le) {
+		respondWithError(w, http.StatusBadRequest, "list not found")
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	if err := completeParents(r.Context(), db, td); err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, td)
+}
+
+func (a *Application) getSubtasks(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	items, err := a.db.subtasks(r.Context(), vars["id"])
+	var e *ErrorItemNotFound
+	if errors.As(err, &e) {
+		respondWithError(w, http.StatusNotFound, "item not found")
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, items)
+}
+
+// checkParent responds with an error and returns false when the item with the given id, or a new item when id
+// is empty, cannot become a subtask of parentId.
+func (a *Application) checkParent(w http.ResponseWriter, r *http.Request, id string, parentId string) bool {
+	if err := checkParent(r.Context(), a.db, id, parentId); err != nil {
+		status, message := parentErrorStatus(err)
+		respondWithError(w, status, message)
+		return false
+	}
+	return true
+}
+
+// parentErrorStatus returns the status code and message to respond with when checkParent fails.
+func parentErrorStatus(err error) (int, string) {
+	var e *ErrorItemNotFound
+	if errors.As(err, &e) {
+		return http.StatusBadRequest, "parent item not found"
+	} else if errors.Is(err, errSubtaskCycle) || errors.Is(err, errSubtaskDepth) {
+		return http.StatusBadRequest, err.Error()
+	}
+	return databaseErrorStatus(err)
+}
+
+// checkParent makes sure that the item with the given id, or a new item when id is empty, can become a subtask
+// of parentId without creating a cycle or nesting subtasks too deeply.
+func checkParent(ctx context.Context, db Database, id string, parentId string) error {
+	ancestors := 0
+	for p := parentId; p != ""; {
+		if p == id {
+			return errSubtaskCycle
+		}
+		ancestors++
+		if ancestors > maxSubtaskDepth {
+			return errSubtaskDepth
+		}
+		parent, err := db.getItem(ctx, p)
+		if err != nil {
+			return err
+		}
+		p = parent.ParentId
+	}
+	if id == "" {
+		return nil
+	}
+
+	height, err := subtaskHeight(ctx, db, id, maxSubtaskDepth-ancestors)
+	if err != nil {
+		return err
+	}
+	if ancestors+height > maxSubtaskDepth {
+		return errSubtaskDepth
+	}
+	return nil
+}
+
+// subtaskHeight returns the number of levels of subtasks below an item, looking no further than limit levels.
+func subtaskHeight(ctx context.Context, db Database, id string, limit int) (int, error) {
+	subtasks, err := db.subtasks(ctx, id)
+	if err != nil || len(subtasks) == 0 {
+		return 0, err
+	}
+	if limit <= 0 {
+		return 1, nil
+	}
+	height := 0
+	for _, s := range subtasks {
+		h, err := subtaskHeight(ctx, db, s.Id, limit-1)
+		if err != nil {
+			return 0, err
+		}
+		if h > height {
+			height = h
+		}
+	}
+	return height + 1, nil
+}
+
+// completeParents completes each ancestor of a completed item that has CompleteWithSubtasks set, once all of
+// its subtasks are complete.
+func completeParents(ctx context.Context, db Database, item Item) error {
+	for depth := 0; item.Completed && item.ParentId != "" && depth < maxSubtaskDepth; depth++ {
+		parent, err := db.getItem(ctx, item.ParentId)
+		if err != nil {
+			return err
+		}
+		if parent.Completed || !parent.CompleteWithSubtasks {
+			return nil
+		}
+		subtasks, err := db.subtasks(ctx, parent.Id)
+		if err != nil {
+			return err
+		}
+		for _, s := range subtasks {
+			if !s.Completed {
+				return nil
+			}
+		}
+		if item, err = db.patchItem(ctx, parent.Id, Item{Completed: true}, []string{FieldCompleted}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandSubtasks fills in the subtasks of an item, and of their subtasks in turn, up to maxSubtaskDepth levels
+// below the item.
+func expandSubtasks(ctx context.Context, db Database, item *Item, depth int) error {
+	if depth >= maxSubtaskDepth {
+		return nil
+	}
+	subtasks, err := db.subtasks(ctx, item.Id)
+	if err != nil {
+		return err
+	}
+	for i := range subtasks {
+		if err := expandSubtasks(ctx, db, &subtasks[i], depth+1); err != nil {
+			return err
+		}
+	}
+	item.Subtasks = subtasks
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApplication_createSubtask(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("getItem", mock.Anything, "1").Return(Item{Id: "1", Description: "Groceries"}, nil)
+	db.On("createSubtask", mock.Anything, "1", Item{Description: "Milk"}).Return(Item{Id: "2", Description: "Milk", ParentId: "1"}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("POST", "/todo/1/subtasks", bytes.NewBufferString(`{"Description": "Milk"}`))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	responseItem := &Item{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", responseItem.Id)
+	assert.Equal(t, "1", responseItem.ParentId)
+}
+
+func TestApplication_createSubtask_not_found(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("getItem", mock.Anything, "1").Return(Item{}, &ErrorItemNotFound{Id: "1"})
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("POST", "/todo/1/subtasks", bytes.NewBufferString(`{"Description": "Milk"}`))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	db.AssertNotCalled(t, "createSubtask", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestApplication_createSubtask_too_deep(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("getItem", mock.Anything, "4").Return(Item{Id: "4", ParentId: "3"}, nil)
+	db.On("getItem", mock.Anything, "3").Return(Item{Id: "3", ParentId: "2"}, nil)
+	db.On("getItem", mock.Anything, "2").Return(Item{Id: "2", ParentId: "1"}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("POST", "/todo/4/subtasks", bytes.NewBufferString(`{"Description": "Milk"}`))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	responseItem := &errorMessage{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, "Subtasks cannot be nested more than 3 levels deep", responseItem.Error)
+	db.AssertNotCalled(t, "createSubtask", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestApplication_getToDoItem_expand_subtasks(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("getItem", mock.Anything, "1").Return(Item{Id: "1", Description: "Groceries"}, nil)
+	db.On("subtasks", mock.Anything, "1").Return([]Item{{Id: "2", ParentId: "1"}, {Id: "3", ParentId: "1"}}, nil)
+	db.On("subtasks", mock.Anything, "2").Return([]Item{{Id: "4", ParentId: "2"}}, nil)
+	db.On("subtasks", mock.Anything, mock.Anything).Return([]Item{}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/todo/1?expand=subtasks", nil)
+	assert.NoError(t, err)
+	req.Header.Set("If-None-Match", `"0"`)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "subtasks may have changed while the item has not")
+	assert.Empty(t, rr.Header().Get("ETag"))
+
+	responseItem := &Item{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(responseItem.Subtasks))
+	assert.Equal(t, "2", responseItem.Subtasks[0].Id)
+	assert.Equal(t, "4", responseItem.Subtasks[0].Subtasks[0].Id)
+	assert.Equal(t, "3", responseItem.Subtasks[1].Id)
+}
+
+func TestApplication_getToDoItem_invalid_expand(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/todo/1?expand=tags", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestApplication_updateToDoItem_cycle(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("getItem", mock.Anything, "2").Return(Item{Id: "2", ParentId: "1"}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("PUT", "/todo/1", bytes.NewBufferString(`{"Description": "Groceries", "ParentId": "2"}`))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	responseItem := &errorMessage{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, "An item cannot be a subtask of itself or of one of its subtasks", responseItem.Error)
+	db.AssertNotCalled(t, "updateItem", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestApplication_updateToDoItem_complete_parent(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	parent := Item{Id: "1", Description: "Groceries", CompleteWithSubtasks: true}
+	completed := Item{Id: "2", Description: "Milk", Completed: true, ParentId: "1"}
+	db.On("getItem", mock.Anything, "1").Return(parent, nil)
+	db.On("subtasks", mock.Anything, "2").Return([]Item{}, nil)
+	db.On("updateItem", mock.Anything, "2", mock.AnythingOfType("Item")).Return(completed, nil)
+	db.On("subtasks", mock.Anything, "1").Return([]Item{completed, {Id: "3", Completed: true, ParentId: "1"}}, nil)
+	db.On("patchItem", mock.Anything, "1", Item{Completed: true}, []string{FieldCompleted}).Return(parent, nil).Once()
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(completed)
+	req, err := http.NewRequest("PUT", "/todo/2", b)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	db.AssertExpectations(t)
+}
+
+func Test_completeParents_incomplete_subtasks(t *testing.T) {
+	db := new(MockDatabase)
+	db.On("getItem", mock.Anything, "1").Return(Item{Id: "1", CompleteWithSubtasks: true}, nil)
+	db.On("subtasks", mock.Anything, "1").Return([]Item{{Id: "2", Completed: true}, {Id: "3", Completed: false}}, nil)
+
+	err := completeParents(ctx, db, Item{Id: "2", Completed: true, ParentId: "1"})
+	assert.NoError(t, err)
+	db.AssertNotCalled(t, "patchItem", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
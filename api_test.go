@@ -9,7 +9,9 @@ import (
 	"github.com/stretchr/testify/mock"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 )
 
 type errorMessage struct {
@@ -27,7 +29,7 @@ func TestApplication_getToDoItem(t *testing.T) {
 
 	db := new(MockDatabase)
 	item := Item{Description: "ABC", Completed: true, Id: "1"}
-	db.On("getItem", "1").Return(item, nil)
+	db.On("getItem", mock.Anything, "1").Return(item, nil)
 
 	app := &Application{db: db, router: router}
 	app.initRoutes()
@@ -53,7 +55,7 @@ func TestApplication_getToDoItem_not_found(t *testing.T) {
 	router := mux.NewRouter()
 
 	db := new(MockDatabase)
-	db.On("getItem", "1").Return(*new(Item), &ErrorItemNotFound{Id: "1"})
+	db.On("getItem", mock.Anything, "1").Return(*new(Item), &ErrorItemNotFound{Id: "1"})
 
 	app := &Application{db: db, router: router}
 	app.initRoutes()
@@ -77,7 +79,7 @@ func TestApplication_getToDoItem_db_error(t *testing.T) {
 	router := mux.NewRouter()
 
 	db := new(MockDatabase)
-	db.On("getItem", "1").Return(*new(Item), errors.New("db error"))
+	db.On("getItem", mock.Anything, "1").Return(*new(Item), errors.New("db error"))
 
 	app := &Application{db: db, router: router}
 	app.initRoutes()
@@ -107,7 +109,7 @@ func TestApplication_getAllToDoItems(t *testing.T) {
 	items[1] = Item{Description: "B", Completed: false, Id: "2"}
 	items[2] = Item{Description: "C", Completed: true, Id: "3"}
 
-	db.On("allItems").Return(items, nil)
+	db.On("allItems", mock.Anything, mock.Anything).Return(items, nil)
 
 	app := &Application{db: db, router: router}
 	app.initRoutes()
@@ -146,7 +148,7 @@ func TestApplication_getAllToDoItems_db_error(t *testing.T) {
 	items[1] = Item{Description: "B", Completed: false, Id: "2"}
 	items[2] = Item{Description: "C", Completed: true, Id: "3"}
 
-	db.On("allItems").Return(items, errors.New("db error"))
+	db.On("allItems", mock.Anything, mock.Anything).Return(items, errors.New("db error"))
 
 	app := &Application{db: db, router: router}
 	app.initRoutes()
@@ -171,7 +173,7 @@ func TestApplication_deleteToDoItem(t *testing.T) {
 
 	db := new(MockDatabase)
 	item := Item{Description: "ABC", Completed: true, Id: "1"}
-	db.On("deleteItem", "1").Return(nil)
+	db.On("deleteItem", mock.Anything, "1", 0).Return(nil)
 
 	app := &Application{db: db, router: router}
 	app.initRoutes()
@@ -197,7 +199,7 @@ func TestApplication_deleteToDoItem_not_found(t *testing.T) {
 	router := mux.NewRouter()
 
 	db := new(MockDatabase)
-	db.On("deleteItem", "1").Return(&ErrorItemNotFound{})
+	db.On("deleteItem", mock.Anything, "1", 0).Return(&ErrorItemNotFound{})
 
 	app := &Application{db: db, router: router}
 	app.initRoutes()
@@ -221,7 +223,7 @@ func TestApplication_deleteToDoItem_db_error(t *testing.T) {
 	router := mux.NewRouter()
 
 	db := new(MockDatabase)
-	db.On("deleteItem", "1").Return(errors.New("db error"))
+	db.On("deleteItem", mock.Anything, "1", 0).Return(errors.New("db error"))
 
 	app := &Application{db: db, router: router}
 	app.initRoutes()
@@ -246,7 +248,7 @@ func TestApplication_updateToDoItem(t *testing.T) {
 
 	db := new(MockDatabase)
 	item := Item{Description: "ABC", Completed: true, Id: "1"}
-	db.On("updateItem", "1", mock.AnythingOfType("Item")).Return(item, nil)
+	db.On("updateItem", mock.Anything, "1", mock.AnythingOfType("Item")).Return(item, nil)
 
 	app := &Application{db: db, router: router}
 	app.initRoutes()
@@ -275,7 +277,7 @@ func TestApplication_updateToDoItem_not_found(t *testing.T) {
 
 	db := new(MockDatabase)
 	item := Item{Description: "ABC", Completed: true, Id: "1"}
-	db.On("updateItem", "1", mock.AnythingOfType("Item")).Return(Item{}, &ErrorItemNotFound{})
+	db.On("updateItem", mock.Anything, "1", mock.AnythingOfType("Item")).Return(Item{}, &ErrorItemNotFound{})
 
 	app := &Application{db: db, router: router}
 	app.initRoutes()
@@ -302,7 +304,7 @@ func TestApplication_updateToDoItem_db_error(t *testing.T) {
 
 	db := new(MockDatabase)
 	item := Item{Description: "ABC", Completed: true, Id: "1"}
-	db.On("updateItem", "1", mock.AnythingOfType("Item")).Return(Item{}, errors.New("db error"))
+	db.On("updateItem", mock.Anything, "1", mock.AnythingOfType("Item")).Return(Item{}, errors.New("db error"))
 
 	app := &Application{db: db, router: router}
 	app.initRoutes()
@@ -355,7 +357,7 @@ func TestApplication_createToDoItem(t *testing.T) {
 	db := new(MockDatabase)
 	requestItem := Item{Description: "ABC", Completed: true}
 	returnItem := Item{Description: "ABC", Completed: true, Id: "1"}
-	db.On("createItem", requestItem).Return(returnItem, nil)
+	db.On("createItem", mock.Anything, requestItem).Return(returnItem, nil)
 
 	app := &Application{db: db, router: router}
 	app.initRoutes()
@@ -409,7 +411,7 @@ func TestApplication_createToDoItem_db_error(t *testing.T) {
 
 	db := new(MockDatabase)
 	item := Item{Description: "ABC", Completed: true, Id: "1"}
-	db.On("createItem", item).Return(Item{}, errors.New("db error"))
+	db.On("createItem", mock.Anything, item).Return(Item{}, errors.New("db error"))
 
 	app := &Application{db: db, router: router}
 	app.initRoutes()
@@ -449,7 +451,7 @@ func TestApplication_health_live(t *testing.T) {
 			db := new(MockDatabase)
 			app := &Application{db: db, router: router}
 			app.initRoutes()
-			db.On("ping").Return(tt.e)
+			db.On("ping", mock.Anything, mock.Anything).Return(tt.e)
 			req, _ := http.NewRequest("GET", tt.url, nil)
 			rr := httptest.NewRecorder()
 			router.ServeHTTP(rr, req)
@@ -457,3 +459,553 @@ func TestApplication_health_live(t *testing.T) {
 		})
 	}
 }
+
+func TestApplication_getAllToDoItems_overdue(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+
+	due := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	items := []Item{{Description: "A", Completed: false, Id: "1", DueAt: &due}}
+
+	db.On("findItems", mock.Anything, mock.MatchedBy(func(q ItemQuery) bool {
+		return q.Completed != nil && !*q.Completed && q.DueBefore != nil
+	})).Return(items, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/todos?overdue=true", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var responseItems []Item
+	err = json.NewDecoder(rr.Body).Decode(&responseItems)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(responseItems))
+	assert.Equal(t, "1", responseItems[0].Id)
+	assert.True(t, due.Equal(*responseItems[0].DueAt))
+}
+
+func TestApplication_getAllToDoItems_invalid_query(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/todos?due_before=tomorrow", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	db.AssertNotCalled(t, "findItems", mock.Anything, mock.Anything)
+}
+
+func Test_parseItemQuery(t *testing.T) {
+	now := time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC)
+	earlier := now.Add(-time.Hour)
+	later := now.Add(time.Hour)
+	completed := false
+
+	var queryTests = []struct {
+		query    string
+		expected ItemQuery
+		err      bool
+	}{
+		{"", ItemQuery{}, false},
+		{"due_before=" + later.Format(time.RFC3339), ItemQuery{DueBefore: &later}, false},
+		{"overdue=true", ItemQuery{Completed: &completed, DueBefore: &now}, false},
+		{"overdue=false", ItemQuery{}, false},
+		{"overdue=true&due_before=" + earlier.Format(time.RFC3339), ItemQuery{Completed: &completed, DueBefore: &earlier}, false},
+		{"overdue=true&due_before=" + later.Format(time.RFC3339), ItemQuery{Completed: &completed, DueBefore: &now}, false},
+		{"due_before=2020-05-10", ItemQuery{}, true},
+		{"overdue=maybe", ItemQuery{}, true},
+		{"filter=" + url.QueryEscape("priority ge 3"), ItemQuery{Filter: FilterComparison{Field: "priority", Op: "ge", Value: 3}}, false},
+		{"filter=" + url.QueryEscape("priority ge"), ItemQuery{}, true},
+	}
+
+	for _, tt := range queryTests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			query, err := parseItemQuery(values, now)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, query)
+		})
+	}
+}
+
+func TestApplication_getAllToDoItems_sorted(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+
+	items := []Item{
+		{Description: "A", Priority: PriorityUrgent, Id: "2"},
+		{Description: "B", Priority: PriorityLow, Id: "1"},
+	}
+
+	expected := ItemQuery{Sort: []SortField{{Field: SortByPriority, Descending: true}, {Field: SortByCreated}}}
+	db.On("findItems", mock.Anything, expected).Return(items, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/todos?sort=-priority,created", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var responseItems []Item
+	err = json.NewDecoder(rr.Body).Decode(&responseItems)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(responseItems))
+	assert.Equal(t, "2", responseItems[0].Id)
+	assert.Equal(t, PriorityUrgent, responseItems[0].Priority)
+}
+
+func Test_parseSort(t *testing.T) {
+	sort, err := parseSort("priority,-due,created")
+	assert.NoError(t, err)
+	assert.Equal(t, []SortField{
+		{Field: SortByPriority},
+		{Field: SortByDue, Descending: true},
+		{Field: SortByCreated},
+	}, sort)
+
+	_, err = parseSort("priority,colour")
+	assert.EqualError(t, err, `Invalid sort field "colour", expected one of id, description, priority, due, start, created, updated, completed_at`)
+
+	_, err = parseSort("")
+	assert.Error(t, err)
+}
+
+func TestApplication_createToDoItem_invalid_priority(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(Item{Description: "ABC", Priority: 7})
+	req, err := http.NewRequest("POST", "/todo", b)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	responseItem := &errorMessage{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, "Invalid priority 7, expected a value from 0 to 4", responseItem.Error)
+	db.AssertNotCalled(t, "createItem", mock.Anything, mock.Anything)
+}
+
+func TestApplication_getAllToDoItems_tags(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+
+	items := []Item{{Description: "A", Id: "1", Tags: []string{"urgent", "work"}}}
+	expected := ItemQuery{AllTags: []string{"work", "urgent"}, AnyTags: []string{"home"}}
+	db.On("findItems", mock.Anything, expected).Return(items, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/todos?tag=work&tag=urgent&any_tag=home", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var responseItems []Item
+	err = json.NewDecoder(rr.Body).Decode(&responseItems)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(responseItems))
+	assert.Equal(t, []string{"urgent", "work"}, responseItems[0].Tags)
+}
+
+func TestApplication_setToDoItemTags(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	item := Item{Description: "ABC", Id: "1", Tags: []string{"home", "work"}}
+	db.On("setItemTags", mock.Anything, "1", []string{"home", "work"}).Return(item, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	b := bytes.NewBufferString(`["work", " home ", "work"]`)
+	req, err := http.NewRequest("PUT", "/todo/1/tags", b)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	responseItem := &Item{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", responseItem.Id)
+	assert.Equal(t, []string{"home", "work"}, responseItem.Tags)
+}
+
+func TestApplication_setToDoItemTags_not_found(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("setItemTags", mock.Anything, "1", []string{"work"}).Return(Item{}, &ErrorItemNotFound{Id: "1"})
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("PUT", "/todo/1/tags", bytes.NewBufferString(`["work"]`))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	responseItem := &errorMessage{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, "item not found", responseItem.Error)
+}
+
+func TestApplication_setToDoItemTags_invalid_json(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("PUT", "/todo/1/tags", bytes.NewBufferString(`{"tags": "work"}`))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestApplication_getAllTags(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("allTags", mock.Anything, mock.Anything).Return([]TagCount{{Name: "home", Count: 1}, {Name: "work", Count: 3}}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/tags", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var tags []TagCount
+	err = json.NewDecoder(rr.Body).Decode(&tags)
+	assert.NoError(t, err)
+	assert.Equal(t, []TagCount{{Name: "home", Count: 1}, {Name: "work", Count: 3}}, tags)
+}
+
+func TestApplication_getAllTags_db_error(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("allTags", mock.Anything, mock.Anything).Return(nil, errors.New("db error"))
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/tags", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestApplication_createToDoItem_list_not_found(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	item := Item{Description: "ABC", ListId: "9"}
+	db.On("createItem", mock.Anything, item).Return(Item{}, &ErrorListNotFound{Id: "9"})
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(item)
+	req, err := http.NewRequest("POST", "/todo", b)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	responseItem := &errorMessage{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, "list not found", responseItem.Error)
+}
+
+func TestApplication_searchToDoItems(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	results := []SearchResult{{Item: Item{Id: "1", Description: "Buy milk", Tags: []string{}}, Score: 2, Snippet: "Buy <mark>milk</mark>"}}
+	db.On("searchItems", mock.Anything, "milk", 5).Return(results, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/todos/search?q=milk&limit=5", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var responseResults []SearchResult
+	err = json.NewDecoder(rr.Body).Decode(&responseResults)
+	assert.NoError(t, err)
+	assert.Equal(t, results, responseResults)
+}
+
+func TestApplication_searchToDoItems_invalid_query(t *testing.T) {
+	for _, query := range []string{"", "q=", "q=%20-", "q=milk&limit=0", "q=milk&limit=101", "q=milk&limit=abc"} {
+		t.Run(query, func(t *testing.T) {
+			router := mux.NewRouter()
+
+			db := new(MockDatabase)
+
+			app := &Application{db: db, router: router}
+			app.initRoutes()
+
+			req, err := http.NewRequest("GET", "/todos/search?"+query, nil)
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			db.AssertNotCalled(t, "searchItems", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestApplication_searchToDoItems_db_error(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("searchItems", mock.Anything, "milk", defaultSearchLimit).Return(nil, errors.New("db error"))
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/todos/search?q=milk", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestApplication_getAllToDoItems_invalid_filter(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/todos?filter="+url.QueryEscape("completed eq false and (priority ge 3 or tag eq ops)"), nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	responseItem := &errorMessage{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, `Invalid filter at position 49: unexpected "ops", expected a string for tag`, responseItem.Error)
+}
+
+func TestApplication_patchToDoItem(t *testing.T) {
+	var patchTests = []struct {
+		contentType string
+		patch       string
+	}{
+		{"application/merge-patch+json", `{"Completed": true, "Notes": null}`},
+		{"application/json-patch+json; charset=utf-8", `[{"op": "replace", "path": "/Completed", "value": true}, {"op": "remove", "path": "/Notes"}]`},
+	}
+
+	for _, tt := range patchTests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			router := mux.NewRouter()
+
+			db := new(MockDatabase)
+			item := Item{Id: "1", Description: "ABC", Notes: "DEF", Priority: PriorityHigh}
+			patched := Item{Id: "1", Description: "ABC", Completed: true, Priority: PriorityHigh}
+			db.On("getItem", mock.Anything, "1").Return(item, nil)
+			db.On("patchItem", mock.Anything, "1", patched, []string{FieldCompleted, FieldNotes}).Return(patched, nil)
+
+			app := &Application{db: db, router: router}
+			app.initRoutes()
+
+			req, err := http.NewRequest("PATCH", "/todo/1", bytes.NewBufferString(tt.patch))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", tt.contentType)
+
+			rr := httptest.NewRecorder()
+
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+
+			responseItem := &Item{}
+			err = json.NewDecoder(rr.Body).Decode(responseItem)
+			assert.NoError(t, err)
+			assert.Equal(t, patched, *responseItem)
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestApplication_patchToDoItem_errors(t *testing.T) {
+	var patchTests = []struct {
+		name        string
+		contentType string
+		patch       string
+		code        int
+		message     string
+	}{
+		{"unsupported_type", "application/json", `{"Completed": true}`, http.StatusUnsupportedMediaType, "Unsupported Content-Type, expected application/merge-patch+json or application/json-patch+json"},
+		{"invalid_json", "application/merge-patch+json", `{"Completed": `, http.StatusBadRequest, "Invalid request payload"},
+		{"unknown_field", "application/merge-patch+json", `{"Size": 3}`, http.StatusUnprocessableEntity, `Unknown field "Size"`},
+		{"missing_path", "application/json-patch+json", `[{"op": "remove", "path": "/Tags/0"}]`, http.StatusUnprocessableEntity, `Operation 0: /Tags/0 does not exist`},
+		{"test_failed", "application/json-patch+json", `[{"op": "test", "path": "/Completed", "value": true}]`, http.StatusConflict, "Patch test operation failed"},
+		{"invalid_priority", "application/merge-patch+json", `{"Priority": 7}`, http.StatusBadRequest, "Invalid priority 7, expected a value from 0 to 4"},
+	}
+
+	for _, tt := range patchTests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := mux.NewRouter()
+
+			db := new(MockDatabase)
+			db.On("getItem", mock.Anything, "1").Return(Item{Id: "1", Description: "ABC"}, nil)
+
+			app := &Application{db: db, router: router}
+			app.initRoutes()
+
+			req, err := http.NewRequest("PATCH", "/todo/1", bytes.NewBufferString(tt.patch))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", tt.contentType)
+
+			rr := httptest.NewRecorder()
+
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.code, rr.Code)
+
+			responseItem := &errorMessage{}
+			err = json.NewDecoder(rr.Body).Decode(responseItem)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.message, responseItem.Error)
+			db.AssertNotCalled(t, "patchItem", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestApplication_patchToDoItem_concurrent(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("getItem", mock.Anything, "1").Return(Item{Id: "1", Description: "ABC", Version: 2}, nil)
+	db.On("patchItem", mock.Anything, "1", Item{Id: "1", Description: "ABC", Completed: true, Version: 2}, []string{FieldCompleted}).
+		Return(Item{}, &ErrorVersionMismatch{Id: "1"})
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("PATCH", "/todo/1", bytes.NewBufferString(`[{"op": "test", "path": "/Completed", "value": false}, {"op": "replace", "path": "/Completed", "value": true}]`))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json-patch+json")
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code, "the item is only written if it is still at the version which was patched")
+	assert.Equal(t, `{"error":"item was modified while it was being patched"}`, rr.Body.String())
+	db.AssertExpectations(t)
+}
+
+func TestApplication_patchToDoItem_not_found(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("getItem", mock.Anything, "1").Return(Item{}, &ErrorItemNotFound{Id: "1"})
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("PATCH", "/todo/1", bytes.NewBufferString(`{"Completed": true}`))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
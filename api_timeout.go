@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Defaults for how long a request may wait on the database, which can be changed with the DB_READ_TIMEOUT and
+// DB_WRITE_TIMEOUT environment variables. Both are shorter than the WriteTimeout of the server, so that a request
+// which runs out of time is still answered.
+const (
+	defaultReadTimeout  = 5 * time.Second
+	defaultWriteTimeout = 10 * time.Second
+)
+
+// withTimeout gives the context of a request a deadline, after which the database gives up on it: readTimeout
+// for GET and HEAD requests, which only read, and writeTimeout for the others. A request is given no deadline when
+// its timeout is zero.
+func (a *Application) withTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := a.writeTimeout
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			timeout = a.readTimeout
+		}
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// databaseErrorStatus returns the status code and message to respond with when the database fails, which tell
+// a request which ran out of time or was cancelled, or which the database does not support, from one the database
+// could not answer.
+func databaseErrorStatus(err error) (int, string) {
+	var ns *ErrorNotSupported
+	if errors.As(err, &ns) {
+		return http.StatusNotImplemented, ns.Reason
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, "The database did not respond in time"
+	} else if errors.Is(err, context.Canceled) {
+		return http.StatusServiceUnavailable, "The request was cancelled"
+	}
+	return http.StatusInternalServerError, "Database error occurred"
+}
+
+// respondWithDatabaseError responds to a request which the database failed. Some drivers fail with errors of
+// their own once a context is done, so the context of the request is checked too.
+func respondWithDatabaseError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		err = r.Context().Err()
+	}
+	status, message := databaseErrorStatus(err)
+	respondWithError(w, status, message)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplication_withTimeout(t *testing.T) {
+	router := mux.NewRouter()
+	db := new(MockDatabase)
+	var deadlines []time.Duration
+	recordDeadline := func(args mock.Arguments) {
+		deadline, ok := args.Get(0).(context.Context).Deadline()
+		assert.True(t, ok)
+		deadlines = append(deadlines, time.Until(deadline))
+	}
+	db.On("getItem", mock.Anything, "1").Run(recordDeadline).Return(Item{Id: "1", Description: "ABC", Version: 1}, nil)
+	db.On("createItem", mock.Anything, Item{Description: "ABC"}).Run(recordDeadline).Return(Item{Id: "1", Description: "ABC", Version: 1}, nil)
+	app := &Application{db: db, router: router, readTimeout: time.Second, writeTimeout: time.Minute}
+	app.initRoutes()
+
+	req, _ := http.NewRequest("GET", "/todo/1", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	req, _ = http.NewRequest("POST", "/todo", strings.NewReader(`{"Description": "ABC"}`))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	assert.Len(t, deadlines, 2)
+	assert.True(t, deadlines[0] <= time.Second, "reads are given the read timeout")
+	assert.True(t, deadlines[1] > time.Second && deadlines[1] <= time.Minute, "writes are given the write timeout")
+}
+
+func TestApplication_withTimeout_deadline_exceeded(t *testing.T) {
+	router := mux.NewRouter()
+	db := new(MockDatabase)
+	db.On("getItem", mock.Anything, "1").Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}).Return(Item{}, errors.New("interrupted"))
+	app := &Application{db: db, router: router, readTimeout: 10 * time.Millisecond}
+	app.initRoutes()
+
+	req, _ := http.NewRequest("GET", "/todo/1", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code, "errors of the driver's own are put down to the deadline")
+	assert.Equal(t, `{"error":"The database did not respond in time"}`, rr.Body.String())
+}
+
+func TestApplication_withTimeout_cancelled(t *testing.T) {
+	router := mux.NewRouter()
+	app := &Application{db: initMemoryDB(), router: router}
+	app.initRoutes()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequest("POST", "/todos:batchCreate?mode=best_effort", strings.NewReader(`[{"Description": "A"}]`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req.WithContext(cancelled))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `[{"Status":503,"Error":"The request was cancelled"}]`, rr.Body.String(), "items are not written once a request is cancelled")
+}
+
+func Test_databaseErrorStatus(t *testing.T) {
+	tests := map[error]int{
+		context.DeadlineExceeded:                     http.StatusGatewayTimeout,
+		&BatchError{Index: 1, Err: context.Canceled}: http.StatusServiceUnavailable,
+		errors.New("connection refused"):             http.StatusInternalServerError,
+		&ErrorNotSupported{Reason: "No"}:             http.StatusNotImplemented,
+	}
+	for err, expected := range tests {
+		status, _ := databaseErrorStatus(err)
+		assert.Equal(t, expected, status, err.Error())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func (a *Application) getTrash(w http.ResponseWriter, r *http.Request) {
+	items, err := a.db.trashedItems(r.Context())
+	if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, items)
+}
+
+// restoreToDoItem moves an item back out of the trash along with the subtasks which were deleted with it.
+func (a *Application) restoreToDoItem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	td, err := a.db.restoreItem(r.Context(), vars["id"])
+	var e *ErrorItemNotFound
+	var te *ErrorItemTrashed
+	if errors.As(err, &e) {
+		respondWithError(w, http.StatusNotFound, "item not found in trash")
+		return
+	} else if errors.As(err, &te) {
+		respondWithError(w, http.StatusConflict, "parent item is in the trash, restore it first")
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	if err := completeParents(r.Context(), a.audited(r), td); err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithItem(w, http.StatusOK, td)
+}
+
+// purgeToDoItem deletes an item in the trash for good, along with its subtasks.
+func (a *Application) purgeToDoItem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	err := a.db.purgeItem(r.Context(), vars["id"])
+	var e *ErrorItemNotFound
+	if errors.As(err, &e) {
+		respondWithError(w, http.StatusNotFound, "item not found in trash")
+		return
+	} else if err != nil {
+		respondWithDatabaseError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
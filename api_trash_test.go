@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestApplication_getTrash(t *testing.T) {
+	router := mux.NewRouter()
+
+	deletedAt := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	db := new(MockDatabase)
+	db.On("trashedItems", mock.Anything, mock.Anything).Return([]Item{{Id: "1", Description: "Groceries", DeletedAt: &deletedAt}}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("GET", "/trash", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var responseItems []Item
+	err = json.NewDecoder(rr.Body).Decode(&responseItems)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, itemIds(responseItems))
+	assert.True(t, deletedAt.Equal(*responseItems[0].DeletedAt))
+}
+
+func TestApplication_restoreToDoItem(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("restoreItem", mock.Anything, "1").Return(Item{Id: "1", Description: "Groceries", Version: 2}, nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("POST", "/todo/1/restore", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+
+	responseItem := &Item{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", responseItem.Id)
+	assert.Nil(t, responseItem.DeletedAt)
+}
+
+func TestApplication_restoreToDoItem_not_found(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("restoreItem", mock.Anything, "1").Return(Item{}, &ErrorItemNotFound{Id: "1"})
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("POST", "/todo/1/restore", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestApplication_restoreToDoItem_parent_trashed(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("restoreItem", mock.Anything, "2").Return(Item{}, &ErrorItemTrashed{Id: "1"})
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("POST", "/todo/2/restore", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	responseItem := &errorMessage{}
+	err = json.NewDecoder(rr.Body).Decode(responseItem)
+	assert.NoError(t, err)
+	assert.Equal(t, "parent item is in the trash, restore it first", responseItem.Error)
+}
+
+func TestApplication_purgeToDoItem(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("purgeItem", mock.Anything, "1").Return(nil)
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("DELETE", "/trash/1", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	db.AssertExpectations(t)
+}
+
+func TestApplication_purgeToDoItem_not_found(t *testing.T) {
+	router := mux.NewRouter()
+
+	db := new(MockDatabase)
+	db.On("purgeItem", mock.Anything, "1").Return(&ErrorItemNotFound{Id: "1"})
+
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	req, err := http.NewRequest("DELETE", "/trash/1", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
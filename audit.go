@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+	// AuditRestore records an item being moved back out of the trash, with the fields which changed as it was:
+	// its list and parent are cleared when they no longer exist.
+	AuditRestore = "restore"
+)
+
+// errNotAudited is returned for the audit log of a database which does not keep one.
+var errNotAudited = &ErrorNotSupported{Reason: "The database does not keep an audit log"}
+
+// auditedFields are the fields of an item whose changes are recorded in the audit log.
+var auditedFields = patchableFields
+
+// FieldChange is the value of a field before and after an item was written, as JSON. Old is null for a newly
+// created item.
+type FieldChange struct {
+	Field string
+	Old   json.RawMessage
+	New   json.RawMessage
+}
+
+// AuditEntry records a write to an item: what it did to which fields, who made it and in which request. Version
+// is the version of the item once written, or the version deleted. Deleting an item records no changes.
+type AuditEntry struct {
+	Id        string
+	ItemId    string
+	Action    string
+	Version   int
+	Actor     string `json:",omitempty"`
+	RequestId string `json:",omitempty"`
+	At        time.Time
+	Changes   []FieldChange `json:",omitempty"`
+}
+
+// AuditQuery asks for the entries recorded at or after Since, following the entry with the ID After when it is
+// given, in the order they were recorded. At most Limit entries are returned, unless it is zero.
+type AuditQuery struct {
+	Since time.Time
+	After string
+	Limit int
+}
+
+// AuditLog is implemented by the databases which keep an append-only log of every item they create, update,
+// delete or restore, written in the same transaction as the item itself. An item deleted along with its list is
+// recorded as deleted too, while the subtasks deleted or restored along with an item are not recorded apart from it.
+type AuditLog interface {
+	// auditedBy returns a copy of the database which records the writes it makes as made by actor, in the request
+	// with the given ID.
+	auditedBy(actor, requestId string) Database
+	// itemHistory returns the entries for an item, oldest first, or ErrorItemNotFound when there are none. The
+	// entries are kept after the item has been deleted.
+	itemHistory(ctx context.Context, id string) ([]AuditEntry, error)
+	auditEntries(ctx context.Context, query AuditQuery) ([]AuditEntry, error)
+}
+
+// auditor is embedded by the databases which keep an audit log, to make the entries for the writes they make.
+type auditor struct {
+	actor     string
+	requestId string
+}
+
+// entry records an action on an item at the given time. A created item is only given as after, and a deleted
+// one only as before.
+func (a auditor) entry(action string, before, after Item, at time.Time) AuditEntry {
+	entry := AuditEntry{
+		ItemId:    after.Id,
+		Action:    action,
+		Version:   after.Version,
+		Actor:     a.actor,
+		RequestId: a.requestId,
+		At:        at.UTC(),
+	}
+	if action == AuditDelete {
+		entry.ItemId = before.Id
+		entry.Version = before.Version
+		return entry
+	}
+	entry.Changes = diffItems(before, after)
+	return entry
+}
+
+// diffItems returns the audited fields whose values differ between two versions of an item.
+func diffItems(before, after Item) []FieldChange {
+	var changes []FieldChange
+	for _, field := range auditedFields {
+		oldValue, _ := json.Marshal(auditValue(before, field))
+		newValue, _ := json.Marshal(auditValue(after, field))
+		if bytes.Equal(oldValue, newValue) {
+			continue
+		}
+		if before.Id == "" {
+			oldValue = json.RawMessage("null")
+		}
+		changes = append(changes, FieldChange{Field: field, Old: oldValue, New: newValue})
+	}
+	return changes
+}
+
+// auditValue returns the value of a field as it is recorded, with tags and times normalised so that the same
+// value read back from different backends is recorded the same way.
+func auditValue(item Item, field string) interface{} {
+	switch field {
+	case FieldDescription:
+		return item.Description
+	case FieldNotes:
+		return item.Notes
+	case FieldCompleted:
+		return item.Completed
+	case FieldPriority:
+		return item.Priority
+	case FieldDueAt:
+		return utc(item.DueAt)
+	case FieldStartAt:
+		return utc(item.StartAt)
+	case FieldListId:
+		return item.ListId
+	case FieldTags:
+		return normaliseTags(item.Tags)
+	case FieldParentId:
+		return item.ParentId
+	case FieldPosition:
+		return item.Position
+	case FieldCompleteWithSubtasks:
+		return item.CompleteWithSubtasks
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ItemPatch replaces the named Fields of the item with the given Id by those of Item, as patchItem does.
+type ItemPatch struct {
+	Id     string
+	Item   Item
+	Fields []string
+}
+
+// BatchResult is the outcome of one item in a batch: the item as written, or the error which stopped it from
+// being written.
+type BatchResult struct {
+	Item Item
+	Err  error
+}
+
+// BatchError is returned by an atomic batch when the item at Index fails, after which nothing is written.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("Item %d: %s", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// runBatch calls fn for each of n items. An atomic batch runs inside a single transaction, which is rolled back
+// as soon as an item fails. Otherwise every item is tried in turn and its error is reported in its result, and
+// once ctx is done the items which have not been tried yet fail with its error.
+func runBatch(ctx context.Context, db Database, n int, atomic bool, atomically func(ctx context.Context, fn func(db Database) error) error,
+	fn func(db Database, i int) (Item, error)) ([]BatchResult, error) {
+	results := make([]BatchResult, n)
+	if !atomic {
+		for i := range results {
+			if results[i].Err = ctx.Err(); results[i].Err == nil {
+				results[i].Item, results[i].Err = fn(db, i)
+			}
+		}
+		return results, nil
+	}
+	err := atomically(ctx, func(tx Database) error {
+		for i := range results {
+			item, err := fn(tx, i)
+			if err != nil {
+				return &BatchError{Index: i, Err: err}
+			}
+			results[i].Item = item
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func createItems(ctx context.Context, db Database, items []Item, atomic bool, atomically func(ctx context.Context, fn func(db Database) error) error) ([]BatchResult, error) {
+	return runBatch(ctx, db, len(items), atomic, atomically, func(db Database, i int) (Item, error) {
+		return db.createItem(ctx, items[i])
+	})
+}
+
+func patchItems(ctx context.Context, db Database, patches []ItemPatch, atomic bool, atomically func(ctx context.Context, fn func(db Database) error) error) ([]BatchResult, error) {
+	return runBatch(ctx, db, len(patches), atomic, atomically, func(db Database, i int) (Item, error) {
+		return db.patchItem(ctx, patches[i].Id, patches[i].Item, patches[i].Fields)
+	})
+}
+
+// deleteItems deletes every item matched by query, along with its subtasks. An item which has already gone as
+// the subtask of another matching item counts as deleted.
+func deleteItems(ctx context.Context, db Database, query ItemQuery, atomic bool, atomically func(ctx context.Context, fn func(db Database) error) error) ([]BatchResult, error) {
+	deleteAll := func(db Database, atomically func(ctx context.Context, fn func(db Database) error) error) ([]BatchResult, error) {
+		items, err := db.findItems(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		return runBatch(ctx, db, len(items), atomic, atomically, func(db Database, i int) (Item, error) {
+			err := db.deleteItem(ctx, items[i].Id, 0)
+			var e *ErrorItemNotFound
+			if errors.As(err, &e) {
+				err = nil
+			}
+			return items[i], err
+		})
+	}
+	if !atomic {
+		return deleteAll(db, atomically)
+	}
+	// The items are looked up in the same transaction as they are deleted in, so that none are missed.
+	var results []BatchResult
+	err := atomically(ctx, func(tx Database) error {
+		var err error
+		results, err = deleteAll(tx, func(ctx context.Context, fn func(db Database) error) error {
+			return fn(tx)
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
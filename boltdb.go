@@ -0,0 +1,993 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Buckets of a bolt database. Items and lists are kept as JSON under their IDs, which are 8 byte big-endian
+// numbers so that they sort in order. The index buckets hold empty values under keys made of the indexed value
+// followed by the ID of the item. Items in the trash are kept apart from the others, and are not indexed. Audit
+// entries are kept under their IDs too, and indexed by the ID of their item followed by their own. Items which
+// were given generated IDs are kept under the next number all the same, which item_ids holds under their IDs.
+var (
+	boltItems       = []byte("items")
+	boltLists       = []byte("lists")
+	boltByCompleted = []byte("items_by_completed")
+	boltByDue       = []byte("items_by_due")
+	boltByParent    = []byte("items_by_parent")
+	boltKeys        = []byte("idempotency_keys")
+	boltTrash       = []byte("trash")
+	boltAudit       = []byte("audit")
+	boltAuditByItem = []byte("audit_by_item")
+	boltItemIDs     = []byte("item_ids")
+)
+
+// boltdb keeps everything in a single file with bbolt, which is written in pure Go so needs no C compiler. IDs
+// are given out the way gormdb does unless newID is set.
+type boltdb struct {
+	auditor
+	path  string
+	newID idGenerator
+	db    *bolt.DB
+	// tx is set on copies made by atomically, whose every method uses the same transaction.
+	tx *bolt.Tx
+}
+
+func (s *boltdb) init() {
+	db, err := bolt.Open(s.path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		fmt.Println(err)
+		panic(fmt.Sprintf("failed to open bolt Database %s", s.path))
+	}
+	s.db = db
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltItems, boltLists, boltByCompleted, boltByDue, boltByParent, boltKeys, boltTrash, boltAudit, boltAuditByItem, boltItemIDs} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		for _, name := range [][]byte{boltItems, boltTrash} {
+			if err := boltSetUpdatedAt(tx.Bucket(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to create buckets in bolt Database %s: %v", s.path, err))
+	}
+}
+
+func (s *boltdb) ping(ctx context.Context) error {
+	return s.view(ctx, func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+// update and view run fn in a read-write or read-only transaction, or in the current one when there is one
+// already. Bolt cannot give up on a transaction part of the way through, so ctx is checked before it starts, once
+// the lock held by other writers has been taken, and before a write is committed, which is rolled back when ctx
+// is done by then.
+func (s *boltdb) update(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return ctx.Err()
+	})
+}
+
+func (s *boltdb) view(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.View(fn)
+}
+
+func (s *boltdb) createItem(ctx context.Context, item Item) (Item, error) {
+	var created memoryItem
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		listID, err := boltListID(tx, item.ListId)
+		if err != nil {
+			return err
+		}
+		parentID, err := boltParentID(tx, item.ParentId)
+		if err != nil {
+			return err
+		}
+		key, err := tx.Bucket(boltItems).NextSequence()
+		if err != nil {
+			return err
+		}
+		id := strconv.FormatUint(key, 10)
+		if s.newID != nil {
+			id = s.newID()
+			if err := tx.Bucket(boltItemIDs).Put([]byte(id), boltKey(key)); err != nil {
+				return err
+			}
+		}
+		created = newMemoryItem(id, item, listID, parentID)
+		if err := boltPutItem(tx, created, nil); err != nil {
+			return err
+		}
+		return boltPutAuditEntry(tx, s.entry(AuditCreate, Item{}, created.Item, created.CreatedAt))
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return created.copy(), nil
+}
+
+func (s *boltdb) updateItem(ctx context.Context, id string, td Item) (Item, error) {
+	return s.patchItem(ctx, id, td, updatableFields)
+}
+
+// patchItem only writes the fields of td named in the field mask, leaving the others as they are.
+func (s *boltdb) patchItem(ctx context.Context, id string, td Item, fields []string) (Item, error) {
+	var patched memoryItem
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		old, err := boltGetItem(tx, id)
+		if err != nil {
+			return err
+		}
+		patched = old
+		listID := func(id string) (string, error) {
+			return boltListID(tx, id)
+		}
+		parentID := func(id string) (string, error) {
+			return boltParentID(tx, id)
+		}
+		if err := patchMemoryItem(&patched, td, fields, listID, parentID); err != nil {
+			return err
+		}
+		if err := boltPutItem(tx, patched, &old); err != nil {
+			return err
+		}
+		return boltPutAuditEntry(tx, s.entry(AuditUpdate, old.Item, patched.Item, patched.UpdatedAt))
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return patched.copy(), nil
+}
+
+// deleteItem moves an item to the trash along with its subtasks.
+func (s *boltdb) deleteItem(ctx context.Context, id string, version int) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		mi, err := boltGetItem(tx, id)
+		if err != nil {
+			return err
+		}
+		if version != 0 && mi.Version != version {
+			return &ErrorVersionMismatch{Id: id}
+		}
+		deletedAt := time.Now().UTC()
+		if err := boltTrashItem(tx, mi, deletedAt); err != nil {
+			return err
+		}
+		return boltPutAuditEntry(tx, s.entry(AuditDelete, mi.Item, Item{}, deletedAt))
+	})
+}
+
+func (s *boltdb) getItem(ctx context.Context, id string) (Item, error) {
+	var mi memoryItem
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		mi, err = boltGetItem(tx, id)
+		return err
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return mi.copy(), nil
+}
+
+func (s *boltdb) allItems(ctx context.Context) ([]Item, error) {
+	var items []memoryItem
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		items, err = boltAllItems(tx)
+		return err
+	})
+	if err != nil {
+		return make([]Item, 0), err
+	}
+	return toMemoryItems(items), nil
+}
+
+func (s *boltdb) findItems(ctx context.Context, query ItemQuery) ([]Item, error) {
+	matches, err := s.find(ctx, query)
+	if err != nil {
+		return make([]Item, 0), err
+	}
+	return toMemoryItems(matches), nil
+}
+
+func (s *boltdb) findItemPage(ctx context.Context, query ItemQuery, page PageRequest) (ItemPage, error) {
+	matches, err := s.find(ctx, query)
+	if err != nil {
+		return ItemPage{Items: make([]Item, 0)}, err
+	}
+	return pageMemoryItems(matches, query.Sort, page)
+}
+
+// find returns the items matched by the query, in the order it asks for. Only the items in the index for
+// Completed or DueBefore are looked at when the query has either of them.
+func (s *boltdb) find(ctx context.Context, query ItemQuery) ([]memoryItem, error) {
+	var matches []memoryItem
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		var candidates []memoryItem
+		var err error
+		switch {
+		case query.Completed != nil:
+			candidates, err = boltIndexedItems(tx, boltByCompleted, boltBool(*query.Completed), nil)
+		case query.DueBefore != nil:
+			candidates, err = boltIndexedItems(tx, boltByDue, nil, boltTime(query.DueBefore.UTC()))
+		default:
+			candidates, err = boltAllItems(tx)
+		}
+		if err != nil {
+			return err
+		}
+		matches, err = findMemoryItems(candidates, query)
+		return err
+	})
+	return matches, err
+}
+
+func (s *boltdb) searchItems(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	var items []memoryItem
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		items, err = boltAllItems(tx)
+		return err
+	})
+	if err != nil {
+		return make([]SearchResult, 0), err
+	}
+	return searchMemoryItems(items, q, limit), nil
+}
+
+func (s *boltdb) setItemTags(ctx context.Context, id string, tags []string) (Item, error) {
+	var tagged memoryItem
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		old, err := boltGetItem(tx, id)
+		if err != nil {
+			return err
+		}
+		tagged = old
+		tagged.Tags = normaliseTags(tags)
+		tagged.Version++
+		tagged.UpdatedAt = time.Now().UTC()
+		if err := boltPutItem(tx, tagged, &old); err != nil {
+			return err
+		}
+		return boltPutAuditEntry(tx, s.entry(AuditUpdate, old.Item, tagged.Item, tagged.UpdatedAt))
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return tagged.copy(), nil
+}
+
+func (s *boltdb) allTags(ctx context.Context) ([]TagCount, error) {
+	counts := make(map[string]int)
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		items, err := boltAllItems(tx)
+		for _, mi := range items {
+			for _, tag := range mi.Tags {
+				counts[tag]++
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return make([]TagCount, 0), err
+	}
+	tags := make([]TagCount, 0, len(counts))
+	for name, count := range counts {
+		tags = append(tags, TagCount{Name: name, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
+
+func (s *boltdb) createList(ctx context.Context, list List) (List, error) {
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		lists := tx.Bucket(boltLists)
+		id, err := lists.NextSequence()
+		if err != nil {
+			return err
+		}
+		list = List{Id: strconv.FormatUint(id, 10), Name: list.Name}
+		return boltPut(lists, boltKey(id), list)
+	})
+	if err != nil {
+		return List{}, err
+	}
+	return list, nil
+}
+
+func (s *boltdb) updateList(ctx context.Context, id string, list List) (List, error) {
+	var updated List
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		var err error
+		if updated, err = boltGetList(tx, id); err != nil {
+			return err
+		}
+		updated.Name = list.Name
+		return boltPut(tx.Bucket(boltLists), boltKey(boltID(updated.Id)), updated)
+	})
+	if err != nil {
+		return List{}, err
+	}
+	return updated, nil
+}
+
+// deleteList deletes a list along with its items when cascade is set, otherwise its items are moved to the inbox.
+func (s *boltdb) deleteList(ctx context.Context, id string, cascade bool) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		list, err := boltGetList(tx, id)
+		if err != nil {
+			return err
+		}
+		items, err := boltAllItems(tx)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		for _, mi := range items {
+			if mi.ListId != list.Id {
+				continue
+			}
+			if cascade {
+				// Subtasks in the same list may have been moved to the trash along with their parent already.
+				if tx.Bucket(boltItems).Get(boltItemKey(tx, mi.Id)) != nil {
+					err = boltTrashItem(tx, mi, now)
+				}
+				if err == nil {
+					err = boltPutAuditEntry(tx, s.entry(AuditDelete, mi.Item, Item{}, now))
+				}
+			} else {
+				moved := mi
+				moved.ListId = ""
+				moved.Version++
+				moved.UpdatedAt = now
+				err = boltPutItem(tx, moved, &mi)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(boltLists).Delete(boltKey(boltID(list.Id)))
+	})
+}
+
+func (s *boltdb) getList(ctx context.Context, id string) (List, error) {
+	var list List
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		list, err = boltGetList(tx, id)
+		return err
+	})
+	if err != nil {
+		return List{}, err
+	}
+	return list, nil
+}
+
+func (s *boltdb) allLists(ctx context.Context) ([]List, error) {
+	lists := make([]List, 0)
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(boltLists).ForEach(func(k, v []byte) error {
+			var list List
+			if err := json.Unmarshal(v, &list); err != nil {
+				return err
+			}
+			lists = append(lists, list)
+			return nil
+		})
+	})
+	if err != nil {
+		return make([]List, 0), err
+	}
+	return lists, nil
+}
+
+// createSubtask adds an item to the end of the subtasks of its parent.
+func (s *boltdb) createSubtask(ctx context.Context, parentId string, item Item) (Item, error) {
+	var created Item
+	err := s.atomically(ctx, func(db Database) error {
+		t := db.(*boltdb)
+		parentID, err := boltParentID(t.tx, parentId)
+		if err != nil {
+			return err
+		}
+		subtasks, err := boltSubtasks(t.tx, parentID)
+		if err != nil {
+			return err
+		}
+		item.ParentId = parentID
+		item.Position = 0
+		for i, mi := range subtasks {
+			if i == 0 || mi.Position >= item.Position {
+				item.Position = mi.Position + 1
+			}
+		}
+		created, err = t.createItem(ctx, item)
+		return err
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return created, nil
+}
+
+func (s *boltdb) subtasks(ctx context.Context, parentId string) ([]Item, error) {
+	var subtasks []memoryItem
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		parentID, err := boltParentID(tx, parentId)
+		if err != nil || parentID == "" {
+			return err
+		}
+		subtasks, err = boltSubtasks(tx, parentID)
+		return err
+	})
+	if err != nil {
+		return make([]Item, 0), err
+	}
+	sort.SliceStable(subtasks, func(i, j int) bool {
+		return subtasks[i].Position < subtasks[j].Position
+	})
+	return toMemoryItems(subtasks), nil
+}
+
+// trashedItems returns the items in the trash, most recently deleted first.
+func (s *boltdb) trashedItems(ctx context.Context) ([]Item, error) {
+	var items []memoryItem
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		items, err = boltTrashedItems(tx)
+		return err
+	})
+	if err != nil {
+		return make([]Item, 0), err
+	}
+	return toMemoryItems(items), nil
+}
+
+// restoreItem moves an item back out of the trash along with the subtasks which were deleted with it.
+func (s *boltdb) restoreItem(ctx context.Context, id string) (Item, error) {
+	var restored []memoryItem
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		mi, err := boltGetTrashedItem(tx, id)
+		if err != nil {
+			return err
+		}
+		trash, err := boltTrashedItems(tx)
+		if err != nil {
+			return err
+		}
+		itemExists := func(id string) bool {
+			_, err := boltGetItem(tx, id)
+			return err == nil
+		}
+		listExists := func(id string) bool {
+			return tx.Bucket(boltLists).Get(boltKey(boltID(id))) != nil
+		}
+		if restored, err = restorableItems(mi, trash, itemExists, listExists); err != nil {
+			return err
+		}
+		for _, mi := range restored {
+			if err := tx.Bucket(boltTrash).Delete(boltItemKey(tx, mi.Id)); err != nil {
+				return err
+			}
+			if err := boltPutItem(tx, mi, nil); err != nil {
+				return err
+			}
+		}
+		return boltPutAuditEntry(tx, s.entry(AuditRestore, mi.Item, restored[0].Item, restored[0].UpdatedAt))
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return restored[0].copy(), nil
+}
+
+// purgeItem deletes an item in the trash for good, along with its subtasks.
+func (s *boltdb) purgeItem(ctx context.Context, id string) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		mi, err := boltGetTrashedItem(tx, id)
+		if err != nil {
+			return err
+		}
+		trash, err := boltTrashedItems(tx)
+		if err != nil {
+			return err
+		}
+		for _, purged := range trashedSubtasks(mi, trash, false) {
+			if err := tx.Bucket(boltTrash).Delete(boltItemKey(tx, purged.Id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// purgeTrash deletes the items which were moved to the trash before the given time for good.
+func (s *boltdb) purgeTrash(ctx context.Context, before time.Time) (int, error) {
+	purged := 0
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		trash, err := boltTrashedItems(tx)
+		if err != nil {
+			return err
+		}
+		for _, mi := range trash {
+			if !mi.DeletedAt.Before(before) {
+				continue
+			}
+			if err := tx.Bucket(boltTrash).Delete(boltItemKey(tx, mi.Id)); err != nil {
+				return err
+			}
+			purged++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// auditedBy returns a copy of the database which records the writes it makes as made by actor.
+func (s *boltdb) auditedBy(actor, requestId string) Database {
+	t := *s
+	t.auditor = auditor{actor: actor, requestId: requestId}
+	return &t
+}
+
+func (s *boltdb) itemHistory(ctx context.Context, id string) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0)
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		prefix := boltItemKey(tx, id)
+		if prefix == nil {
+			return nil
+		}
+		byID := tx.Bucket(boltAudit)
+		c := tx.Bucket(boltAuditByItem).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			var entry AuditEntry
+			if err := json.Unmarshal(byID.Get(k[len(prefix):]), &entry); err != nil {
+				return err
+			}
+			// The entries of an item with a generated ID are not found by its key.
+			if entry.ItemId == id {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, &ErrorItemNotFound{Id: id}
+	}
+	return entries, nil
+}
+
+func (s *boltdb) auditEntries(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	var after uint64
+	if query.After != "" {
+		var err error
+		if after, err = strconv.ParseUint(query.After, 10, 64); err != nil {
+			return nil, errInvalidCursor
+		}
+	}
+	entries := make([]AuditEntry, 0)
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltAudit).Cursor()
+		for k, v := c.Seek(boltKey(after + 1)); k != nil; k, v = c.Next() {
+			if query.Limit > 0 && len(entries) == query.Limit {
+				break
+			}
+			var entry AuditEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if !entry.At.Before(query.Since) {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// atomically runs fn with a copy of the database whose every method uses the same transaction, which is rolled
+// back if fn fails.
+func (s *boltdb) atomically(ctx context.Context, fn func(db Database) error) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		t := *s
+		t.tx = tx
+		return fn(&t)
+	})
+}
+
+func (s *boltdb) createItems(ctx context.Context, items []Item, atomic bool) ([]BatchResult, error) {
+	return createItems(ctx, s, items, atomic, s.atomically)
+}
+
+func (s *boltdb) patchItems(ctx context.Context, patches []ItemPatch, atomic bool) ([]BatchResult, error) {
+	return patchItems(ctx, s, patches, atomic, s.atomically)
+}
+
+func (s *boltdb) deleteItems(ctx context.Context, query ItemQuery, atomic bool) ([]BatchResult, error) {
+	return deleteItems(ctx, s, query, atomic, s.atomically)
+}
+
+func (s *boltdb) reserveKey(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error) {
+	var existing *IdempotencyRecord
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		keys := tx.Bucket(boltKeys)
+		now := time.Now()
+		c := keys.Cursor()
+		for k, v := c.First(); k != nil; {
+			var r IdempotencyRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if now.Before(r.ExpiresAt) {
+				k, v = c.Next()
+				continue
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			// Deleting moves the cursor on to the next key.
+			k, v = c.Seek(k)
+		}
+
+		if v := keys.Get([]byte(record.Key)); v != nil {
+			existing = &IdempotencyRecord{}
+			return json.Unmarshal(v, existing)
+		}
+		record.Status = 0
+		b, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return keys.Put([]byte(record.Key), b)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+func (s *boltdb) saveResponse(ctx context.Context, key string, status int, etag string, body []byte) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		keys := tx.Bucket(boltKeys)
+		v := keys.Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		var r IdempotencyRecord
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+		r.Status, r.ETag, r.Body = status, etag, body
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return keys.Put([]byte(key), b)
+	})
+}
+
+func (s *boltdb) releaseKey(ctx context.Context, key string) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(boltKeys).Delete([]byte(key))
+	})
+}
+
+func (s *boltdb) close() {
+	s.db.Close()
+}
+
+// boltID returns the numeric value of an ID given out by boltdb, which is zero for an invalid ID.
+func boltID(id string) uint64 {
+	uintId, _ := strconv.ParseUint(id, 10, 64)
+	return uintId
+}
+
+// boltItemKey returns the key an item with the given ID is kept under, which is the ID itself for a legacy ID, or
+// nil for a generated ID which was never given out. Generated IDs keep their keys once their items are purged, so
+// that their history can still be found.
+func boltItemKey(tx *bolt.Tx, id string) []byte {
+	if !isGeneratedID(id) {
+		return boltKey(boltID(id))
+	}
+	if k := tx.Bucket(boltItemIDs).Get([]byte(id)); k != nil {
+		// Values read from bolt are only valid during the transaction, and must not be appended to.
+		return append([]byte{}, k...)
+	}
+	return nil
+}
+
+func boltKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+func boltBool(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// boltTime encodes a timestamp so that earlier timestamps sort first, including those before 1970.
+func boltTime(t time.Time) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano())^1<<63)
+	return k
+}
+
+func boltPut(b *bolt.Bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+func boltGetItem(tx *bolt.Tx, id string) (memoryItem, error) {
+	return boltLookup(tx, boltItems, id)
+}
+
+// boltLookup returns an item in a bucket of items. An item which was given a generated ID is only found by it, and
+// not by its key, which would be easy to guess.
+func boltLookup(tx *bolt.Tx, bucket []byte, id string) (memoryItem, error) {
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil && !isGeneratedID(id) {
+		return memoryItem{}, errors.New("Invalid ID type.")
+	}
+	var v []byte
+	if k := boltItemKey(tx, id); k != nil {
+		v = tx.Bucket(bucket).Get(k)
+	}
+	if v == nil {
+		return memoryItem{}, &ErrorItemNotFound{Id: id}
+	}
+	var mi memoryItem
+	if err := json.Unmarshal(v, &mi); err != nil {
+		return memoryItem{}, err
+	}
+	if mi.Id != id && isGeneratedID(mi.Id) {
+		return memoryItem{}, &ErrorItemNotFound{Id: id}
+	}
+	return mi, nil
+}
+
+func boltGetList(tx *bolt.Tx, id string) (List, error) {
+	uintId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return List{}, errors.New("Invalid ID type.")
+	}
+	v := tx.Bucket(boltLists).Get(boltKey(uintId))
+	if v == nil {
+		return List{}, &ErrorListNotFound{Id: id}
+	}
+	var list List
+	err = json.Unmarshal(v, &list)
+	return list, err
+}
+
+// boltAllItems returns every item in ID order.
+func boltAllItems(tx *bolt.Tx) ([]memoryItem, error) {
+	items := make([]memoryItem, 0)
+	err := tx.Bucket(boltItems).ForEach(func(k, v []byte) error {
+		var mi memoryItem
+		if err := json.Unmarshal(v, &mi); err != nil {
+			return err
+		}
+		items = append(items, mi)
+		return nil
+	})
+	return items, err
+}
+
+// boltIndexedItems returns the items in an index whose keys start with prefix, or come before the key end when
+// it is given.
+func boltIndexedItems(tx *bolt.Tx, index []byte, prefix []byte, end []byte) ([]memoryItem, error) {
+	items := make([]memoryItem, 0)
+	byID := tx.Bucket(boltItems)
+	c := tx.Bucket(index).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		if end != nil && bytes.Compare(k[:len(k)-8], end) >= 0 {
+			break
+		}
+		var mi memoryItem
+		if err := json.Unmarshal(byID.Get(k[len(k)-8:]), &mi); err != nil {
+			return nil, err
+		}
+		items = append(items, mi)
+	}
+	return items, nil
+}
+
+func boltSubtasks(tx *bolt.Tx, parentId string) ([]memoryItem, error) {
+	return boltIndexedItems(tx, boltByParent, boltItemKey(tx, parentId), nil)
+}
+
+// boltIndexKeys returns the keys of an item in each index, with no key for a missing due date or parent.
+func boltIndexKeys(tx *bolt.Tx, mi memoryItem) map[string][]byte {
+	id := boltItemKey(tx, mi.Id)
+	keys := map[string][]byte{string(boltByCompleted): append(boltBool(mi.Completed), id...)}
+	if mi.DueAt != nil {
+		keys[string(boltByDue)] = append(boltTime(mi.DueAt.UTC()), id...)
+	}
+	if mi.ParentId != "" {
+		keys[string(boltByParent)] = append(boltItemKey(tx, mi.ParentId), id...)
+	}
+	return keys
+}
+
+// boltPutItem writes an item, moving it from where old was in the indexes.
+func boltPutItem(tx *bolt.Tx, mi memoryItem, old *memoryItem) error {
+	if old != nil {
+		for index, k := range boltIndexKeys(tx, *old) {
+			if err := tx.Bucket([]byte(index)).Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	for index, k := range boltIndexKeys(tx, mi) {
+		if err := tx.Bucket([]byte(index)).Put(k, []byte{}); err != nil {
+			return err
+		}
+	}
+	return boltPut(tx.Bucket(boltItems), boltItemKey(tx, mi.Id), mi)
+}
+
+func boltDeleteItem(tx *bolt.Tx, mi memoryItem) error {
+	for index, k := range boltIndexKeys(tx, mi) {
+		if err := tx.Bucket([]byte(index)).Delete(k); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(boltItems).Delete(boltItemKey(tx, mi.Id))
+}
+
+// boltTrashItem moves an item to the trash along with its subtasks, level by level. They are all given the same
+// deletion time, so that they can be restored together.
+func boltTrashItem(tx *bolt.Tx, mi memoryItem, deletedAt time.Time) error {
+	level := []memoryItem{mi}
+	for len(level) > 0 {
+		var children []memoryItem
+		for _, mi := range level {
+			subtasks, err := boltSubtasks(tx, mi.Id)
+			if err != nil {
+				return err
+			}
+			children = append(children, subtasks...)
+			if err := boltDeleteItem(tx, mi); err != nil {
+				return err
+			}
+			mi.DeletedAt = &deletedAt
+			if err := boltPut(tx.Bucket(boltTrash), boltItemKey(tx, mi.Id), mi); err != nil {
+				return err
+			}
+		}
+		level = children
+	}
+	return nil
+}
+
+// boltPutAuditEntry appends an entry to the audit log, giving it the next ID.
+func boltPutAuditEntry(tx *bolt.Tx, entry AuditEntry) error {
+	log := tx.Bucket(boltAudit)
+	id, err := log.NextSequence()
+	if err != nil {
+		return err
+	}
+	entry.Id = strconv.FormatUint(id, 10)
+	if err := boltPut(log, boltKey(id), entry); err != nil {
+		return err
+	}
+	return tx.Bucket(boltAuditByItem).Put(append(boltItemKey(tx, entry.ItemId), boltKey(id)...), []byte{})
+}
+
+// boltSetUpdatedAt gives the items in a bucket which were saved before they had an UpdatedAt one.
+func boltSetUpdatedAt(b *bolt.Bucket) error {
+	outdated := make(map[string]memoryItem)
+	err := b.ForEach(func(k, v []byte) error {
+		var mi memoryItem
+		if err := json.Unmarshal(v, &mi); err != nil {
+			return err
+		}
+		if mi.UpdatedAt.IsZero() {
+			outdated[string(k)] = mi.withUpdatedAt()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for k, mi := range outdated {
+		if err := boltPut(b, []byte(k), mi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func boltGetTrashedItem(tx *bolt.Tx, id string) (memoryItem, error) {
+	return boltLookup(tx, boltTrash, id)
+}
+
+// boltTrashedItems returns the items in the trash, most recently deleted first.
+func boltTrashedItems(tx *bolt.Tx) ([]memoryItem, error) {
+	items := make([]memoryItem, 0)
+	err := tx.Bucket(boltTrash).ForEach(func(k, v []byte) error {
+		var mi memoryItem
+		if err := json.Unmarshal(v, &mi); err != nil {
+			return err
+		}
+		items = append(items, mi)
+		return nil
+	})
+	sortTrash(items)
+	return items, err
+}
+
+// boltParentID resolves the parent item of a subtask, where an empty id means the item is not a subtask.
+func boltParentID(tx *bolt.Tx, id string) (string, error) {
+	if id == "" {
+		return "", nil
+	}
+	parent, err := boltGetItem(tx, id)
+	if err != nil {
+		return "", &ErrorItemNotFound{Id: id}
+	}
+	return parent.Id, nil
+}
+
+// boltListID resolves the list an item refers to, where an empty id refers to the inbox.
+func boltListID(tx *bolt.Tx, id string) (string, error) {
+	if id == "" {
+		return "", nil
+	}
+	uintId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil || tx.Bucket(boltLists).Get(boltKey(uintId)) == nil {
+		return "", &ErrorListNotFound{Id: id}
+	}
+	return strconv.FormatUint(uintId, 10), nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// initBoltDB opens a bolt database in a new temporary directory, which the returned function closes and removes.
+func initBoltDB(t *testing.T) (*boltdb, func()) {
+	dir, err := ioutil.TempDir("", "todo")
+	assert.NoError(t, err)
+	db := &boltdb{path: filepath.Join(dir, "todo.db")}
+	db.init()
+	return db, func() {
+		db.close()
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_boltdb_items(t *testing.T) {
+	db, cleanup := initBoltDB(t)
+	defer cleanup()
+
+	list, _ := db.createList(ctx, List{Name: "Shopping"})
+	item, err := db.createItem(ctx, Item{Description: "Buy milk", ListId: list.Id, Tags: []string{"home", " home"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", item.Id)
+	assert.Equal(t, 1, item.Version)
+	assert.Equal(t, []string{"home"}, item.Tags)
+
+	_, err = db.createItem(ctx, Item{Description: "Buy bread", ListId: "9"})
+	var le *ErrorListNotFound
+	assert.True(t, errors.As(err, &le))
+
+	item, err = db.updateItem(ctx, item.Id, Item{Description: "Buy oat milk", Completed: true, Version: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, "Buy oat milk", item.Description)
+	assert.Equal(t, "", item.ListId)
+	assert.Equal(t, 2, item.Version)
+
+	_, err = db.updateItem(ctx, item.Id, Item{Description: "Buy soy milk", Version: 1})
+	var ve *ErrorVersionMismatch
+	assert.True(t, errors.As(err, &ve))
+
+	_, err = db.getItem(ctx, "a")
+	assert.EqualError(t, err, "Invalid ID type.")
+	_, err = db.getItem(ctx, "7")
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(err, &e))
+
+	assert.NoError(t, db.deleteItem(ctx, item.Id, 0))
+	assert.True(t, errors.As(db.deleteItem(ctx, item.Id, 0), &e))
+
+	item, _ = db.createItem(ctx, Item{Description: "Buy bread"})
+	assert.Equal(t, "2", item.Id, "IDs are not reused")
+}
+
+func Test_boltdb_indexes(t *testing.T) {
+	db, cleanup := initBoltDB(t)
+	defer cleanup()
+
+	early := time.Date(1960, 5, 1, 0, 0, 0, 0, time.UTC)
+	late := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	db.createItem(ctx, Item{Description: "A", DueAt: &late})
+	db.createItem(ctx, Item{Description: "B", DueAt: &early, Completed: true})
+	c, _ := db.createItem(ctx, Item{Description: "C"})
+
+	completed := true
+	items, err := db.findItems(ctx, ItemQuery{Completed: &completed})
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "B", items[0].Description)
+
+	before := late.Add(time.Hour)
+	items, _ = db.findItems(ctx, ItemQuery{DueBefore: &before})
+	assert.Len(t, items, 2)
+	items, _ = db.findItems(ctx, ItemQuery{DueBefore: &late})
+	assert.Len(t, items, 1)
+	assert.Equal(t, "B", items[0].Description)
+
+	db.patchItem(ctx, c.Id, Item{Completed: true, DueAt: &early}, []string{FieldCompleted, FieldDueAt})
+	items, _ = db.findItems(ctx, ItemQuery{Completed: &completed, DueBefore: &late})
+	assert.Len(t, items, 2)
+	completed = false
+	items, _ = db.findItems(ctx, ItemQuery{Completed: &completed})
+	assert.Len(t, items, 1)
+	assert.Equal(t, "A", items[0].Description)
+}
+
+func Test_boltdb_subtasks(t *testing.T) {
+	db, cleanup := initBoltDB(t)
+	defer cleanup()
+
+	parent, _ := db.createItem(ctx, Item{Description: "Move house"})
+	first, err := db.createSubtask(ctx, parent.Id, Item{Description: "Pack"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, first.Position)
+	second, _ := db.createSubtask(ctx, parent.Id, Item{Description: "Clean"})
+	assert.Equal(t, 1, second.Position)
+	db.createSubtask(ctx, first.Id, Item{Description: "Buy boxes"})
+
+	subtasks, err := db.subtasks(ctx, parent.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{first.Id, second.Id}, []string{subtasks[0].Id, subtasks[1].Id})
+
+	assert.NoError(t, db.deleteItem(ctx, parent.Id, 0))
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 0)
+}
+
+func Test_boltdb_lists(t *testing.T) {
+	db, cleanup := initBoltDB(t)
+	defer cleanup()
+
+	list, _ := db.createList(ctx, List{Name: "Shopping"})
+	item, _ := db.createItem(ctx, Item{Description: "Buy milk", ListId: list.Id})
+	assert.NoError(t, db.deleteList(ctx, list.Id, false))
+	item, _ = db.getItem(ctx, item.Id)
+	assert.Equal(t, "", item.ListId)
+	assert.Equal(t, 2, item.Version)
+
+	list, _ = db.createList(ctx, List{Name: "Chores"})
+	db.createItem(ctx, Item{Description: "Hoover", ListId: list.Id})
+	assert.NoError(t, db.deleteList(ctx, list.Id, true))
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 1)
+	var e *ErrorListNotFound
+	_, err := db.getList(ctx, list.Id)
+	assert.True(t, errors.As(err, &e))
+}
+
+func Test_boltdb_atomically(t *testing.T) {
+	db, cleanup := initBoltDB(t)
+	defer cleanup()
+
+	_, err := db.createItems(ctx, []Item{{Description: "A", Completed: true}, {Description: "B", ListId: "9"}}, true)
+	var be *BatchError
+	assert.True(t, errors.As(err, &be))
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 0)
+	completed := true
+	items, _ = db.findItems(ctx, ItemQuery{Completed: &completed})
+	assert.Len(t, items, 0, "index entries are rolled back too")
+
+	results, err := db.createItems(ctx, []Item{{Description: "A"}, {Description: "B"}}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "B", results[1].Item.Description)
+}
+
+func Test_boltdb_context_done(t *testing.T) {
+	db, cleanup := initBoltDB(t)
+	defer cleanup()
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := db.createItem(cancelled, Item{Description: "A"})
+	assert.Equal(t, context.Canceled, err)
+	_, err = db.getItem(cancelled, "1")
+	assert.Equal(t, context.Canceled, err)
+
+	// A write which runs out of time while it waits for another to finish is not made.
+	expiring, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	done := make(chan error)
+	err = db.atomically(ctx, func(tx Database) error {
+		go func() {
+			_, err := db.createItem(expiring, Item{Description: "B"})
+			done <- err
+		}()
+		<-expiring.Done()
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, context.DeadlineExceeded, <-done)
+
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 0)
+}
+
+func Test_boltdb_reopen(t *testing.T) {
+	db, cleanup := initBoltDB(t)
+	defer cleanup()
+
+	list, _ := db.createList(ctx, List{Name: "Shopping"})
+	db.createItem(ctx, Item{Description: "Buy milk", ListId: list.Id, Tags: []string{"home"}})
+	db.createItem(ctx, Item{Description: "Buy bread"})
+	db.deleteItem(ctx, "2", 0)
+	db.close()
+
+	db.init()
+	item, err := db.getItem(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Buy milk", item.Description)
+	assert.Equal(t, list.Id, item.ListId)
+	assert.Equal(t, []string{"home"}, item.Tags)
+	item, _ = db.createItem(ctx, Item{Description: "Buy eggs"})
+	assert.Equal(t, "3", item.Id)
+}
+
+func Test_boltdb_keyStore(t *testing.T) {
+	db, cleanup := initBoltDB(t)
+	defer cleanup()
+
+	testKeyStore(t, db)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for the cache, which can be changed with the CACHE_TTL and CACHE_SIZE environment variables.
+const (
+	defaultCacheTTL  = time.Minute
+	defaultCacheSize = 10000
+)
+
+// cacheGeneration is the counter which cached results are kept under.
+const cacheGeneration = "generation"
+
+// Cache keeps values for a while under keys made up by cachedb.
+type Cache interface {
+	get(key string) ([]byte, bool, error)
+	set(key string, value []byte, ttl time.Duration) error
+	// counter and incr read and add one to a counter kept under key, which never expires. A counter which has never
+	// been added to is zero.
+	counter(key string) (int64, error)
+	incr(key string) (int64, error)
+}
+
+// CacheStats counts the reads which were answered by the cache and those which went to the database, along with
+// the errors from the cache, since the server started.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Errors uint64
+}
+
+// CachingDatabase is implemented by the databases which cache what is read from them.
+type CachingDatabase interface {
+	cacheStats() CacheStats
+}
+
+// cachedb caches the items returned by getItem, allItems, findItems and findItemPage of another database for ttl.
+// Results are cached under the current generation, which every write that changes items moves on once it is done,
+// so that nothing read before a write is returned after it. A cache which fails is logged and read around.
+type cachedb struct {
+	Database
+	cache Cache
+	ttl   time.Duration
+	stats *CacheStats
+}
+
+// timeTravellingCachedb is a cachedb of a database which can go back to an earlier state.
+type timeTravellingCachedb struct {
+	*cachedb
+}
+
+// newCachedb returns db with a cache in front of it.
+func newCachedb(db Database, cache Cache, ttl time.Duration) Database {
+	s := &cachedb{Database: db, cache: cache, ttl: ttl, stats: &CacheStats{}}
+	if _, ok := db.(TimeTraveller); ok {
+		return &timeTravellingCachedb{s}
+	}
+	return s
+}
+
+func (s *cachedb) getItem(ctx context.Context, id string) (item Item, err error) {
+	err = s.readThrough("item:"+id, &item, func() error {
+		item, err = s.Database.getItem(ctx, id)
+		return err
+	})
+	return item, err
+}
+
+func (s *cachedb) allItems(ctx context.Context) (items []Item, err error) {
+	err = s.readThrough("items", &items, func() error {
+		items, err = s.Database.allItems(ctx)
+		return err
+	})
+	return items, err
+}
+
+func (s *cachedb) findItems(ctx context.Context, query ItemQuery) (items []Item, err error) {
+	err = s.readThrough("find:"+cacheKey(query), &items, func() error {
+		items, err = s.Database.findItems(ctx, query)
+		return err
+	})
+	return items, err
+}
+
+func (s *cachedb) findItemPage(ctx context.Context, query ItemQuery, page PageRequest) (result ItemPage, err error) {
+	err = s.readThrough("page:"+cacheKey(query, page), &result, func() error {
+		result, err = s.Database.findItemPage(ctx, query, page)
+		return err
+	})
+	return result, err
+}
+
+func (s *cachedb) createItem(ctx context.Context, item Item) (Item, error) {
+	defer s.invalidate()
+	return s.Database.createItem(ctx, item)
+}
+
+func (s *cachedb) deleteItem(ctx context.Context, id string, version int) error {
+	defer s.invalidate()
+	return s.Database.deleteItem(ctx, id, version)
+}
+
+func (s *cachedb) updateItem(ctx context.Context, id string, td Item) (Item, error) {
+	defer s.invalidate()
+	return s.Database.updateItem(ctx, id, td)
+}
+
+func (s *cachedb) patchItem(ctx context.Context, id string, td Item, fields []string) (Item, error) {
+	defer s.invalidate()
+	return s.Database.patchItem(ctx, id, td, fields)
+}
+
+func (s *cachedb) createItems(ctx context.Context, items []Item, atomic bool) ([]BatchResult, error) {
+	defer s.invalidate()
+	return s.Database.createItems(ctx, items, atomic)
+}
+
+func (s *cachedb) patchItems(ctx context.Context, patches []ItemPatch, atomic bool) ([]BatchResult, error) {
+	defer s.invalidate()
+	return s.Database.patchItems(ctx, patches, atomic)
+}
+
+func (s *cachedb) deleteItems(ctx context.Context, query ItemQuery, atomic bool) ([]BatchResult, error) {
+	defer s.invalidate()
+	return s.Database.deleteItems(ctx, query, atomic)
+}
+
+func (s *cachedb) setItemTags(ctx context.Context, id string, tags []string) (Item, error) {
+	defer s.invalidate()
+	return s.Database.setItemTags(ctx, id, tags)
+}
+
+// deleteList moves the items of the list to the inbox or the trash.
+func (s *cachedb) deleteList(ctx context.Context, id string, cascade bool) error {
+	defer s.invalidate()
+	return s.Database.deleteList(ctx, id, cascade)
+}
+
+func (s *cachedb) createSubtask(ctx context.Context, parentId string, item Item) (Item, error) {
+	defer s.invalidate()
+	return s.Database.createSubtask(ctx, parentId, item)
+}
+
+func (s *cachedb) restoreItem(ctx context.Context, id string) (Item, error) {
+	defer s.invalidate()
+	return s.Database.restoreItem(ctx, id)
+}
+
+// auditedBy returns a copy of the database which shares its cache, and writes to the copy of the database it
+// caches which records the writes as made by actor.
+func (s *cachedb) auditedBy(actor, requestId string) Database {
+	log, ok := s.Database.(AuditLog)
+	if !ok {
+		return s
+	}
+	t := *s
+	t.Database = log.auditedBy(actor, requestId)
+	return &t
+}
+
+func (s *cachedb) itemHistory(ctx context.Context, id string) ([]AuditEntry, error) {
+	log, ok := s.Database.(AuditLog)
+	if !ok {
+		return nil, errNotAudited
+	}
+	return log.itemHistory(ctx, id)
+}
+
+func (s *cachedb) auditEntries(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	log, ok := s.Database.(AuditLog)
+	if !ok {
+		return nil, errNotAudited
+	}
+	return log.auditEntries(ctx, query)
+}
+
+func (s *cachedb) cacheStats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&s.stats.Hits),
+		Misses: atomic.LoadUint64(&s.stats.Misses),
+		Errors: atomic.LoadUint64(&s.stats.Errors),
+	}
+}
+
+// asOf is not cached, since each time it is read at is likely to be different.
+func (s *timeTravellingCachedb) asOf(ctx context.Context, at time.Time) (Database, error) {
+	return s.Database.(TimeTraveller).asOf(ctx, at)
+}
+
+// readThrough decodes the value cached under name into v, or calls read to fill v in from the database and caches
+// it. Errors from read are not cached.
+func (s *cachedb) readThrough(name string, v interface{}, read func() error) error {
+	generation, err := s.cache.counter(cacheGeneration)
+	if err != nil {
+		s.failed(err)
+		return read()
+	}
+	key := fmt.Sprintf("%d:%s", generation, name)
+	b, ok, err := s.cache.get(key)
+	if err != nil {
+		s.failed(err)
+	} else if ok && json.Unmarshal(b, v) == nil {
+		atomic.AddUint64(&s.stats.Hits, 1)
+		return nil
+	}
+	atomic.AddUint64(&s.stats.Misses, 1)
+	if err := read(); err != nil {
+		return err
+	}
+	if b, err = json.Marshal(v); err == nil {
+		err = s.cache.set(key, b, s.ttl)
+	}
+	if err != nil {
+		s.failed(err)
+	}
+	return nil
+}
+
+// invalidate moves the cache on to the next generation. Should that fail, results cached before the write are
+// returned until they expire.
+func (s *cachedb) invalidate() {
+	if _, err := s.cache.incr(cacheGeneration); err != nil {
+		s.failed(err)
+	}
+}
+
+func (s *cachedb) failed(err error) {
+	atomic.AddUint64(&s.stats.Errors, 1)
+	log.Printf("Cache error: %v", err)
+}
+
+// cacheKey returns a key standing for the arguments to a read, which are JSON.
+func cacheKey(args ...interface{}) string {
+	b, _ := json.Marshal(args)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// lruCache keeps at most size entries in memory, dropping the one used least recently to make room for another.
+type lruCache struct {
+	mu       sync.Mutex
+	size     int
+	entries  map[string]*list.Element
+	order    *list.List
+	counters map[string]int64
+}
+
+// lruEntry is an entry of an lruCache, which is the value of an element of its order, most recently used first.
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{size: size, entries: make(map[string]*list.Element), order: list.New(), counters: make(map[string]int64)}
+}
+
+func (c *lruCache) get(key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := e.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(e)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(e)
+	return entry.value, true, nil
+}
+
+func (c *lruCache) set(key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lruEntry{key: key, value: value, expires: time.Now().Add(ttl)}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+	return nil
+}
+
+func (c *lruCache) counter(key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counters[key], nil
+}
+
+func (c *lruCache) incr(key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counters[key]++
+	return c.counters[key], nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCache checks the behaviour every Cache has in common. Counters may have been added to already.
+func testCache(t *testing.T, cache Cache) {
+	_, ok, err := cache.get("test:missing")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, cache.set("test:key", []byte(`{"Id":"1"}`), time.Minute))
+	value, ok, err := cache.get("test:key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, `{"Id":"1"}`, string(value))
+
+	assert.NoError(t, cache.set("test:expiring", []byte("1"), 10*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	_, ok, err = cache.get("test:expiring")
+	assert.NoError(t, err)
+	assert.False(t, ok, "entries expire")
+
+	n, err := cache.counter("test:counter")
+	assert.NoError(t, err)
+	incremented, err := cache.incr("test:counter")
+	assert.NoError(t, err)
+	assert.Equal(t, n+1, incremented)
+	n, err = cache.counter("test:counter")
+	assert.NoError(t, err)
+	assert.Equal(t, incremented, n)
+}
+
+func Test_lruCache(t *testing.T) {
+	testCache(t, newLRUCache(10))
+
+	cache := newLRUCache(2)
+	cache.set("a", []byte("1"), time.Minute)
+	cache.set("b", []byte("2"), time.Minute)
+	cache.get("a")
+	cache.set("c", []byte("3"), time.Minute)
+	_, ok, _ := cache.get("b")
+	assert.False(t, ok, "the entry used least recently is dropped")
+	_, ok, _ = cache.get("a")
+	assert.True(t, ok)
+	_, ok, _ = cache.get("c")
+	assert.True(t, ok)
+}
+
+func Test_cachedb(t *testing.T) {
+	db := newCachedb(initMemoryDB(), newLRUCache(100), time.Minute).(*cachedb)
+	created, _ := db.createItem(ctx, Item{Description: "Buy milk"})
+
+	item, err := db.getItem(ctx, created.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, created, item)
+	item, err = db.getItem(ctx, created.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, created, item)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, db.cacheStats())
+
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 1)
+	db.updateItem(ctx, created.Id, Item{Description: "Buy oat milk"})
+	db.createItem(ctx, Item{Description: "Buy bread"})
+	item, _ = db.getItem(ctx, created.Id)
+	assert.Equal(t, "Buy oat milk", item.Description, "writes invalidate the cache")
+	items, _ = db.allItems(ctx)
+	assert.Len(t, items, 2)
+
+	audited := db.auditedBy("alice", "req-1")
+	audited.patchItem(ctx, created.Id, Item{Notes: "Barista"}, []string{FieldNotes})
+	item, _ = db.getItem(ctx, created.Id)
+	assert.Equal(t, "Barista", item.Notes, "writes by an audited copy invalidate the cache too")
+	entries, err := db.itemHistory(ctx, created.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", entries[2].Actor)
+
+	_, err = db.getItem(ctx, "9")
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(err, &e), "errors are passed on")
+}
+
+// failingCache fails every call.
+type failingCache struct{}
+
+func (failingCache) get(key string) ([]byte, bool, error) {
+	return nil, false, errors.New("cache down")
+}
+
+func (failingCache) set(key string, value []byte, ttl time.Duration) error {
+	return errors.New("cache down")
+}
+
+func (failingCache) counter(key string) (int64, error) {
+	return 0, errors.New("cache down")
+}
+
+func (failingCache) incr(key string) (int64, error) {
+	return 0, errors.New("cache down")
+}
+
+func Test_cachedb_failing(t *testing.T) {
+	db := newCachedb(initMemoryDB(), failingCache{}, time.Minute).(*cachedb)
+	created, err := db.createItem(ctx, Item{Description: "Buy milk"})
+	assert.NoError(t, err)
+	item, err := db.getItem(ctx, created.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Buy milk", item.Description)
+	assert.Equal(t, CacheStats{Errors: 2}, db.cacheStats())
+}
+
+func TestApplication_getCacheStats(t *testing.T) {
+	router := mux.NewRouter()
+	app := &Application{db: newCachedb(initMemoryDB(), newLRUCache(100), time.Minute), router: router}
+	app.initRoutes()
+
+	requests := []struct {
+		method, url, body string
+	}{
+		{"POST", "/todo", `{"Description": "Buy milk"}`},
+		{"GET", "/todo/1", ""},
+		{"GET", "/todo/1", ""},
+		{"PUT", "/todo/1", `{"Description": "Buy oat milk"}`},
+		{"GET", "/todo/1", ""},
+	}
+	for _, r := range requests {
+		req, _ := http.NewRequest(r.method, r.url, strings.NewReader(r.body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Less(t, rr.Code, 300, r.method+" "+r.url)
+		if r.method == "GET" {
+			assert.Contains(t, rr.Body.String(), `"Version":`)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/todo/1", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Contains(t, rr.Body.String(), "Buy oat milk")
+
+	req, _ = http.NewRequest("GET", "/cache/stats", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"Hits":2,"Misses":2,"Errors":0}`, rr.Body.String())
+}
+
+func TestApplication_getCacheStats_not_cached(t *testing.T) {
+	router := mux.NewRouter()
+	app := &Application{db: initMemoryDB(), router: router}
+	app.initRoutes()
+
+	req, _ := http.NewRequest("GET", "/cache/stats", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+	assert.Equal(t, `{"error":"The database is not cached"}`, rr.Body.String())
+}
+
+func TestApplication_getToDoItemHistory_cached_not_audited(t *testing.T) {
+	router := mux.NewRouter()
+	app := &Application{db: newCachedb(new(MockDatabase), newLRUCache(100), time.Minute), router: router}
+	app.initRoutes()
+
+	for _, url := range []string{"/todo/1/history", "/audit"} {
+		req, _ := http.NewRequest("GET", url, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotImplemented, rr.Code, url)
+		assert.Equal(t, `{"error":"The database does not keep an audit log"}`, rr.Body.String(), url)
+	}
+}
@@ -0,0 +1,743 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ctx is the context the tests call databases with.
+var ctx = context.Background()
+
+// conformanceBackends gives a function opening an empty database for each backend, which is closed when the test
+// using it finishes. Mongo is only tested when TEST_MONGO is set to the connection string of a server whose todo
+// database the tests may wipe, which it must be in CI.
+func conformanceBackends() map[string]func(t *testing.T) Database {
+	backends := map[string]func(t *testing.T) Database{
+		testDialect: func(t *testing.T) Database {
+			db := initDB()
+			t.Cleanup(db.close)
+			return db
+		},
+		"memory": func(t *testing.T) Database {
+			db := initMemoryDB()
+			t.Cleanup(db.close)
+			return db
+		},
+		"bolt": func(t *testing.T) Database {
+			db, cleanup := initBoltDB(t)
+			t.Cleanup(cleanup)
+			return db
+		},
+		// The cache is checked against the memory database, as it is in front of every backend the same way.
+		"cached": func(t *testing.T) Database {
+			db := newCachedb(initMemoryDB(), newLRUCache(100), time.Minute)
+			t.Cleanup(db.close)
+			return db
+		},
+		"events": func(t *testing.T) Database {
+			db, cleanup := initEventDB(t)
+			t.Cleanup(func() {
+				assertReplays(t, db)
+				cleanup()
+			})
+			return db
+		},
+	}
+	if connectionString := os.Getenv("TEST_MONGO"); connectionString != "" {
+		backends["mongo"] = func(t *testing.T) Database {
+			resetMongo(connectionString)
+			db := &mongodb{connectionString: connectionString, autoMigrate: true}
+			db.init()
+			t.Cleanup(db.close)
+			return db
+		}
+	}
+	return backends
+}
+
+// resetMongo drops the todo database, so that each test starts with no items, lists or keys.
+func resetMongo(connectionString string) {
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(connectionString))
+	if err != nil {
+		panic(err)
+	}
+	defer client.Disconnect(context.TODO())
+	if err := client.Database("todo").Drop(context.TODO()); err != nil {
+		panic(err)
+	}
+}
+
+// Test_conformance checks that every backend behaves the same way, so that the API does not depend on which one
+// it is running against.
+func Test_conformance(t *testing.T) {
+	tests := map[string]func(t *testing.T, db Database){
+		"items":         testConformanceItems,
+		"not_found":     testConformanceNotFound,
+		"invalid_ids":   testConformanceInvalidIDs,
+		"ordering":      testConformanceOrdering,
+		"concurrent":    testConformanceConcurrent,
+		"subtasks":      testConformanceSubtasks,
+		"lists":         testConformanceLists,
+		"clear_fields":  testConformanceClearFields,
+		"atomic_writes": testConformanceAtomicWrites,
+		"trash":         testConformanceTrash,
+		"purge":         testConformancePurge,
+		"timestamps":    testConformanceTimestamps,
+		"audit":         testConformanceAudit,
+		"audit_trash":   testConformanceAuditTrash,
+		"generated_ids": testConformanceGeneratedIDs,
+	}
+	if os.Getenv("CI") != "" && os.Getenv("TEST_MONGO") == "" {
+		t.Fatal("TEST_MONGO is not set, so the mongo backend would go untested")
+	}
+	for backend, open := range conformanceBackends() {
+		open := open
+		t.Run(backend, func(t *testing.T) {
+			for name, test := range tests {
+				test := test
+				t.Run(name, func(t *testing.T) {
+					test(t, open(t))
+				})
+			}
+		})
+	}
+}
+
+func testConformanceItems(t *testing.T, db Database) {
+	created, err := db.createItem(ctx, Item{Description: "Buy milk", Notes: "Semi-skimmed", Priority: PriorityHigh, Tags: []string{"home", " home"}})
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", created.Id)
+	assert.Equal(t, 1, created.Version)
+	assert.Equal(t, []string{"home"}, created.Tags)
+
+	item, err := db.getItem(ctx, created.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, created, item)
+
+	item, err = db.updateItem(ctx, created.Id, Item{Description: "Buy oat milk", Completed: true, Version: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, created.Id, item.Id)
+	assert.Equal(t, "Buy oat milk", item.Description)
+	assert.Equal(t, "", item.Notes)
+	assert.True(t, item.Completed)
+	assert.Equal(t, []string{"home"}, item.Tags, "tags are not changed by an update")
+	assert.Equal(t, 2, item.Version)
+
+	item, err = db.patchItem(ctx, created.Id, Item{Notes: "Barista"}, []string{FieldNotes})
+	assert.NoError(t, err)
+	assert.Equal(t, "Buy oat milk", item.Description)
+	assert.Equal(t, "Barista", item.Notes)
+	assert.Equal(t, 3, item.Version)
+
+	item, err = db.setItemTags(ctx, created.Id, []string{"shop"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"shop"}, item.Tags)
+	assert.Equal(t, 4, item.Version)
+
+	_, err = db.updateItem(ctx, created.Id, Item{Description: "Buy soy milk", Version: 1})
+	var ve *ErrorVersionMismatch
+	assert.True(t, errors.As(err, &ve))
+	assert.True(t, errors.As(db.deleteItem(ctx, created.Id, 1), &ve))
+
+	assert.NoError(t, db.deleteItem(ctx, created.Id, 4))
+	items, err := db.allItems(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, items, 0)
+}
+
+func testConformanceNotFound(t *testing.T, db Database) {
+	// IDs of deleted items and lists are valid but refer to nothing, whatever form a backend's IDs take.
+	deleted, _ := db.createItem(ctx, Item{Description: "Deleted"})
+	assert.NoError(t, db.deleteItem(ctx, deleted.Id, 0))
+	id := deleted.Id
+	deletedList, _ := db.createList(ctx, List{Name: "Deleted"})
+	assert.NoError(t, db.deleteList(ctx, deletedList.Id, false))
+	listId := deletedList.Id
+
+	var e *ErrorItemNotFound
+	_, err := db.getItem(ctx, id)
+	assert.True(t, errors.As(err, &e), "getItem")
+	_, err = db.updateItem(ctx, id, Item{Description: "Updated"})
+	assert.True(t, errors.As(err, &e), "updateItem")
+	_, err = db.updateItem(ctx, id, Item{Description: "Updated", Version: 1})
+	assert.True(t, errors.As(err, &e), "updateItem with a version")
+	_, err = db.patchItem(ctx, id, Item{Completed: true}, []string{FieldCompleted})
+	assert.True(t, errors.As(err, &e), "patchItem")
+	assert.True(t, errors.As(db.deleteItem(ctx, id, 0), &e), "deleteItem")
+	assert.True(t, errors.As(db.deleteItem(ctx, id, 1), &e), "deleteItem with a version")
+	_, err = db.setItemTags(ctx, id, []string{"home"})
+	assert.True(t, errors.As(err, &e), "setItemTags")
+	_, err = db.createSubtask(ctx, id, Item{Description: "Subtask"})
+	assert.True(t, errors.As(err, &e), "createSubtask")
+	_, err = db.subtasks(ctx, id)
+	assert.True(t, errors.As(err, &e), "subtasks")
+	_, err = db.createItem(ctx, Item{Description: "Subtask", ParentId: id})
+	assert.True(t, errors.As(err, &e), "createItem with a parent")
+
+	var le *ErrorListNotFound
+	_, err = db.getList(ctx, listId)
+	assert.True(t, errors.As(err, &le), "getList")
+	_, err = db.updateList(ctx, listId, List{Name: "Updated"})
+	assert.True(t, errors.As(err, &le), "updateList")
+	assert.True(t, errors.As(db.deleteList(ctx, listId, false), &le), "deleteList")
+	_, err = db.createItem(ctx, Item{Description: "Listed", ListId: listId})
+	assert.True(t, errors.As(err, &le), "createItem with a list")
+
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 0, "nothing is written by a failed call")
+}
+
+func testConformanceInvalidIDs(t *testing.T, db Database) {
+	_, err := db.getItem(ctx, "foo")
+	assert.EqualError(t, err, "Invalid ID type.")
+	_, err = db.updateItem(ctx, "foo", Item{Description: "Updated"})
+	assert.EqualError(t, err, "Invalid ID type.")
+	_, err = db.patchItem(ctx, "foo", Item{Completed: true}, []string{FieldCompleted})
+	assert.EqualError(t, err, "Invalid ID type.")
+	assert.EqualError(t, db.deleteItem(ctx, "foo", 0), "Invalid ID type.")
+	_, err = db.setItemTags(ctx, "foo", []string{"home"})
+	assert.EqualError(t, err, "Invalid ID type.")
+	_, err = db.getList(ctx, "foo")
+	assert.EqualError(t, err, "Invalid ID type.")
+	_, err = db.updateList(ctx, "foo", List{Name: "Updated"})
+	assert.EqualError(t, err, "Invalid ID type.")
+	assert.EqualError(t, db.deleteList(ctx, "foo", false), "Invalid ID type.")
+}
+
+// setIDGenerator makes a database give out IDs with newID from then on, as when a server is restarted with -ids.
+func setIDGenerator(db Database, newID idGenerator) {
+	switch d := db.(type) {
+	case *gormdb:
+		d.newID = newID
+	case *mongodb:
+		d.newID = newID
+	case *memorydb:
+		d.newID = newID
+	case *boltdb:
+		d.newID = newID
+	case *eventdb:
+		d.newID = newID
+		d.memorydb.newID = newID
+	case *cachedb:
+		setIDGenerator(d.Database, newID)
+	default:
+		panic(fmt.Sprintf("unknown database %T", db))
+	}
+}
+
+func testConformanceGeneratedIDs(t *testing.T, db Database) {
+	legacy, err := db.createItem(ctx, Item{Description: "Buy milk"})
+	assert.NoError(t, err)
+	assert.False(t, isGeneratedID(legacy.Id))
+	newID, _ := newIDGenerator(IDFormatULID)
+	setIDGenerator(db, newID)
+	created, err := db.createItem(ctx, Item{Description: "Buy bread"})
+	assert.NoError(t, err)
+	assert.True(t, isGeneratedID(created.Id), created.Id)
+	subtask, err := db.createSubtask(ctx, created.Id, Item{Description: "Sourdough"})
+	assert.NoError(t, err)
+	assert.Equal(t, created.Id, subtask.ParentId)
+
+	for _, id := range []string{legacy.Id, created.Id} {
+		item, err := db.getItem(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, id, item.Id)
+		item, err = db.updateItem(ctx, id, Item{Description: "Updated", Version: 1})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, item.Version)
+		item, err = db.setItemTags(ctx, id, []string{"shop"})
+		assert.NoError(t, err)
+		assert.Equal(t, id, item.Id)
+	}
+	_, err = db.getItem(ctx, "2")
+	assert.Error(t, err, "items given a generated ID are not found by the ID they would have had")
+	var nf *ErrorItemNotFound
+	_, err = db.getItem(ctx, "01ARZ3NDEKTSV4RRFFQ69G5FAV")
+	assert.True(t, errors.As(err, &nf))
+
+	items, err := db.allItems(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{legacy.Id, created.Id, subtask.Id}, itemIds(items))
+	items, err = db.findItems(ctx, ItemQuery{Sort: []SortField{{Field: SortById, Descending: true}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{subtask.Id, created.Id, legacy.Id}, itemIds(items), "legacy IDs sort before generated ones")
+	for _, id := range []string{legacy.Id, created.Id} {
+		filter, err := parseFilter(`id eq "` + id + `"`)
+		assert.NoError(t, err)
+		items, err = db.findItems(ctx, ItemQuery{Filter: filter})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{id}, itemIds(items))
+	}
+	filter, err := parseFilter(`parent eq "` + created.Id + `"`)
+	assert.NoError(t, err)
+	items, err = db.findItems(ctx, ItemQuery{Filter: filter})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{subtask.Id}, itemIds(items))
+	subtasks, err := db.subtasks(ctx, created.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{subtask.Id}, itemIds(subtasks))
+
+	assert.NoError(t, db.deleteItem(ctx, created.Id, 0))
+	_, err = db.restoreItem(ctx, subtask.Id)
+	var te *ErrorItemTrashed
+	assert.True(t, errors.As(err, &te))
+	assert.Equal(t, created.Id, te.Id)
+	restored, err := db.restoreItem(ctx, created.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, created.Id, restored.Id)
+	entries, err := db.(AuditLog).itemHistory(ctx, created.Id)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 5)
+	assert.NoError(t, db.deleteItem(ctx, created.Id, 0))
+	assert.NoError(t, db.purgeItem(ctx, created.Id))
+	assert.NoError(t, db.deleteItem(ctx, legacy.Id, 0))
+	items, err = db.allItems(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func testConformanceOrdering(t *testing.T, db Database) {
+	var ids []string
+	for _, item := range []Item{
+		{Description: "B", Priority: PriorityLow, Tags: []string{"work"}},
+		{Description: "A", Priority: PriorityHigh, Tags: []string{"home", "work"}},
+		{Description: "D", Priority: PriorityHigh},
+		{Description: "C", Priority: PriorityNone},
+	} {
+		created, err := db.createItem(ctx, item)
+		assert.NoError(t, err)
+		ids = append(ids, created.Id)
+	}
+
+	items, err := db.allItems(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ids, itemIds(items), "items are in the order they were created")
+
+	items, err = db.findItems(ctx, ItemQuery{Sort: []SortField{{Field: SortByPriority, Descending: true}, {Field: SortByDescription}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A", "D", "B", "C"}, itemDescriptions(items))
+
+	items, err = db.findItems(ctx, ItemQuery{Sort: []SortField{{Field: SortByCreated, Descending: true}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ids[3], ids[2], ids[1], ids[0]}, itemIds(items))
+
+	page, err := db.findItemPage(ctx, ItemQuery{Sort: []SortField{{Field: SortByDescription}}}, PageRequest{Limit: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A", "B", "C"}, itemDescriptions(page.Items))
+	page, err = db.findItemPage(ctx, ItemQuery{Sort: []SortField{{Field: SortByDescription}}}, PageRequest{Limit: 3, Cursor: page.Next})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"D"}, itemDescriptions(page.Items))
+
+	tags, err := db.allTags(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []TagCount{{Name: "home", Count: 1}, {Name: "work", Count: 2}}, tags)
+}
+
+func testConformanceConcurrent(t *testing.T, db Database) {
+	var wg sync.WaitGroup
+	created := make([]string, 20)
+	for i := range created {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			item, err := db.createItem(ctx, Item{Description: "Concurrent"})
+			assert.NoError(t, err)
+			created[i] = item.Id
+		}(i)
+	}
+	wg.Wait()
+	ids := make(map[string]bool)
+	for _, id := range created {
+		ids[id] = true
+	}
+	assert.Len(t, ids, len(created), "every item gets its own ID")
+
+	// Only one of several writes made against the same version may succeed.
+	item, _ := db.createItem(ctx, Item{Description: "Contended"})
+	var mu sync.Mutex
+	var succeeded, mismatched int
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.updateItem(ctx, item.Id, Item{Description: "Updated", Version: item.Version})
+			mu.Lock()
+			defer mu.Unlock()
+			var ve *ErrorVersionMismatch
+			if err == nil {
+				succeeded++
+			} else if errors.As(err, &ve) {
+				mismatched++
+			} else {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, succeeded)
+	assert.Equal(t, 9, mismatched)
+	item, _ = db.getItem(ctx, item.Id)
+	assert.Equal(t, 2, item.Version)
+}
+
+func testConformanceSubtasks(t *testing.T, db Database) {
+	parent, _ := db.createItem(ctx, Item{Description: "Move house"})
+	first, err := db.createSubtask(ctx, parent.Id, Item{Description: "Pack"})
+	assert.NoError(t, err)
+	assert.Equal(t, parent.Id, first.ParentId)
+	assert.Equal(t, 0, first.Position)
+	second, _ := db.createSubtask(ctx, parent.Id, Item{Description: "Clean"})
+	assert.Equal(t, 1, second.Position)
+	db.createSubtask(ctx, first.Id, Item{Description: "Buy boxes"})
+	db.patchItem(ctx, second.Id, Item{Position: -1}, []string{FieldPosition})
+
+	subtasks, err := db.subtasks(ctx, parent.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{second.Id, first.Id}, itemIds(subtasks))
+
+	assert.NoError(t, db.deleteItem(ctx, parent.Id, 0))
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 0, "subtasks are deleted along with their parent")
+}
+
+func testConformanceLists(t *testing.T, db Database) {
+	shopping, _ := db.createList(ctx, List{Name: "Shopping"})
+	chores, _ := db.createList(ctx, List{Name: "Chores"})
+	list, err := db.updateList(ctx, chores.Id, List{Name: "Housework"})
+	assert.NoError(t, err)
+	assert.Equal(t, List{Id: chores.Id, Name: "Housework"}, list)
+	lists, err := db.allLists(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []List{shopping, list}, lists)
+
+	milk, _ := db.createItem(ctx, Item{Description: "Buy milk", ListId: shopping.Id})
+	db.createItem(ctx, Item{Description: "Hoover", ListId: chores.Id})
+	items, err := db.findItems(ctx, ItemQuery{ListId: shopping.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{milk.Id}, itemIds(items))
+
+	assert.NoError(t, db.deleteList(ctx, shopping.Id, false))
+	milk, _ = db.getItem(ctx, milk.Id)
+	assert.Equal(t, "", milk.ListId, "items are moved to the inbox")
+	assert.Equal(t, 2, milk.Version)
+	assert.NoError(t, db.deleteList(ctx, chores.Id, true))
+	items, _ = db.allItems(ctx)
+	assert.Equal(t, []string{milk.Id}, itemIds(items))
+}
+
+func testConformanceClearFields(t *testing.T, db Database) {
+	list, _ := db.createList(ctx, List{Name: "Shopping"})
+	parent, _ := db.createItem(ctx, Item{Description: "Move house"})
+	due := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	item, _ := db.createSubtask(ctx, parent.Id, Item{Description: "Buy boxes", DueAt: &due, StartAt: &due, ListId: list.Id})
+
+	patched, err := db.patchItem(ctx, item.Id, Item{}, []string{FieldDueAt, FieldStartAt, FieldListId, FieldParentId})
+	assert.NoError(t, err)
+	assert.Nil(t, patched.DueAt)
+	assert.Nil(t, patched.StartAt)
+	assert.Equal(t, "", patched.ListId)
+	assert.Equal(t, "", patched.ParentId)
+
+	item, _ = db.createItem(ctx, Item{Description: "Buy milk", DueAt: &due, ListId: list.Id})
+	updated, err := db.updateItem(ctx, item.Id, Item{Description: "Buy oat milk"})
+	assert.NoError(t, err)
+	assert.Nil(t, updated.DueAt)
+	assert.Equal(t, "", updated.ListId)
+}
+
+func testConformanceAtomicWrites(t *testing.T, db Database) {
+	missing, _ := db.createList(ctx, List{Name: "Deleted"})
+	db.deleteList(ctx, missing.Id, false)
+
+	_, err := db.createItems(ctx, []Item{{Description: "A"}, {Description: "B", ListId: missing.Id}}, true)
+	if m, ok := db.(*mongodb); ok && !m.transactions {
+		// Without transactions an atomic batch is refused before anything is written.
+		var ns *ErrorNotSupported
+		assert.True(t, errors.As(err, &ns), "%v", err)
+		_, err = db.deleteItems(ctx, ItemQuery{Completed: new(bool)}, true)
+		assert.True(t, errors.As(err, &ns), "%v", err)
+	} else {
+		var be *BatchError
+		assert.True(t, errors.As(err, &be))
+		assert.Equal(t, 1, be.Index)
+	}
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 0)
+
+	results, err := db.createItems(ctx, []Item{{Description: "A"}, {Description: "B", ListId: missing.Id}}, false)
+	assert.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	var le *ErrorListNotFound
+	assert.True(t, errors.As(results[1].Err, &le))
+	items, _ = db.allItems(ctx)
+	assert.Len(t, items, 1)
+}
+
+func testConformanceTrash(t *testing.T, db Database) {
+	parent, _ := db.createItem(ctx, Item{Description: "Move house", Tags: []string{"home"}})
+	child, _ := db.createSubtask(ctx, parent.Id, Item{Description: "Pack"})
+	other, _ := db.createItem(ctx, Item{Description: "Buy milk"})
+	assert.NoError(t, db.deleteItem(ctx, parent.Id, 0))
+
+	_, err := db.getItem(ctx, child.Id)
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(err, &e), "subtasks are moved to the trash along with their parent")
+	tags, _ := db.allTags(ctx)
+	assert.Len(t, tags, 0)
+	trash, err := db.trashedItems(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{parent.Id, child.Id}, itemIds(trash))
+	assert.NotNil(t, trash[0].DeletedAt)
+	assert.True(t, trash[0].DeletedAt.Equal(*trash[1].DeletedAt))
+
+	_, err = db.restoreItem(ctx, child.Id)
+	var te *ErrorItemTrashed
+	assert.True(t, errors.As(err, &te))
+	_, err = db.restoreItem(ctx, other.Id)
+	assert.True(t, errors.As(err, &e), "only items in the trash can be restored")
+
+	restored, err := db.restoreItem(ctx, parent.Id)
+	assert.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+	assert.Equal(t, 2, restored.Version)
+	assert.Equal(t, []string{"home"}, restored.Tags)
+	subtasks, _ := db.subtasks(ctx, parent.Id)
+	assert.Equal(t, []string{child.Id}, itemIds(subtasks))
+	trash, _ = db.trashedItems(ctx)
+	assert.Len(t, trash, 0)
+
+	// Only the subtasks deleted along with an item are restored with it.
+	assert.NoError(t, db.deleteItem(ctx, child.Id, 0))
+	time.Sleep(2 * time.Millisecond)
+	assert.NoError(t, db.deleteItem(ctx, parent.Id, 0))
+	trash, _ = db.trashedItems(ctx)
+	assert.Equal(t, []string{parent.Id, child.Id}, itemIds(trash), "most recently deleted first")
+	db.restoreItem(ctx, parent.Id)
+	trash, _ = db.trashedItems(ctx)
+	assert.Equal(t, []string{child.Id}, itemIds(trash))
+	restored, err = db.restoreItem(ctx, child.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, parent.Id, restored.ParentId)
+
+	list, _ := db.createList(ctx, List{Name: "Shopping"})
+	bread, _ := db.createItem(ctx, Item{Description: "Buy bread", ListId: list.Id})
+	assert.NoError(t, db.deleteList(ctx, list.Id, true))
+	trash, _ = db.trashedItems(ctx)
+	assert.Equal(t, []string{bread.Id}, itemIds(trash))
+	restored, err = db.restoreItem(ctx, bread.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "", restored.ListId, "items in a deleted list are restored to the inbox")
+}
+
+func testConformancePurge(t *testing.T, db Database) {
+	parent, _ := db.createItem(ctx, Item{Description: "Move house", Tags: []string{"home"}})
+	child, _ := db.createSubtask(ctx, parent.Id, Item{Description: "Pack"})
+	old, _ := db.createItem(ctx, Item{Description: "Buy milk"})
+	recent, _ := db.createItem(ctx, Item{Description: "Buy bread"})
+
+	err := db.purgeItem(ctx, recent.Id)
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(err, &e), "only items in the trash can be purged")
+	db.deleteItem(ctx, parent.Id, 0)
+	assert.NoError(t, db.purgeItem(ctx, parent.Id))
+	trash, _ := db.trashedItems(ctx)
+	assert.Len(t, trash, 0, "subtasks are purged along with their parent")
+	_, err = db.restoreItem(ctx, child.Id)
+	assert.True(t, errors.As(err, &e))
+
+	db.deleteItem(ctx, old.Id, 0)
+	time.Sleep(2 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	db.deleteItem(ctx, recent.Id, 0)
+	purged, err := db.purgeTrash(ctx, cutoff)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	trash, _ = db.trashedItems(ctx)
+	assert.Equal(t, []string{recent.Id}, itemIds(trash))
+}
+
+func testConformanceTimestamps(t *testing.T, db Database) {
+	item, _ := db.createItem(ctx, Item{Description: "Buy milk"})
+	assert.False(t, item.CreatedAt.IsZero())
+	assert.Equal(t, item.CreatedAt, item.UpdatedAt)
+	assert.Nil(t, item.CompletedAt)
+	read, _ := db.getItem(ctx, item.Id)
+	assert.WithinDuration(t, item.CreatedAt, read.CreatedAt, time.Millisecond)
+
+	time.Sleep(2 * time.Millisecond)
+	completed, err := db.patchItem(ctx, item.Id, Item{Completed: true}, []string{FieldCompleted})
+	assert.NoError(t, err)
+	assert.True(t, completed.UpdatedAt.After(item.UpdatedAt))
+	assert.NotNil(t, completed.CompletedAt)
+	time.Sleep(2 * time.Millisecond)
+	patched, _ := db.patchItem(ctx, item.Id, Item{Completed: true, Description: "Buy oat milk"}, []string{FieldCompleted, FieldDescription})
+	assert.WithinDuration(t, *completed.CompletedAt, *patched.CompletedAt, time.Millisecond, "completing a complete item leaves the time alone")
+	assert.True(t, patched.UpdatedAt.After(completed.UpdatedAt))
+	patched, _ = db.patchItem(ctx, item.Id, Item{Completed: false}, []string{FieldCompleted})
+	assert.Nil(t, patched.CompletedAt)
+	tagged, _ := db.setItemTags(ctx, item.Id, []string{"home"})
+	assert.True(t, tagged.UpdatedAt.After(completed.UpdatedAt))
+
+	done, _ := db.createItem(ctx, Item{Description: "Buy bread", Completed: true})
+	assert.NotNil(t, done.CompletedAt)
+	time.Sleep(2 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	db.patchItem(ctx, item.Id, Item{Completed: true}, []string{FieldCompleted})
+	filter, err := parseFilter(`completed_at ge "` + cutoff.UTC().Format(time.RFC3339Nano) + `"`)
+	assert.NoError(t, err)
+	items, err := db.findItems(ctx, ItemQuery{Filter: filter})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{item.Id}, itemIds(items))
+	items, err = db.findItems(ctx, ItemQuery{Sort: []SortField{{Field: SortByCompletedAt, Descending: true}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{item.Id, done.Id}, itemIds(items))
+	page, err := db.findItemPage(ctx, ItemQuery{Sort: []SortField{{Field: SortByUpdated}}}, PageRequest{Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{done.Id}, itemIds(page.Items))
+	page, err = db.findItemPage(ctx, ItemQuery{Sort: []SortField{{Field: SortByUpdated}}}, PageRequest{Limit: 1, Cursor: page.Next})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{item.Id}, itemIds(page.Items))
+}
+
+func testConformanceAuditTrash(t *testing.T, db Database) {
+	audited := db.(AuditLog).auditedBy("alice", "req-1")
+	list, _ := db.createList(ctx, List{Name: "Shopping"})
+	milk, _ := db.createItem(ctx, Item{Description: "Buy milk", ListId: list.Id, Tags: []string{"home"}})
+	bread, _ := db.createItem(ctx, Item{Description: "Buy bread", ListId: list.Id})
+	hoover, _ := db.createItem(ctx, Item{Description: "Hoover"})
+	assert.NoError(t, audited.deleteList(ctx, list.Id, true))
+	assert.NoError(t, audited.deleteItem(ctx, hoover.Id, 0))
+
+	for _, item := range []Item{milk, bread} {
+		history, err := db.(AuditLog).itemHistory(ctx, item.Id)
+		assert.NoError(t, err)
+		if assert.Len(t, history, 2, "items deleted along with their list are recorded as deleted") {
+			assert.Equal(t, AuditDelete, history[1].Action)
+			assert.Equal(t, "alice", history[1].Actor)
+			assert.Equal(t, 1, history[1].Version)
+		}
+	}
+
+	restored, err := audited.restoreItem(ctx, milk.Id)
+	assert.NoError(t, err)
+	history, _ := db.(AuditLog).itemHistory(ctx, milk.Id)
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, AuditRestore, history[2].Action)
+		assert.Equal(t, "alice", history[2].Actor)
+		assert.Equal(t, "req-1", history[2].RequestId)
+		assert.Equal(t, restored.Version, history[2].Version)
+		assert.Equal(t, []FieldChange{{Field: FieldListId, Old: json.RawMessage(`"` + list.Id + `"`), New: json.RawMessage(`""`)}},
+			history[2].Changes, "the item is moved to the inbox as its list has gone")
+	}
+	_, err = db.restoreItem(ctx, hoover.Id)
+	assert.NoError(t, err)
+	history, _ = db.(AuditLog).itemHistory(ctx, hoover.Id)
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, AuditRestore, history[2].Action)
+		assert.Equal(t, 2, history[2].Version)
+		assert.Empty(t, history[2].Changes)
+	}
+}
+
+func testConformanceAudit(t *testing.T, db Database) {
+	audited := db.(AuditLog).auditedBy("alice", "req-1")
+	item, err := audited.createItem(ctx, Item{Description: "Buy milk", Tags: []string{"home"}})
+	assert.NoError(t, err)
+	due := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	patched, err := db.(AuditLog).auditedBy("bob", "req-2").patchItem(ctx, item.Id, Item{Description: "Buy oat milk", DueAt: &due}, []string{FieldDescription, FieldDueAt})
+	assert.NoError(t, err)
+	other, _ := db.createItem(ctx, Item{Description: "Buy bread"})
+	assert.NoError(t, audited.deleteItem(ctx, item.Id, 0))
+
+	history, err := db.(AuditLog).itemHistory(ctx, item.Id)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, AuditCreate, history[0].Action)
+		assert.Equal(t, "alice", history[0].Actor)
+		assert.Equal(t, "req-1", history[0].RequestId)
+		assert.Equal(t, 1, history[0].Version)
+		assert.Equal(t, []FieldChange{
+			{Field: FieldDescription, Old: json.RawMessage(`null`), New: json.RawMessage(`"Buy milk"`)},
+			{Field: FieldTags, Old: json.RawMessage(`null`), New: json.RawMessage(`["home"]`)},
+		}, history[0].Changes)
+		assert.WithinDuration(t, item.CreatedAt, history[0].At, time.Millisecond)
+
+		assert.Equal(t, AuditUpdate, history[1].Action)
+		assert.Equal(t, "bob", history[1].Actor)
+		assert.Equal(t, 2, history[1].Version)
+		assert.Equal(t, []FieldChange{
+			{Field: FieldDescription, Old: json.RawMessage(`"Buy milk"`), New: json.RawMessage(`"Buy oat milk"`)},
+			{Field: FieldDueAt, Old: json.RawMessage(`null`), New: json.RawMessage(`"2020-05-01T00:00:00Z"`)},
+		}, history[1].Changes)
+		assert.WithinDuration(t, patched.UpdatedAt, history[1].At, time.Millisecond)
+
+		assert.Equal(t, AuditDelete, history[2].Action)
+		assert.Equal(t, 2, history[2].Version)
+		assert.Nil(t, history[2].Changes)
+	}
+	_, err = db.(AuditLog).itemHistory(ctx, other.Id)
+	assert.NoError(t, err, "writes made without an actor are recorded too")
+
+	entries, err := db.(AuditLog).auditEntries(ctx, AuditQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+	entries, err = db.(AuditLog).auditEntries(ctx, AuditQuery{After: history[0].Id, Limit: 2})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, history[1], entries[0])
+		assert.Equal(t, other.Id, entries[1].ItemId)
+	}
+	entries, err = db.(AuditLog).auditEntries(ctx, AuditQuery{Since: time.Now().Add(time.Minute)})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+	_, err = db.(AuditLog).auditEntries(ctx, AuditQuery{After: "invalid"})
+	assert.Equal(t, errInvalidCursor, err)
+
+	_, err = db.(AuditLog).itemHistory(ctx, other.Id+"0")
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(err, &e))
+
+	_, err = audited.setItemTags(ctx, other.Id, []string{"shop"})
+	assert.NoError(t, err)
+	history, _ = db.(AuditLog).itemHistory(ctx, other.Id)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, []FieldChange{{Field: FieldTags, Old: json.RawMessage(`[]`), New: json.RawMessage(`["shop"]`)}}, history[1].Changes)
+	}
+
+	if m, ok := db.(*mongodb); !ok || m.transactions {
+		// Entries are written in the same transaction as the items, so an atomic batch which fails leaves none.
+		missing, _ := db.createList(ctx, List{Name: "Deleted"})
+		db.deleteList(ctx, missing.Id, false)
+		_, err = audited.createItems(ctx, []Item{{Description: "A"}, {Description: "B", ListId: missing.Id}}, true)
+		assert.Error(t, err)
+		entries, _ = db.(AuditLog).auditEntries(ctx, AuditQuery{})
+		assert.Len(t, entries, 5)
+	}
+}
+
+func itemIds(items []Item) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.Id)
+	}
+	return ids
+}
+
+func itemDescriptions(items []Item) []string {
+	descriptions := make([]string, 0, len(items))
+	for _, item := range items {
+		descriptions = append(descriptions, item.Description)
+	}
+	return descriptions
+}
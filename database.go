@@ -1,30 +1,301 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/jinzhu/gorm"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"strings"
+	"time"
 )
 
 type GormItem struct {
 	gorm.Model
-	Description string
-	Completed   bool
+	Description          string
+	Notes                string `gorm:"type:text"`
+	Completed            bool
+	Priority             int
+	DueAt                *time.Time
+	StartAt              *time.Time
+	ListID               *uint   `gorm:"index"`
+	ParentID             *uint   `gorm:"index"`
+	UID                  *string `gorm:"unique_index"`
+	ParentUID            *string
+	Position             int
+	CompleteWithSubtasks bool
+	Version              int `gorm:"not null;default:1"`
+	CompletedAt          *time.Time
+	Tags                 []GormTag `gorm:"many2many:item_tags;"`
+}
+
+type GormList struct {
+	gorm.Model
+	Name string
+}
+
+type GormTag struct {
+	ID   uint
+	Name string `gorm:"unique_index"`
+}
+
+// GormIdempotencyKey stores an IdempotencyRecord. The key is a reserved word in MySQL, so its column is renamed, and
+// the body is given a size which makes MySQL store it as a longblob instead of a varbinary(255).
+type GormIdempotencyKey struct {
+	Key         string `gorm:"primary_key;column:idempotency_key"`
+	Fingerprint string
+	Status      int
+	ETag        string
+	Body        []byte    `gorm:"size:4294967295"`
+	ExpiresAt   time.Time `gorm:"index"`
+}
+
+// GormAuditEntry stores an AuditEntry, with its changes as JSON.
+type GormAuditEntry struct {
+	ID         uint   `gorm:"primary_key"`
+	ItemID     string `gorm:"index"`
+	Action     string
+	Version    int
+	Actor      string
+	RequestID  string
+	Changes    string    `gorm:"type:text"`
+	RecordedAt time.Time `gorm:"index"`
+}
+
+type MongoItem struct {
+	ID                   primitive.ObjectID  `bson:"_id,omitempty"`
+	Description          string              `bson:"description"`
+	Notes                string              `bson:"notes,omitempty"`
+	Completed            bool                `bson:"completed"`
+	Priority             int                 `bson:"priority"`
+	DueAt                *time.Time          `bson:"dueat,omitempty"`
+	StartAt              *time.Time          `bson:"startat,omitempty"`
+	ListID               *primitive.ObjectID `bson:"listid,omitempty"`
+	ParentID             *primitive.ObjectID `bson:"parentid,omitempty"`
+	UID                  string              `bson:"uid,omitempty"`
+	ParentUID            string              `bson:"parentuid,omitempty"`
+	Position             int                 `bson:"position"`
+	CompleteWithSubtasks bool                `bson:"completewithsubtasks"`
+	Version              int                 `bson:"version"`
+	Tags                 []string            `bson:"tags,omitempty"`
+	CreatedAt            time.Time           `bson:"createdat"`
+	UpdatedAt            time.Time           `bson:"updatedat"`
+	CompletedAt          *time.Time          `bson:"completedat,omitempty"`
+	DeletedAt            *time.Time          `bson:"deletedat,omitempty"`
+}
+
+// MongoAuditEntry stores an AuditEntry.
+type MongoAuditEntry struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	ItemID    string             `bson:"itemid"`
+	Action    string             `bson:"action"`
+	Version   int                `bson:"version"`
+	Actor     string             `bson:"actor,omitempty"`
+	RequestID string             `bson:"requestid,omitempty"`
+	At        time.Time          `bson:"at"`
+	Changes   []MongoFieldChange `bson:"changes,omitempty"`
+}
+
+// MongoFieldChange stores a FieldChange, with the values as JSON.
+type MongoFieldChange struct {
+	Field string `bson:"field"`
+	Old   string `bson:"old"`
+	New   string `bson:"new"`
+}
+
+type MongoList struct {
+	ID   primitive.ObjectID `bson:"_id,omitempty"`
+	Name string             `bson:"name"`
+}
+
+type MongoIdempotencyKey struct {
+	Key         string    `bson:"_id"`
+	Fingerprint string    `bson:"fingerprint"`
+	Status      int       `bson:"status"`
+	ETag        string    `bson:"etag,omitempty"`
+	Body        []byte    `bson:"body,omitempty"`
+	ExpiresAt   time.Time `bson:"expiresat"`
 }
 
+// Item priorities, from lowest to highest.
+const (
+	PriorityNone = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+)
+
 type Item struct {
 	Id          string
 	Description string
+	Notes       string
 	Completed   bool
+	Priority    int
+	DueAt       *time.Time
+	StartAt     *time.Time
+	ListId      string
+	Tags        []string
+	// ParentId is set on subtasks, which are ordered by Position within their parent. A parent with
+	// CompleteWithSubtasks set is completed as soon as all of its subtasks are.
+	ParentId             string
+	Position             int
+	CompleteWithSubtasks bool
+	// Version starts at 1 and goes up by one every time the item changes, which UpdatedAt is the time of.
+	Version   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// CompletedAt is set when the item is completed, and cleared when it is no longer complete.
+	CompletedAt *time.Time `json:",omitempty"`
+	Subtasks    []Item     `json:",omitempty"`
+	// DeletedAt is only set on items in the trash.
+	DeletedAt *time.Time `json:",omitempty"`
+}
+
+// Item fields which can be named in the field mask given to patchItem.
+const (
+	FieldDescription          = "Description"
+	FieldNotes                = "Notes"
+	FieldCompleted            = "Completed"
+	FieldPriority             = "Priority"
+	FieldDueAt                = "DueAt"
+	FieldStartAt              = "StartAt"
+	FieldListId               = "ListId"
+	FieldTags                 = "Tags"
+	FieldParentId             = "ParentId"
+	FieldPosition             = "Position"
+	FieldCompleteWithSubtasks = "CompleteWithSubtasks"
+)
+
+var patchableFields = []string{FieldDescription, FieldNotes, FieldCompleted, FieldPriority, FieldDueAt, FieldStartAt,
+	FieldListId, FieldTags, FieldParentId, FieldPosition, FieldCompleteWithSubtasks}
+
+// updatableFields are replaced by updateItem. Tags are left alone, and replaced with setItemTags instead.
+var updatableFields = []string{FieldDescription, FieldNotes, FieldCompleted, FieldPriority, FieldDueAt, FieldStartAt,
+	FieldListId, FieldParentId, FieldPosition, FieldCompleteWithSubtasks}
+
+// List groups items together. Items which do not belong to a list are in the inbox.
+type List struct {
+	Id   string
+	Name string
+}
+
+// TagCount is the number of items a tag is attached to.
+type TagCount struct {
+	Name  string
+	Count int
 }
 
+// Fields which items can be sorted by.
+const (
+	SortById          = "id"
+	SortByDescription = "description"
+	SortByPriority    = "priority"
+	SortByDue         = "due"
+	SortByStart       = "start"
+	SortByCreated     = "created"
+	SortByUpdated     = "updated"
+	SortByCompletedAt = "completed_at"
+)
+
+var sortableFields = []string{SortById, SortByDescription, SortByPriority, SortByDue, SortByStart, SortByCreated,
+	SortByUpdated, SortByCompletedAt}
+
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// ItemQuery describes a subset of items to be returned by findItems, in the order given by Sort. A nil or empty
+// field matches every item. Items must carry every tag in AllTags and at least one of AnyTags, and be matched by
+// Filter. Items which compare equal are returned in ID order.
+type ItemQuery struct {
+	ListId    string
+	Completed *bool
+	DueBefore *time.Time
+	AllTags   []string
+	AnyTags   []string
+	Filter    FilterExpr
+	Sort      []SortField
+}
+
+func (q ItemQuery) isEmpty() bool {
+	return q.matchesAll() && len(q.Sort) == 0
+}
+
+// matchesAll returns whether the query matches every item, whatever order it asks for.
+func (q ItemQuery) matchesAll() bool {
+	return q.ListId == "" && q.Completed == nil && q.DueBefore == nil && len(q.AllTags) == 0 && len(q.AnyTags) == 0 && q.Filter == nil
+}
+
+// PageRequest asks for at most Limit items following the position marked by Cursor, or preceding it for a
+// Before cursor. The first page is returned when Cursor is nil. The total number of matching items is only
+// counted when Total is set.
+type PageRequest struct {
+	Limit  int
+	Cursor *Cursor
+	Total  bool
+}
+
+// ItemPage is a page of items, along with cursors for the pages either side of it which are nil at either end
+// of the listing.
+type ItemPage struct {
+	Items []Item
+	Next  *Cursor
+	Prev  *Cursor
+	Total int
+}
+
+// Cursor marks a position in a sorted listing of items by the sort keys of an item, followed by its ID. Keys
+// are formatted as strings, and are nil for missing dates.
+type Cursor struct {
+	Keys   []*string
+	Before bool `json:",omitempty"`
+}
+
+// SearchResult is an item matched by a search, along with its relevance and a snippet of the matching text in
+// which the search terms are highlighted. Results with a higher Score are more relevant.
+type SearchResult struct {
+	Item    Item
+	Score   float64
+	Snippet string
+}
+
+var errInvalidCursor = errors.New("Invalid cursor")
+
+// Database is implemented by each backend. Every method but init and close takes the context of the request it is
+// made for, and the backends which wait on a server or a file give up once it is done.
 type Database interface {
 	init()
-	ping() error
-	createItem(item Item) (Item, error)
-	deleteItem(id string) error
-	updateItem(id string, td Item) (Item, error)
-	getItem(id string) (Item, error)
-	allItems() ([]Item, error)
+	ping(ctx context.Context) error
+	createItem(ctx context.Context, item Item) (Item, error)
+	deleteItem(ctx context.Context, id string, version int) error
+	updateItem(ctx context.Context, id string, td Item) (Item, error)
+	patchItem(ctx context.Context, id string, td Item, fields []string) (Item, error)
+	getItem(ctx context.Context, id string) (Item, error)
+	allItems(ctx context.Context) ([]Item, error)
+	findItems(ctx context.Context, query ItemQuery) ([]Item, error)
+	findItemPage(ctx context.Context, query ItemQuery, page PageRequest) (ItemPage, error)
+	searchItems(ctx context.Context, q string, limit int) ([]SearchResult, error)
+	createItems(ctx context.Context, items []Item, atomic bool) ([]BatchResult, error)
+	patchItems(ctx context.Context, patches []ItemPatch, atomic bool) ([]BatchResult, error)
+	deleteItems(ctx context.Context, query ItemQuery, atomic bool) ([]BatchResult, error)
+	setItemTags(ctx context.Context, id string, tags []string) (Item, error)
+	allTags(ctx context.Context) ([]TagCount, error)
+	createList(ctx context.Context, list List) (List, error)
+	deleteList(ctx context.Context, id string, cascade bool) error
+	updateList(ctx context.Context, id string, list List) (List, error)
+	getList(ctx context.Context, id string) (List, error)
+	allLists(ctx context.Context) ([]List, error)
+	createSubtask(ctx context.Context, parentId string, item Item) (Item, error)
+	subtasks(ctx context.Context, parentId string) ([]Item, error)
+	// Deleted items are moved to the trash along with their subtasks, all with the same DeletedAt. They can be
+	// restored from it until they are purged.
+	trashedItems(ctx context.Context) ([]Item, error)
+	restoreItem(ctx context.Context, id string) (Item, error)
+	purgeItem(ctx context.Context, id string) error
+	purgeTrash(ctx context.Context, before time.Time) (int, error)
 	close()
 }
 
@@ -36,3 +307,97 @@ func (e *ErrorItemNotFound) Error() string {
 	return fmt.Sprintf("Unable to find item with id %s", e.Id)
 }
 
+// ErrorUnknownField is returned when a field mask names a field which cannot be patched.
+type ErrorUnknownField struct {
+	Field string
+}
+
+func (e *ErrorUnknownField) Error() string {
+	return fmt.Sprintf("Unknown field %s", e.Field)
+}
+
+// ErrorVersionMismatch is returned when an item is written on condition of having a version which it no longer
+// has. Conditional writes are given the expected version of the item, in td.Version when updating it, with 0
+// meaning that any version will do.
+type ErrorVersionMismatch struct {
+	Id string
+}
+
+func (e *ErrorVersionMismatch) Error() string {
+	return fmt.Sprintf("Item with id %s has been modified", e.Id)
+}
+
+// ErrorItemTrashed is returned when restoring a subtask whose parent is still in the trash.
+type ErrorItemTrashed struct {
+	Id string
+}
+
+func (e *ErrorItemTrashed) Error() string {
+	return fmt.Sprintf("Item with id %s is in the trash", e.Id)
+}
+
+// ErrorNotSupported is returned when the database cannot do what it was asked to at all, rather than failing to.
+type ErrorNotSupported struct {
+	Reason string
+}
+
+func (e *ErrorNotSupported) Error() string {
+	return e.Reason
+}
+
+type ErrorListNotFound struct {
+	Id string
+}
+
+func (e *ErrorListNotFound) Error() string {
+	return fmt.Sprintf("Unable to find list with id %s", e.Id)
+}
+
+// utc normalises optional timestamps so they compare consistently in every backend.
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+// normaliseTags trims whitespace from tags and returns them sorted, without blanks or duplicates.
+func normaliseTags(tags []string) []string {
+	seen := make(map[string]bool)
+	normalised := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalised = append(normalised, tag)
+	}
+	sort.Strings(normalised)
+	return normalised
+}
+
+// cursorTime formats a timestamp as a cursor key.
+func cursorTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.UTC().Format(time.RFC3339Nano)
+	return &s
+}
+
+// parseCursorTime parses a timestamp cursor key, which is nil when nullable is set and the timestamp was missing.
+func parseCursorTime(s *string, nullable bool) (interface{}, error) {
+	if s == nil {
+		if !nullable {
+			return nil, errInvalidCursor
+		}
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, *s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,636 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Types of the events kept by eventdb.
+const (
+	EventCreated            = "Created"
+	EventDescriptionChanged = "DescriptionChanged"
+	EventCompleted          = "Completed"
+	EventReopened           = "Reopened"
+	EventChanged            = "Changed"
+	EventDeleted            = "Deleted"
+	EventRestored           = "Restored"
+	EventPurged             = "Purged"
+	EventListCreated        = "ListCreated"
+	EventListRenamed        = "ListRenamed"
+	EventListDeleted        = "ListDeleted"
+)
+
+// Event is a change to an item or a list. An event changing an item gives the version it was changed to and
+// when, along with what it was changed to: the whole item for Created and Restored, the new Description for
+// DescriptionChanged, CompletedAt for Completed and the new values of any other fields for Changed. At is the time
+// the item was moved to the trash for Deleted, and the time the change was written for list events.
+type Event struct {
+	Type        string
+	ItemId      string `json:",omitempty"`
+	ListId      string `json:",omitempty"`
+	Version     int    `json:",omitempty"`
+	At          time.Time
+	Item        *Item         `json:",omitempty"`
+	Description string        `json:",omitempty"`
+	CompletedAt *time.Time    `json:",omitempty"`
+	Changes     []FieldChange `json:",omitempty"`
+	Name        string        `json:",omitempty"`
+}
+
+// eventCommit is a line of the event log: the events of one write, which are applied all together or not at all,
+// along with the audit entries the write recorded. Commits are numbered from 1 in the order they were written.
+type eventCommit struct {
+	Seq    uint64
+	At     time.Time
+	Events []Event
+	Audit  []AuditEntry `json:",omitempty"`
+}
+
+// eventSnapshot is the projection of the log up to and including the commit numbered Seq.
+type eventSnapshot struct {
+	Seq  uint64
+	Data *memoryData
+}
+
+// Replayer is implemented by the databases whose state is a projection of a log of events, which replay rebuilds
+// from the whole log.
+type Replayer interface {
+	replay(out io.Writer) error
+}
+
+// TimeTraveller is implemented by the databases which can go back to an earlier state. asOf returns a copy of the
+// database as it was at the given time, which is thrown away once it has been read from.
+type TimeTraveller interface {
+	asOf(ctx context.Context, at time.Time) (Database, error)
+}
+
+// errReplayDone stops readEventLog before the end of the log.
+var errReplayDone = errors.New("replay done")
+
+// eventdb keeps items and lists as a log of the events which changed them, in a file with a line for each write.
+// Everything is read from a projection of the log kept by a memorydb, which is saved to a snapshot next to the log
+// on close so that init only has to replay the commits written since. Idempotency keys are not logged: they are
+// kept by the memorydb, so they are lost on restart.
+type eventdb struct {
+	*memorydb
+	path  string
+	newID idGenerator
+	log   *eventLog
+}
+
+// eventLog is the file the commits are appended to, shared by the copies of an eventdb. seq is the number of the
+// last commit in it and size is where that commit ends.
+type eventLog struct {
+	file *os.File
+	seq  uint64
+	size int64
+}
+
+func (s *eventdb) init() {
+	s.memorydb = &memorydb{newID: s.newID}
+	s.memorydb.init()
+	if err := s.open(true); err != nil {
+		log.Fatalf("Unable to open event log %s: %v", s.path, err)
+	}
+}
+
+// open opens the log and projects it, starting from the snapshot when fromSnapshot is set and there is a valid
+// one. A last line which was not written in full, because the process stopped in the middle of a write, is cut off
+// the log.
+func (s *eventdb) open(fromSnapshot bool) error {
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.log = &eventLog{file: file}
+	var from uint64
+	if fromSnapshot {
+		from = s.loadSnapshot()
+	}
+	s.log.seq, s.log.size, err = readEventLog(file, func(c eventCommit) error {
+		if c.Seq <= from {
+			return nil
+		}
+		return s.data.apply(c)
+	})
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if s.log.seq < from {
+		log.Printf("Snapshot %s is ahead of event log %s, replaying the whole log", s.snapshotPath(), s.path)
+		file.Close()
+		s.memorydb.init()
+		return s.open(false)
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() > s.log.size {
+		log.Printf("Cutting off the last %d bytes of event log %s, which were not written in full", info.Size()-s.log.size, s.path)
+		err = file.Truncate(s.log.size)
+	}
+	if err != nil {
+		file.Close()
+	}
+	return err
+}
+
+// loadSnapshot loads the projection saved by close, returning the number of the last commit in it. An invalid
+// snapshot is ignored, since the log can be replayed from the start instead.
+func (s *eventdb) loadSnapshot() uint64 {
+	b, err := ioutil.ReadFile(s.snapshotPath())
+	if os.IsNotExist(err) {
+		return 0
+	}
+	snapshot := eventSnapshot{Data: s.data}
+	if err == nil {
+		err = json.Unmarshal(b, &snapshot)
+	}
+	if err != nil {
+		log.Printf("Ignoring snapshot %s: %v", s.snapshotPath(), err)
+		s.memorydb.init()
+		return 0
+	}
+	s.data.indexIDs()
+	return snapshot.Seq
+}
+
+func (s *eventdb) snapshotPath() string {
+	return s.path + ".snapshot"
+}
+
+// atomically runs fn with a copy of the projection, then writes the events which turn the projection into the
+// copy to the log as a single commit. The projection is only replaced by the copy once the commit is on disk.
+// Nothing is written once ctx is done.
+func (s *eventdb) atomically(ctx context.Context, fn func(db Database) error) error {
+	defer s.lock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t := *s.memorydb
+	t.data = s.data.copy()
+	t.inTransaction = true
+	if err := fn(&t); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	at := time.Now().UTC()
+	c := eventCommit{At: at, Events: memoryEvents(s.data, t.data, at), Audit: t.data.Audit[len(s.data.Audit):]}
+	if len(c.Events) > 0 || len(c.Audit) > 0 {
+		if err := s.log.append(c); err != nil {
+			return err
+		}
+	}
+	*s.data = *t.data
+	return nil
+}
+
+func (s *eventdb) createItem(ctx context.Context, item Item) (created Item, err error) {
+	err = s.atomically(ctx, func(db Database) error {
+		created, err = db.createItem(ctx, item)
+		return err
+	})
+	return created, err
+}
+
+func (s *eventdb) updateItem(ctx context.Context, id string, td Item) (Item, error) {
+	return s.patchItem(ctx, id, td, updatableFields)
+}
+
+func (s *eventdb) patchItem(ctx context.Context, id string, td Item, fields []string) (patched Item, err error) {
+	err = s.atomically(ctx, func(db Database) error {
+		patched, err = db.patchItem(ctx, id, td, fields)
+		return err
+	})
+	return patched, err
+}
+
+func (s *eventdb) deleteItem(ctx context.Context, id string, version int) error {
+	return s.atomically(ctx, func(db Database) error {
+		return db.deleteItem(ctx, id, version)
+	})
+}
+
+func (s *eventdb) setItemTags(ctx context.Context, id string, tags []string) (tagged Item, err error) {
+	err = s.atomically(ctx, func(db Database) error {
+		tagged, err = db.setItemTags(ctx, id, tags)
+		return err
+	})
+	return tagged, err
+}
+
+func (s *eventdb) createList(ctx context.Context, list List) (created List, err error) {
+	err = s.atomically(ctx, func(db Database) error {
+		created, err = db.createList(ctx, list)
+		return err
+	})
+	return created, err
+}
+
+func (s *eventdb) updateList(ctx context.Context, id string, list List) (updated List, err error) {
+	err = s.atomically(ctx, func(db Database) error {
+		updated, err = db.updateList(ctx, id, list)
+		return err
+	})
+	return updated, err
+}
+
+func (s *eventdb) deleteList(ctx context.Context, id string, cascade bool) error {
+	return s.atomically(ctx, func(db Database) error {
+		return db.deleteList(ctx, id, cascade)
+	})
+}
+
+func (s *eventdb) createSubtask(ctx context.Context, parentId string, item Item) (created Item, err error) {
+	err = s.atomically(ctx, func(db Database) error {
+		created, err = db.createSubtask(ctx, parentId, item)
+		return err
+	})
+	return created, err
+}
+
+func (s *eventdb) restoreItem(ctx context.Context, id string) (restored Item, err error) {
+	err = s.atomically(ctx, func(db Database) error {
+		restored, err = db.restoreItem(ctx, id)
+		return err
+	})
+	return restored, err
+}
+
+func (s *eventdb) purgeItem(ctx context.Context, id string) error {
+	return s.atomically(ctx, func(db Database) error {
+		return db.purgeItem(ctx, id)
+	})
+}
+
+func (s *eventdb) purgeTrash(ctx context.Context, before time.Time) (purged int, err error) {
+	err = s.atomically(ctx, func(db Database) error {
+		purged, err = db.purgeTrash(ctx, before)
+		return err
+	})
+	return purged, err
+}
+
+func (s *eventdb) createItems(ctx context.Context, items []Item, atomic bool) ([]BatchResult, error) {
+	return createItems(ctx, s, items, atomic, s.atomically)
+}
+
+func (s *eventdb) patchItems(ctx context.Context, patches []ItemPatch, atomic bool) ([]BatchResult, error) {
+	return patchItems(ctx, s, patches, atomic, s.atomically)
+}
+
+func (s *eventdb) deleteItems(ctx context.Context, query ItemQuery, atomic bool) ([]BatchResult, error) {
+	return deleteItems(ctx, s, query, atomic, s.atomically)
+}
+
+// auditedBy returns a copy of the database which shares its projection and log, and records the writes it makes
+// as made by actor.
+func (s *eventdb) auditedBy(actor, requestId string) Database {
+	t := *s
+	t.memorydb = s.memorydb.auditedBy(actor, requestId).(*memorydb)
+	return &t
+}
+
+// asOf replays the commits written up to the given time into a new memorydb.
+func (s *eventdb) asOf(ctx context.Context, at time.Time) (Database, error) {
+	defer s.rlock()()
+	past := &memorydb{}
+	past.init()
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	_, _, err = readEventLog(io.LimitReader(file, s.log.size), func(c eventCommit) error {
+		if c.At.After(at) {
+			return errReplayDone
+		}
+		return past.data.apply(c)
+	})
+	if err != nil && err != errReplayDone {
+		return nil, err
+	}
+	return past, nil
+}
+
+// replay rebuilds the projection from the whole log and saves it to the snapshot. It is run while nothing else
+// has the log open, instead of init.
+func (s *eventdb) replay(out io.Writer) error {
+	s.memorydb = &memorydb{newID: s.newID}
+	s.memorydb.init()
+	if err := s.open(false); err != nil {
+		return err
+	}
+	defer s.log.file.Close()
+	if err := saveSnapshot(s.snapshotPath(), eventSnapshot{Seq: s.log.seq, Data: s.data}); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Replayed %d commits: %d items, %d lists and %d items in the trash\n", s.log.seq, len(s.data.Items), len(s.data.Lists), len(s.data.Trash))
+	return nil
+}
+
+// close saves the projection to the snapshot, so that the log does not have to be replayed from the start next
+// time.
+func (s *eventdb) close() {
+	defer s.rlock()()
+	if err := saveSnapshot(s.snapshotPath(), eventSnapshot{Seq: s.log.seq, Data: s.data}); err != nil {
+		log.Printf("Unable to save snapshot %s: %v", s.snapshotPath(), err)
+	}
+	s.log.file.Close()
+}
+
+// append writes a commit to the end of the log as the next one, and syncs it to disk. The log is cut back to where
+// it was when the commit cannot be written in full.
+func (l *eventLog) append(c eventCommit) error {
+	c.Seq = l.seq + 1
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if _, err = l.file.Write(b); err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		l.file.Truncate(l.size)
+		return err
+	}
+	l.seq = c.Seq
+	l.size += int64(len(b))
+	return nil
+}
+
+// readEventLog calls fn with each commit in a log in turn, stopping at the first error it returns. It returns the
+// number of the last commit read and where it ends, which is before the end of the log when its last line was not
+// written in full.
+func readEventLog(r io.Reader, fn func(c eventCommit) error) (seq uint64, size int64, err error) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return seq, size, nil
+		} else if err != nil {
+			return seq, size, err
+		}
+		var c eventCommit
+		if err := json.Unmarshal(line, &c); err != nil {
+			return seq, size, fmt.Errorf("Invalid commit at offset %d: %v", size, err)
+		}
+		if c.Seq != seq+1 {
+			return seq, size, fmt.Errorf("Commit %d at offset %d follows commit %d", c.Seq, size, seq)
+		}
+		if err := fn(c); err != nil {
+			return seq, size, err
+		}
+		seq = c.Seq
+		size += int64(len(line))
+	}
+}
+
+// memoryEvents returns the events which turn one state of the data into another. Lists are created first and
+// deleted last, so that items only ever refer to lists which are there.
+func memoryEvents(before, after *memoryData, at time.Time) []Event {
+	var events, deletedLists []Event
+	listIDs := make(map[uint]bool)
+	for _, lists := range []map[uint]List{before.Lists, after.Lists} {
+		for id := range lists {
+			listIDs[id] = true
+		}
+	}
+	for _, id := range sortedIDs(listIDs) {
+		old, existed := before.Lists[id]
+		l, exists := after.Lists[id]
+		switch {
+		case !existed:
+			events = append(events, Event{Type: EventListCreated, ListId: l.Id, At: at, Name: l.Name})
+		case !exists:
+			deletedLists = append(deletedLists, Event{Type: EventListDeleted, ListId: old.Id, At: at})
+		case old.Name != l.Name:
+			events = append(events, Event{Type: EventListRenamed, ListId: l.Id, At: at, Name: l.Name})
+		}
+	}
+
+	itemIDs := make(map[uint]bool)
+	for _, items := range []map[uint]memoryItem{before.Items, before.Trash, after.Items, after.Trash} {
+		for id := range items {
+			itemIDs[id] = true
+		}
+	}
+	for _, id := range sortedIDs(itemIDs) {
+		events = append(events, itemEvents(before, after, id, at)...)
+	}
+	return append(events, deletedLists...)
+}
+
+// itemEvents returns the events which turn one state of an item into another, where at is when the change was
+// written.
+func itemEvents(before, after *memoryData, id uint, at time.Time) []Event {
+	old, existed := before.Items[id]
+	_, wasTrashed := before.Trash[id]
+	mi, exists := after.Items[id]
+	trashed, isTrashed := after.Trash[id]
+	switch {
+	case exists && wasTrashed:
+		return []Event{itemEvent(EventRestored, mi)}
+	case exists && !existed:
+		return []Event{itemEvent(EventCreated, mi)}
+	case exists:
+		return changeEvents(old, mi)
+	case isTrashed && !wasTrashed:
+		untrashed := trashed
+		untrashed.DeletedAt = nil
+		events := []Event{itemEvent(EventCreated, untrashed)}
+		if existed {
+			events = changeEvents(old, untrashed)
+		}
+		return append(events, Event{Type: EventDeleted, ItemId: trashed.Id, Version: trashed.Version, At: *trashed.DeletedAt})
+	case wasTrashed && !isTrashed:
+		return []Event{{Type: EventPurged, ItemId: before.Trash[id].Id, At: at}}
+	}
+	return nil
+}
+
+// itemEvent returns an event carrying the whole of an item.
+func itemEvent(eventType string, mi memoryItem) Event {
+	item := mi.copy()
+	return Event{Type: eventType, ItemId: item.Id, Version: item.Version, At: item.UpdatedAt, Item: &item}
+}
+
+// changeEvents returns the events which turn one version of an item into another. A change to anything other
+// than its description or whether it is complete is recorded by a Changed event, as is a change to nothing but
+// its version.
+func changeEvents(old, mi memoryItem) []Event {
+	if reflect.DeepEqual(old, mi) {
+		return nil
+	}
+	event := func(e Event) Event {
+		e.ItemId, e.Version, e.At = mi.Id, mi.Version, mi.UpdatedAt
+		return e
+	}
+	var events []Event
+	if old.Description != mi.Description {
+		events = append(events, event(Event{Type: EventDescriptionChanged, Description: mi.Description}))
+	}
+	if !old.Completed && mi.Completed {
+		events = append(events, event(Event{Type: EventCompleted, CompletedAt: mi.CompletedAt}))
+	} else if old.Completed && !mi.Completed {
+		events = append(events, event(Event{Type: EventReopened}))
+	}
+	var changes []FieldChange
+	for _, change := range diffItems(old.Item, mi.Item) {
+		if change.Field != FieldDescription && change.Field != FieldCompleted {
+			changes = append(changes, change)
+		}
+	}
+	if len(changes) > 0 || len(events) == 0 {
+		events = append(events, event(Event{Type: EventChanged, Changes: changes}))
+	}
+	return events
+}
+
+// apply makes the changes of the events in a commit to the data, and appends the audit entries it recorded.
+func (d *memoryData) apply(c eventCommit) error {
+	for _, e := range c.Events {
+		if err := d.applyEvent(e); err != nil {
+			return fmt.Errorf("Commit %d: %v", c.Seq, err)
+		}
+	}
+	for _, entry := range c.Audit {
+		d.Audit = append(d.Audit, entry)
+		d.LastAuditID = uint(memoryID(entry.Id))
+	}
+	return nil
+}
+
+func (d *memoryData) applyEvent(e Event) error {
+	id := uint(memoryID(e.ItemId))
+	if isGeneratedID(e.ItemId) {
+		var ok bool
+		// The key of an item with a generated ID is not part of its events. Items are created in the order of
+		// their keys, so a new item is given the next one.
+		if id, ok = d.keys[e.ItemId]; !ok {
+			id = d.LastItemID + 1
+		}
+	}
+	switch e.Type {
+	case EventCreated, EventRestored:
+		if e.Item == nil {
+			return fmt.Errorf("%s event for item %s has no item", e.Type, e.ItemId)
+		}
+		delete(d.Trash, id)
+		d.Items[id] = memoryItem{Item: *e.Item}
+		if isGeneratedID(e.ItemId) {
+			d.keys[e.ItemId] = id
+		}
+		if id > d.LastItemID {
+			d.LastItemID = id
+		}
+		return nil
+	case EventPurged:
+		delete(d.keys, e.ItemId)
+		delete(d.Items, id)
+		delete(d.Trash, id)
+		return nil
+	case EventListCreated, EventListRenamed:
+		listID := uint(memoryID(e.ListId))
+		d.Lists[listID] = List{Id: e.ListId, Name: e.Name}
+		if listID > d.LastListID {
+			d.LastListID = listID
+		}
+		return nil
+	case EventListDeleted:
+		delete(d.Lists, uint(memoryID(e.ListId)))
+		return nil
+	}
+
+	mi, ok := d.Items[id]
+	if !ok {
+		return fmt.Errorf("%s event for unknown item %s", e.Type, e.ItemId)
+	}
+	switch e.Type {
+	case EventDeleted:
+		deletedAt := e.At
+		mi.DeletedAt = &deletedAt
+		delete(d.Items, id)
+		d.Trash[id] = mi
+		return nil
+	case EventDescriptionChanged:
+		mi.Description = e.Description
+	case EventCompleted:
+		mi.Completed = true
+		mi.CompletedAt = e.CompletedAt
+	case EventReopened:
+		mi.Completed = false
+		mi.CompletedAt = nil
+	case EventChanged:
+		for _, change := range e.Changes {
+			if err := setFieldValue(&mi.Item, change.Field, change.New); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Unknown event type %q", e.Type)
+	}
+	mi.Version = e.Version
+	mi.UpdatedAt = e.At
+	d.Items[id] = mi
+	return nil
+}
+
+// setFieldValue sets a field of an item to a value recorded by auditValue.
+func setFieldValue(item *Item, field string, value json.RawMessage) error {
+	var v interface{}
+	switch field {
+	case FieldNotes:
+		v = &item.Notes
+	case FieldPriority:
+		v = &item.Priority
+	case FieldDueAt:
+		item.DueAt = nil
+		v = &item.DueAt
+	case FieldStartAt:
+		item.StartAt = nil
+		v = &item.StartAt
+	case FieldListId:
+		v = &item.ListId
+	case FieldTags:
+		item.Tags = nil
+		v = &item.Tags
+	case FieldParentId:
+		v = &item.ParentId
+	case FieldPosition:
+		v = &item.Position
+	case FieldCompleteWithSubtasks:
+		v = &item.CompleteWithSubtasks
+	case FieldDescription:
+		v = &item.Description
+	case FieldCompleted:
+		v = &item.Completed
+	default:
+		return &ErrorUnknownField{Field: field}
+	}
+	return json.Unmarshal(value, v)
+}
+
+// sortedIDs returns the IDs in a set in order.
+func sortedIDs(set map[uint]bool) []uint {
+	ids := make([]uint, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
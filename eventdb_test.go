@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// initEventDB opens an event database in a new temporary directory, which the returned function closes and
+// removes.
+func initEventDB(t *testing.T) (*eventdb, func()) {
+	dir, err := ioutil.TempDir("", "todo")
+	assert.NoError(t, err)
+	db := &eventdb{path: filepath.Join(dir, "todo.events")}
+	db.init()
+	return db, func() {
+		db.close()
+		os.RemoveAll(dir)
+	}
+}
+
+// assertReplays checks that replaying the whole log of a database gives the projection it has built up as it was
+// written to.
+func assertReplays(t *testing.T, db *eventdb) {
+	replayed := &memorydb{}
+	replayed.init()
+	file, err := os.Open(db.path)
+	assert.NoError(t, err)
+	defer file.Close()
+	_, _, err = readEventLog(file, replayed.data.apply)
+	assert.NoError(t, err)
+
+	expected, _ := json.Marshal(db.data)
+	actual, _ := json.Marshal(replayed.data)
+	assert.JSONEq(t, string(expected), string(actual))
+}
+
+// eventTypes returns the types of the events in each commit of the log of a database.
+func eventTypes(t *testing.T, db *eventdb) [][]string {
+	file, err := os.Open(db.path)
+	assert.NoError(t, err)
+	defer file.Close()
+	var types [][]string
+	_, _, err = readEventLog(file, func(c eventCommit) error {
+		var commit []string
+		for _, e := range c.Events {
+			commit = append(commit, e.Type)
+		}
+		types = append(types, commit)
+		return nil
+	})
+	assert.NoError(t, err)
+	return types
+}
+
+func Test_eventdb_events(t *testing.T) {
+	db, cleanup := initEventDB(t)
+	defer cleanup()
+
+	list, _ := db.createList(ctx, List{Name: "Shopping"})
+	item, _ := db.createItem(ctx, Item{Description: "Buy milk", ListId: list.Id})
+	db.patchItem(ctx, item.Id, Item{Description: "Buy oat milk", Completed: true}, []string{FieldDescription, FieldCompleted})
+	db.patchItem(ctx, item.Id, Item{Completed: false, Priority: PriorityHigh}, []string{FieldCompleted, FieldPriority})
+	db.setItemTags(ctx, item.Id, []string{"shop"})
+	assertReplays(t, db)
+	db.updateList(ctx, list.Id, List{Name: "Groceries"})
+	db.deleteList(ctx, list.Id, true)
+	db.restoreItem(ctx, item.Id)
+	db.deleteItem(ctx, item.Id, 0)
+	db.purgeItem(ctx, item.Id)
+	_, err := db.patchItem(ctx, item.Id, Item{Notes: "Gone"}, []string{FieldNotes})
+	assert.Error(t, err)
+
+	assert.Equal(t, [][]string{
+		{EventListCreated},
+		{EventCreated},
+		{EventDescriptionChanged, EventCompleted},
+		{EventReopened, EventChanged},
+		{EventChanged},
+		{EventListRenamed},
+		{EventDeleted, EventListDeleted},
+		{EventRestored},
+		{EventDeleted},
+		{EventPurged},
+	}, eventTypes(t, db), "failed writes write nothing")
+	assertReplays(t, db)
+}
+
+func Test_eventdb_batch(t *testing.T) {
+	db, cleanup := initEventDB(t)
+	defer cleanup()
+
+	_, err := db.createItems(ctx, []Item{{Description: "A"}, {Description: "B", ListId: "9"}}, true)
+	assert.Error(t, err)
+	results, err := db.createItems(ctx, []Item{{Description: "A"}, {Description: "B"}}, true)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	assert.Equal(t, [][]string{{EventCreated, EventCreated}}, eventTypes(t, db), "an atomic batch is a single commit")
+}
+
+func Test_eventdb_context_done(t *testing.T) {
+	db, cleanup := initEventDB(t)
+	defer cleanup()
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := db.createItem(cancelled, Item{Description: "Buy milk"})
+	assert.Equal(t, context.Canceled, err)
+	_, err = db.createItems(cancelled, []Item{{Description: "A"}, {Description: "B"}}, true)
+	assert.Equal(t, context.Canceled, err)
+	assert.Empty(t, eventTypes(t, db), "nothing is written once the context is done")
+}
+
+func Test_eventdb_reopen(t *testing.T) {
+	db, cleanup := initEventDB(t)
+	defer cleanup()
+
+	list, _ := db.createList(ctx, List{Name: "Shopping"})
+	db.createItem(ctx, Item{Description: "Buy milk", ListId: list.Id, Tags: []string{"home"}})
+	db.createItem(ctx, Item{Description: "Buy bread"})
+	db.deleteItem(ctx, "2", 0)
+	db.close()
+
+	db.init()
+	db.createItem(ctx, Item{Description: "Buy eggs"})
+	db.close()
+	// Without the snapshot the whole log is replayed.
+	assert.NoError(t, os.Remove(db.snapshotPath()))
+
+	db.init()
+	item, err := db.getItem(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Buy milk", item.Description)
+	assert.Equal(t, list.Id, item.ListId)
+	assert.Equal(t, []string{"home"}, item.Tags)
+	trashed, _ := db.trashedItems(ctx)
+	assert.Len(t, trashed, 1)
+	item, _ = db.createItem(ctx, Item{Description: "Buy butter"})
+	assert.Equal(t, "4", item.Id)
+	entries, _ := db.itemHistory(ctx, "1")
+	assert.Len(t, entries, 1, "the audit log is kept in the event log")
+}
+
+func Test_eventdb_reopen_generated_ids(t *testing.T) {
+	db, cleanup := initEventDB(t)
+	defer cleanup()
+
+	legacy, _ := db.createItem(ctx, Item{Description: "Buy milk"})
+	db.close()
+	newID, _ := newIDGenerator(IDFormatULID)
+	db.newID = newID
+	db.init()
+	created, _ := db.createItem(ctx, Item{Description: "Buy bread"})
+	db.close()
+
+	for _, snapshot := range []bool{true, false} {
+		if !snapshot {
+			assert.NoError(t, os.Remove(db.snapshotPath()))
+		}
+		db.init()
+		item, err := db.getItem(ctx, created.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "Buy bread", item.Description)
+		_, err = db.getItem(ctx, legacy.Id)
+		assert.NoError(t, err, "items created before a generator was chosen keep their IDs")
+		db.close()
+	}
+	assert.Equal(t, "1", legacy.Id)
+}
+
+func Test_eventdb_torn_write(t *testing.T) {
+	db, cleanup := initEventDB(t)
+	defer cleanup()
+
+	db.createItem(ctx, Item{Description: "Buy milk"})
+	db.close()
+	file, err := os.OpenFile(db.path, os.O_WRONLY|os.O_APPEND, 0600)
+	assert.NoError(t, err)
+	file.WriteString(`{"Seq":2,"Events":[{"Type":"Cre`)
+	file.Close()
+
+	db.init()
+	item, err := db.createItem(ctx, Item{Description: "Buy bread"})
+	assert.NoError(t, err)
+	assert.Equal(t, "2", item.Id)
+	assert.Equal(t, [][]string{{EventCreated}, {EventCreated}}, eventTypes(t, db))
+}
+
+func Test_eventdb_replay(t *testing.T) {
+	db, cleanup := initEventDB(t)
+	defer cleanup()
+
+	db.createItem(ctx, Item{Description: "Buy milk"})
+	db.createItem(ctx, Item{Description: "Buy bread"})
+	db.deleteItem(ctx, "2", 0)
+	db.close()
+	assert.NoError(t, ioutil.WriteFile(db.snapshotPath(), []byte(`{"Seq": 3, "Data": {}}`), 0600))
+
+	var out bytes.Buffer
+	assert.NoError(t, db.replay(&out))
+	assert.Equal(t, "Replayed 3 commits: 1 items, 0 lists and 1 items in the trash\n", out.String())
+
+	db.init()
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 1)
+}
+
+func Test_eventdb_asOf(t *testing.T) {
+	db, cleanup := initEventDB(t)
+	defer cleanup()
+
+	before := time.Now()
+	item, _ := db.createItem(ctx, Item{Description: "Buy milk"})
+	created := time.Now()
+	db.patchItem(ctx, item.Id, Item{Description: "Buy oat milk", Completed: true}, []string{FieldDescription, FieldCompleted})
+
+	past, err := db.asOf(ctx, created)
+	assert.NoError(t, err)
+	item, err = past.getItem(ctx, item.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Buy milk", item.Description)
+	assert.False(t, item.Completed)
+	assert.Equal(t, 1, item.Version)
+
+	past, err = db.asOf(ctx, before)
+	assert.NoError(t, err)
+	_, err = past.getItem(ctx, item.Id)
+	assert.Error(t, err)
+}
+
+func TestApplication_getToDoItem_asOf(t *testing.T) {
+	db, cleanup := initEventDB(t)
+	defer cleanup()
+	router := mux.NewRouter()
+	app := &Application{db: db, router: router}
+	app.initRoutes()
+
+	db.createItem(ctx, Item{Description: "Buy milk"})
+	asOf := time.Now().UTC().Format(time.RFC3339Nano)
+	db.patchItem(ctx, "1", Item{Description: "Buy oat milk"}, []string{FieldDescription})
+
+	req, _ := http.NewRequest("GET", "/todo/1?as_of="+asOf, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var item Item
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&item))
+	assert.Equal(t, "Buy milk", item.Description)
+
+	req, _ = http.NewRequest("GET", "/todo/1?as_of=yesterday", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, `{"error":"Invalid as_of value \"yesterday\", expected an RFC 3339 timestamp"}`, rr.Body.String())
+}
+
+func TestApplication_getToDoItem_asOf_not_supported(t *testing.T) {
+	router := mux.NewRouter()
+	app := &Application{db: initMemoryDB(), router: router}
+	app.initRoutes()
+
+	req, _ := http.NewRequest("GET", "/todo/1?as_of=2021-01-01T00:00:00Z", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+	assert.Equal(t, `{"error":"The database does not keep earlier versions of items"}`, rr.Body.String())
+}
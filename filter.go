@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Fields which can be used in filter expressions, in addition to the sortable fields.
+const (
+	FilterByNotes     = "notes"
+	FilterByCompleted = "completed"
+	FilterByList      = "list"
+	FilterByParent    = "parent"
+	FilterByPosition  = "position"
+	FilterByTag       = "tag"
+)
+
+// Filter operators. Contains matches strings containing the value, ignoring case.
+const (
+	FilterEq       = "eq"
+	FilterNe       = "ne"
+	FilterLt       = "lt"
+	FilterLe       = "le"
+	FilterGt       = "gt"
+	FilterGe       = "ge"
+	FilterContains = "contains"
+	FilterAnd      = "and"
+	FilterOr       = "or"
+)
+
+// maxFilterDepth limits how deeply expressions can be nested.
+const maxFilterDepth = 32
+
+type filterKind int
+
+const (
+	filterString filterKind = iota
+	filterBool
+	filterInt
+	filterTime
+	filterID
+	filterTag
+)
+
+type filterField struct {
+	kind     filterKind
+	nullable bool
+}
+
+var filterFields = map[string]filterField{
+	SortById:          {kind: filterID},
+	SortByDescription: {kind: filterString},
+	FilterByNotes:     {kind: filterString},
+	FilterByCompleted: {kind: filterBool},
+	SortByPriority:    {kind: filterInt},
+	SortByDue:         {kind: filterTime, nullable: true},
+	SortByStart:       {kind: filterTime, nullable: true},
+	SortByCreated:     {kind: filterTime},
+	SortByUpdated:     {kind: filterTime},
+	SortByCompletedAt: {kind: filterTime, nullable: true},
+	FilterByList:      {kind: filterID, nullable: true},
+	FilterByParent:    {kind: filterID, nullable: true},
+	FilterByPosition:  {kind: filterInt},
+	FilterByTag:       {kind: filterTag},
+}
+
+var filterFieldNames = []string{SortById, SortByDescription, FilterByNotes, FilterByCompleted, SortByPriority,
+	SortByDue, SortByStart, SortByCreated, SortByUpdated, SortByCompletedAt, FilterByList, FilterByParent,
+	FilterByPosition, FilterByTag}
+
+var filterOperators = map[filterKind][]string{
+	filterString: {FilterEq, FilterNe, FilterLt, FilterLe, FilterGt, FilterGe, FilterContains},
+	filterBool:   {FilterEq, FilterNe},
+	filterInt:    {FilterEq, FilterNe, FilterLt, FilterLe, FilterGt, FilterGe},
+	filterTime:   {FilterEq, FilterNe, FilterLt, FilterLe, FilterGt, FilterGe},
+	filterID:     {FilterEq, FilterNe},
+	filterTag:    {FilterEq, FilterNe},
+}
+
+// FilterExpr is a node of a parsed filter expression: a FilterLogical, FilterNot or FilterComparison.
+type FilterExpr interface {
+	filterExpr()
+}
+
+// FilterLogical combines its operands with FilterAnd or FilterOr.
+type FilterLogical struct {
+	Op       string
+	Operands []FilterExpr
+}
+
+// FilterNot matches the items which its operand does not.
+type FilterNot struct {
+	Operand FilterExpr
+}
+
+// FilterComparison compares a field with a value. The value is a string, bool, int or time.Time depending on
+// the field, or nil for a missing date, list or parent. Comparisons other than FilterNe never match missing
+// values, and a tag comparison matches items carrying (or not carrying) the tag.
+type FilterComparison struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+func (FilterLogical) filterExpr()    {}
+func (FilterNot) filterExpr()        {}
+func (FilterComparison) filterExpr() {}
+
+// FilterError describes what is wrong with a filter expression, and where.
+type FilterError struct {
+	Pos int
+	Msg string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("Invalid filter at position %d: %s", e.Pos, e.Msg)
+}
+
+type filterTokenKind int
+
+const (
+	filterTokenEnd filterTokenKind = iota
+	filterTokenWord
+	filterTokenString
+	filterTokenNumber
+	filterTokenOpen
+	filterTokenClose
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+	pos  int
+}
+
+func (t filterToken) String() string {
+	switch t.kind {
+	case filterTokenEnd:
+		return "end of filter"
+	case filterTokenString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// parseFilter parses a filter expression such as `completed eq false and (priority ge 3 or tag eq "ops")`.
+// Comparisons take the form field operator value, and can be combined with and, or, not and parentheses. Values
+// are double quoted strings, integers, true, false or null. Dates are given as RFC 3339 strings.
+func parseFilter(input string) (FilterExpr, error) {
+	tokens, err := lexFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != filterTokenEnd {
+		return nil, &FilterError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s, expected and, or or the end of filter", t)}
+	}
+	return expr, nil
+}
+
+func lexFilter(input string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, filterToken{kind: filterTokenOpen, text: "(", pos: pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{kind: filterTokenClose, text: ")", pos: pos})
+			i++
+		case r == '"':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, &FilterError{Pos: pos, Msg: "unterminated string"}
+				}
+				if runes[i] == '"' {
+					i++
+					break
+				}
+				if runes[i] == '\\' {
+					if i+1 >= len(runes) || (runes[i+1] != '"' && runes[i+1] != '\\') {
+						return nil, &FilterError{Pos: i + 1, Msg: `invalid escape, expected \" or \\`}
+					}
+					i++
+				}
+				b.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenString, text: b.String(), pos: pos})
+		case r == '-' || unicode.IsDigit(r):
+			start := i
+			for i++; i < len(runes) && unicode.IsDigit(runes[i]); i++ {
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenNumber, text: string(runes[start:i]), pos: pos})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i++; i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_'); i++ {
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenWord, text: string(runes[start:i]), pos: pos})
+		default:
+			return nil, &FilterError{Pos: pos, Msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	return append(tokens, filterToken{kind: filterTokenEnd, pos: len(runes) + 1}), nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	next   int
+	depth  int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.next]
+}
+
+func (p *filterParser) take() filterToken {
+	t := p.tokens[p.next]
+	if t.kind != filterTokenEnd {
+		p.next++
+	}
+	return t
+}
+
+// isWord reports whether the next token is the given keyword, which is matched ignoring case.
+func (p *filterParser) isWord(word string) bool {
+	t := p.peek()
+	return t.kind == filterTokenWord && strings.EqualFold(t.text, word)
+}
+
+func (p *filterParser) parseOr() (FilterExpr, error) {
+	return p.parseLogical(FilterOr, p.parseAnd)
+}
+
+func (p *filterParser) parseAnd() (FilterExpr, error) {
+	return p.parseLogical(FilterAnd, p.parseUnary)
+}
+
+func (p *filterParser) parseLogical(op string, operand func() (FilterExpr, error)) (FilterExpr, error) {
+	expr, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	operands := []FilterExpr{expr}
+	for p.isWord(op) {
+		p.take()
+		expr, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, expr)
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return FilterLogical{Op: op, Operands: operands}, nil
+}
+
+func (p *filterParser) parseUnary() (FilterExpr, error) {
+	t := p.peek()
+	if t.kind == filterTokenOpen || p.isWord("not") {
+		p.depth++
+		if p.depth > maxFilterDepth {
+			return nil, &FilterError{Pos: t.pos, Msg: fmt.Sprintf("expressions cannot be nested more than %d deep", maxFilterDepth)}
+		}
+		defer func() { p.depth-- }()
+	}
+
+	if p.isWord("not") {
+		p.take()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return FilterNot{Operand: expr}, nil
+	}
+	if t.kind == filterTokenOpen {
+		p.take()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.take(); c.kind != filterTokenClose {
+			return nil, &FilterError{Pos: c.pos, Msg: fmt.Sprintf("unexpected %s, expected \")\" to close \"(\" at position %d", c, t.pos)}
+		}
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (FilterExpr, error) {
+	name := p.take()
+	if name.kind != filterTokenWord {
+		return nil, &FilterError{Pos: name.pos, Msg: fmt.Sprintf("unexpected %s, expected a field name", name)}
+	}
+	fieldName := strings.ToLower(name.text)
+	field, ok := filterFields[fieldName]
+	if !ok {
+		return nil, &FilterError{Pos: name.pos, Msg: fmt.Sprintf("unknown field %q, expected one of %s", name.text, strings.Join(filterFieldNames, ", "))}
+	}
+
+	op := p.take()
+	if op.kind != filterTokenWord {
+		return nil, &FilterError{Pos: op.pos, Msg: fmt.Sprintf("unexpected %s, expected an operator after %s", op, fieldName)}
+	}
+	opName := strings.ToLower(op.text)
+	if !isFilterOperator(field.kind, opName) {
+		return nil, &FilterError{Pos: op.pos, Msg: fmt.Sprintf("invalid operator %q for %s, expected one of %s", op.text, fieldName, strings.Join(filterOperators[field.kind], ", "))}
+	}
+
+	value, err := p.parseValue(fieldName, field, opName)
+	if err != nil {
+		return nil, err
+	}
+	return FilterComparison{Field: fieldName, Op: opName, Value: value}, nil
+}
+
+func isFilterOperator(kind filterKind, op string) bool {
+	for _, o := range filterOperators[kind] {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *filterParser) parseValue(fieldName string, field filterField, op string) (interface{}, error) {
+	t := p.take()
+	if t.kind == filterTokenWord && strings.EqualFold(t.text, "null") {
+		if !field.nullable {
+			return nil, &FilterError{Pos: t.pos, Msg: fmt.Sprintf("%s cannot be null", fieldName)}
+		}
+		if op != FilterEq && op != FilterNe {
+			return nil, &FilterError{Pos: t.pos, Msg: "null can only be compared with eq or ne"}
+		}
+		return nil, nil
+	}
+
+	switch field.kind {
+	case filterBool:
+		if t.kind == filterTokenWord && (strings.EqualFold(t.text, "true") || strings.EqualFold(t.text, "false")) {
+			return strings.EqualFold(t.text, "true"), nil
+		}
+		return nil, &FilterError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s, expected true or false for %s", t, fieldName)}
+	case filterInt:
+		if t.kind == filterTokenNumber {
+			if v, err := strconv.Atoi(t.text); err == nil {
+				return v, nil
+			}
+		}
+		return nil, &FilterError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s, expected an integer for %s", t, fieldName)}
+	case filterTime:
+		if t.kind == filterTokenString {
+			if v, err := time.Parse(time.RFC3339, t.text); err == nil {
+				return v.UTC(), nil
+			}
+		}
+		return nil, &FilterError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s, expected an RFC 3339 timestamp string for %s", t, fieldName)}
+	case filterTag:
+		if t.kind == filterTokenString {
+			return strings.TrimSpace(t.text), nil
+		}
+		return nil, &FilterError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s, expected a string for %s", t, fieldName)}
+	default:
+		if t.kind == filterTokenString {
+			return t.text, nil
+		}
+		return nil, &FilterError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s, expected a string for %s", t, fieldName)}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseFilter(t *testing.T) {
+	due := time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	var filterTests = []struct {
+		filter   string
+		expected FilterExpr
+	}{
+		{`completed eq false`, FilterComparison{Field: "completed", Op: "eq", Value: false}},
+		{`Priority GE -1`, FilterComparison{Field: "priority", Op: "ge", Value: -1}},
+		{`due lt "2020-05-10T14:00:00+02:00"`, FilterComparison{Field: "due", Op: "lt", Value: due}},
+		{`list eq null`, FilterComparison{Field: "list", Op: "eq", Value: nil}},
+		{`description contains "say \"hi\" \\ wave"`, FilterComparison{Field: "description", Op: "contains", Value: `say "hi" \ wave`}},
+		{`tag ne " ops "`, FilterComparison{Field: "tag", Op: "ne", Value: "ops"}},
+		{`completed eq false and (priority ge 3 or tag eq "ops")`, FilterLogical{Op: "and", Operands: []FilterExpr{
+			FilterComparison{Field: "completed", Op: "eq", Value: false},
+			FilterLogical{Op: "or", Operands: []FilterExpr{
+				FilterComparison{Field: "priority", Op: "ge", Value: 3},
+				FilterComparison{Field: "tag", Op: "eq", Value: "ops"},
+			}},
+		}}},
+		{`id eq "1" or id eq "2" and not not position gt 0`, FilterLogical{Op: "or", Operands: []FilterExpr{
+			FilterComparison{Field: "id", Op: "eq", Value: "1"},
+			FilterLogical{Op: "and", Operands: []FilterExpr{
+				FilterComparison{Field: "id", Op: "eq", Value: "2"},
+				FilterNot{Operand: FilterNot{Operand: FilterComparison{Field: "position", Op: "gt", Value: 0}}},
+			}},
+		}}},
+	}
+
+	for _, tt := range filterTests {
+		t.Run(tt.filter, func(t *testing.T) {
+			expr, err := parseFilter(tt.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+}
+
+func Test_parseFilter_errors(t *testing.T) {
+	var filterTests = []struct {
+		filter   string
+		expected string
+	}{
+		{``, `Invalid filter at position 1: unexpected end of filter, expected a field name`},
+		{`size eq 1`, `Invalid filter at position 1: unknown field "size", expected one of id, description, notes, completed, priority, due, start, created, updated, completed_at, list, parent, position, tag`},
+		{`priority`, `Invalid filter at position 9: unexpected end of filter, expected an operator after priority`},
+		{`completed lt true`, `Invalid filter at position 11: invalid operator "lt" for completed, expected one of eq, ne`},
+		{`completed eq yes`, `Invalid filter at position 14: unexpected "yes", expected true or false for completed`},
+		{`priority eq "3"`, `Invalid filter at position 13: unexpected "3", expected an integer for priority`},
+		{`due lt "tomorrow"`, `Invalid filter at position 8: unexpected "tomorrow", expected an RFC 3339 timestamp string for due`},
+		{`due lt null`, `Invalid filter at position 8: null can only be compared with eq or ne`},
+		{`tag eq null`, `Invalid filter at position 8: tag cannot be null`},
+		{`description eq "abc`, `Invalid filter at position 16: unterminated string`},
+		{`description eq "a\b"`, `Invalid filter at position 18: invalid escape, expected \" or \\`},
+		{`completed eq true & priority gt 1`, `Invalid filter at position 19: unexpected character '&'`},
+		{`(completed eq true`, `Invalid filter at position 19: unexpected end of filter, expected ")" to close "(" at position 1`},
+		{`completed eq true priority gt 1`, `Invalid filter at position 19: unexpected "priority", expected and, or or the end of filter`},
+		{`completed eq true and`, `Invalid filter at position 22: unexpected end of filter, expected a field name`},
+		{strings.Repeat("(", 40) + "completed eq true" + strings.Repeat(")", 40), `Invalid filter at position 33: expressions cannot be nested more than 32 deep`},
+	}
+
+	for _, tt := range filterTests {
+		t.Run(tt.filter, func(t *testing.T) {
+			_, err := parseFilter(tt.filter)
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+}
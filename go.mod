@@ -1,10 +1,12 @@
 module todo-go
 
-go 1.14
+go 1.16
 
 require (
 	github.com/gorilla/mux v1.7.4
 	github.com/jinzhu/gorm v1.9.12
+	github.com/lib/pq v1.1.1 // indirect
 	github.com/stretchr/testify v1.6.1
+	go.etcd.io/bbolt v1.3.5
 	go.mongodb.org/mongo-driver v1.5.1
 )
@@ -14,15 +14,15 @@ contains() {
 contains $1 "amd64"
 if [ $? -eq 0 ]
 then
-  go build -o todo-api -v
+  env CGO_ENABLED=0 GOOS=linux GOARCH=amd64 \
+      go build -v -o todo-api
   exit
 fi
 
 contains $1 "arm/v7"
 if [ $? -eq 0 ]
 then
-  env CC=arm-linux-gnueabihf-gcc CXX=arm-linux-gnueabihf-g++ \
-      CGO_ENABLED=1 GOOS=linux GOARCH=arm GOARM=7 \
+  env CGO_ENABLED=0 GOOS=linux GOARCH=arm GOARM=7 \
       go build -v -o todo-api
   exit
 fi
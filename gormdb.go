@@ -1,101 +1,1708 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"log"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// gormMigrationFiles holds the SQL of each migration for each dialect, in files named after the migration's
+// version and name, such as migrations/sqlite3/0001_create_tables.up.sql and its down counterpart.
+//
+//go:embed migrations
+var gormMigrationFiles embed.FS
+
+// gormTimestampTypes are the column types used for the times migrations were applied.
+var gormTimestampTypes = map[string]string{
+	"sqlite3":  "datetime",
+	"mysql":    "DATETIME",
+	"postgres": "timestamp with time zone",
+}
+
+// The gormAdopted models are the tables as gorm's AutoMigrate last made them, which is the schema the first
+// migration creates. Databases made by AutoMigrate before that hold earlier versions of them, which are brought
+// up to it before the first migration is recorded.
+type gormAdoptedItem struct {
+	gorm.Model
+	Description          string
+	Notes                string `gorm:"type:text"`
+	Completed            bool
+	Priority             int
+	DueAt                *time.Time
+	StartAt              *time.Time
+	ListID               *uint `gorm:"index"`
+	ParentID             *uint `gorm:"index"`
+	Position             int
+	CompleteWithSubtasks bool
+	Version              int `gorm:"not null;default:1"`
+}
+
+func (gormAdoptedItem) TableName() string { return "gorm_items" }
+
+type gormAdoptedTag struct {
+	ID   uint
+	Name string `gorm:"unique_index"`
+}
+
+func (gormAdoptedTag) TableName() string { return "gorm_tags" }
+
+type gormAdoptedList struct {
+	gorm.Model
+	Name string
+}
+
+func (gormAdoptedList) TableName() string { return "gorm_lists" }
+
+type gormAdoptedIdempotencyKey struct {
+	Key         string `gorm:"primary_key;column:idempotency_key"`
+	Fingerprint string
+	Status      int
+	ETag        string
+	Body        []byte    `gorm:"size:4294967295"`
+	ExpiresAt   time.Time `gorm:"index"`
+}
+
+func (gormAdoptedIdempotencyKey) TableName() string { return "gorm_idempotency_keys" }
+
+var gormSortColumns = map[string]string{
+	SortById:          "id",
+	SortByDescription: "description",
+	SortByPriority:    "priority",
+	SortByDue:         "due_at",
+	SortByStart:       "start_at",
+	SortByCreated:     "created_at",
+	SortByUpdated:     "updated_at",
+	SortByCompletedAt: "completed_at",
+}
+
+var gormFilterColumns = map[string]string{
+	SortById:          "id",
+	SortByDescription: "description",
+	FilterByNotes:     "notes",
+	FilterByCompleted: "completed",
+	SortByPriority:    "priority",
+	SortByDue:         "due_at",
+	SortByStart:       "start_at",
+	SortByCreated:     "created_at",
+	SortByUpdated:     "updated_at",
+	SortByCompletedAt: "completed_at",
+	FilterByList:      "list_id",
+	FilterByParent:    "parent_id",
+	FilterByPosition:  "position",
+}
+
+var gormFilterOperators = map[string]string{
+	FilterEq: "=",
+	FilterNe: "<>",
+	FilterLt: "<",
+	FilterLe: "<=",
+	FilterGt: ">",
+	FilterGe: ">=",
+}
+
+// Full-text search strategies, depending on what the database supports.
+const (
+	gormSearchScan = iota
+	gormSearchFTS5
+	gormSearchFulltext
+	gormSearchTSVector
+)
+
+// gormSearchVector is the text searched in Postgres, which uses the simple configuration to match words as they
+// are, like the other backends.
+const gormSearchVector = "to_tsvector('simple', COALESCE(description, '') || ' ' || COALESCE(notes, ''))"
+
+// gormSearchTriggers keep the sqlite3 FTS5 table in step with the items, so that every way of writing an item
+// updates the index in the same transaction. Soft deleted items are removed from the index.
+var gormSearchTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS item_search_insert AFTER INSERT ON gorm_items WHEN new.deleted_at IS NULL BEGIN
+		INSERT INTO item_search (rowid, description, notes) VALUES (new.id, new.description, new.notes);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS item_search_update AFTER UPDATE ON gorm_items BEGIN
+		DELETE FROM item_search WHERE rowid = old.id;
+		INSERT INTO item_search (rowid, description, notes) SELECT new.id, new.description, new.notes WHERE new.deleted_at IS NULL;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS item_search_delete AFTER DELETE ON gorm_items BEGIN
+		DELETE FROM item_search WHERE rowid = old.id;
+	END`,
+}
+
 type gormdb struct {
-	db *gorm.DB
-	dialect string
+	auditor
+	db               *gorm.DB
+	dialect          string
 	connectionString string
+	// autoMigrate applies pending migrations on init, which otherwise refuses to use an out of date schema.
+	autoMigrate bool
+	search      int
+	// newID gives out the IDs of new items, which are kept in the uid column. Items are given their numeric ID when
+	// it is nil.
+	newID idGenerator
+	// inTransaction is set on copies made by atomically, whose db is a transaction.
+	inTransaction bool
+	// clock gives the time written to timestamps, which is gormNow when it is nil.
+	clock func() time.Time
+}
+
+// gormContext is the setting under which the gorm.DB made for a call by with keeps the context of the call.
+const gormContext = "todo:context"
+
+// gormQueryer is the part of both sql.DB and sql.Tx which gormCheckContext fails row queries with.
+type gormQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 func (s *gormdb) init() {
+	if s.db == nil {
+		s.open()
+	}
+	if err := checkSchema(s, s.autoMigrate); err != nil {
+		panic(err.Error())
+	}
+	s.initSearch()
+}
+
+func (s *gormdb) open() {
 	gormdb, err := gorm.Open(s.dialect, s.connectionString)
 	if err != nil {
 		fmt.Println(err)
 		panic(fmt.Sprintf("failed to connect to %s Database with connection string %s", s.dialect, s.connectionString))
 	}
-	s.db = gormdb
-	s.db.AutoMigrate(&GormItem{})
+	s.db = s.configure(gormdb)
 }
 
-func (s *gormdb) ping() error {
-	return s.db.DB().Ping()
+// configure applies the settings of the gorm.DB opened by open, which those made for each call by with share.
+// Statements made with a context which is done are given up on before they are sent.
+func (s *gormdb) configure(db *gorm.DB) *gorm.DB {
+	callbacks := db.Callback()
+	callbacks.Create().Before("gorm:begin_transaction").Register(gormContext, gormCheckContext)
+	callbacks.Update().Before("gorm:begin_transaction").Register(gormContext, gormCheckContext)
+	callbacks.Delete().Before("gorm:begin_transaction").Register(gormContext, gormCheckContext)
+	callbacks.Query().Before("gorm:query").Register(gormContext, gormCheckContext)
+	callbacks.RowQuery().Before("gorm:row_query").Register(gormContext, gormCheckContext)
+	return db.SetNowFuncOverride(s.now)
 }
 
-func (s *gormdb) createItem(item Item) (Item, error) {
-	gtd := &GormItem{Description: item.Description, Completed: item.Completed}
-	if err := s.db.Create(gtd).Error; err != nil {
-		return Item{}, err
+// gormCheckContext fails a statement whose context is done. Row queries are failed through their result, as gorm
+// leaves it to whoever made them to look at their error.
+func gormCheckContext(scope *gorm.Scope) {
+	v, ok := scope.Get(gormContext)
+	if !ok {
+		return
+	}
+	err := v.(context.Context).Err()
+	if err == nil {
+		return
+	}
+	scope.Err(err)
+	scope.SkipLeft()
+	if result, ok := scope.InstanceGet("row_query_result"); ok {
+		if rows, ok := result.(*gorm.RowsQueryResult); ok {
+			rows.Error = err
+		} else if row, ok := result.(*gorm.RowQueryResult); ok {
+			// A sql.Row can only be given an error by a query, which fails straight away with ctx.
+			row.Row = scope.SQLDB().(gormQueryer).QueryRowContext(v.(context.Context), scope.SQL)
+		}
 	}
-	id := strconv.FormatUint(uint64(gtd.ID), 10)
-	return Item{Description: gtd.Description, Completed: gtd.Completed, Id: id}, nil
 }
 
-func (s *gormdb) updateItem(id string, td Item) (Item, error) {
-	uintId, err := strconv.ParseUint(id, 10, 64)
+// now is the time written to timestamps, both those gorm sets and those set by hand.
+func (s *gormdb) now() time.Time {
+	if s.clock != nil {
+		return s.clock()
+	}
+	return gormNow()
+}
+
+// gormNow is the clock of a database which has not been given one. Timestamps are stored in UTC so that they compare
+// correctly with each other.
+func gormNow() time.Time {
+	return time.Now().UTC()
+}
+
+// migrations reads the migrations for the dialect from the embedded files, each of which must have both an up
+// and a down file.
+func (s *gormdb) migrations() []Migration {
+	dir := path.Join("migrations", s.dialect)
+	entries, err := gormMigrationFiles.ReadDir(dir)
+	if err != nil {
+		panic(fmt.Sprintf("no migrations for %s: %v", s.dialect, err))
+	}
+	directions := make(map[Migration]int)
+	for _, entry := range entries {
+		var version int
+		var rest string
+		if _, err := fmt.Sscanf(entry.Name(), "%04d_%s", &version, &rest); err != nil {
+			panic(fmt.Sprintf("invalid migration file name %s", path.Join(dir, entry.Name())))
+		}
+		switch {
+		case strings.HasSuffix(rest, ".up.sql"):
+			directions[Migration{Version: version, Name: strings.TrimSuffix(rest, ".up.sql")}]++
+		case strings.HasSuffix(rest, ".down.sql"):
+			directions[Migration{Version: version, Name: strings.TrimSuffix(rest, ".down.sql")}]++
+		default:
+			panic(fmt.Sprintf("invalid migration file name %s", path.Join(dir, entry.Name())))
+		}
+	}
+	migrations := make([]Migration, 0, len(directions))
+	for migration, count := range directions {
+		if count != 2 {
+			panic(fmt.Sprintf("migration %04d_%s for %s needs both an up and a down file", migration.Version, migration.Name, s.dialect))
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations
+}
+
+func (s *gormdb) appliedMigrations() (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+	if !s.db.HasTable("schema_migrations") {
+		return applied, nil
+	}
+	rows, err := s.db.Raw("SELECT version, applied_at FROM schema_migrations").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// applyMigration runs the statements of a migration in a transaction, along with recording it in the
+// schema_migrations table. MySQL commits each change to the schema straight away, so a migration which fails
+// part way through there has to be tidied up by hand.
+func (s *gormdb) applyMigration(migration Migration, up bool) error {
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+	name := fmt.Sprintf("%04d_%s.%s.sql", migration.Version, migration.Name, direction)
+	sql, err := gormMigrationFiles.ReadFile(path.Join("migrations", s.dialect, name))
+	if err != nil {
+		return err
+	}
+	create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS schema_migrations (version integer NOT NULL, name varchar(255) NOT NULL, applied_at %s NOT NULL, PRIMARY KEY (version))", gormTimestampTypes[s.dialect])
+	if err := s.db.Exec(create).Error; err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if up && migration.Version == 1 {
+			if err := adoptTables(tx); err != nil {
+				return err
+			}
+		}
+		for _, statement := range sqlStatements(string(sql)) {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		if up {
+			return tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", migration.Version, migration.Name, s.now()).Error
+		}
+		return tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version).Error
+	})
+}
+
+// adoptTables adds the columns and indexes missing from the tables of a database made by AutoMigrate, so that the
+// first migration finds them as it would have created them. Tables which do not exist are left to the migration.
+func adoptTables(tx *gorm.DB) error {
+	for _, model := range []interface{}{&gormAdoptedItem{}, &gormAdoptedTag{}, &gormAdoptedList{}, &gormAdoptedIdempotencyKey{}} {
+		if !tx.HasTable(model) {
+			continue
+		}
+		if err := tx.AutoMigrate(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqlStatements splits the SQL of a migration into its statements, each of which ends with a semicolon at the end
+// of a line. Lines starting with -- are comments.
+func sqlStatements(sql string) []string {
+	var statements []string
+	var statement strings.Builder
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		statement.WriteString(line)
+		statement.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(statement.String()))
+			statement.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(statement.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// initSearch creates the full-text index used by searchItems. sqlite3 needs to be built with the sqlite_fts5 tag
+// for FTS5, without which items are scanned instead.
+func (s *gormdb) initSearch() {
+	switch s.dialect {
+	case "sqlite3":
+		var fts5 bool
+		if err := s.db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Row().Scan(&fts5); err != nil || !fts5 {
+			log.Print("Full-text index unavailable without FTS5, searches will scan all items")
+			return
+		}
+		exists := s.db.HasTable("item_search")
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS item_search USING fts5(description, notes, tokenize = "unicode61 remove_diacritics 0")`).Error; err != nil {
+				return err
+			}
+			for _, trigger := range gormSearchTriggers {
+				if err := tx.Exec(trigger).Error; err != nil {
+					return err
+				}
+			}
+			if exists {
+				return nil
+			}
+			return tx.Exec("INSERT INTO item_search (rowid, description, notes) SELECT id, description, notes FROM gorm_items WHERE deleted_at IS NULL").Error
+		})
+		if err != nil {
+			log.Printf("Full-text index unavailable, searches will scan all items: %v", err)
+			return
+		}
+		s.search = gormSearchFTS5
+	case "mysql":
+		if !s.db.Dialect().HasIndex("gorm_items", "idx_gorm_items_search") {
+			if err := s.db.Exec("ALTER TABLE gorm_items ADD FULLTEXT INDEX idx_gorm_items_search (description, notes)").Error; err != nil {
+				log.Printf("Full-text index unavailable, searches will scan all items: %v", err)
+				return
+			}
+		}
+		s.search = gormSearchFulltext
+	case "postgres":
+		err := s.db.Exec("CREATE INDEX IF NOT EXISTS idx_gorm_items_search ON gorm_items USING GIN (" + gormSearchVector + ")").Error
+		if err != nil {
+			log.Printf("Full-text index unavailable, searches will scan all items: %v", err)
+			return
+		}
+		s.search = gormSearchTSVector
+	}
+}
+
+// with returns a copy of the database which makes its statements with ctx, or the database itself when it is a
+// copy made by atomically, whose statements are already made with the context of the call which made it.
+func (s *gormdb) with(ctx context.Context) *gormdb {
+	if s.inTransaction {
+		return s
+	}
+	t := *s
+	t.db = s.db.New().Set(gormContext, ctx)
+	return &t
+}
+
+func (s *gormdb) ping(ctx context.Context) error {
+	return s.db.DB().PingContext(ctx)
+}
+
+func (s *gormdb) createItem(ctx context.Context, item Item) (Item, error) {
+	s = s.with(ctx)
+	listID, err := s.listID(item.ListId)
+	if err != nil {
+		return Item{}, err
+	}
+	parentID, parentUID, err := s.parentID(item.ParentId)
+	if err != nil {
+		return Item{}, err
+	}
+	gtd := &GormItem{
+		Description:          item.Description,
+		Notes:                item.Notes,
+		Completed:            item.Completed,
+		Priority:             item.Priority,
+		DueAt:                utc(item.DueAt),
+		StartAt:              utc(item.StartAt),
+		ListID:               listID,
+		ParentID:             parentID,
+		ParentUID:            parentUID,
+		Position:             item.Position,
+		CompleteWithSubtasks: item.CompleteWithSubtasks,
+		Version:              1,
+	}
+	if item.Completed {
+		now := s.now()
+		gtd.CompletedAt = &now
+	}
+	if s.newID != nil {
+		uid := s.newID()
+		gtd.UID = &uid
+	}
+	err = s.transaction(func(tx *gorm.DB) error {
+		tags, err := findOrCreateTags(tx, item.Tags)
+		if err != nil {
+			return err
+		}
+		gtd.Tags = tags
+		if err := tx.Create(gtd).Error; err != nil {
+			return err
+		}
+		return gormAudit(tx, s.entry(AuditCreate, Item{}, gtd.toItem(), gtd.CreatedAt))
+	})
 	if err != nil {
+		return Item{}, err
+	}
+	return gtd.toItem(), nil
+}
+
+func (s *gormdb) updateItem(ctx context.Context, id string, td Item) (Item, error) {
+	return s.patchItem(ctx, id, td, updatableFields)
+}
+
+// patchItem only writes the fields of td named in the field mask, leaving the others as they are.
+func (s *gormdb) patchItem(ctx context.Context, id string, td Item, fields []string) (Item, error) {
+	s = s.with(ctx)
+	where, ok := gormWhereID(s.db, id)
+	if !ok {
 		return Item{}, errors.New("Invalid ID type.")
 	}
 	var gtd GormItem
-	if err := s.db.First(&gtd, uintId).Error; gorm.IsRecordNotFoundError(err) {
+	if err := where.First(&gtd).Error; gorm.IsRecordNotFoundError(err) {
 		return Item{}, &ErrorItemNotFound{Id: id}
 	} else if err != nil {
 		return Item{}, err
 	}
-	s.db.Model(&gtd).Update("Completed", td.Completed).Update("Description", td.Description)
-	return Item{Description: gtd.Description, Completed: gtd.Completed, Id: id}, nil
-}
 
-func (s *gormdb) deleteItem(id string) error {
-	uintId, err := strconv.ParseUint(id, 10, 64)
+	updates := map[string]interface{}{"Version": gorm.Expr("version + 1")}
+	setTags := false
+	for _, field := range fields {
+		switch field {
+		case FieldDescription:
+			updates["Description"] = td.Description
+		case FieldNotes:
+			updates["Notes"] = td.Notes
+		case FieldCompleted:
+			updates["Completed"] = td.Completed
+			// Completing an item which is complete already leaves the time it was completed alone.
+			if td.Completed {
+				updates["CompletedAt"] = gorm.Expr("COALESCE(completed_at, ?)", s.now())
+			} else {
+				updates["CompletedAt"] = nil
+			}
+		case FieldPriority:
+			updates["Priority"] = td.Priority
+		case FieldDueAt:
+			updates["DueAt"] = utc(td.DueAt)
+		case FieldStartAt:
+			updates["StartAt"] = utc(td.StartAt)
+		case FieldListId:
+			listID, err := s.listID(td.ListId)
+			if err != nil {
+				return Item{}, err
+			}
+			updates["ListID"] = listID
+		case FieldParentId:
+			parentID, parentUID, err := s.parentID(td.ParentId)
+			if err != nil {
+				return Item{}, err
+			}
+			updates["ParentID"] = parentID
+			updates["ParentUID"] = parentUID
+		case FieldPosition:
+			updates["Position"] = td.Position
+		case FieldCompleteWithSubtasks:
+			updates["CompleteWithSubtasks"] = td.CompleteWithSubtasks
+		case FieldTags:
+			setTags = true
+		default:
+			return Item{}, &ErrorUnknownField{Field: field}
+		}
+	}
+
+	var patched GormItem
+	err := s.transaction(func(tx *gorm.DB) error {
+		var before GormItem
+		if err := tx.Preload("Tags").First(&before, gtd.ID).Error; gorm.IsRecordNotFoundError(err) {
+			return &ErrorItemNotFound{Id: id}
+		} else if err != nil {
+			return err
+		}
+		// The version is checked and incremented by the same statement, so concurrent writes cannot both succeed.
+		update := tx.Model(&GormItem{}).Where("id = ?", gtd.ID)
+		if td.Version != 0 {
+			update = update.Where("version = ?", td.Version)
+		}
+		if result := update.Updates(updates); result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return &ErrorVersionMismatch{Id: id}
+		}
+		if setTags {
+			gtags, err := findOrCreateTags(tx, td.Tags)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&gtd).Association("Tags").Replace(gtags).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Preload("Tags").First(&patched, gtd.ID).Error; err != nil {
+			return err
+		}
+		return gormAudit(tx, s.entry(AuditUpdate, before.toItem(), patched.toItem(), patched.UpdatedAt))
+	})
 	if err != nil {
+		return Item{}, err
+	}
+	return patched.toItem(), nil
+}
+
+func (s *gormdb) deleteItem(ctx context.Context, id string, version int) error {
+	s = s.with(ctx)
+	where, ok := gormWhereID(s.db, id)
+	if !ok {
 		return errors.New("Invalid ID type.")
 	}
 	var gtd GormItem
-	if err := s.db.First(&gtd, uintId).Error; gorm.IsRecordNotFoundError(err) {
+	if err := where.First(&gtd).Error; gorm.IsRecordNotFoundError(err) {
 		return &ErrorItemNotFound{Id: id}
 	} else if err != nil {
 		return err
 	}
-	s.db.Delete(&gtd)
+	// Items are moved to the trash by setting deleted_at, which gorm leaves out of every other query.
+	deletedAt := s.now()
+	return s.transaction(func(tx *gorm.DB) error {
+		if version != 0 {
+			result := tx.Model(&GormItem{}).Where("id = ? AND version = ?", gtd.ID, version).UpdateColumn("deleted_at", deletedAt)
+			if result.Error != nil {
+				return result.Error
+			} else if result.RowsAffected == 0 {
+				return &ErrorVersionMismatch{Id: id}
+			}
+		}
+		if err := gormTrash(tx, []uint{gtd.ID}, deletedAt); err != nil {
+			return err
+		}
+		return gormAudit(tx, s.entry(AuditDelete, gtd.toItem(), Item{}, deletedAt))
+	})
+}
+
+// gormAudit appends an entry to the audit log.
+func gormAudit(tx *gorm.DB, entry AuditEntry) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&GormAuditEntry{
+		ItemID:     entry.ItemId,
+		Action:     entry.Action,
+		Version:    entry.Version,
+		Actor:      entry.Actor,
+		RequestID:  entry.RequestId,
+		Changes:    string(changes),
+		RecordedAt: entry.At,
+	}).Error
+}
+
+// gormTrash moves items to the trash along with their subtasks, level by level. They are all given the same
+// deletion time, so that they can be restored together.
+func gormTrash(tx *gorm.DB, ids []uint, deletedAt time.Time) error {
+	for len(ids) > 0 {
+		var children []uint
+		if err := tx.Model(&GormItem{}).Where("parent_id IN (?)", ids).Pluck("id", &children).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&GormItem{}).Where("id IN (?)", ids).UpdateColumn("deleted_at", deletedAt).Error; err != nil {
+			return err
+		}
+		ids = children
+	}
 	return nil
 }
 
-func (s *gormdb) getItem(id string) (Item, error) {
-	uintId, err := strconv.ParseUint(id, 10, 64)
+func (s *gormdb) getItem(ctx context.Context, id string) (Item, error) {
+	s = s.with(ctx)
+	where, ok := gormWhereID(s.db, id)
+	if !ok {
+		return Item{}, errors.New("Invalid ID type.")
+	}
+	var gtd GormItem
+	if err := where.Preload("Tags").First(&gtd).Error; gorm.IsRecordNotFoundError(err) {
+		return Item{}, &ErrorItemNotFound{Id: id}
+	} else if err != nil {
+		return Item{}, err
+	}
+	return gtd.toItem(), nil
+}
+
+func (s *gormdb) allItems(ctx context.Context) ([]Item, error) {
+	s = s.with(ctx)
+	var gtds []GormItem
+	if err := s.db.Preload("Tags").Order("id").Find(&gtds).Error; err != nil {
+		return make([]Item, 0), err
+	}
+
+	return toItems(gtds), nil
+}
+
+func (s *gormdb) findItems(ctx context.Context, query ItemQuery) ([]Item, error) {
+	s = s.with(ctx)
+	tx, err := s.filter(query)
+	if err != nil {
+		return make([]Item, 0), err
+	}
+	for _, k := range gormSortKeys(query.Sort) {
+		tx = tx.Order(k.order(false, s.dialect))
+	}
+
+	var gtds []GormItem
+	if err := tx.Preload("Tags").Find(&gtds).Error; err != nil {
+		return make([]Item, 0), err
+	}
+	return toItems(gtds), nil
+}
+
+func (s *gormdb) findItemPage(ctx context.Context, query ItemQuery, page PageRequest) (ItemPage, error) {
+	s = s.with(ctx)
+	tx, err := s.filter(query)
 	if err != nil {
+		return ItemPage{Items: make([]Item, 0)}, err
+	}
+	var result ItemPage
+	if page.Total {
+		if err := tx.Model(&GormItem{}).Count(&result.Total).Error; err != nil {
+			return ItemPage{Items: make([]Item, 0)}, err
+		}
+	}
+
+	keys := gormSortKeys(query.Sort)
+	before := page.Cursor != nil && page.Cursor.Before
+	if page.Cursor != nil {
+		condition, args, err := gormKeyset(keys, *page.Cursor)
+		if err != nil {
+			return ItemPage{Items: make([]Item, 0)}, err
+		}
+		tx = tx.Where(condition, args...)
+	}
+	for _, k := range keys {
+		tx = tx.Order(k.order(before, s.dialect))
+	}
+
+	// One item more than the limit is fetched to find out whether there is a further page.
+	var gtds []GormItem
+	if err := tx.Preload("Tags").Limit(page.Limit + 1).Find(&gtds).Error; err != nil {
+		return ItemPage{Items: make([]Item, 0)}, err
+	}
+	more := len(gtds) > page.Limit
+	if more {
+		gtds = gtds[:page.Limit]
+	}
+	if before {
+		for i, j := 0, len(gtds)-1; i < j; i, j = i+1, j-1 {
+			gtds[i], gtds[j] = gtds[j], gtds[i]
+		}
+	}
+
+	result.Items = toItems(gtds)
+	if len(gtds) > 0 {
+		if before || more {
+			result.Next = &Cursor{Keys: gormCursorKeys(gtds[len(gtds)-1], keys)}
+		}
+		if before && more || !before && page.Cursor != nil {
+			result.Prev = &Cursor{Keys: gormCursorKeys(gtds[0], keys), Before: true}
+		}
+	}
+	return result, nil
+}
+
+func (s *gormdb) searchItems(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	s = s.with(ctx)
+	terms := searchTerms(q)
+	if len(terms) == 0 {
+		return make([]SearchResult, 0), nil
+	}
+	var scores []struct {
+		ID    uint
+		Score float64
+	}
+	switch s.search {
+	case gormSearchFTS5:
+		quoted := make([]string, len(terms))
+		for i, t := range terms {
+			quoted[i] = `"` + t + `"`
+		}
+		err := s.db.Raw("SELECT rowid AS id, -rank AS score FROM item_search WHERE item_search MATCH ? ORDER BY rank, rowid LIMIT ?",
+			strings.Join(quoted, " "), limit).Scan(&scores).Error
+		if err != nil {
+			return make([]SearchResult, 0), err
+		}
+	case gormSearchFulltext:
+		required := make([]string, len(terms))
+		for i, t := range terms {
+			required[i] = `+"` + t + `"`
+		}
+		match := "MATCH (description, notes) AGAINST (? IN BOOLEAN MODE)"
+		err := s.db.Model(&GormItem{}).Select("id, "+match+" AS score", strings.Join(required, " ")).
+			Where(match, strings.Join(required, " ")).Order("score DESC").Order("id").Limit(limit).Scan(&scores).Error
+		if err != nil {
+			return make([]SearchResult, 0), err
+		}
+	case gormSearchTSVector:
+		quoted := make([]string, len(terms))
+		for i, t := range terms {
+			quoted[i] = "'" + t + "'"
+		}
+		match := "to_tsquery('simple', ?)"
+		err := s.db.Model(&GormItem{}).Select("id, ts_rank("+gormSearchVector+", "+match+") AS score", strings.Join(quoted, " & ")).
+			Where(gormSearchVector+" @@ "+match, strings.Join(quoted, " & ")).Order("score DESC").Order("id").Limit(limit).Scan(&scores).Error
+		if err != nil {
+			return make([]SearchResult, 0), err
+		}
+	default:
+		return s.scanItems(terms, limit)
+	}
+
+	ids := make([]uint, len(scores))
+	for i, sc := range scores {
+		ids[i] = sc.ID
+	}
+	var gtds []GormItem
+	if err := s.db.Preload("Tags").Where("id IN (?)", ids).Find(&gtds).Error; err != nil {
+		return make([]SearchResult, 0), err
+	}
+	byID := make(map[uint]Item, len(gtds))
+	for _, g := range gtds {
+		byID[g.ID] = g.toItem()
+	}
+	results := make([]SearchResult, 0, len(scores))
+	for _, sc := range scores {
+		if item, ok := byID[sc.ID]; ok {
+			results = append(results, SearchResult{Item: item, Score: sc.Score, Snippet: itemSnippet(item, terms)})
+		}
+	}
+	return results, nil
+}
+
+// scanItems searches without a full-text index, narrowing down the items with LIKE before ranking them.
+func (s *gormdb) scanItems(terms []string, limit int) ([]SearchResult, error) {
+	tx := s.db
+	for _, t := range terms {
+		tx = tx.Where("LOWER(description) LIKE ? OR LOWER(notes) LIKE ?", "%"+t+"%", "%"+t+"%")
+	}
+	var gtds []GormItem
+	if err := tx.Preload("Tags").Order("id").Find(&gtds).Error; err != nil {
+		return make([]SearchResult, 0), err
+	}
+	results := make([]SearchResult, 0)
+	for _, g := range gtds {
+		item := g.toItem()
+		if score := matchScore(item, terms); score > 0 {
+			results = append(results, SearchResult{Item: item, Score: score, Snippet: itemSnippet(item, terms)})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// filter narrows down the items to those matched by the query.
+func (s *gormdb) filter(query ItemQuery) (*gorm.DB, error) {
+	tx := s.db
+	if query.ListId != "" {
+		listID, err := strconv.ParseUint(query.ListId, 10, 64)
+		if err != nil {
+			return nil, &ErrorListNotFound{Id: query.ListId}
+		}
+		tx = tx.Where("list_id = ?", listID)
+	}
+	if query.Completed != nil {
+		tx = tx.Where("completed = ?", *query.Completed)
+	}
+	if query.DueBefore != nil {
+		tx = tx.Where("due_at < ?", query.DueBefore.UTC())
+	}
+	if len(query.AllTags) > 0 {
+		tags := normaliseTags(query.AllTags)
+		tx = tx.Where("id IN (SELECT item_tags.gorm_item_id FROM item_tags "+
+			"JOIN gorm_tags ON gorm_tags.id = item_tags.gorm_tag_id WHERE gorm_tags.name IN (?) "+
+			"GROUP BY item_tags.gorm_item_id HAVING COUNT(*) = ?)", tags, len(tags))
+	}
+	if len(query.AnyTags) > 0 {
+		tx = tx.Where("id IN (SELECT item_tags.gorm_item_id FROM item_tags "+
+			"JOIN gorm_tags ON gorm_tags.id = item_tags.gorm_tag_id WHERE gorm_tags.name IN (?))", normaliseTags(query.AnyTags))
+	}
+	if query.Filter != nil {
+		condition, args := gormFilter(query.Filter)
+		tx = tx.Where(condition, args...)
+	}
+	return tx, nil
+}
+
+// gormFilter translates a filter expression into an SQL condition. Comparisons are written so that they are
+// never NULL, otherwise negating them would not match items with missing values as the other backends do.
+func gormFilter(expr FilterExpr) (string, []interface{}) {
+	switch e := expr.(type) {
+	case FilterLogical:
+		var conditions []string
+		var args []interface{}
+		for _, operand := range e.Operands {
+			condition, operandArgs := gormFilter(operand)
+			conditions = append(conditions, "("+condition+")")
+			args = append(args, operandArgs...)
+		}
+		return strings.Join(conditions, " "+strings.ToUpper(e.Op)+" "), args
+	case FilterNot:
+		condition, args := gormFilter(e.Operand)
+		return "NOT (" + condition + ")", args
+	case FilterComparison:
+		return gormComparison(e)
+	}
+	panic(fmt.Sprintf("unknown filter expression %T", expr))
+}
+
+func gormComparison(c FilterComparison) (string, []interface{}) {
+	field := filterFields[c.Field]
+	if field.kind == filterTag {
+		condition := "id IN (SELECT item_tags.gorm_item_id FROM item_tags " +
+			"JOIN gorm_tags ON gorm_tags.id = item_tags.gorm_tag_id WHERE gorm_tags.name = ?)"
+		if c.Op == FilterNe {
+			return "NOT " + condition, []interface{}{c.Value}
+		}
+		return condition, []interface{}{c.Value}
+	}
+
+	column := gormFilterColumns[c.Field]
+	value := c.Value
+	switch {
+	case value == nil && c.Op == FilterEq:
+		return column + " IS NULL", nil
+	case value == nil:
+		return column + " IS NOT NULL", nil
+	case field.kind == filterID:
+		return gormIDComparison(c, column)
+	case field.kind == filterString:
+		// Notes are NULL on items created before they were added.
+		column = "COALESCE(" + column + ", '')"
+		if c.Op == FilterContains {
+			escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(strings.ToLower(value.(string)))
+			return "LOWER(" + column + ") LIKE ? ESCAPE '!'", []interface{}{"%" + escaped + "%"}
+		}
+	}
+
+	condition := column + " " + gormFilterOperators[c.Op] + " ?"
+	if field.nullable && c.Op == FilterNe {
+		return column + " IS NULL OR " + condition, []interface{}{value}
+	} else if field.nullable {
+		return column + " IS NOT NULL AND " + condition, []interface{}{value}
+	}
+	return condition, []interface{}{value}
+}
+
+// gormUIDColumns hold the generated IDs of the items in the ID columns which are filtered on. Lists are never given
+// one.
+var gormUIDColumns = map[string]string{SortById: "uid", FilterByParent: "parent_uid"}
+
+// gormIDComparison compares an ID column with a legacy or a generated ID. Legacy IDs only match items which were
+// not given a generated ID, and IDs which cannot exist in this database never equal any item's.
+func gormIDComparison(c FilterComparison, column string) (string, []interface{}) {
+	uidColumn := gormUIDColumns[c.Field]
+	condition, args := "1 = 0", []interface{}(nil)
+	if id, err := strconv.ParseUint(c.Value.(string), 10, 64); err == nil {
+		condition, args = column+" IS NOT NULL AND "+column+" = ?", []interface{}{id}
+		if uidColumn != "" {
+			condition += " AND " + uidColumn + " IS NULL"
+		}
+	} else if isGeneratedID(c.Value.(string)) && uidColumn != "" {
+		condition, args = uidColumn+" IS NOT NULL AND "+uidColumn+" = ?", []interface{}{c.Value}
+	}
+	if c.Op == FilterNe {
+		return "NOT (" + condition + ")", args
+	}
+	return condition, args
+}
+
+type gormSortKey struct {
+	column     string
+	descending bool
+}
+
+// order returns the ORDER BY clause for the key, reversed when paging backwards. NULLs sort first, which
+// Postgres needs to be told as it otherwise sorts them last.
+func (k gormSortKey) order(reverse bool, dialect string) string {
+	descending := k.descending != reverse
+	if dialect == "postgres" && descending {
+		return k.column + " desc NULLS LAST"
+	} else if dialect == "postgres" {
+		return k.column + " NULLS FIRST"
+	} else if descending {
+		return k.column + " desc"
+	}
+	return k.column
+}
+
+// gormSortKeys returns the columns to sort by, ending with the ID so that the order is stable.
+func gormSortKeys(fields []SortField) []gormSortKey {
+	var keys []gormSortKey
+	seen := make(map[string]bool)
+	fields = append(fields[:len(fields):len(fields)], SortField{Field: SortById})
+	for _, f := range fields {
+		column := gormSortColumns[f.Field]
+		if seen[column] {
+			continue
+		}
+		seen[column] = true
+		keys = append(keys, gormSortKey{column: column, descending: f.Descending})
+	}
+	return keys
+}
+
+// gormKeyset builds a condition matching the items which come after the cursor in the order given by keys, or
+// before it for a Before cursor. NULLs sort before any other value, as they do in SQLite and MySQL.
+func gormKeyset(keys []gormSortKey, cursor Cursor) (string, []interface{}, error) {
+	if len(cursor.Keys) != len(keys) {
+		return "", nil, errInvalidCursor
+	}
+
+	var clauses, equal []string
+	var args, equalArgs []interface{}
+	for i, k := range keys {
+		v, err := gormCursorValue(k.column, cursor.Keys[i])
+		if err != nil {
+			return "", nil, err
+		}
+
+		var after string
+		descending := k.descending != cursor.Before
+		switch {
+		case v == nil && !descending:
+			after = k.column + " IS NOT NULL"
+		case v == nil && descending:
+			// Nothing comes after NULL in descending order.
+		case !descending:
+			after = k.column + " > ?"
+		default:
+			after = "(" + k.column + " < ? OR " + k.column + " IS NULL)"
+		}
+		if after != "" {
+			clauses = append(clauses, "("+strings.Join(append(equal[:len(equal):len(equal)], after), " AND ")+")")
+			args = append(args, equalArgs...)
+			if v != nil {
+				args = append(args, v)
+			}
+		}
+
+		if v == nil {
+			equal = append(equal, k.column+" IS NULL")
+		} else {
+			equal = append(equal, k.column+" = ?")
+			equalArgs = append(equalArgs, v)
+		}
+	}
+	if len(clauses) == 0 {
+		return "1 = 0", nil, nil
+	}
+	return strings.Join(clauses, " OR "), args, nil
+}
+
+func gormCursorValue(column string, key *string) (interface{}, error) {
+	switch column {
+	case "due_at", "start_at", "completed_at":
+		return parseCursorTime(key, true)
+	case "created_at", "updated_at":
+		return parseCursorTime(key, false)
+	}
+	if key == nil {
+		return nil, errInvalidCursor
+	}
+	switch column {
+	case "id":
+		id, err := strconv.ParseUint(*key, 10, 64)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		return id, nil
+	case "priority":
+		priority, err := strconv.Atoi(*key)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		return priority, nil
+	}
+	return *key, nil
+}
+
+func gormCursorKeys(g GormItem, keys []gormSortKey) []*string {
+	values := make([]*string, len(keys))
+	for i, k := range keys {
+		var v string
+		switch k.column {
+		case "id":
+			v = strconv.FormatUint(uint64(g.ID), 10)
+		case "description":
+			v = g.Description
+		case "priority":
+			v = strconv.Itoa(g.Priority)
+		case "due_at":
+			values[i] = cursorTime(g.DueAt)
+			continue
+		case "start_at":
+			values[i] = cursorTime(g.StartAt)
+			continue
+		case "created_at":
+			values[i] = cursorTime(&g.CreatedAt)
+			continue
+		case "updated_at":
+			values[i] = cursorTime(&g.UpdatedAt)
+			continue
+		case "completed_at":
+			values[i] = cursorTime(g.CompletedAt)
+			continue
+		}
+		values[i] = &v
+	}
+	return values
+}
+
+func (s *gormdb) setItemTags(ctx context.Context, id string, tags []string) (Item, error) {
+	s = s.with(ctx)
+	where, ok := gormWhereID(s.db, id)
+	if !ok {
 		return Item{}, errors.New("Invalid ID type.")
 	}
 	var gtd GormItem
-	if err := s.db.First(&gtd, uintId).Error; gorm.IsRecordNotFoundError(err) {
+	if err := where.First(&gtd).Error; gorm.IsRecordNotFoundError(err) {
 		return Item{}, &ErrorItemNotFound{Id: id}
 	} else if err != nil {
 		return Item{}, err
 	}
-	return Item{Description: gtd.Description, Completed: gtd.Completed, Id: id}, nil
+	var tagged GormItem
+	err := s.transaction(func(tx *gorm.DB) error {
+		var before GormItem
+		if err := tx.Preload("Tags").First(&before, gtd.ID).Error; err != nil {
+			return err
+		}
+		gtags, err := findOrCreateTags(tx, tags)
+		if err != nil {
+			return err
+		}
+		gtd.Tags = gtags
+		if err := tx.Model(&GormItem{}).Where("id = ?", gtd.ID).Update("Version", gorm.Expr("version + 1")).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&gtd).Association("Tags").Replace(gtags).Error; err != nil {
+			return err
+		}
+		if err := tx.Preload("Tags").First(&tagged, gtd.ID).Error; err != nil {
+			return err
+		}
+		return gormAudit(tx, s.entry(AuditUpdate, before.toItem(), tagged.toItem(), tagged.UpdatedAt))
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return tagged.toItem(), nil
+}
+
+func (s *gormdb) allTags(ctx context.Context) ([]TagCount, error) {
+	s = s.with(ctx)
+	tags := make([]TagCount, 0)
+	err := s.db.Table("gorm_tags").Select("gorm_tags.name AS name, COUNT(*) AS count").
+		Joins("JOIN item_tags ON item_tags.gorm_tag_id = gorm_tags.id").
+		Joins("JOIN gorm_items ON gorm_items.id = item_tags.gorm_item_id AND gorm_items.deleted_at IS NULL").
+		Group("gorm_tags.name").Order("gorm_tags.name").Scan(&tags).Error
+	if err != nil {
+		return make([]TagCount, 0), err
+	}
+	return tags, nil
+}
+
+func (s *gormdb) createList(ctx context.Context, list List) (List, error) {
+	s = s.with(ctx)
+	gl := &GormList{Name: list.Name}
+	if err := s.db.Create(gl).Error; err != nil {
+		return List{}, err
+	}
+	return gl.toList(), nil
+}
+
+func (s *gormdb) updateList(ctx context.Context, id string, list List) (List, error) {
+	s = s.with(ctx)
+	uintId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return List{}, errors.New("Invalid ID type.")
+	}
+	var gl GormList
+	if err := s.db.First(&gl, uintId).Error; gorm.IsRecordNotFoundError(err) {
+		return List{}, &ErrorListNotFound{Id: id}
+	} else if err != nil {
+		return List{}, err
+	}
+	if err := s.db.Model(&gl).Update("Name", list.Name).Error; err != nil {
+		return List{}, err
+	}
+	return gl.toList(), nil
+}
+
+// deleteList deletes a list along with its items when cascade is set, otherwise its items are moved to the inbox.
+func (s *gormdb) deleteList(ctx context.Context, id string, cascade bool) error {
+	s = s.with(ctx)
+	uintId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return errors.New("Invalid ID type.")
+	}
+	var gl GormList
+	if err := s.db.First(&gl, uintId).Error; gorm.IsRecordNotFoundError(err) {
+		return &ErrorListNotFound{Id: id}
+	} else if err != nil {
+		return err
+	}
+	return s.transaction(func(tx *gorm.DB) error {
+		items := tx.Model(&GormItem{}).Where("list_id = ?", gl.ID)
+		if cascade {
+			var trashed []GormItem
+			if err := items.Order("id").Find(&trashed).Error; err != nil {
+				return err
+			}
+			ids := make([]uint, len(trashed))
+			for i, gtd := range trashed {
+				ids[i] = gtd.ID
+			}
+			deletedAt := s.now()
+			if err := gormTrash(tx, ids, deletedAt); err != nil {
+				return err
+			}
+			for _, gtd := range trashed {
+				if err := gormAudit(tx, s.entry(AuditDelete, gtd.toItem(), Item{}, deletedAt)); err != nil {
+					return err
+				}
+			}
+		} else {
+			err = items.Updates(map[string]interface{}{"ListID": nil, "Version": gorm.Expr("version + 1")}).Error
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&gl).Error
+	})
+}
+
+func (s *gormdb) getList(ctx context.Context, id string) (List, error) {
+	s = s.with(ctx)
+	uintId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return List{}, errors.New("Invalid ID type.")
+	}
+	var gl GormList
+	if err := s.db.First(&gl, uintId).Error; gorm.IsRecordNotFoundError(err) {
+		return List{}, &ErrorListNotFound{Id: id}
+	} else if err != nil {
+		return List{}, err
+	}
+	return gl.toList(), nil
+}
+
+func (s *gormdb) allLists(ctx context.Context) ([]List, error) {
+	s = s.with(ctx)
+	var gls []GormList
+	if err := s.db.Order("id").Find(&gls).Error; err != nil {
+		return make([]List, 0), err
+	}
+
+	lists := make([]List, len(gls))
+	for i, v := range gls {
+		lists[i] = v.toList()
+	}
+	return lists, nil
+}
+
+// createSubtask adds an item to the end of the subtasks of its parent.
+func (s *gormdb) createSubtask(ctx context.Context, parentId string, item Item) (Item, error) {
+	s = s.with(ctx)
+	parentID, _, err := s.parentID(parentId)
+	if err != nil {
+		return Item{}, err
+	}
+	var position int
+	row := s.db.Model(&GormItem{}).Where("parent_id = ?", *parentID).Select("COALESCE(MAX(position) + 1, 0)").Row()
+	if err := row.Scan(&position); err != nil {
+		return Item{}, err
+	}
+	item.ParentId = parentId
+	item.Position = position
+	return s.createItem(ctx, item)
+}
+
+func (s *gormdb) subtasks(ctx context.Context, parentId string) ([]Item, error) {
+	s = s.with(ctx)
+	parentID, _, err := s.parentID(parentId)
+	if err != nil {
+		return make([]Item, 0), err
+	}
+	var gtds []GormItem
+	if err := s.db.Preload("Tags").Where("parent_id = ?", *parentID).Order("position").Order("id").Find(&gtds).Error; err != nil {
+		return make([]Item, 0), err
+	}
+	return toItems(gtds), nil
 }
 
-func (s *gormdb) allItems() ([]Item, error) {
+// trashedItems returns the items in the trash, most recently deleted first.
+func (s *gormdb) trashedItems(ctx context.Context) ([]Item, error) {
+	s = s.with(ctx)
 	var gtds []GormItem
-	if err := s.db.Find(&gtds).Error; err != nil {
+	err := s.db.Unscoped().Preload("Tags").Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Order("id").Find(&gtds).Error
+	if err != nil {
 		return make([]Item, 0), err
 	}
+	return toItems(gtds), nil
+}
 
-	tds := make([]Item, len(gtds))
-	for i, v := range gtds {
-		id := strconv.FormatUint(uint64(v.ID), 10)
-		tds[i] = Item{Description: v.Description, Completed: v.Completed, Id: id}
+// restoreItem moves an item back out of the trash along with the subtasks which were deleted with it. A subtask
+// cannot be restored while its parent is in the trash, but becomes a top level item once its parent has been
+// purged. Items in a list which has since been deleted are moved to the inbox.
+func (s *gormdb) restoreItem(ctx context.Context, id string) (Item, error) {
+	s = s.with(ctx)
+	gtd, err := s.trashedItem(id)
+	if err != nil {
+		return Item{}, err
+	}
+	err = s.transaction(func(tx *gorm.DB) error {
+		var before GormItem
+		if err := tx.Unscoped().Preload("Tags").First(&before, gtd.ID).Error; err != nil {
+			return err
+		}
+		if gtd.ParentID != nil {
+			var parent GormItem
+			err := tx.Unscoped().First(&parent, *gtd.ParentID).Error
+			if gorm.IsRecordNotFoundError(err) {
+				if err := tx.Unscoped().Model(&GormItem{}).Where("id = ?", gtd.ID).UpdateColumns(map[string]interface{}{"parent_id": nil, "parent_uid": nil}).Error; err != nil {
+					return err
+				}
+			} else if err != nil {
+				return err
+			} else if parent.DeletedAt != nil {
+				return &ErrorItemTrashed{Id: parent.id()}
+			}
+		}
+		ids, err := gormTrashedSubtasks(tx, gtd, true)
+		if err != nil {
+			return err
+		}
+		restored := tx.Unscoped().Model(&GormItem{}).Where("id IN (?)", ids)
+		err = restored.Where("list_id NOT IN (?)", tx.Model(&GormList{}).Select("id").QueryExpr()).UpdateColumn("list_id", nil).Error
+		if err != nil {
+			return err
+		}
+		if err := restored.UpdateColumns(map[string]interface{}{"deleted_at": nil, "updated_at": s.now(), "version": gorm.Expr("version + 1")}).Error; err != nil {
+			return err
+		}
+		var after GormItem
+		if err := tx.Preload("Tags").First(&after, gtd.ID).Error; err != nil {
+			return err
+		}
+		return gormAudit(tx, s.entry(AuditRestore, before.toItem(), after.toItem(), after.UpdatedAt))
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return s.getItem(ctx, id)
+}
+
+// purgeItem deletes an item in the trash for good, along with its subtasks.
+func (s *gormdb) purgeItem(ctx context.Context, id string) error {
+	s = s.with(ctx)
+	gtd, err := s.trashedItem(id)
+	if err != nil {
+		return err
 	}
-	return tds, nil
+	return s.transaction(func(tx *gorm.DB) error {
+		ids, err := gormTrashedSubtasks(tx, gtd, false)
+		if err != nil {
+			return err
+		}
+		return gormPurge(tx, ids)
+	})
+}
+
+// purgeTrash deletes the items which were moved to the trash before the given time for good.
+func (s *gormdb) purgeTrash(ctx context.Context, before time.Time) (int, error) {
+	s = s.with(ctx)
+	var ids []uint
+	err := s.transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&GormItem{}).Where("deleted_at < ?", before.UTC()).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		return gormPurge(tx, ids)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+func (s *gormdb) trashedItem(id string) (GormItem, error) {
+	where, ok := gormWhereID(s.db.Unscoped(), id)
+	if !ok {
+		return GormItem{}, errors.New("Invalid ID type.")
+	}
+	var gtd GormItem
+	if err := where.Where("deleted_at IS NOT NULL").First(&gtd).Error; gorm.IsRecordNotFoundError(err) {
+		return GormItem{}, &ErrorItemNotFound{Id: id}
+	} else if err != nil {
+		return GormItem{}, err
+	}
+	return gtd, nil
+}
+
+// gormTrashedSubtasks returns the IDs of an item in the trash and of its subtasks in the trash, level by level.
+// When sameTime is set, only the subtasks which were deleted along with the item are returned.
+func gormTrashedSubtasks(tx *gorm.DB, root GormItem, sameTime bool) ([]uint, error) {
+	ids := []uint{root.ID}
+	parents := ids
+	for len(parents) > 0 {
+		var children []GormItem
+		if err := tx.Unscoped().Where("parent_id IN (?) AND deleted_at IS NOT NULL", parents).Find(&children).Error; err != nil {
+			return nil, err
+		}
+		parents = nil
+		for _, child := range children {
+			if !sameTime || child.DeletedAt.Equal(*root.DeletedAt) {
+				parents = append(parents, child.ID)
+			}
+		}
+		ids = append(ids, parents...)
+	}
+	return ids, nil
+}
+
+// gormPurge deletes items for good, along with their tags.
+func gormPurge(tx *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := tx.Exec("DELETE FROM item_tags WHERE gorm_item_id IN (?)", ids).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("id IN (?)", ids).Delete(&GormItem{}).Error
+}
+
+// parentID resolves the parent item of a subtask, where an empty id means the item is not a subtask. The parent's
+// generated ID is returned along with its numeric one, or nil when it has none.
+func (s *gormdb) parentID(id string) (*uint, *string, error) {
+	if id == "" {
+		return nil, nil, nil
+	}
+	where, ok := gormWhereID(s.db, id)
+	if !ok {
+		return nil, nil, &ErrorItemNotFound{Id: id}
+	}
+	var gtd GormItem
+	if err := where.First(&gtd).Error; gorm.IsRecordNotFoundError(err) {
+		return nil, nil, &ErrorItemNotFound{Id: id}
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return &gtd.ID, gtd.UID, nil
+}
+
+// gormWhereID narrows a query down to the item with the given ID, reporting false when it is not an ID this
+// database could have given out. Items which were given a generated ID are only found by it, so that their
+// numeric IDs cannot be guessed.
+func gormWhereID(db *gorm.DB, id string) (*gorm.DB, bool) {
+	if isGeneratedID(id) {
+		return db.Where("uid = ?", id), true
+	}
+	uintId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return db.Where("id = ? AND uid IS NULL", uintId), true
+}
+
+// listID resolves the list an item refers to, where an empty id refers to the inbox.
+func (s *gormdb) listID(id string) (*uint, error) {
+	if id == "" {
+		return nil, nil
+	}
+	uintId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, &ErrorListNotFound{Id: id}
+	}
+	var gl GormList
+	if err := s.db.First(&gl, uintId).Error; gorm.IsRecordNotFoundError(err) {
+		return nil, &ErrorListNotFound{Id: id}
+	} else if err != nil {
+		return nil, err
+	}
+	return &gl.ID, nil
+}
+
+func findOrCreateTags(tx *gorm.DB, names []string) ([]GormTag, error) {
+	tags := make([]GormTag, 0, len(names))
+	for _, name := range normaliseTags(names) {
+		var tag GormTag
+		if err := tx.Where(GormTag{Name: name}).FirstOrCreate(&tag).Error; err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// transaction runs fn in a transaction, or in the current one when there is one already. The transaction is begun
+// with the context of the call, so that it is rolled back once the context is done.
+func (s *gormdb) transaction(fn func(tx *gorm.DB) error) (err error) {
+	if s.inTransaction {
+		return fn(s.db)
+	}
+	ctx := context.Background()
+	if v, ok := s.db.Get(gormContext); ok {
+		ctx = v.(context.Context)
+	}
+	tx := s.db.BeginTx(ctx, nil)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	committed = true
+	return tx.Commit().Error
+}
+
+// auditedBy returns a copy of the database which records the writes it makes as made by actor.
+func (s *gormdb) auditedBy(actor, requestId string) Database {
+	t := *s
+	t.auditor = auditor{actor: actor, requestId: requestId}
+	return &t
+}
+
+func (s *gormdb) itemHistory(ctx context.Context, id string) ([]AuditEntry, error) {
+	s = s.with(ctx)
+	var gentries []GormAuditEntry
+	if err := s.db.Where("item_id = ?", id).Order("id").Find(&gentries).Error; err != nil {
+		return nil, err
+	}
+	if len(gentries) == 0 {
+		return nil, &ErrorItemNotFound{Id: id}
+	}
+	return toAuditEntries(gentries)
+}
+
+func (s *gormdb) auditEntries(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	s = s.with(ctx)
+	q := s.db.Where("recorded_at >= ?", query.Since.UTC()).Order("id")
+	if query.After != "" {
+		after, err := strconv.ParseUint(query.After, 10, 64)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		q = q.Where("id > ?", after)
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+	var gentries []GormAuditEntry
+	if err := q.Find(&gentries).Error; err != nil {
+		return nil, err
+	}
+	return toAuditEntries(gentries)
+}
+
+// atomically runs fn with a copy of the database whose every method uses the same transaction, which is rolled
+// back if fn fails.
+func (s *gormdb) atomically(ctx context.Context, fn func(db Database) error) error {
+	return s.with(ctx).transaction(func(tx *gorm.DB) error {
+		t := *s
+		t.db = tx
+		t.inTransaction = true
+		return fn(&t)
+	})
+}
+
+func (s *gormdb) createItems(ctx context.Context, items []Item, atomic bool) ([]BatchResult, error) {
+	return createItems(ctx, s, items, atomic, s.atomically)
+}
+
+func (s *gormdb) patchItems(ctx context.Context, patches []ItemPatch, atomic bool) ([]BatchResult, error) {
+	return patchItems(ctx, s, patches, atomic, s.atomically)
+}
+
+func (s *gormdb) deleteItems(ctx context.Context, query ItemQuery, atomic bool) ([]BatchResult, error) {
+	return deleteItems(ctx, s, query, atomic, s.atomically)
+}
+
+func (s *gormdb) reserveKey(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error) {
+	s = s.with(ctx)
+	now := time.Now().UTC()
+	var existing *IdempotencyRecord
+	err := s.transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at <= ?", now).Delete(&GormIdempotencyKey{}).Error; err != nil {
+			return err
+		}
+		var key GormIdempotencyKey
+		err := tx.Where("idempotency_key = ?", record.Key).First(&key).Error
+		if err == nil {
+			r := key.toRecord()
+			existing = &r
+			return nil
+		} else if !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+		return tx.Create(&GormIdempotencyKey{
+			Key:         record.Key,
+			Fingerprint: record.Fingerprint,
+			ExpiresAt:   record.ExpiresAt.UTC(),
+		}).Error
+	})
+	if err != nil {
+		// Another request may have claimed the key in the meantime.
+		var key GormIdempotencyKey
+		if s.db.Where("idempotency_key = ? AND expires_at > ?", record.Key, now).First(&key).Error == nil {
+			r := key.toRecord()
+			return &r, nil
+		}
+		return nil, err
+	}
+	return existing, nil
+}
+
+func (s *gormdb) saveResponse(ctx context.Context, key string, status int, etag string, body []byte) error {
+	s = s.with(ctx)
+	return s.db.Model(&GormIdempotencyKey{}).Where("idempotency_key = ?", key).
+		Updates(map[string]interface{}{"Status": status, "ETag": etag, "Body": body}).Error
+}
+
+func (s *gormdb) releaseKey(ctx context.Context, key string) error {
+	s = s.with(ctx)
+	return s.db.Where("idempotency_key = ?", key).Delete(&GormIdempotencyKey{}).Error
 }
 
 func (s *gormdb) close() {
 	s.db.Close()
 }
+
+func (g GormItem) toItem() Item {
+	tags := make([]string, len(g.Tags))
+	for i, t := range g.Tags {
+		tags[i] = t.Name
+	}
+	tags = normaliseTags(tags)
+	listId := ""
+	if g.ListID != nil {
+		listId = strconv.FormatUint(uint64(*g.ListID), 10)
+	}
+	parentId := ""
+	if g.ParentUID != nil {
+		parentId = *g.ParentUID
+	} else if g.ParentID != nil {
+		parentId = strconv.FormatUint(uint64(*g.ParentID), 10)
+	}
+	return Item{
+		Id:                   g.id(),
+		Description:          g.Description,
+		Notes:                g.Notes,
+		Completed:            g.Completed,
+		Priority:             g.Priority,
+		DueAt:                g.DueAt,
+		StartAt:              g.StartAt,
+		ListId:               listId,
+		Tags:                 tags,
+		ParentId:             parentId,
+		Position:             g.Position,
+		CompleteWithSubtasks: g.CompleteWithSubtasks,
+		Version:              g.Version,
+		CreatedAt:            g.CreatedAt.UTC(),
+		UpdatedAt:            g.UpdatedAt.UTC(),
+		CompletedAt:          utc(g.CompletedAt),
+		DeletedAt:            utc(g.DeletedAt),
+	}
+}
+
+// id returns the ID the item is known by, which is its generated ID when it was given one.
+func (g GormItem) id() string {
+	if g.UID != nil {
+		return *g.UID
+	}
+	return strconv.FormatUint(uint64(g.ID), 10)
+}
+
+func (g GormIdempotencyKey) toRecord() IdempotencyRecord {
+	return IdempotencyRecord{
+		Key:         g.Key,
+		Fingerprint: g.Fingerprint,
+		Status:      g.Status,
+		ETag:        g.ETag,
+		Body:        g.Body,
+		ExpiresAt:   g.ExpiresAt,
+	}
+}
+
+func toItems(gtds []GormItem) []Item {
+	tds := make([]Item, len(gtds))
+	for i, v := range gtds {
+		tds[i] = v.toItem()
+	}
+	return tds
+}
+
+func (g GormAuditEntry) toAuditEntry() (AuditEntry, error) {
+	var changes []FieldChange
+	if err := json.Unmarshal([]byte(g.Changes), &changes); err != nil {
+		return AuditEntry{}, err
+	}
+	return AuditEntry{
+		Id:        strconv.FormatUint(uint64(g.ID), 10),
+		ItemId:    g.ItemID,
+		Action:    g.Action,
+		Version:   g.Version,
+		Actor:     g.Actor,
+		RequestId: g.RequestID,
+		At:        g.RecordedAt.UTC(),
+		Changes:   changes,
+	}, nil
+}
+
+func toAuditEntries(gentries []GormAuditEntry) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, len(gentries))
+	for i, g := range gentries {
+		var err error
+		if entries[i], err = g.toAuditEntry(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func (g GormList) toList() List {
+	return List{Id: strconv.FormatUint(uint64(g.ID), 10), Name: g.Name}
+}
@@ -1,13 +1,25 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
+	"time"
 )
 
+// testDialect and testConnectionString give the database the gormdb tests run against, which TestMain sets to
+// Postgres when asked to.
+var testDialect, testConnectionString = "sqlite3", ":memory:"
+
 func initDB() *gormdb {
-	db := &gormdb{dialect: "sqlite3", connectionString: ":memory:"}
+	if testDialect == "postgres" {
+		resetPostgres(testConnectionString)
+	}
+	db := &gormdb{dialect: testDialect, connectionString: testConnectionString, autoMigrate: true}
 	db.init()
 	return db
 }
@@ -38,7 +50,7 @@ func Test_createItem(t *testing.T) {
 	db := initDB()
 	defer db.close()
 
-	item, err := db.createItem(Item{Description: "test_description", Completed: false})
+	item, err := db.createItem(ctx, Item{Description: "test_description", Completed: false})
 
 	assert.NoError(t, err)
 	assert.Equal(t, "1", item.Id)
@@ -50,7 +62,7 @@ func Test_createItem_db_error(t *testing.T) {
 	db := initDB()
 	db.close()
 
-	item, err := db.createItem(Item{Description: "test_description", Completed: false})
+	item, err := db.createItem(ctx, Item{Description: "test_description", Completed: false})
 
 	assert.EqualError(t, err, "sql: database is closed")
 	assert.Equal(t, "", item.Id)
@@ -62,9 +74,9 @@ func Test_updateItem(t *testing.T) {
 	db := initDB()
 	defer db.close()
 
-	createdItem, _ := db.createItem(Item{Description: "test_description", Completed: false})
+	createdItem, _ := db.createItem(ctx, Item{Description: "test_description", Completed: false})
 	update := Item{Description: "updated description", Completed: true}
-	item, err := db.updateItem(createdItem.Id, update)
+	item, err := db.updateItem(ctx, createdItem.Id, update)
 
 	assert.NoError(t, err)
 	assert.Equal(t, "1", item.Id)
@@ -77,7 +89,7 @@ func Test_updateItem_not_exists(t *testing.T) {
 	defer db.close()
 
 	update := Item{Description: "updated description", Completed: true}
-	item, err := db.updateItem("1234", update)
+	item, err := db.updateItem(ctx, "1234", update)
 
 	var e *ErrorItemNotFound;
 	assert.True(t, errors.As(err, &e))
@@ -91,7 +103,7 @@ func Test_updateItem_invalid_id(t *testing.T) {
 	defer db.close()
 
 	update := Item{Description: "updated description", Completed: true}
-	item, err := db.updateItem("foo", update)
+	item, err := db.updateItem(ctx, "foo", update)
 
 	assert.EqualError(t, err, "Invalid ID type.")
 	assert.Equal(t, "", item.Id)
@@ -104,7 +116,7 @@ func Test_updateItem_db_error(t *testing.T) {
 	db.close()
 
 	update := Item{Description: "updated description", Completed: true}
-	item, err := db.updateItem("1234", update)
+	item, err := db.updateItem(ctx, "1234", update)
 
 	assert.EqualError(t, err, "sql: database is closed")
 	assert.Equal(t, "", item.Id)
@@ -116,10 +128,10 @@ func Test_deleteItem(t *testing.T) {
 	db := initDB()
 	defer db.close()
 
-	createdItem, _ := db.createItem(Item{Description: "test_description", Completed: false})
-	err := db.deleteItem(createdItem.Id)
+	createdItem, _ := db.createItem(ctx, Item{Description: "test_description", Completed: false})
+	err := db.deleteItem(ctx, createdItem.Id, 0)
 	assert.NoError(t, err)
-	item, err := db.getItem(createdItem.Id)
+	item, err := db.getItem(ctx, createdItem.Id)
 	var e *ErrorItemNotFound;
 	assert.True(t, errors.As(err, &e))
 	assert.Equal(t, "", item.Id)
@@ -131,7 +143,7 @@ func Test_deleteItem_not_exists(t *testing.T) {
 	db := initDB()
 	defer db.close()
 
-	err := db.deleteItem("1327")
+	err := db.deleteItem(ctx, "1327", 0)
 	var e *ErrorItemNotFound;
 	assert.True(t, errors.As(err, &e))
 }
@@ -140,7 +152,7 @@ func Test_deleteItem_invalid_id(t *testing.T) {
 	db := initDB()
 	defer db.close()
 
-	err := db.deleteItem("foo")
+	err := db.deleteItem(ctx, "foo", 0)
 	assert.EqualError(t, err, "Invalid ID type.")
 }
 
@@ -148,7 +160,7 @@ func Test_deleteItem_db_error(t *testing.T) {
 	db := initDB()
 	db.close()
 
-	err := db.deleteItem("1327")
+	err := db.deleteItem(ctx, "1327", 0)
 	assert.EqualError(t, err, "sql: database is closed")
 }
 
@@ -156,8 +168,8 @@ func Test_getItem(t *testing.T) {
 	db := initDB()
 	defer db.close()
 
-	createdItem, _ := db.createItem(Item{Description: "test_description", Completed: false})
-	item, err := db.getItem(createdItem.Id)
+	createdItem, _ := db.createItem(ctx, Item{Description: "test_description", Completed: false})
+	item, err := db.getItem(ctx, createdItem.Id)
 	assert.NoError(t, err)
 	assert.Equal(t, "1", item.Id)
 	assert.Equal(t, "test_description", item.Description)
@@ -168,7 +180,7 @@ func Test_getItem_not_exists(t *testing.T) {
 	db := initDB()
 	defer db.close()
 
-	item, err := db.getItem("1327")
+	item, err := db.getItem(ctx, "1327")
 	var e *ErrorItemNotFound;
 	assert.True(t, errors.As(err, &e))
 	assert.Equal(t, "", item.Id)
@@ -180,7 +192,7 @@ func Test_getItem_invalid_id(t *testing.T) {
 	db := initDB()
 	defer db.close()
 
-	item, err := db.getItem("foo")
+	item, err := db.getItem(ctx, "foo")
 	assert.EqualError(t, err, "Invalid ID type.")
 	assert.Equal(t, "", item.Id)
 	assert.Equal(t, "", item.Description)
@@ -190,7 +202,7 @@ func Test_getItem_invalid_id(t *testing.T) {
 func Test_getItem_db_error(t *testing.T) {
 	db := initDB()
 	db.close()
-	item, err := db.getItem("1327")
+	item, err := db.getItem(ctx, "1327")
 	assert.EqualError(t, err, "sql: database is closed")
 	assert.Equal(t, "", item.Id)
 	assert.Equal(t, "", item.Description)
@@ -201,11 +213,11 @@ func Test_allItems(t *testing.T) {
 	db := initDB()
 	defer db.close()
 
-	db.createItem(Item{Description: "A", Completed: false})
-	db.createItem(Item{Description: "B", Completed: true})
-	db.createItem(Item{Description: "C", Completed: false})
+	db.createItem(ctx, Item{Description: "A", Completed: false})
+	db.createItem(ctx, Item{Description: "B", Completed: true})
+	db.createItem(ctx, Item{Description: "C", Completed: false})
 
-	items, err := db.allItems()
+	items, err := db.allItems(ctx)
 
 	assert.NoError(t, err)
 	assert.Equal(t, 3, len(items))
@@ -224,11 +236,940 @@ func Test_allItems_db_error(t *testing.T) {
 	db := initDB()
 	db.close()
 
-	db.createItem(Item{Description: "A", Completed: false})
-	db.createItem(Item{Description: "B", Completed: true})
-	db.createItem(Item{Description: "C", Completed: false})
-	items, err := db.allItems()
+	db.createItem(ctx, Item{Description: "A", Completed: false})
+	db.createItem(ctx, Item{Description: "B", Completed: true})
+	db.createItem(ctx, Item{Description: "C", Completed: false})
+	items, err := db.allItems(ctx)
 	assert.EqualError(t, err, "sql: database is closed")
 	assert.Equal(t, 0, len(items))
 }
 
+
+func Test_createItem_dates(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	due := time.Date(2020, 5, 10, 17, 0, 0, 0, time.UTC)
+	start := due.Add(-48 * time.Hour)
+	item, err := db.createItem(ctx, Item{Description: "A", DueAt: &due, StartAt: &start})
+	assert.NoError(t, err)
+
+	item, err = db.getItem(ctx, item.Id)
+	assert.NoError(t, err)
+	assert.True(t, due.Equal(*item.DueAt))
+	assert.True(t, start.Equal(*item.StartAt))
+}
+
+func Test_updateItem_clear_dates(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	due := time.Date(2020, 5, 10, 17, 0, 0, 0, time.UTC)
+	createdItem, _ := db.createItem(ctx, Item{Description: "A", DueAt: &due})
+	_, err := db.updateItem(ctx, createdItem.Id, Item{Description: "A"})
+	assert.NoError(t, err)
+
+	item, err := db.getItem(ctx, createdItem.Id)
+	assert.NoError(t, err)
+	assert.Nil(t, item.DueAt)
+}
+
+func Test_findItems(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	now := time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC)
+	yesterday := now.Add(-24 * time.Hour)
+	tomorrow := now.Add(24 * time.Hour)
+	db.createItem(ctx, Item{Description: "A", Completed: false, DueAt: &yesterday})
+	db.createItem(ctx, Item{Description: "B", Completed: true, DueAt: &yesterday})
+	db.createItem(ctx, Item{Description: "C", Completed: false, DueAt: &tomorrow})
+	db.createItem(ctx, Item{Description: "D", Completed: false})
+
+	items, err := db.findItems(ctx, ItemQuery{DueBefore: &now})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(items))
+	assert.Equal(t, "A", items[0].Description)
+	assert.Equal(t, "B", items[1].Description)
+
+	completed := false
+	items, err = db.findItems(ctx, ItemQuery{DueBefore: &now, Completed: &completed})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, "1", items[0].Id)
+	assert.Equal(t, "A", items[0].Description)
+}
+
+func Test_findItems_db_error(t *testing.T) {
+	db := initDB()
+	db.close()
+
+	items, err := db.findItems(ctx, ItemQuery{})
+	assert.EqualError(t, err, "sql: database is closed")
+	assert.Equal(t, 0, len(items))
+}
+
+func Test_findItems_sorted(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	early := time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC)
+	late := early.Add(24 * time.Hour)
+	db.createItem(ctx, Item{Description: "A", Priority: PriorityLow, DueAt: &early})
+	db.createItem(ctx, Item{Description: "B", Priority: PriorityHigh, DueAt: &early})
+	db.createItem(ctx, Item{Description: "C", Priority: PriorityHigh, DueAt: &late})
+	db.createItem(ctx, Item{Description: "D", Priority: PriorityLow, DueAt: &early})
+
+	items, err := db.findItems(ctx, ItemQuery{Sort: []SortField{
+		{Field: SortByPriority, Descending: true},
+		{Field: SortByDue, Descending: true},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(items))
+	assert.Equal(t, "C", items[0].Description)
+	assert.Equal(t, "B", items[1].Description)
+	assert.Equal(t, "A", items[2].Description)
+	assert.Equal(t, "D", items[3].Description)
+}
+
+func Test_createItem_tags(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	item, err := db.createItem(ctx, Item{Description: "A", Tags: []string{"work", "home", "work"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"home", "work"}, item.Tags)
+
+	item, err = db.getItem(ctx, item.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"home", "work"}, item.Tags)
+}
+
+func Test_setItemTags(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	createdItem, _ := db.createItem(ctx, Item{Description: "A", Tags: []string{"work"}})
+	item, err := db.setItemTags(ctx, createdItem.Id, []string{"home", "urgent"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"home", "urgent"}, item.Tags)
+
+	item, err = db.getItem(ctx, createdItem.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"home", "urgent"}, item.Tags)
+
+	item, err = db.setItemTags(ctx, createdItem.Id, []string{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, item.Tags)
+
+	item, err = db.updateItem(ctx, createdItem.Id, Item{Description: "B"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, item.Tags)
+}
+
+func Test_setItemTags_not_exists(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	_, err := db.setItemTags(ctx, "1327", []string{"work"})
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(err, &e))
+}
+
+func Test_setItemTags_invalid_id(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	_, err := db.setItemTags(ctx, "foo", []string{"work"})
+	assert.EqualError(t, err, "Invalid ID type.")
+}
+
+func Test_findItems_tags(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	db.createItem(ctx, Item{Description: "A", Tags: []string{"work", "urgent"}})
+	db.createItem(ctx, Item{Description: "B", Tags: []string{"work"}})
+	db.createItem(ctx, Item{Description: "C", Tags: []string{"home", "urgent"}})
+	db.createItem(ctx, Item{Description: "D"})
+
+	items, err := db.findItems(ctx, ItemQuery{AllTags: []string{"work", "urgent"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, "A", items[0].Description)
+
+	items, err = db.findItems(ctx, ItemQuery{AnyTags: []string{"home", "work"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(items))
+	assert.Equal(t, "A", items[0].Description)
+	assert.Equal(t, "B", items[1].Description)
+	assert.Equal(t, "C", items[2].Description)
+
+	items, err = db.findItems(ctx, ItemQuery{AllTags: []string{"urgent"}, AnyTags: []string{"home"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, "C", items[0].Description)
+}
+
+func Test_allTags(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	db.createItem(ctx, Item{Description: "A", Tags: []string{"work", "urgent"}})
+	db.createItem(ctx, Item{Description: "B", Tags: []string{"work"}})
+	deleted, _ := db.createItem(ctx, Item{Description: "C", Tags: []string{"work", "home"}})
+	db.deleteItem(ctx, deleted.Id, 0)
+
+	tags, err := db.allTags(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []TagCount{{Name: "urgent", Count: 1}, {Name: "work", Count: 2}}, tags)
+}
+
+func Test_createList(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	list, err := db.createList(ctx, List{Name: "Groceries"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", list.Id)
+	assert.Equal(t, "Groceries", list.Name)
+
+	list, err = db.getList(ctx, list.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Groceries", list.Name)
+}
+
+func Test_updateList(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	createdList, _ := db.createList(ctx, List{Name: "Groceries"})
+	list, err := db.updateList(ctx, createdList.Id, List{Name: "Shopping"})
+	assert.NoError(t, err)
+	assert.Equal(t, List{Id: "1", Name: "Shopping"}, list)
+
+	_, err = db.updateList(ctx, "1327", List{Name: "Shopping"})
+	var e *ErrorListNotFound
+	assert.True(t, errors.As(err, &e))
+}
+
+func Test_getList_not_exists(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	_, err := db.getList(ctx, "1327")
+	var e *ErrorListNotFound
+	assert.True(t, errors.As(err, &e))
+
+	_, err = db.getList(ctx, "foo")
+	assert.EqualError(t, err, "Invalid ID type.")
+}
+
+func Test_allLists(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	db.createList(ctx, List{Name: "Groceries"})
+	db.createList(ctx, List{Name: "Work"})
+
+	lists, err := db.allLists(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []List{{Id: "1", Name: "Groceries"}, {Id: "2", Name: "Work"}}, lists)
+}
+
+func Test_createItem_list(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	list, _ := db.createList(ctx, List{Name: "Groceries"})
+	item, err := db.createItem(ctx, Item{Description: "Milk", ListId: list.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, list.Id, item.ListId)
+
+	_, err = db.createItem(ctx, Item{Description: "Bread", ListId: "1327"})
+	var e *ErrorListNotFound
+	assert.True(t, errors.As(err, &e))
+
+	item, err = db.updateItem(ctx, item.Id, Item{Description: "Milk"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", item.ListId)
+}
+
+func Test_findItems_list(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	groceries, _ := db.createList(ctx, List{Name: "Groceries"})
+	work, _ := db.createList(ctx, List{Name: "Work"})
+	db.createItem(ctx, Item{Description: "Milk", ListId: groceries.Id})
+	db.createItem(ctx, Item{Description: "Report", ListId: work.Id})
+	db.createItem(ctx, Item{Description: "Bread", ListId: groceries.Id})
+	db.createItem(ctx, Item{Description: "Call mom"})
+
+	items, err := db.findItems(ctx, ItemQuery{ListId: groceries.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(items))
+	assert.Equal(t, "Milk", items[0].Description)
+	assert.Equal(t, "Bread", items[1].Description)
+}
+
+func Test_deleteList(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	list, _ := db.createList(ctx, List{Name: "Groceries"})
+	item, _ := db.createItem(ctx, Item{Description: "Milk", ListId: list.Id})
+
+	err := db.deleteList(ctx, list.Id, false)
+	assert.NoError(t, err)
+
+	_, err = db.getList(ctx, list.Id)
+	var e *ErrorListNotFound
+	assert.True(t, errors.As(err, &e))
+
+	item, err = db.getItem(ctx, item.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "", item.ListId)
+}
+
+func Test_deleteList_cascade(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	list, _ := db.createList(ctx, List{Name: "Groceries"})
+	item, _ := db.createItem(ctx, Item{Description: "Milk", ListId: list.Id})
+	other, _ := db.createItem(ctx, Item{Description: "Call mom"})
+
+	err := db.deleteList(ctx, list.Id, true)
+	assert.NoError(t, err)
+
+	_, err = db.getItem(ctx, item.Id)
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(err, &e))
+
+	_, err = db.getItem(ctx, other.Id)
+	assert.NoError(t, err)
+}
+
+func Test_deleteList_not_exists(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	err := db.deleteList(ctx, "1327", true)
+	var e *ErrorListNotFound
+	assert.True(t, errors.As(err, &e))
+}
+
+func Test_createSubtask(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	parent, _ := db.createItem(ctx, Item{Description: "Groceries"})
+	first, err := db.createSubtask(ctx, parent.Id, Item{Description: "Milk"})
+	assert.NoError(t, err)
+	assert.Equal(t, parent.Id, first.ParentId)
+	assert.Equal(t, 0, first.Position)
+
+	second, err := db.createSubtask(ctx, parent.Id, Item{Description: "Bread"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, second.Position)
+
+	_, err = db.createSubtask(ctx, "1327", Item{Description: "Eggs"})
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(err, &e))
+}
+
+func Test_subtasks(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	parent, _ := db.createItem(ctx, Item{Description: "Groceries"})
+	milk, _ := db.createSubtask(ctx, parent.Id, Item{Description: "Milk"})
+	db.createSubtask(ctx, parent.Id, Item{Description: "Bread"})
+	db.createSubtask(ctx, milk.Id, Item{Description: "Skimmed"})
+
+	milk.Position = 5
+	db.updateItem(ctx, milk.Id, milk)
+
+	items, err := db.subtasks(ctx, parent.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(items))
+	assert.Equal(t, "Bread", items[0].Description)
+	assert.Equal(t, "Milk", items[1].Description)
+	assert.Equal(t, parent.Id, items[1].ParentId)
+
+	_, err = db.subtasks(ctx, "1327")
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(err, &e))
+}
+
+func Test_deleteItem_subtasks(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	parent, _ := db.createItem(ctx, Item{Description: "Groceries"})
+	milk, _ := db.createSubtask(ctx, parent.Id, Item{Description: "Milk"})
+	skimmed, _ := db.createSubtask(ctx, milk.Id, Item{Description: "Skimmed"})
+	other, _ := db.createItem(ctx, Item{Description: "Call mom"})
+
+	err := db.deleteItem(ctx, parent.Id, 0)
+	assert.NoError(t, err)
+
+	var e *ErrorItemNotFound
+	_, err = db.getItem(ctx, milk.Id)
+	assert.True(t, errors.As(err, &e))
+	_, err = db.getItem(ctx, skimmed.Id)
+	assert.True(t, errors.As(err, &e))
+	_, err = db.getItem(ctx, other.Id)
+	assert.NoError(t, err)
+}
+
+func Test_findItemPage(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	for _, d := range []string{"A", "B", "C", "D", "E"} {
+		db.createItem(ctx, Item{Description: d})
+	}
+
+	page, err := db.findItemPage(ctx, ItemQuery{}, PageRequest{Limit: 2, Total: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, page.Total)
+	assert.Equal(t, 2, len(page.Items))
+	assert.Equal(t, "A", page.Items[0].Description)
+	assert.Equal(t, "B", page.Items[1].Description)
+	assert.Nil(t, page.Prev)
+	assert.NotNil(t, page.Next)
+
+	page, err = db.findItemPage(ctx, ItemQuery{}, PageRequest{Limit: 2, Cursor: page.Next})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(page.Items))
+	assert.Equal(t, "C", page.Items[0].Description)
+	assert.Equal(t, "D", page.Items[1].Description)
+	assert.NotNil(t, page.Prev)
+	assert.NotNil(t, page.Next)
+	prev := page.Prev
+
+	page, err = db.findItemPage(ctx, ItemQuery{}, PageRequest{Limit: 2, Cursor: page.Next})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(page.Items))
+	assert.Equal(t, "E", page.Items[0].Description)
+	assert.Nil(t, page.Next)
+
+	page, err = db.findItemPage(ctx, ItemQuery{}, PageRequest{Limit: 2, Cursor: prev})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(page.Items))
+	assert.Equal(t, "A", page.Items[0].Description)
+	assert.Equal(t, "B", page.Items[1].Description)
+	assert.Nil(t, page.Prev)
+	assert.NotNil(t, page.Next)
+}
+
+func Test_findItemPage_sorted(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	early := time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC)
+	late := early.Add(24 * time.Hour)
+	db.createItem(ctx, Item{Description: "A", DueAt: &late})
+	db.createItem(ctx, Item{Description: "B"})
+	db.createItem(ctx, Item{Description: "C", DueAt: &early})
+	db.createItem(ctx, Item{Description: "D"})
+	db.createItem(ctx, Item{Description: "E", DueAt: &early})
+
+	for _, sort := range [][]SortField{
+		{{Field: SortByDue}},
+		{{Field: SortByDue, Descending: true}},
+		{{Field: SortByDue}, {Field: SortById, Descending: true}},
+		{{Field: SortByDue, Descending: true}, {Field: SortByCreated, Descending: true}},
+	} {
+		query := ItemQuery{Sort: sort}
+		expected, _ := db.findItems(ctx, query)
+
+		var forward []Item
+		page, err := db.findItemPage(ctx, query, PageRequest{Limit: 2})
+		for {
+			assert.NoError(t, err)
+			forward = append(forward, page.Items...)
+			if page.Next == nil {
+				break
+			}
+			page, err = db.findItemPage(ctx, query, PageRequest{Limit: 2, Cursor: page.Next})
+		}
+		assert.Equal(t, expected, forward)
+
+		var backward []Item
+		for page.Prev != nil {
+			page, err = db.findItemPage(ctx, query, PageRequest{Limit: 2, Cursor: page.Prev})
+			assert.NoError(t, err)
+			backward = append(page.Items, backward...)
+		}
+		assert.Equal(t, expected[:len(expected)-1], backward)
+	}
+}
+
+func Test_findItemPage_invalid_cursor(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	one := "1"
+	_, err := db.findItemPage(ctx, ItemQuery{Sort: []SortField{{Field: SortByPriority}}}, PageRequest{Limit: 2, Cursor: &Cursor{Keys: []*string{&one}}})
+	assert.Equal(t, errInvalidCursor, err)
+
+	foo := "foo"
+	_, err = db.findItemPage(ctx, ItemQuery{}, PageRequest{Limit: 2, Cursor: &Cursor{Keys: []*string{&foo}}})
+	assert.Equal(t, errInvalidCursor, err)
+}
+
+func testSearchItems(t *testing.T, db *gormdb) {
+	milk, _ := db.createItem(ctx, Item{Description: "Buy milk", Notes: "Semi-skimmed milk from the corner shop"})
+	bank, _ := db.createItem(ctx, Item{Description: "Call the bank", Notes: "Ask about the milk money"})
+
+	results, err := db.searchItems(ctx, "Milk", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, milk.Id, results[0].Item.Id)
+	assert.Equal(t, "Buy <mark>milk</mark>", results[0].Snippet)
+	assert.Equal(t, bank.Id, results[1].Item.Id)
+	assert.Equal(t, "Ask about the <mark>milk</mark> money", results[1].Snippet)
+	assert.True(t, results[0].Score > results[1].Score)
+
+	results, err = db.searchItems(ctx, "milk shop", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, milk.Id, results[0].Item.Id)
+
+	results, err = db.searchItems(ctx, "milk", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+
+	_, err = db.updateItem(ctx, bank.Id, Item{Description: "Call the building society"})
+	assert.NoError(t, err)
+	results, err = db.searchItems(ctx, "bank", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(results))
+	results, err = db.searchItems(ctx, "society", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "Call the building <mark>society</mark>", results[0].Snippet)
+
+	assert.NoError(t, db.deleteItem(ctx, milk.Id, 0))
+	results, err = db.searchItems(ctx, "milk", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(results))
+}
+
+func Test_searchItems(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	testSearchItems(t, db)
+}
+
+func Test_searchItems_scan(t *testing.T) {
+	db := initDB()
+	defer db.close()
+	db.search = gormSearchScan
+
+	testSearchItems(t, db)
+}
+
+func Test_findItems_filter(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	due := time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC)
+	list, _ := db.createList(ctx, List{Name: "Work"})
+	db.createItem(ctx, Item{Description: "Deploy 100% of the fleet", Priority: PriorityUrgent, Tags: []string{"ops"}, ListId: list.Id})
+	db.createItem(ctx, Item{Description: "Write report", Priority: PriorityLow, DueAt: &due, ListId: list.Id})
+	db.createItem(ctx, Item{Description: "Restart servers", Priority: PriorityLow, Tags: []string{"ops"}, Completed: true})
+	db.createItem(ctx, Item{Description: "Buy milk", Notes: "Semi-skimmed", Priority: PriorityHigh})
+
+	var filterTests = []struct {
+		filter   string
+		expected []string
+	}{
+		{`completed eq false and (priority ge 3 or tag eq "ops")`, []string{"Deploy 100% of the fleet", "Buy milk"}},
+		{`tag ne "ops"`, []string{"Write report", "Buy milk"}},
+		{`due lt "2020-05-11T00:00:00Z"`, []string{"Write report"}},
+		{`not due lt "2020-05-11T00:00:00Z"`, []string{"Deploy 100% of the fleet", "Restart servers", "Buy milk"}},
+		{`due ne "2020-05-10T12:00:00Z"`, []string{"Deploy 100% of the fleet", "Restart servers", "Buy milk"}},
+		{`due eq null`, []string{"Deploy 100% of the fleet", "Restart servers", "Buy milk"}},
+		{`list eq "` + list.Id + `"`, []string{"Deploy 100% of the fleet", "Write report"}},
+		{`list ne "` + list.Id + `"`, []string{"Restart servers", "Buy milk"}},
+		{`list eq "abc"`, []string{}},
+		{`id eq "2" or id eq "4"`, []string{"Write report", "Buy milk"}},
+		{`description contains "100%"`, []string{"Deploy 100% of the fleet"}},
+		{`description contains "RE"`, []string{"Write report", "Restart servers"}},
+		{`notes eq ""`, []string{"Deploy 100% of the fleet", "Write report", "Restart servers"}},
+		{`notes gt "" and not completed eq true`, []string{"Buy milk"}},
+	}
+
+	for _, tt := range filterTests {
+		t.Run(tt.filter, func(t *testing.T) {
+			filter, err := parseFilter(tt.filter)
+			assert.NoError(t, err)
+			items, err := db.findItems(ctx, ItemQuery{Filter: filter})
+			assert.NoError(t, err)
+			descriptions := make([]string, len(items))
+			for i, item := range items {
+				descriptions[i] = item.Description
+			}
+			assert.Equal(t, tt.expected, descriptions)
+		})
+	}
+}
+
+func Test_patchItem(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	due := time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC)
+	createdItem, _ := db.createItem(ctx, Item{Description: "Buy milk", Priority: PriorityHigh, DueAt: &due, Tags: []string{"home"}})
+
+	item, err := db.patchItem(ctx, createdItem.Id, Item{Completed: true, Tags: []string{"shop"}}, []string{FieldCompleted, FieldTags})
+	assert.NoError(t, err)
+	assert.Equal(t, "Buy milk", item.Description)
+	assert.True(t, item.Completed)
+	assert.Equal(t, PriorityHigh, item.Priority)
+	assert.True(t, due.Equal(*item.DueAt))
+	assert.Equal(t, []string{"shop"}, item.Tags)
+
+	item, err = db.getItem(ctx, createdItem.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Buy milk", item.Description)
+	assert.True(t, item.Completed)
+	assert.Equal(t, []string{"shop"}, item.Tags)
+}
+
+func Test_patchItem_errors(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	createdItem, _ := db.createItem(ctx, Item{Description: "Buy milk"})
+
+	_, err := db.patchItem(ctx, createdItem.Id, Item{}, []string{"Size"})
+	var fe *ErrorUnknownField
+	assert.True(t, errors.As(err, &fe))
+
+	_, err = db.patchItem(ctx, "1327", Item{}, []string{FieldCompleted})
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(err, &e))
+
+	_, err = db.patchItem(ctx, createdItem.Id, Item{ListId: "9"}, []string{FieldListId})
+	var le *ErrorListNotFound
+	assert.True(t, errors.As(err, &le))
+}
+
+func Test_updateItem_version(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	createdItem, _ := db.createItem(ctx, Item{Description: "A"})
+	assert.Equal(t, 1, createdItem.Version)
+
+	item, err := db.updateItem(ctx, createdItem.Id, Item{Description: "B", Version: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, item.Version)
+
+	_, err = db.updateItem(ctx, createdItem.Id, Item{Description: "C", Version: 1})
+	var ve *ErrorVersionMismatch
+	assert.True(t, errors.As(err, &ve))
+
+	item, err = db.updateItem(ctx, createdItem.Id, Item{Description: "D"})
+	assert.NoError(t, err)
+	assert.Equal(t, "D", item.Description)
+	assert.Equal(t, 3, item.Version)
+
+	item, err = db.setItemTags(ctx, createdItem.Id, []string{"work"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, item.Version)
+	item, err = db.getItem(ctx, createdItem.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, item.Version)
+}
+
+func Test_deleteItem_version(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	createdItem, _ := db.createItem(ctx, Item{Description: "A"})
+	db.updateItem(ctx, createdItem.Id, Item{Description: "B"})
+
+	err := db.deleteItem(ctx, createdItem.Id, 1)
+	var ve *ErrorVersionMismatch
+	assert.True(t, errors.As(err, &ve))
+
+	err = db.deleteItem(ctx, createdItem.Id, 2)
+	assert.NoError(t, err)
+	_, err = db.getItem(ctx, createdItem.Id)
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(err, &e))
+}
+
+func Test_purgeItem(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	createdItem, _ := db.createItem(ctx, Item{Description: "A", Tags: []string{"work"}})
+	db.deleteItem(ctx, createdItem.Id, 0)
+	var count int
+	db.db.Table("item_tags").Count(&count)
+	assert.Equal(t, 1, count, "tags are kept while an item is in the trash")
+
+	assert.NoError(t, db.purgeItem(ctx, createdItem.Id))
+	db.db.Table("item_tags").Count(&count)
+	assert.Equal(t, 0, count)
+	db.db.Unscoped().Model(&GormItem{}).Count(&count)
+	assert.Equal(t, 0, count)
+}
+
+func Test_createItems(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	_, err := db.createItems(ctx, []Item{{Description: "A"}, {Description: "B", ListId: "9"}}, true)
+	var be *BatchError
+	assert.True(t, errors.As(err, &be))
+	assert.Equal(t, 1, be.Index)
+	var le *ErrorListNotFound
+	assert.True(t, errors.As(err, &le))
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 0)
+
+	results, err := db.createItems(ctx, []Item{{Description: "A", Tags: []string{"work"}}, {Description: "B", ListId: "9"}}, false)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, "A", results[0].Item.Description)
+	assert.Equal(t, []string{"work"}, results[0].Item.Tags)
+	assert.True(t, errors.As(results[1].Err, &le))
+	items, _ = db.allItems(ctx)
+	assert.Len(t, items, 1)
+}
+
+func Test_patchItems(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	a, _ := db.createItem(ctx, Item{Description: "A"})
+	b, _ := db.createItem(ctx, Item{Description: "B"})
+
+	_, err := db.patchItems(ctx, []ItemPatch{
+		{Id: a.Id, Item: Item{Completed: true}, Fields: []string{FieldCompleted}},
+		{Id: b.Id, Item: Item{Completed: true, Version: 2}, Fields: []string{FieldCompleted}},
+	}, true)
+	var ve *ErrorVersionMismatch
+	assert.True(t, errors.As(err, &ve))
+	item, _ := db.getItem(ctx, a.Id)
+	assert.False(t, item.Completed)
+	assert.Equal(t, 1, item.Version)
+
+	results, err := db.patchItems(ctx, []ItemPatch{
+		{Id: a.Id, Item: Item{Completed: true}, Fields: []string{FieldCompleted}},
+		{Id: "1327", Item: Item{Completed: true}, Fields: []string{FieldCompleted}},
+	}, false)
+	assert.NoError(t, err)
+	assert.True(t, results[0].Item.Completed)
+	assert.Equal(t, 2, results[0].Item.Version)
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(results[1].Err, &e))
+}
+
+func Test_deleteItems(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	parent, _ := db.createItem(ctx, Item{Description: "A", Completed: true})
+	db.createSubtask(ctx, parent.Id, Item{Description: "A1", Completed: true})
+	db.createSubtask(ctx, parent.Id, Item{Description: "A2"})
+	db.createItem(ctx, Item{Description: "B", Completed: true})
+	c, _ := db.createItem(ctx, Item{Description: "C"})
+
+	completed := true
+	results, err := db.deleteItems(ctx, ItemQuery{Completed: &completed}, true)
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	for _, r := range results {
+		assert.NoError(t, r.Err)
+		assert.True(t, r.Item.Completed)
+	}
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 1)
+	assert.Equal(t, c.Id, items[0].Id)
+
+	results, err = db.deleteItems(ctx, ItemQuery{Completed: &completed}, false)
+	assert.NoError(t, err)
+	assert.Len(t, results, 0)
+}
+
+func Test_context_done(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := db.createItem(cancelled, Item{Description: "A"})
+	assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+	_, err = db.createItems(cancelled, []Item{{Description: "A"}, {Description: "B"}}, true)
+	assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+	_, err = db.getItem(cancelled, "1")
+	assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+	_, err = db.reserveKey(cancelled, IdempotencyRecord{Key: "a", ExpiresAt: time.Now().Add(time.Minute)})
+	assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+	var count int
+	err = db.with(cancelled).db.Model(&GormItem{}).Count(&count).Error
+	assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+	_, err = db.with(cancelled).db.Raw("SELECT id FROM gorm_items").Rows()
+	assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+
+	items, err := db.allItems(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, items, 0, "nothing is written once the context is done")
+}
+
+func Test_with_settings(t *testing.T) {
+	db := initDB()
+	defer db.close()
+	then := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	db.clock = func() time.Time {
+		return then
+	}
+
+	created, err := db.createItem(ctx, Item{Description: "A"})
+	assert.NoError(t, err)
+	assert.True(t, then.Equal(created.CreatedAt), "the gorm.DB made for a call keeps the settings of the one opened, got %v", created.CreatedAt)
+	assert.True(t, then.Equal(created.UpdatedAt), "%v", created.UpdatedAt)
+}
+
+func Test_keyStore(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	testKeyStore(t, db)
+}
+
+func Test_migrations(t *testing.T) {
+	for _, dialect := range []string{"sqlite3", "mysql", "postgres"} {
+		db := &gormdb{dialect: dialect}
+		migrations := db.migrations()
+		assert.NotEmpty(t, migrations, dialect)
+		assert.Equal(t, Migration{Version: 1, Name: "create_tables"}, migrations[0], dialect)
+		for i, migration := range migrations {
+			assert.Equal(t, i+1, migration.Version, "%s migrations are numbered in order", dialect)
+		}
+	}
+}
+
+func Test_init_schema_behind(t *testing.T) {
+	db := &gormdb{dialect: testDialect, connectionString: testConnectionString}
+	defer func() {
+		r := recover()
+		assert.Equal(t, fmt.Sprintf(`Database schema is at version 0 but version %d is needed, run "todo-api migrate up" or start with -auto-migrate`, latestVersion(db)), r)
+	}()
+	if testDialect == "postgres" {
+		resetPostgres(testConnectionString)
+	}
+	db.init()
+	defer db.close()
+}
+
+func Test_migrate_down(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	db.createItem(ctx, Item{Description: "A"})
+	assert.NoError(t, migrateTo(db, 0))
+	assert.False(t, db.db.HasTable("gorm_items"))
+	statuses, err := migrationStatus(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, schemaVersion(statuses))
+
+	assert.NoError(t, migrateTo(db, latestVersion(db)))
+	item, err := db.createItem(ctx, Item{Description: "B"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", item.Id)
+}
+
+// baselineItem is the table items were kept in before they had anything but a description.
+type baselineItem struct {
+	gorm.Model
+	Description string
+	Completed   bool
+}
+
+func (baselineItem) TableName() string { return "gorm_items" }
+
+func Test_migrate_adopts_baseline(t *testing.T) {
+	if testDialect == "postgres" {
+		resetPostgres(testConnectionString)
+	}
+	db := &gormdb{dialect: testDialect, connectionString: testConnectionString}
+	db.open()
+	defer db.close()
+	assert.NoError(t, db.db.AutoMigrate(&baselineItem{}).Error)
+	assert.NoError(t, db.db.Create(&baselineItem{Description: "A", Completed: true}).Error)
+
+	var out strings.Builder
+	assert.NoError(t, runMigrate(db, []string{"up"}, &out))
+	statuses, err := migrationStatus(db)
+	assert.NoError(t, err)
+	assert.Equal(t, latestVersion(db), schemaVersion(statuses))
+	assert.True(t, db.db.Dialect().HasIndex("gorm_items", "idx_gorm_items_list_id"))
+
+	items, err := db.allItems(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "A", items[0].Description)
+	assert.True(t, items[0].Completed)
+	assert.Equal(t, 1, items[0].Version)
+	list, _ := db.createList(ctx, List{Name: "Shopping"})
+	_, err = db.patchItem(ctx, items[0].Id, Item{Notes: "Semi-skimmed", ListId: list.Id, Tags: []string{"home"}}, []string{FieldNotes, FieldListId, FieldTags})
+	assert.NoError(t, err)
+}
+
+func Test_migrate_completed_at(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	createdItem, _ := db.createItem(ctx, Item{Description: "A", Completed: true})
+	assert.NoError(t, migrateTo(db, 1))
+	assert.False(t, db.db.Dialect().HasColumn("gorm_items", "completed_at"))
+	var description string
+	assert.NoError(t, db.db.Table("gorm_items").Where("id = ?", createdItem.Id).Select("description").Row().Scan(&description))
+	assert.Equal(t, "A", description, "items are kept when the column is dropped")
+
+	assert.NoError(t, migrateTo(db, latestVersion(db)))
+	item, err := db.getItem(ctx, createdItem.Id)
+	assert.NoError(t, err)
+	assert.Nil(t, item.CompletedAt)
+}
+
+func Test_migrate_item_uids(t *testing.T) {
+	db := initDB()
+	defer db.close()
+
+	legacy, _ := db.createItem(ctx, Item{Description: "A"})
+	newID, _ := newIDGenerator(IDFormatULID)
+	db.newID = newID
+	created, err := db.createItem(ctx, Item{Description: "B"})
+	assert.NoError(t, err)
+	assert.NoError(t, migrateTo(db, latestVersion(db)-1))
+	assert.False(t, db.db.Dialect().HasColumn("gorm_items", "uid"))
+	var count int
+	assert.NoError(t, db.db.Table("gorm_items").Count(&count).Error)
+	assert.Equal(t, 2, count, "items are kept when the columns are dropped")
+
+	assert.NoError(t, migrateTo(db, latestVersion(db)))
+	item, err := db.getItem(ctx, legacy.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "A", item.Description)
+	_, err = db.getItem(ctx, created.Id)
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(err, &e), "generated IDs are lost along with the column")
+}
+
+func Test_sqlStatements(t *testing.T) {
+	sql := "-- A comment\nCREATE TABLE a (id integer);\n\nCREATE TABLE b (\n  id integer\n);\nDROP TABLE c"
+	assert.Equal(t, []string{"CREATE TABLE a (id integer);", "CREATE TABLE b (\n  id integer\n);", "DROP TABLE c"}, sqlStatements(sql))
+}
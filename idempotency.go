@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// IdempotencyRecord is the response to the first request sent with an idempotency key, which is replayed for any
+// repeats of the request until ExpiresAt. Status is zero while the first request is still being handled.
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	Status      int
+	ETag        string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore keeps the responses to requests with idempotency keys.
+type IdempotencyStore interface {
+	// reserveKey claims the key of a record which has no response yet. When the key is already held by a record
+	// which has not expired, that record is returned instead and nothing is stored.
+	reserveKey(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error)
+	// saveResponse stores the response to the request which reserved key.
+	saveResponse(ctx context.Context, key string, status int, etag string, body []byte) error
+	// releaseKey forgets key, so that the request can be tried again.
+	releaseKey(ctx context.Context, key string) error
+}
+
+// memoryKeyStore keeps idempotency keys in memory, so they are lost on restart and are not shared between
+// instances.
+type memoryKeyStore struct {
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+}
+
+func newMemoryKeyStore() *memoryKeyStore {
+	return &memoryKeyStore{records: make(map[string]IdempotencyRecord)}
+}
+
+func (s *memoryKeyStore) reserveKey(_ context.Context, record IdempotencyRecord) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, r := range s.records {
+		if !now.Before(r.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+	if r, ok := s.records[record.Key]; ok {
+		return &r, nil
+	}
+	record.Status = 0
+	s.records[record.Key] = record
+	return nil, nil
+}
+
+func (s *memoryKeyStore) saveResponse(_ context.Context, key string, status int, etag string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.records[key]; ok {
+		r.Status = status
+		r.ETag = etag
+		r.Body = body
+		s.records[key] = r
+	}
+	return nil
+}
+
+func (s *memoryKeyStore) releaseKey(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// testKeyStore checks an IdempotencyStore, which must not hold the keys it uses.
+func testKeyStore(t *testing.T, store IdempotencyStore) {
+	expiresAt := time.Now().Add(time.Hour)
+	record, err := store.reserveKey(ctx, IdempotencyRecord{Key: "a", Fingerprint: "1", ExpiresAt: expiresAt})
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+
+	record, err = store.reserveKey(ctx, IdempotencyRecord{Key: "a", Fingerprint: "2", ExpiresAt: expiresAt})
+	assert.NoError(t, err)
+	if assert.NotNil(t, record) {
+		assert.Equal(t, "1", record.Fingerprint)
+		assert.Equal(t, 0, record.Status)
+	}
+
+	assert.NoError(t, store.saveResponse(ctx, "a", 201, `"1"`, []byte(`{"Id":"1"}`)))
+	record, err = store.reserveKey(ctx, IdempotencyRecord{Key: "a", Fingerprint: "1", ExpiresAt: expiresAt})
+	assert.NoError(t, err)
+	if assert.NotNil(t, record) {
+		assert.Equal(t, 201, record.Status)
+		assert.Equal(t, `"1"`, record.ETag)
+		assert.Equal(t, `{"Id":"1"}`, string(record.Body))
+	}
+
+	assert.NoError(t, store.releaseKey(ctx, "a"))
+	record, err = store.reserveKey(ctx, IdempotencyRecord{Key: "a", Fingerprint: "3", ExpiresAt: expiresAt})
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+
+	_, err = store.reserveKey(ctx, IdempotencyRecord{Key: "b", Fingerprint: "1", ExpiresAt: time.Now().Add(-time.Second)})
+	assert.NoError(t, err)
+	record, err = store.reserveKey(ctx, IdempotencyRecord{Key: "b", Fingerprint: "2", ExpiresAt: expiresAt})
+	assert.NoError(t, err)
+	assert.Nil(t, record, "expired keys can be reused")
+}
+
+func Test_memoryKeyStore(t *testing.T) {
+	testKeyStore(t, newMemoryKeyStore())
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the IDs given to new items, which can be chosen with the -ids flag. Legacy IDs are the ones each
+// backend gives out by itself: numbers counting up from 1, or ObjectIDs in Mongo.
+const (
+	IDFormatLegacy = "legacy"
+	IDFormatULID   = "ulid"
+	IDFormatUUIDv7 = "uuidv7"
+)
+
+// idGenerator gives out the IDs of new items. Backends without one give out legacy IDs.
+type idGenerator func() string
+
+// crockford is the alphabet ULIDs are written in, which leaves out I, L, O and U.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newIDGenerator returns the generator for a format, which is nil for legacy IDs.
+func newIDGenerator(format string) (idGenerator, error) {
+	switch format {
+	case IDFormatLegacy:
+		return nil, nil
+	case IDFormatULID:
+		ids := &timeOrderedIDs{bits: 80}
+		return func() string {
+			return encodeULID(ids.next())
+		}, nil
+	case IDFormatUUIDv7:
+		ids := &timeOrderedIDs{bits: 74}
+		return func() string {
+			return encodeUUIDv7(ids.next())
+		}, nil
+	}
+	return nil, fmt.Errorf("Unknown ID format %q", format)
+}
+
+// timeOrderedID is the time an ID was made, in milliseconds since 1970, along with its random bits.
+type timeOrderedID struct {
+	ms     uint64
+	hi, lo uint64
+}
+
+// timeOrderedIDs makes IDs out of the current time and a number of random bits. IDs made within the same
+// millisecond count up from the first one instead, so that IDs sort in the order they were made even when the
+// clock goes back.
+type timeOrderedIDs struct {
+	bits int
+	mu   sync.Mutex
+	last timeOrderedID
+}
+
+func (g *timeOrderedIDs) next() timeOrderedID {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	if ms <= g.last.ms {
+		g.last.lo++
+		if g.last.lo == 0 {
+			g.last.hi++
+		}
+		if g.last.hi < 1<<(g.bits-64) {
+			return g.last
+		}
+		// The random bits ran out, which is next to impossible, so the ID is made as if a millisecond had passed.
+		ms = g.last.ms + 1
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("unable to read random bits for an ID: %v", err))
+	}
+	g.last = timeOrderedID{
+		ms: ms,
+		hi: binary.BigEndian.Uint64(b[:8]) & (1<<(g.bits-64) - 1),
+		lo: binary.BigEndian.Uint64(b[8:]),
+	}
+	return g.last
+}
+
+// encodeULID writes the 48 bits of the time followed by 80 random bits as 26 characters of Crockford's base32.
+func encodeULID(id timeOrderedID) string {
+	hi := id.ms<<16 | id.hi
+	lo := id.lo
+	var s [26]byte
+	for i := len(s) - 1; i >= 0; i-- {
+		s[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
+
+// encodeUUIDv7 writes the 48 bits of the time, the version, 12 random bits, the variant and 62 more random bits
+// as a UUID.
+func encodeUUIDv7(id timeOrderedID) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], id.ms<<16|0x7000|(id.hi<<2|id.lo>>62))
+	binary.BigEndian.PutUint64(b[8:], 1<<63|id.lo&(1<<62-1))
+	h := hex.EncodeToString(b[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// isGeneratedID reports whether an ID is a ULID or a UUID written as the generators write them, which cannot be
+// mistaken for a legacy ID of any backend. Items are looked up by either kind of ID, so that those created before
+// a generator was chosen can still be found.
+func isGeneratedID(id string) bool {
+	switch len(id) {
+	case 26:
+		if id[0] > '7' {
+			return false
+		}
+		for i := 0; i < len(id); i++ {
+			if strings.IndexByte(crockford, id[i]) < 0 {
+				return false
+			}
+		}
+		return true
+	case 36:
+		for i := 0; i < len(id); i++ {
+			switch c := id[i]; {
+			case i == 8 || i == 13 || i == 18 || i == 23:
+				if c != '-' {
+					return false
+				}
+			case !('0' <= c && c <= '9' || 'a' <= c && c <= 'f'):
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func Test_newIDGenerator(t *testing.T) {
+	formats := map[string]*regexp.Regexp{
+		IDFormatULID:   regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`),
+		IDFormatUUIDv7: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+	}
+	for format, pattern := range formats {
+		newID, err := newIDGenerator(format)
+		assert.NoError(t, err)
+		ids := make([]string, 1000)
+		for i := range ids {
+			ids[i] = newID()
+			assert.Regexp(t, pattern, ids[i])
+			assert.True(t, isGeneratedID(ids[i]), ids[i])
+		}
+		assert.True(t, sort.StringsAreSorted(ids), "%s IDs sort in the order they were made", format)
+		for i := 1; i < len(ids); i++ {
+			assert.NotEqual(t, ids[i-1], ids[i])
+		}
+	}
+
+	newID, err := newIDGenerator(IDFormatLegacy)
+	assert.NoError(t, err)
+	assert.Nil(t, newID)
+	_, err = newIDGenerator("snowflake")
+	assert.EqualError(t, err, `Unknown ID format "snowflake"`)
+}
+
+func Test_timeOrderedIDs_overflow(t *testing.T) {
+	ids := &timeOrderedIDs{bits: 74, last: timeOrderedID{ms: 1 << 47, hi: 1<<10 - 1, lo: 1<<64 - 1}}
+	id := ids.next()
+	assert.Equal(t, uint64(1<<47+1), id.ms, "an ID is made in the next millisecond once the random bits run out")
+	assert.True(t, id.hi < 1<<10)
+}
+
+func Test_encodeULID(t *testing.T) {
+	assert.Equal(t, "00000000000000000000000000", encodeULID(timeOrderedID{}))
+	assert.Equal(t, "7ZZZZZZZZZZZZZZZZZZZZZZZZZ", encodeULID(timeOrderedID{ms: 1<<48 - 1, hi: 1<<16 - 1, lo: 1<<64 - 1}))
+	assert.Equal(t, "01ARZ3NDEK0000000000000001", encodeULID(timeOrderedID{ms: 1469922850259, lo: 1}))
+}
+
+func Test_encodeUUIDv7(t *testing.T) {
+	assert.Equal(t, "00000000-0000-7000-8000-000000000000", encodeUUIDv7(timeOrderedID{}))
+	assert.Equal(t, "017f22e2-79b0-7cc3-98c4-dc0c0c07398f", encodeUUIDv7(timeOrderedID{ms: 0x017f22e279b0, hi: 0x0cc3 >> 2, lo: 0x3<<62 | 0x18c4dc0c0c07398f}))
+}
+
+func Test_isGeneratedID(t *testing.T) {
+	tests := map[string]bool{
+		"01ARZ3NDEKTSV4RRFFQ69G5FAV":           true,
+		"017f22e2-79b0-7cc3-98c4-dc0c0c07398f": true,
+		"1":                                    false,
+		"5f1d7a3e9b1e8a0001a2b3c4":             false,
+		"81ARZ3NDEKTSV4RRFFQ69G5FAV":           false,
+		"01ARZ3NDEKTSV4RRFFQ69G5FAU":           false,
+		"01arz3ndektsv4rrffq69g5fav":           false,
+		"017F22E2-79B0-7CC3-98C4-DC0C0C07398F": false,
+		"017f22e2079b007cc3098c40dc0c0c07398f": false,
+	}
+	for id, expected := range tests {
+		assert.Equal(t, expected, isGeneratedID(id), id)
+	}
+}
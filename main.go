@@ -1,47 +1,138 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 )
 
 func main() {
-	dbType := flag.String("db", "", "Database to use. Options are: \"sqlite3\", \"mysql\" and \"mongo\"")
+	dbType := flag.String("db", "", "Database to use. Options are: \"sqlite3\", \"mysql\", \"postgres\", \"mongo\", \"memory\", \"bolt\" and \"events\"")
+	keyStore := flag.String("idempotency-store", "db", "Where to keep responses to requests with an Idempotency-Key. Options are: \"db\", \"memory\" and \"none\"")
+	cacheType := flag.String("cache", "none", "Where to cache items read from the database. Options are: \"none\", \"memory\" and \"redis\"")
+	autoMigrate := flag.Bool("auto-migrate", false, "Apply pending database migrations on startup, instead of refusing to start")
+	ids := flag.String("ids", IDFormatLegacy, "IDs to give new items. Options are: \"legacy\", \"ulid\" and \"uuidv7\". Items keep the IDs they were created with")
 	flag.Parse()
 	a := flag.Args()
 
-	if len(a) != 0 {
+	if len(a) != 0 && (a[0] == "transfer" || a[0] == "export" || a[0] == "import") {
+		if err := runDataCommand(a[0], a[1:], os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(a) != 0 && a[0] != "migrate" && a[0] != "replay" {
 		log.Fatalf("Uknown argument: %s", a[0])
 	}
 
-	var db Database
-	if *dbType == "mongo" {
-		connectionString := os.Getenv("CONNECTION_STRING")
-		if connectionString == "" {
-			log.Fatal("Missing value for CONNECTION_STRING environment variable.")
-		}
-		db = &mongodb{connectionString: connectionString}
-	} else if *dbType == "sqlite3" || *dbType == "mysql" {
-		connectionString := os.Getenv("CONNECTION_STRING")
-		if connectionString == "" {
-			log.Fatal("Missing value for CONNECTION_STRING environment variable.")
-		}
-		db = &gormdb{dialect: *dbType, connectionString: connectionString}
-	} else {
+	newID, err := newIDGenerator(*ids)
+	if err != nil {
+		flag.Usage()
+		log.Fatal(err)
+	}
+	db, err := newDatabase(*dbType, os.Getenv("CONNECTION_STRING"), *autoMigrate, newID)
+	if err == errMissingConnectionString {
+		log.Fatal("Missing value for CONNECTION_STRING environment variable.")
+	} else if err != nil {
 		flag.Usage()
 		log.Fatal("Please specify a valid database to use.")
 	}
 
+	if len(a) != 0 && a[0] == "replay" {
+		replayer, ok := db.(Replayer)
+		if !ok {
+			log.Fatalf("The %s database has no events to replay.", *dbType)
+		}
+		if err := replayer.replay(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if migrator, ok := db.(Migrator); ok {
+		migrator.open()
+		if len(a) != 0 {
+			err := runMigrate(migrator, a[1:], os.Stdout)
+			migrator.close()
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		if err := checkSchema(migrator, *autoMigrate); err != nil {
+			migrator.close()
+			log.Fatal(err)
+		}
+	} else if len(a) != 0 {
+		log.Fatalf("The %s database has no schema to migrate.", *dbType)
+	}
+
 	db.init()
 	defer db.close()
 	router := mux.NewRouter()
-	app := &Application{db: db, router: router}
+	app := &Application{db: db, router: router, keyTTL: defaultKeyTTL}
+	switch *keyStore {
+	case "db":
+		keys, ok := db.(IdempotencyStore)
+		if !ok {
+			log.Fatalf("The %s database cannot store idempotency keys, use -idempotency-store memory or none instead.", *dbType)
+		}
+		app.keys = keys
+	case "memory":
+		app.keys = newMemoryKeyStore()
+	case "none":
+	default:
+		flag.Usage()
+		log.Fatal("Please specify a valid idempotency store to use.")
+	}
+	if v := os.Getenv("IDEMPOTENCY_KEY_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			log.Fatalf("Invalid value for IDEMPOTENCY_KEY_TTL environment variable: %s", v)
+		}
+		app.keyTTL = ttl
+	}
+	// Requests may wait on the database for as long as they need when a timeout is zero.
+	app.readTimeout, app.writeTimeout = defaultReadTimeout, defaultWriteTimeout
+	for name, timeout := range map[string]*time.Duration{"DB_READ_TIMEOUT": &app.readTimeout, "DB_WRITE_TIMEOUT": &app.writeTimeout} {
+		if v := os.Getenv(name); v != "" {
+			*timeout, err = time.ParseDuration(v)
+			if err != nil || *timeout < 0 {
+				log.Fatalf("Invalid value for %s environment variable: %s", name, v)
+			}
+		}
+	}
+	if cache := newCache(*cacheType); cache != nil {
+		ttl := defaultCacheTTL
+		if v := os.Getenv("CACHE_TTL"); v != "" {
+			ttl, err = time.ParseDuration(v)
+			if err != nil || ttl <= 0 {
+				log.Fatalf("Invalid value for CACHE_TTL environment variable: %s", v)
+			}
+		}
+		app.db = newCachedb(db, cache, ttl)
+	}
 	app.initRoutes()
 
+	// Items stay in the trash for good when the retention period is zero.
+	retention := defaultTrashRetention
+	if v := os.Getenv("TRASH_RETENTION"); v != "" {
+		retention, err = time.ParseDuration(v)
+		if err != nil || retention < 0 {
+			log.Fatalf("Invalid value for TRASH_RETENTION environment variable: %s", v)
+		}
+	}
+	if retention > 0 {
+		defer startPurger(db, retention).close()
+	}
+
 	address := os.Getenv("HOST_ADDRESS")
 	log.Printf("Starting web server on %s\n", address)
 	srv := &http.Server{
@@ -51,5 +142,50 @@ func main() {
 		ReadTimeout:  15 * time.Second,
 	}
 
-	log.Fatal(srv.ListenAndServe())
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// The database is closed once the requests in progress have finished.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	log.Print("Shutting down web server")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Print(err)
+	}
+}
+
+// newCache returns the cache of the given type, or nil for none.
+func newCache(cacheType string) Cache {
+	switch cacheType {
+	case "none":
+		return nil
+	case "memory":
+		size := defaultCacheSize
+		if v := os.Getenv("CACHE_SIZE"); v != "" {
+			var err error
+			if size, err = strconv.Atoi(v); err != nil || size <= 0 {
+				log.Fatalf("Invalid value for CACHE_SIZE environment variable: %s", v)
+			}
+		}
+		return newLRUCache(size)
+	case "redis":
+		address := os.Getenv("REDIS_ADDRESS")
+		if address == "" {
+			log.Fatal("Missing value for REDIS_ADDRESS environment variable.")
+		}
+		cache := newRedisCache(address)
+		if err := cache.ping(); err != nil {
+			log.Printf("Unable to reach Redis at %s, reading from the database until it can: %v", address, err)
+		}
+		return cache
+	}
+	flag.Usage()
+	log.Fatal("Please specify a valid cache to use.")
+	return nil
 }
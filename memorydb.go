@@ -0,0 +1,1249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memorydb keeps everything in memory, giving out IDs the way gormdb does unless newID is set. When snapshot names
+// a file, the data is loaded from it by init and saved to it by close.
+type memorydb struct {
+	*memoryKeyStore
+	auditor
+	snapshot string
+	newID    idGenerator
+	mu       *sync.RWMutex
+	data     *memoryData
+	// inTransaction is set on copies made by atomically, which hold the lock and work on a copy of the data.
+	inTransaction bool
+}
+
+// memoryData is everything held by memorydb, as it is saved in a snapshot.
+type memoryData struct {
+	Items map[uint]memoryItem
+	Lists map[uint]List
+	Trash map[uint]memoryItem
+	// Audit is only ever appended to, in the order the entries were recorded.
+	Audit       []AuditEntry
+	LastItemID  uint
+	LastListID  uint
+	LastAuditID uint
+	// keys holds the keys of the items which were given generated IDs, which are kept under the next number all
+	// the same. It is not saved, but rebuilt from the items by indexIDs.
+	keys map[string]uint
+}
+
+// memoryItem is an item as it is kept by memorydb and boltdb.
+type memoryItem struct {
+	Item
+}
+
+func (s *memorydb) init() {
+	s.memoryKeyStore = newMemoryKeyStore()
+	s.mu = &sync.RWMutex{}
+	s.data = &memoryData{Items: make(map[uint]memoryItem), Lists: make(map[uint]List), Trash: make(map[uint]memoryItem), keys: make(map[string]uint)}
+	if s.snapshot == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(s.snapshot)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(b, s.data); err != nil {
+		log.Fatalf("Invalid snapshot %s: %v", s.snapshot, err)
+	}
+	// Snapshots saved before there was a trash have none.
+	if s.data.Trash == nil {
+		s.data.Trash = make(map[uint]memoryItem)
+	}
+	for _, items := range []map[uint]memoryItem{s.data.Items, s.data.Trash} {
+		for id, mi := range items {
+			items[id] = mi.withUpdatedAt()
+		}
+	}
+	s.data.indexIDs()
+}
+
+func (s *memorydb) ping(ctx context.Context) error {
+	return nil
+}
+
+// lock and rlock return the function which releases the lock. Copies made by atomically already hold the lock.
+func (s *memorydb) lock() func() {
+	if s.inTransaction {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+func (s *memorydb) rlock() func() {
+	if s.inTransaction {
+		return func() {}
+	}
+	s.mu.RLock()
+	return s.mu.RUnlock
+}
+
+func (s *memorydb) createItem(ctx context.Context, item Item) (Item, error) {
+	defer s.lock()()
+	return s.data.createItem(item, s.newID, s.auditor)
+}
+
+func (s *memorydb) updateItem(ctx context.Context, id string, td Item) (Item, error) {
+	return s.patchItem(ctx, id, td, updatableFields)
+}
+
+func (s *memorydb) patchItem(ctx context.Context, id string, td Item, fields []string) (Item, error) {
+	defer s.lock()()
+	return s.data.patchItem(id, td, fields, s.auditor)
+}
+
+func (s *memorydb) deleteItem(ctx context.Context, id string, version int) error {
+	defer s.lock()()
+	return s.data.deleteItem(id, version, s.auditor)
+}
+
+func (s *memorydb) getItem(ctx context.Context, id string) (Item, error) {
+	defer s.rlock()()
+	_, mi, err := s.data.lookup(s.data.Items, id)
+	if err != nil {
+		return Item{}, err
+	}
+	return mi.copy(), nil
+}
+
+func (s *memorydb) allItems(ctx context.Context) ([]Item, error) {
+	defer s.rlock()()
+	return toMemoryItems(s.data.sorted(nil)), nil
+}
+
+func (s *memorydb) findItems(ctx context.Context, query ItemQuery) ([]Item, error) {
+	defer s.rlock()()
+	matches, err := s.data.find(query)
+	if err != nil {
+		return make([]Item, 0), err
+	}
+	return toMemoryItems(matches), nil
+}
+
+func (s *memorydb) findItemPage(ctx context.Context, query ItemQuery, page PageRequest) (ItemPage, error) {
+	defer s.rlock()()
+	matches, err := s.data.find(query)
+	if err != nil {
+		return ItemPage{Items: make([]Item, 0)}, err
+	}
+	return pageMemoryItems(matches, query.Sort, page)
+}
+
+func (s *memorydb) searchItems(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	defer s.rlock()()
+	return searchMemoryItems(s.data.sorted(nil), q, limit), nil
+}
+
+func (s *memorydb) setItemTags(ctx context.Context, id string, tags []string) (Item, error) {
+	defer s.lock()()
+	key, mi, err := s.data.lookup(s.data.Items, id)
+	if err != nil {
+		return Item{}, err
+	}
+	before := mi.copy()
+	mi.Tags = normaliseTags(tags)
+	mi.Version++
+	mi.UpdatedAt = time.Now().UTC()
+	s.data.Items[key] = mi
+	s.data.audit(s.entry(AuditUpdate, before, mi.copy(), mi.UpdatedAt))
+	return mi.copy(), nil
+}
+
+func (s *memorydb) allTags(ctx context.Context) ([]TagCount, error) {
+	defer s.rlock()()
+	counts := make(map[string]int)
+	for _, mi := range s.data.Items {
+		for _, tag := range mi.Tags {
+			counts[tag]++
+		}
+	}
+	tags := make([]TagCount, 0, len(counts))
+	for name, count := range counts {
+		tags = append(tags, TagCount{Name: name, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
+
+func (s *memorydb) createList(ctx context.Context, list List) (List, error) {
+	defer s.lock()()
+	s.data.LastListID++
+	list = List{Id: strconv.FormatUint(uint64(s.data.LastListID), 10), Name: list.Name}
+	s.data.Lists[s.data.LastListID] = list
+	return list, nil
+}
+
+func (s *memorydb) updateList(ctx context.Context, id string, list List) (List, error) {
+	defer s.lock()()
+	uintId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return List{}, errors.New("Invalid ID type.")
+	}
+	l, ok := s.data.Lists[uint(uintId)]
+	if !ok {
+		return List{}, &ErrorListNotFound{Id: id}
+	}
+	l.Name = list.Name
+	s.data.Lists[uint(uintId)] = l
+	return l, nil
+}
+
+// deleteList deletes a list along with its items when cascade is set, otherwise its items are moved to the inbox.
+func (s *memorydb) deleteList(ctx context.Context, id string, cascade bool) error {
+	defer s.lock()()
+	uintId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return errors.New("Invalid ID type.")
+	}
+	l, ok := s.data.Lists[uint(uintId)]
+	if !ok {
+		return &ErrorListNotFound{Id: id}
+	}
+	trashed := make(map[string]bool)
+	var deleted []memoryItem
+	for itemID, mi := range s.data.Items {
+		if mi.ListId != l.Id {
+			continue
+		}
+		if cascade {
+			trashed[mi.Id] = true
+			deleted = append(deleted, mi)
+			continue
+		}
+		mi.ListId = ""
+		mi.Version++
+		mi.UpdatedAt = time.Now().UTC()
+		s.data.Items[itemID] = mi
+	}
+	deletedAt := time.Now().UTC()
+	s.data.trash(trashed, deletedAt)
+	sortMemoryItems(deleted, nil)
+	for _, mi := range deleted {
+		s.data.audit(s.entry(AuditDelete, mi.copy(), Item{}, deletedAt))
+	}
+	delete(s.data.Lists, uint(uintId))
+	return nil
+}
+
+func (s *memorydb) getList(ctx context.Context, id string) (List, error) {
+	defer s.rlock()()
+	uintId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return List{}, errors.New("Invalid ID type.")
+	}
+	l, ok := s.data.Lists[uint(uintId)]
+	if !ok {
+		return List{}, &ErrorListNotFound{Id: id}
+	}
+	return l, nil
+}
+
+func (s *memorydb) allLists(ctx context.Context) ([]List, error) {
+	defer s.rlock()()
+	lists := make([]List, 0, len(s.data.Lists))
+	for _, l := range s.data.Lists {
+		lists = append(lists, l)
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		return memoryID(lists[i].Id) < memoryID(lists[j].Id)
+	})
+	return lists, nil
+}
+
+// createSubtask adds an item to the end of the subtasks of its parent.
+func (s *memorydb) createSubtask(ctx context.Context, parentId string, item Item) (Item, error) {
+	defer s.lock()()
+	parentID, err := s.data.parentID(parentId)
+	if err != nil {
+		return Item{}, err
+	}
+	item.ParentId = parentID
+	item.Position = 0
+	last := false
+	for _, mi := range s.data.Items {
+		if mi.ParentId == parentID && (!last || mi.Position >= item.Position) {
+			item.Position = mi.Position + 1
+			last = true
+		}
+	}
+	return s.data.createItem(item, s.newID, s.auditor)
+}
+
+func (s *memorydb) subtasks(ctx context.Context, parentId string) ([]Item, error) {
+	defer s.rlock()()
+	parentID, err := s.data.parentID(parentId)
+	if err != nil {
+		return make([]Item, 0), err
+	}
+	var subtasks []memoryItem
+	for _, mi := range s.data.sorted(nil) {
+		if mi.ParentId == parentID && parentID != "" {
+			subtasks = append(subtasks, mi)
+		}
+	}
+	sort.SliceStable(subtasks, func(i, j int) bool {
+		return subtasks[i].Position < subtasks[j].Position
+	})
+	return toMemoryItems(subtasks), nil
+}
+
+// trashedItems returns the items in the trash, most recently deleted first.
+func (s *memorydb) trashedItems(ctx context.Context) ([]Item, error) {
+	defer s.rlock()()
+	return toMemoryItems(s.data.trashed()), nil
+}
+
+// restoreItem moves an item back out of the trash along with the subtasks which were deleted with it.
+func (s *memorydb) restoreItem(ctx context.Context, id string) (Item, error) {
+	defer s.lock()()
+	_, mi, err := s.data.lookup(s.data.Trash, id)
+	if err != nil {
+		return Item{}, err
+	}
+	itemExists := func(id string) bool {
+		_, _, err := s.data.lookup(s.data.Items, id)
+		return err == nil
+	}
+	listExists := func(id string) bool {
+		_, ok := s.data.Lists[uint(memoryID(id))]
+		return ok
+	}
+	restored, err := restorableItems(mi, s.data.trashed(), itemExists, listExists)
+	if err != nil {
+		return Item{}, err
+	}
+	for _, mi := range restored {
+		key, _ := s.data.key(mi.Id)
+		delete(s.data.Trash, key)
+		s.data.Items[key] = mi
+	}
+	s.data.audit(s.entry(AuditRestore, mi.copy(), restored[0].copy(), restored[0].UpdatedAt))
+	return restored[0].copy(), nil
+}
+
+// purgeItem deletes an item in the trash for good, along with its subtasks.
+func (s *memorydb) purgeItem(ctx context.Context, id string) error {
+	defer s.lock()()
+	_, mi, err := s.data.lookup(s.data.Trash, id)
+	if err != nil {
+		return err
+	}
+	for _, purged := range trashedSubtasks(mi, s.data.trashed(), false) {
+		key, _ := s.data.key(purged.Id)
+		s.data.purge(key)
+	}
+	return nil
+}
+
+// purgeTrash deletes the items which were moved to the trash before the given time for good.
+func (s *memorydb) purgeTrash(ctx context.Context, before time.Time) (int, error) {
+	defer s.lock()()
+	purged := 0
+	for itemID, mi := range s.data.Trash {
+		if mi.DeletedAt.Before(before) {
+			s.data.purge(itemID)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// auditedBy returns a copy of the database which shares its data, and records the writes it makes as made by
+// actor.
+func (s *memorydb) auditedBy(actor, requestId string) Database {
+	t := *s
+	t.auditor = auditor{actor: actor, requestId: requestId}
+	return &t
+}
+
+func (s *memorydb) itemHistory(ctx context.Context, id string) ([]AuditEntry, error) {
+	defer s.rlock()()
+	var entries []AuditEntry
+	for _, entry := range s.data.Audit {
+		if entry.ItemId == id {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, &ErrorItemNotFound{Id: id}
+	}
+	return entries, nil
+}
+
+func (s *memorydb) auditEntries(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	defer s.rlock()()
+	return memoryAuditEntries(s.data.Audit, query)
+}
+
+// atomically runs fn with a copy of the database which works on a copy of the data, replacing the data with it
+// only if fn succeeds. Other calls wait until fn is done.
+func (s *memorydb) atomically(ctx context.Context, fn func(db Database) error) error {
+	defer s.lock()()
+	t := *s
+	t.data = s.data.copy()
+	t.inTransaction = true
+	if err := fn(&t); err != nil {
+		return err
+	}
+	*s.data = *t.data
+	return nil
+}
+
+func (s *memorydb) createItems(ctx context.Context, items []Item, atomic bool) ([]BatchResult, error) {
+	return createItems(ctx, s, items, atomic, s.atomically)
+}
+
+func (s *memorydb) patchItems(ctx context.Context, patches []ItemPatch, atomic bool) ([]BatchResult, error) {
+	return patchItems(ctx, s, patches, atomic, s.atomically)
+}
+
+func (s *memorydb) deleteItems(ctx context.Context, query ItemQuery, atomic bool) ([]BatchResult, error) {
+	return deleteItems(ctx, s, query, atomic, s.atomically)
+}
+
+// close saves a snapshot, replacing the previous one only once the new one has been written in full.
+func (s *memorydb) close() {
+	if s.snapshot == "" {
+		return
+	}
+	defer s.rlock()()
+	if err := saveSnapshot(s.snapshot, s.data); err != nil {
+		log.Printf("Unable to save snapshot %s: %v", s.snapshot, err)
+	}
+}
+
+// saveSnapshot writes v to a file as JSON, replacing the file only once it has been written in full.
+func saveSnapshot(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// createItem gives the item the next key, which is its ID unless newID gives it one.
+func (d *memoryData) createItem(item Item, newID idGenerator, a auditor) (Item, error) {
+	listID, err := d.listID(item.ListId)
+	if err != nil {
+		return Item{}, err
+	}
+	parentID, err := d.parentID(item.ParentId)
+	if err != nil {
+		return Item{}, err
+	}
+	d.LastItemID++
+	id := strconv.FormatUint(uint64(d.LastItemID), 10)
+	if newID != nil {
+		id = newID()
+		d.keys[id] = d.LastItemID
+	}
+	mi := newMemoryItem(id, item, listID, parentID)
+	d.Items[d.LastItemID] = mi
+	d.audit(a.entry(AuditCreate, Item{}, mi.copy(), mi.CreatedAt))
+	return mi.copy(), nil
+}
+
+// patchItem only writes the fields of td named in the field mask, leaving the others as they are.
+func (d *memoryData) patchItem(id string, td Item, fields []string, a auditor) (Item, error) {
+	key, mi, err := d.lookup(d.Items, id)
+	if err != nil {
+		return Item{}, err
+	}
+	before := mi.copy()
+	if err := patchMemoryItem(&mi, td, fields, d.listID, d.parentID); err != nil {
+		return Item{}, err
+	}
+	d.Items[key] = mi
+	d.audit(a.entry(AuditUpdate, before, mi.copy(), mi.UpdatedAt))
+	return mi.copy(), nil
+}
+
+// deleteItem moves an item to the trash along with its subtasks.
+func (d *memoryData) deleteItem(id string, version int, a auditor) error {
+	_, mi, err := d.lookup(d.Items, id)
+	if err != nil {
+		return err
+	}
+	if version != 0 && mi.Version != version {
+		return &ErrorVersionMismatch{Id: id}
+	}
+	deletedAt := time.Now().UTC()
+	d.trash(map[string]bool{mi.Id: true}, deletedAt)
+	d.audit(a.entry(AuditDelete, mi.copy(), Item{}, deletedAt))
+	return nil
+}
+
+// audit appends an entry to the audit log, giving it the next ID.
+func (d *memoryData) audit(entry AuditEntry) {
+	d.LastAuditID++
+	entry.Id = strconv.FormatUint(uint64(d.LastAuditID), 10)
+	d.Audit = append(d.Audit, entry)
+}
+
+// trash moves items to the trash along with their subtasks, level by level. They are all given the same
+// deletion time, so that they can be restored together.
+func (d *memoryData) trash(parents map[string]bool, deletedAt time.Time) {
+	for len(parents) > 0 {
+		children := make(map[string]bool)
+		for itemID, child := range d.Items {
+			if parents[child.ParentId] {
+				children[child.Id] = true
+			}
+			if parents[child.Id] {
+				child.DeletedAt = &deletedAt
+				d.Trash[itemID] = child
+				delete(d.Items, itemID)
+			}
+		}
+		parents = children
+	}
+}
+
+// purge deletes an item in the trash for good.
+func (d *memoryData) purge(key uint) {
+	delete(d.keys, d.Trash[key].Id)
+	delete(d.Trash, key)
+}
+
+// trashed returns the items in the trash, most recently deleted first.
+func (d *memoryData) trashed() []memoryItem {
+	items := make([]memoryItem, 0, len(d.Trash))
+	for _, mi := range d.Trash {
+		items = append(items, mi)
+	}
+	sortTrash(items)
+	return items
+}
+
+// find returns the items matched by the query, in the order it asks for.
+func (d *memoryData) find(query ItemQuery) ([]memoryItem, error) {
+	items := make([]memoryItem, 0, len(d.Items))
+	for _, mi := range d.Items {
+		items = append(items, mi)
+	}
+	return findMemoryItems(items, query)
+}
+
+// sorted returns every item in the order given by sortFields, followed by their IDs.
+func (d *memoryData) sorted(sortFields []SortField) []memoryItem {
+	items := make([]memoryItem, 0, len(d.Items))
+	for _, mi := range d.Items {
+		items = append(items, mi)
+	}
+	sortMemoryItems(items, sortFields)
+	return items
+}
+
+// key returns the key an item with the given ID is kept under, which is the ID itself for a legacy ID. A
+// generated ID which was never given out has no key, so is not found.
+func (d *memoryData) key(id string) (uint, error) {
+	if isGeneratedID(id) {
+		return d.keys[id], nil
+	}
+	uintId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, errors.New("Invalid ID type.")
+	}
+	return uint(uintId), nil
+}
+
+// lookup returns an item in items along with its key. An item which was given a generated ID is only found by
+// it, and not by its key, which would be easy to guess.
+func (d *memoryData) lookup(items map[uint]memoryItem, id string) (uint, memoryItem, error) {
+	key, err := d.key(id)
+	if err != nil {
+		return 0, memoryItem{}, err
+	}
+	mi, ok := items[key]
+	if !ok || mi.Id != id && isGeneratedID(mi.Id) {
+		return 0, memoryItem{}, &ErrorItemNotFound{Id: id}
+	}
+	return key, mi, nil
+}
+
+// indexIDs rebuilds the keys of the items which were given generated IDs.
+func (d *memoryData) indexIDs() {
+	d.keys = make(map[string]uint)
+	for _, items := range []map[uint]memoryItem{d.Items, d.Trash} {
+		for key, mi := range items {
+			if isGeneratedID(mi.Id) {
+				d.keys[mi.Id] = key
+			}
+		}
+	}
+}
+
+// parentID resolves the parent item of a subtask, where an empty id means the item is not a subtask.
+func (d *memoryData) parentID(id string) (string, error) {
+	if id == "" {
+		return "", nil
+	}
+	_, parent, err := d.lookup(d.Items, id)
+	if err != nil {
+		return "", &ErrorItemNotFound{Id: id}
+	}
+	return parent.Id, nil
+}
+
+// listID resolves the list an item refers to, where an empty id refers to the inbox.
+func (d *memoryData) listID(id string) (string, error) {
+	if id == "" {
+		return "", nil
+	}
+	uintId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return "", &ErrorListNotFound{Id: id}
+	}
+	if _, ok := d.Lists[uint(uintId)]; !ok {
+		return "", &ErrorListNotFound{Id: id}
+	}
+	return strconv.FormatUint(uintId, 10), nil
+}
+
+// newMemoryItem returns a new item with the given ID, in the list and under the parent which have been resolved
+// already.
+func newMemoryItem(id string, item Item, listID string, parentID string) memoryItem {
+	now := time.Now().UTC()
+	mi := memoryItem{
+		Item: Item{
+			Id:                   id,
+			Description:          item.Description,
+			Notes:                item.Notes,
+			Completed:            item.Completed,
+			Priority:             item.Priority,
+			DueAt:                utc(item.DueAt),
+			StartAt:              utc(item.StartAt),
+			ListId:               listID,
+			Tags:                 normaliseTags(item.Tags),
+			ParentId:             parentID,
+			Position:             item.Position,
+			CompleteWithSubtasks: item.CompleteWithSubtasks,
+			Version:              1,
+			CreatedAt:            now,
+			UpdatedAt:            now,
+		},
+	}
+	if item.Completed {
+		mi.CompletedAt = &now
+	}
+	return mi
+}
+
+// patchMemoryItem writes the fields of td named in the field mask to an item and bumps its version, resolving
+// lists and parents with the given functions.
+func patchMemoryItem(mi *memoryItem, td Item, fields []string, listID, parentID func(id string) (string, error)) error {
+	patched := *mi
+	var err error
+	for _, field := range fields {
+		switch field {
+		case FieldDescription:
+			patched.Description = td.Description
+		case FieldNotes:
+			patched.Notes = td.Notes
+		case FieldCompleted:
+			patched.Completed = td.Completed
+			// Completing an item which is complete already leaves the time it was completed alone.
+			if !td.Completed {
+				patched.CompletedAt = nil
+			} else if patched.CompletedAt == nil {
+				now := time.Now().UTC()
+				patched.CompletedAt = &now
+			}
+		case FieldPriority:
+			patched.Priority = td.Priority
+		case FieldDueAt:
+			patched.DueAt = utc(td.DueAt)
+		case FieldStartAt:
+			patched.StartAt = utc(td.StartAt)
+		case FieldListId:
+			if patched.ListId, err = listID(td.ListId); err != nil {
+				return err
+			}
+		case FieldParentId:
+			if patched.ParentId, err = parentID(td.ParentId); err != nil {
+				return err
+			}
+		case FieldPosition:
+			patched.Position = td.Position
+		case FieldCompleteWithSubtasks:
+			patched.CompleteWithSubtasks = td.CompleteWithSubtasks
+		case FieldTags:
+			patched.Tags = normaliseTags(td.Tags)
+		default:
+			return &ErrorUnknownField{Field: field}
+		}
+	}
+	if td.Version != 0 && td.Version != mi.Version {
+		return &ErrorVersionMismatch{Id: mi.Id}
+	}
+	patched.Version++
+	patched.UpdatedAt = time.Now().UTC()
+	*mi = patched
+	return nil
+}
+
+// pageMemoryItems returns a page of the items, which are sorted in the order given by sortFields already.
+func pageMemoryItems(matches []memoryItem, sortFields []SortField, page PageRequest) (ItemPage, error) {
+	var result ItemPage
+	if page.Total {
+		result.Total = len(matches)
+	}
+
+	fields := memorySortFields(sortFields)
+	before := page.Cursor != nil && page.Cursor.Before
+	if page.Cursor != nil {
+		if len(page.Cursor.Keys) != len(fields) {
+			return ItemPage{Items: make([]Item, 0)}, errInvalidCursor
+		}
+		cursor := make([]interface{}, len(fields))
+		for i, f := range fields {
+			var err error
+			if cursor[i], err = memoryCursorValue(f.Field, page.Cursor.Keys[i]); err != nil {
+				return ItemPage{Items: make([]Item, 0)}, err
+			}
+		}
+		var remaining []memoryItem
+		for _, mi := range matches {
+			c := compareSortKeys(memorySortValues(mi, fields), cursor, fields)
+			if before && c < 0 || !before && c > 0 {
+				remaining = append(remaining, mi)
+			}
+		}
+		matches = remaining
+	}
+	if before {
+		for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+			matches[i], matches[j] = matches[j], matches[i]
+		}
+	}
+
+	more := len(matches) > page.Limit
+	if more {
+		matches = matches[:page.Limit]
+	}
+	if before {
+		for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+			matches[i], matches[j] = matches[j], matches[i]
+		}
+	}
+
+	result.Items = toMemoryItems(matches)
+	if len(matches) > 0 {
+		if before || more {
+			result.Next = &Cursor{Keys: memoryCursorKeys(matches[len(matches)-1], fields)}
+		}
+		if before && more || !before && page.Cursor != nil {
+			result.Prev = &Cursor{Keys: memoryCursorKeys(matches[0], fields), Before: true}
+		}
+	}
+	return result, nil
+}
+
+// searchMemoryItems ranks the items matching a search, which are given in ID order.
+func searchMemoryItems(items []memoryItem, q string, limit int) []SearchResult {
+	terms := searchTerms(q)
+	results := make([]SearchResult, 0)
+	if len(terms) == 0 {
+		return results
+	}
+	for _, mi := range items {
+		item := mi.copy()
+		if score := matchScore(item, terms); score > 0 {
+			results = append(results, SearchResult{Item: item, Score: score, Snippet: itemSnippet(item, terms)})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func (d *memoryData) copy() *memoryData {
+	c := &memoryData{
+		Items: make(map[uint]memoryItem, len(d.Items)),
+		Lists: make(map[uint]List, len(d.Lists)),
+		Trash: make(map[uint]memoryItem, len(d.Trash)),
+		// The log is only appended to, so the copy shares it until it appends to it itself.
+		Audit:       d.Audit[:len(d.Audit):len(d.Audit)],
+		LastItemID:  d.LastItemID,
+		LastListID:  d.LastListID,
+		LastAuditID: d.LastAuditID,
+		keys:        make(map[string]uint, len(d.keys)),
+	}
+	for id, key := range d.keys {
+		c.keys[id] = key
+	}
+	for id, mi := range d.Items {
+		c.Items[id] = mi
+	}
+	for id, l := range d.Lists {
+		c.Lists[id] = l
+	}
+	for id, mi := range d.Trash {
+		c.Trash[id] = mi
+	}
+	return c
+}
+
+// memoryAuditEntries returns the entries of a log, in the order they were recorded, which are wanted by query.
+// Entry IDs are numbers which go up as entries are recorded.
+func memoryAuditEntries(log []AuditEntry, query AuditQuery) ([]AuditEntry, error) {
+	var after uint64
+	if query.After != "" {
+		var err error
+		if after, err = strconv.ParseUint(query.After, 10, 64); err != nil {
+			return nil, errInvalidCursor
+		}
+	}
+	entries := make([]AuditEntry, 0)
+	for _, entry := range log {
+		if query.Limit > 0 && len(entries) == query.Limit {
+			break
+		}
+		if memoryID(entry.Id) > after && !entry.At.Before(query.Since) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// withUpdatedAt returns the item with an UpdatedAt. Items saved before they had one are taken to have been last
+// updated when they were created.
+func (mi memoryItem) withUpdatedAt() memoryItem {
+	if mi.UpdatedAt.IsZero() {
+		mi.UpdatedAt = mi.CreatedAt
+	}
+	return mi
+}
+
+// copy returns the item with its own tags, since the stored item is shared with whoever it is returned to.
+func (mi memoryItem) copy() Item {
+	item := mi.Item
+	item.Tags = append(make([]string, 0, len(mi.Tags)), mi.Tags...)
+	return item
+}
+
+func toMemoryItems(mis []memoryItem) []Item {
+	items := make([]Item, len(mis))
+	for i, mi := range mis {
+		items[i] = mi.copy()
+	}
+	return items
+}
+
+// memoryID returns the numeric value of an ID given out by memorydb.
+func memoryID(id string) uint64 {
+	uintId, _ := strconv.ParseUint(id, 10, 64)
+	return uintId
+}
+
+// memorySortID returns a value which sorts IDs given out by memorydb in the order they were given out: legacy IDs
+// as numbers, followed by generated IDs, which sort as text. ok is false for an ID which cannot have been given
+// out.
+func memorySortID(id string) (sortID string, ok bool) {
+	if isGeneratedID(id) {
+		return "1" + id, true
+	}
+	uintId, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("0%020d", uintId), true
+}
+
+// findMemoryItems returns the items matched by the query, in the order it asks for.
+func findMemoryItems(items []memoryItem, query ItemQuery) ([]memoryItem, error) {
+	if query.ListId != "" {
+		if _, err := strconv.ParseUint(query.ListId, 10, 64); err != nil {
+			return nil, &ErrorListNotFound{Id: query.ListId}
+		}
+	}
+	var matches []memoryItem
+	for _, mi := range items {
+		if matchItemQuery(mi.Item, query) {
+			matches = append(matches, mi)
+		}
+	}
+	sortMemoryItems(matches, query.Sort)
+	return matches, nil
+}
+
+// matchItemQuery returns whether an item is matched by every part of a query.
+func matchItemQuery(item Item, query ItemQuery) bool {
+	if query.ListId != "" && (item.ListId == "" || memoryID(item.ListId) != memoryID(query.ListId)) {
+		return false
+	}
+	if query.Completed != nil && item.Completed != *query.Completed {
+		return false
+	}
+	if query.DueBefore != nil && (item.DueAt == nil || !item.DueAt.Before(*query.DueBefore)) {
+		return false
+	}
+	tags := make(map[string]bool, len(item.Tags))
+	for _, tag := range item.Tags {
+		tags[tag] = true
+	}
+	for _, tag := range normaliseTags(query.AllTags) {
+		if !tags[tag] {
+			return false
+		}
+	}
+	if len(query.AnyTags) > 0 {
+		found := false
+		for _, tag := range normaliseTags(query.AnyTags) {
+			found = found || tags[tag]
+		}
+		if !found {
+			return false
+		}
+	}
+	return query.Filter == nil || matchFilter(item, query.Filter)
+}
+
+// matchFilter evaluates a filter expression against an item, treating missing values as the other backends do.
+func matchFilter(item Item, expr FilterExpr) bool {
+	switch e := expr.(type) {
+	case FilterLogical:
+		for _, operand := range e.Operands {
+			if matchFilter(item, operand) != (e.Op == FilterAnd) {
+				return e.Op != FilterAnd
+			}
+		}
+		return e.Op == FilterAnd
+	case FilterNot:
+		return !matchFilter(item, e.Operand)
+	case FilterComparison:
+		return matchComparison(item, e)
+	}
+	panic(fmt.Sprintf("unknown filter expression %T", expr))
+}
+
+func matchComparison(item Item, c FilterComparison) bool {
+	field := filterFields[c.Field]
+	if field.kind == filterTag {
+		found := false
+		for _, tag := range item.Tags {
+			found = found || tag == c.Value
+		}
+		return found != (c.Op == FilterNe)
+	}
+
+	v := memoryFieldValue(item, c.Field)
+	value := c.Value
+	switch {
+	case value == nil:
+		return (v == nil) == (c.Op == FilterEq)
+	case field.kind == filterID:
+		// IDs which cannot exist in this database never equal any item's.
+		id, ok := memorySortID(value.(string))
+		if !ok {
+			return c.Op != FilterEq
+		}
+		value = id
+	case field.kind == filterString && c.Op == FilterContains:
+		return strings.Contains(strings.ToLower(v.(string)), strings.ToLower(value.(string)))
+	}
+	if v == nil {
+		return c.Op == FilterNe
+	}
+
+	cmp := compareValues(v, value)
+	switch c.Op {
+	case FilterEq:
+		return cmp == 0
+	case FilterNe:
+		return cmp != 0
+	case FilterLt:
+		return cmp < 0
+	case FilterLe:
+		return cmp <= 0
+	case FilterGt:
+		return cmp > 0
+	case FilterGe:
+		return cmp >= 0
+	}
+	return false
+}
+
+// memoryFieldValue returns the value of a sortable or filterable field of an item, which is nil when missing.
+func memoryFieldValue(item Item, field string) interface{} {
+	switch field {
+	case SortById:
+		id, _ := memorySortID(item.Id)
+		return id
+	case SortByDescription:
+		return item.Description
+	case FilterByNotes:
+		return item.Notes
+	case FilterByCompleted:
+		return item.Completed
+	case SortByPriority:
+		return item.Priority
+	case SortByDue:
+		if item.DueAt == nil {
+			return nil
+		}
+		return item.DueAt.UTC()
+	case SortByStart:
+		if item.StartAt == nil {
+			return nil
+		}
+		return item.StartAt.UTC()
+	case SortByCreated:
+		return item.CreatedAt.UTC()
+	case SortByUpdated:
+		return item.UpdatedAt.UTC()
+	case SortByCompletedAt:
+		if item.CompletedAt == nil {
+			return nil
+		}
+		return item.CompletedAt.UTC()
+	case FilterByList:
+		if item.ListId == "" {
+			return nil
+		}
+		id, _ := memorySortID(item.ListId)
+		return id
+	case FilterByParent:
+		if item.ParentId == "" {
+			return nil
+		}
+		id, _ := memorySortID(item.ParentId)
+		return id
+	case FilterByPosition:
+		return item.Position
+	}
+	panic(fmt.Sprintf("unknown field %s", field))
+}
+
+// compareValues compares two values of the same type, returning a negative number when a comes first.
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case bool:
+		if a == b.(bool) {
+			return 0
+		} else if a {
+			return 1
+		}
+		return -1
+	case int:
+		return a - b.(int)
+	case uint64:
+		if a < b.(uint64) {
+			return -1
+		} else if a > b.(uint64) {
+			return 1
+		}
+		return 0
+	case time.Time:
+		if a.Before(b.(time.Time)) {
+			return -1
+		} else if a.After(b.(time.Time)) {
+			return 1
+		}
+		return 0
+	}
+	panic(fmt.Sprintf("cannot compare %T", a))
+}
+
+// sortMemoryItems sorts items in the order given by sortFields, followed by their IDs.
+func sortMemoryItems(items []memoryItem, sortFields []SortField) {
+	fields := memorySortFields(sortFields)
+	sort.Slice(items, func(i, j int) bool {
+		return compareSortKeys(memorySortValues(items[i], fields), memorySortValues(items[j], fields), fields) < 0
+	})
+}
+
+// memorySortFields returns the fields to sort by, ending with the ID so that the order is stable.
+func memorySortFields(fields []SortField) []SortField {
+	var sortFields []SortField
+	seen := make(map[string]bool)
+	fields = append(fields[:len(fields):len(fields)], SortField{Field: SortById})
+	for _, f := range fields {
+		if seen[f.Field] {
+			continue
+		}
+		seen[f.Field] = true
+		sortFields = append(sortFields, f)
+	}
+	return sortFields
+}
+
+func memorySortValues(mi memoryItem, fields []SortField) []interface{} {
+	values := make([]interface{}, len(fields))
+	for i, f := range fields {
+		values[i] = memoryFieldValue(mi.Item, f.Field)
+	}
+	return values
+}
+
+// compareSortKeys compares the sort keys of two items, where NULLs sort before any other value as they do in
+// SQLite and MySQL.
+func compareSortKeys(a, b []interface{}, fields []SortField) int {
+	for i, f := range fields {
+		var c int
+		switch {
+		case a[i] == nil && b[i] == nil:
+			c = 0
+		case a[i] == nil:
+			c = -1
+		case b[i] == nil:
+			c = 1
+		default:
+			c = compareValues(a[i], b[i])
+		}
+		if f.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func memoryCursorValue(field string, key *string) (interface{}, error) {
+	switch field {
+	case SortByDue, SortByStart, SortByCompletedAt:
+		return parseCursorTime(key, true)
+	case SortByCreated, SortByUpdated:
+		return parseCursorTime(key, false)
+	}
+	if key == nil {
+		return nil, errInvalidCursor
+	}
+	switch field {
+	case SortById:
+		id, ok := memorySortID(*key)
+		if !ok {
+			return nil, errInvalidCursor
+		}
+		return id, nil
+	case SortByPriority:
+		priority, err := strconv.Atoi(*key)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		return priority, nil
+	}
+	return *key, nil
+}
+
+func memoryCursorKeys(mi memoryItem, fields []SortField) []*string {
+	values := make([]*string, len(fields))
+	for i, f := range fields {
+		var v string
+		switch f.Field {
+		case SortById:
+			v = mi.Id
+		case SortByDescription:
+			v = mi.Description
+		case SortByPriority:
+			v = strconv.Itoa(mi.Priority)
+		case SortByDue:
+			values[i] = cursorTime(mi.DueAt)
+			continue
+		case SortByStart:
+			values[i] = cursorTime(mi.StartAt)
+			continue
+		case SortByCreated:
+			values[i] = cursorTime(&mi.CreatedAt)
+			continue
+		case SortByUpdated:
+			values[i] = cursorTime(&mi.UpdatedAt)
+			continue
+		case SortByCompletedAt:
+			values[i] = cursorTime(mi.CompletedAt)
+			continue
+		}
+		values[i] = &v
+	}
+	return values
+}
+
+// sortTrash sorts items in the trash most recently deleted first, then in ID order.
+func sortTrash(items []memoryItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if a, b := *items[i].DeletedAt, *items[j].DeletedAt; !a.Equal(b) {
+			return a.After(b)
+		}
+		a, _ := memorySortID(items[i].Id)
+		b, _ := memorySortID(items[j].Id)
+		return a < b
+	})
+}
+
+// trashedSubtasks returns an item in the trash followed by its subtasks in the trash, level by level. When
+// sameTime is set, only the subtasks which were deleted along with the item are returned.
+func trashedSubtasks(root memoryItem, trash []memoryItem, sameTime bool) []memoryItem {
+	items := []memoryItem{root}
+	parents := map[string]bool{root.Id: true}
+	for len(parents) > 0 {
+		children := make(map[string]bool)
+		for _, mi := range trash {
+			if parents[mi.ParentId] && (!sameTime || mi.DeletedAt.Equal(*root.DeletedAt)) {
+				children[mi.Id] = true
+				items = append(items, mi)
+			}
+		}
+		parents = children
+	}
+	return items
+}
+
+// restorableItems returns an item in the trash along with the subtasks which were deleted with it, as they are
+// once restored. A subtask cannot be restored while its parent is in the trash, but becomes a top level item once
+// its parent has been purged. Items in a list which has since been deleted are moved to the inbox.
+func restorableItems(root memoryItem, trash []memoryItem, itemExists, listExists func(id string) bool) ([]memoryItem, error) {
+	if root.ParentId != "" {
+		for _, mi := range trash {
+			if mi.Id == root.ParentId {
+				return nil, &ErrorItemTrashed{Id: mi.Id}
+			}
+		}
+		if !itemExists(root.ParentId) {
+			root.ParentId = ""
+		}
+	}
+	items := trashedSubtasks(root, trash, true)
+	for i := range items {
+		if items[i].ListId != "" && !listExists(items[i].ListId) {
+			items[i].ListId = ""
+		}
+		items[i].DeletedAt = nil
+		items[i].Version++
+		items[i].UpdatedAt = time.Now().UTC()
+	}
+	return items, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func initMemoryDB() *memorydb {
+	db := &memorydb{}
+	db.init()
+	return db
+}
+
+func Test_memorydb_items(t *testing.T) {
+	db := initMemoryDB()
+	defer db.close()
+
+	list, _ := db.createList(ctx, List{Name: "Shopping"})
+	item, err := db.createItem(ctx, Item{Description: "Buy milk", ListId: list.Id, Tags: []string{"home", " home"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", item.Id)
+	assert.Equal(t, 1, item.Version)
+	assert.Equal(t, []string{"home"}, item.Tags)
+
+	_, err = db.createItem(ctx, Item{Description: "Buy bread", ListId: "9"})
+	var le *ErrorListNotFound
+	assert.True(t, errors.As(err, &le))
+
+	item, err = db.updateItem(ctx, item.Id, Item{Description: "Buy oat milk", Completed: true, Version: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, "Buy oat milk", item.Description)
+	assert.Equal(t, "", item.ListId)
+	assert.Equal(t, []string{"home"}, item.Tags)
+	assert.Equal(t, 2, item.Version)
+
+	_, err = db.updateItem(ctx, item.Id, Item{Description: "Buy soy milk", Version: 1})
+	var ve *ErrorVersionMismatch
+	assert.True(t, errors.As(err, &ve))
+
+	_, err = db.getItem(ctx, "a")
+	assert.EqualError(t, err, "Invalid ID type.")
+	_, err = db.getItem(ctx, "7")
+	var e *ErrorItemNotFound
+	assert.True(t, errors.As(err, &e))
+
+	assert.NoError(t, db.deleteItem(ctx, item.Id, 0))
+	assert.True(t, errors.As(db.deleteItem(ctx, item.Id, 0), &e))
+
+	item, _ = db.createItem(ctx, Item{Description: "Buy bread"})
+	assert.Equal(t, "2", item.Id, "IDs are not reused")
+}
+
+func Test_memorydb_subtasks(t *testing.T) {
+	db := initMemoryDB()
+	defer db.close()
+
+	parent, _ := db.createItem(ctx, Item{Description: "Move house"})
+	first, err := db.createSubtask(ctx, parent.Id, Item{Description: "Pack"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, first.Position)
+	second, _ := db.createSubtask(ctx, parent.Id, Item{Description: "Clean"})
+	assert.Equal(t, 1, second.Position)
+	db.createSubtask(ctx, first.Id, Item{Description: "Buy boxes"})
+
+	subtasks, err := db.subtasks(ctx, parent.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{first.Id, second.Id}, []string{subtasks[0].Id, subtasks[1].Id})
+
+	assert.NoError(t, db.deleteItem(ctx, parent.Id, 0))
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 0)
+}
+
+func Test_memorydb_findItemPage(t *testing.T) {
+	db := initMemoryDB()
+	defer db.close()
+
+	due := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	db.createItem(ctx, Item{Description: "A", Priority: PriorityLow})
+	db.createItem(ctx, Item{Description: "B", Priority: PriorityHigh, DueAt: &due})
+	db.createItem(ctx, Item{Description: "C", Priority: PriorityHigh})
+	db.createItem(ctx, Item{Description: "D", Completed: true})
+
+	filter, _ := parseFilter("priority ge 1 and not (due eq null)")
+	items, err := db.findItems(ctx, ItemQuery{Filter: filter})
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "B", items[0].Description)
+
+	sort := []SortField{{Field: SortByPriority, Descending: true}, {Field: SortByDue}}
+	page, err := db.findItemPage(ctx, ItemQuery{Sort: sort}, PageRequest{Limit: 2, Total: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, page.Total)
+	assert.Equal(t, []string{"C", "B"}, []string{page.Items[0].Description, page.Items[1].Description})
+
+	page, err = db.findItemPage(ctx, ItemQuery{Sort: sort}, PageRequest{Limit: 2, Cursor: page.Next})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A", "D"}, []string{page.Items[0].Description, page.Items[1].Description})
+	assert.Nil(t, page.Next)
+
+	page, err = db.findItemPage(ctx, ItemQuery{Sort: sort}, PageRequest{Limit: 2, Cursor: page.Prev})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"C", "B"}, []string{page.Items[0].Description, page.Items[1].Description})
+	assert.Nil(t, page.Prev)
+}
+
+func Test_memorydb_atomically(t *testing.T) {
+	db := initMemoryDB()
+	defer db.close()
+
+	_, err := db.createItems(ctx, []Item{{Description: "A"}, {Description: "B", ListId: "9"}}, true)
+	var be *BatchError
+	assert.True(t, errors.As(err, &be))
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 0)
+
+	results, err := db.createItems(ctx, []Item{{Description: "A"}, {Description: "B"}}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", results[1].Item.Id)
+}
+
+func Test_memorydb_concurrent(t *testing.T) {
+	db := initMemoryDB()
+	defer db.close()
+
+	item, _ := db.createItem(ctx, Item{Description: "Counter"})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db.createItem(ctx, Item{Description: "A"})
+			db.patchItem(ctx, item.Id, Item{Completed: true}, []string{FieldCompleted})
+		}()
+	}
+	wg.Wait()
+
+	items, _ := db.allItems(ctx)
+	assert.Len(t, items, 21)
+	item, _ = db.getItem(ctx, item.Id)
+	assert.Equal(t, 21, item.Version)
+}
+
+func Test_memorydb_snapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "todo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	snapshot := filepath.Join(dir, "todo.json")
+
+	db := &memorydb{snapshot: snapshot}
+	db.init()
+	list, _ := db.createList(ctx, List{Name: "Shopping"})
+	db.createItem(ctx, Item{Description: "Buy milk", ListId: list.Id, Tags: []string{"home"}})
+	db.createItem(ctx, Item{Description: "Buy bread"})
+	db.deleteItem(ctx, "2", 0)
+	db.close()
+
+	db = &memorydb{snapshot: snapshot}
+	db.init()
+	item, err := db.getItem(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Buy milk", item.Description)
+	assert.Equal(t, list.Id, item.ListId)
+	assert.Equal(t, []string{"home"}, item.Tags)
+	item, _ = db.createItem(ctx, Item{Description: "Buy eggs"})
+	assert.Equal(t, "3", item.Id)
+}
+
+func Test_memorydb_snapshot_generated_ids(t *testing.T) {
+	dir, err := ioutil.TempDir("", "todo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	snapshot := filepath.Join(dir, "todo.json")
+
+	newID, _ := newIDGenerator(IDFormatUUIDv7)
+	db := &memorydb{snapshot: snapshot, newID: newID}
+	db.init()
+	created, _ := db.createItem(ctx, Item{Description: "Buy milk"})
+	trashed, _ := db.createItem(ctx, Item{Description: "Buy bread"})
+	db.deleteItem(ctx, trashed.Id, 0)
+	db.close()
+
+	db = &memorydb{snapshot: snapshot}
+	db.init()
+	item, err := db.getItem(ctx, created.Id)
+	assert.NoError(t, err, "generated IDs are found again once a snapshot is loaded")
+	assert.Equal(t, "Buy milk", item.Description)
+	item, err = db.restoreItem(ctx, trashed.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, trashed.Id, item.Id)
+	item, _ = db.createItem(ctx, Item{Description: "Buy eggs"})
+	assert.Equal(t, "3", item.Id, "items are given legacy IDs once there is no generator")
+}
+
+func TestApplication_memorydb(t *testing.T) {
+	router := mux.NewRouter()
+	app := &Application{db: initMemoryDB(), router: router}
+	app.initRoutes()
+
+	req, _ := http.NewRequest("POST", "/todos:batchCreate", bytes.NewBufferString(`[{"Description": "A", "Completed": true}, {"Description": "B"}]`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	req, _ = http.NewRequest("DELETE", "/todos?completed=true", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req, _ = http.NewRequest("GET", "/todos", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var items []Item
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&items))
+	assert.Len(t, items, 1)
+	assert.Equal(t, "B", items[0].Description)
+}
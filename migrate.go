@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Migration is one step in the history of a database's schema, which can be applied and reverted.
+type Migration struct {
+	Version int
+	Name    string
+}
+
+// MigrationStatus tells whether a migration has been applied to a database, and when.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator is implemented by the databases whose schema is versioned. Applied migrations are recorded in the
+// database, so that it can tell which of the migrations built into the binary it still needs.
+type Migrator interface {
+	// open connects to the database without checking or changing its schema.
+	open()
+	// migrations returns every migration the database knows about, in version order.
+	migrations() []Migration
+	// appliedMigrations returns when each migration recorded as applied was applied.
+	appliedMigrations() (map[int]time.Time, error)
+	// applyMigration runs a migration up or down, recording whether it has been applied along with it.
+	applyMigration(migration Migration, up bool) error
+	close()
+}
+
+// ErrorSchemaBehind is returned when a database has migrations which have not been applied yet.
+type ErrorSchemaBehind struct {
+	Current int
+	Latest  int
+}
+
+func (e *ErrorSchemaBehind) Error() string {
+	return fmt.Sprintf("Database schema is at version %d but version %d is needed, run \"todo-api migrate up\" or start with -auto-migrate", e.Current, e.Latest)
+}
+
+// ErrorSchemaAhead is returned when a database has had migrations applied which the binary does not know about,
+// usually because it was migrated by a newer release.
+type ErrorSchemaAhead struct {
+	Current int
+	Latest  int
+}
+
+func (e *ErrorSchemaAhead) Error() string {
+	return fmt.Sprintf("Database schema is at version %d, which is newer than version %d known to this build", e.Current, e.Latest)
+}
+
+// migrationStatus returns every migration the database knows about along with whether it has been applied,
+// followed by any applied migrations which it does not know about.
+func migrationStatus(m Migrator) ([]MigrationStatus, error) {
+	applied, err := m.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(applied))
+	known := make(map[int]bool)
+	for _, migration := range m.migrations() {
+		known[migration.Version] = true
+		status := MigrationStatus{Migration: migration}
+		if at, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	for version, at := range applied {
+		if !known[version] {
+			at := at
+			statuses = append(statuses, MigrationStatus{Migration: Migration{Version: version, Name: "unknown"}, AppliedAt: &at})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// schemaVersion returns the version of the latest applied migration, which is 0 when none have been.
+func schemaVersion(statuses []MigrationStatus) int {
+	version := 0
+	for _, status := range statuses {
+		if status.AppliedAt != nil && status.Version > version {
+			version = status.Version
+		}
+	}
+	return version
+}
+
+func latestVersion(m Migrator) int {
+	version := 0
+	for _, migration := range m.migrations() {
+		if migration.Version > version {
+			version = migration.Version
+		}
+	}
+	return version
+}
+
+// migrateTo applies or reverts migrations, one at a time, until the schema is at the target version. Pending
+// migrations up to the target are applied in order, and applied migrations after it are reverted newest first.
+func migrateTo(m Migrator, target int) error {
+	latest := latestVersion(m)
+	if target < 0 || target > latest {
+		return fmt.Errorf("Unknown migration %d, expected a version from 0 to %d", target, latest)
+	}
+	statuses, err := migrationStatus(m)
+	if err != nil {
+		return err
+	}
+	if current := schemaVersion(statuses); current > latest {
+		return &ErrorSchemaAhead{Current: current, Latest: latest}
+	}
+	for i := len(statuses) - 1; i >= 0; i-- {
+		if status := statuses[i]; status.Version > target && status.AppliedAt != nil {
+			if err := m.applyMigration(status.Migration, false); err != nil {
+				return fmt.Errorf("Unable to revert migration %d %s: %v", status.Version, status.Name, err)
+			}
+		}
+	}
+	for _, status := range statuses {
+		if status.Version <= target && status.AppliedAt == nil {
+			if err := m.applyMigration(status.Migration, true); err != nil {
+				return fmt.Errorf("Unable to apply migration %d %s: %v", status.Version, status.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkSchema makes sure the schema is up to date before the database is used, applying any pending migrations
+// when autoMigrate is set.
+func checkSchema(m Migrator, autoMigrate bool) error {
+	statuses, err := migrationStatus(m)
+	if err != nil {
+		return err
+	}
+	latest := latestVersion(m)
+	current := schemaVersion(statuses)
+	if current > latest {
+		return &ErrorSchemaAhead{Current: current, Latest: latest}
+	}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			continue
+		}
+		if autoMigrate {
+			return migrateTo(m, latest)
+		}
+		return &ErrorSchemaBehind{Current: current, Latest: latest}
+	}
+	return nil
+}
+
+// runMigrate carries out the migrate subcommand: "up" applies every pending migration, "down" reverts the latest
+// one, "to N" migrates to version N and "status" lists the migrations.
+func runMigrate(m Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Missing migrate command, expected up, down, status or to")
+	}
+	switch args[0] {
+	case "up":
+		if len(args) == 1 {
+			return migrateTo(m, latestVersion(m))
+		}
+	case "down":
+		if len(args) == 1 {
+			statuses, err := migrationStatus(m)
+			if err != nil {
+				return err
+			}
+			previous := 0
+			for _, status := range statuses {
+				if status.AppliedAt != nil && status.Version < schemaVersion(statuses) {
+					previous = status.Version
+				}
+			}
+			return migrateTo(m, previous)
+		}
+	case "to":
+		if len(args) == 2 {
+			version, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("Invalid migration version %q", args[1])
+			}
+			return migrateTo(m, version)
+		}
+	case "status":
+		if len(args) == 1 {
+			statuses, err := migrationStatus(m)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+			for _, status := range statuses {
+				applied := "pending"
+				if status.AppliedAt != nil {
+					applied = status.AppliedAt.UTC().Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+			}
+			return w.Flush()
+		}
+	default:
+		return fmt.Errorf("Unknown migrate command %q, expected up, down, status or to", args[0])
+	}
+	return fmt.Errorf("Unexpected arguments to migrate %s", args[0])
+}
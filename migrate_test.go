@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// fakeMigrator keeps track of which of its migrations have been applied, in the order it was asked to run them.
+type fakeMigrator struct {
+	known   []Migration
+	applied map[int]time.Time
+	runs    []int
+	fail    int
+}
+
+func newFakeMigrator(versions ...int) *fakeMigrator {
+	m := &fakeMigrator{applied: make(map[int]time.Time)}
+	for _, version := range versions {
+		m.known = append(m.known, Migration{Version: version, Name: "step"})
+	}
+	return m
+}
+
+func (m *fakeMigrator) open() {}
+
+func (m *fakeMigrator) migrations() []Migration {
+	return m.known
+}
+
+func (m *fakeMigrator) appliedMigrations() (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+	for version, at := range m.applied {
+		applied[version] = at
+	}
+	return applied, nil
+}
+
+func (m *fakeMigrator) applyMigration(migration Migration, up bool) error {
+	if migration.Version == m.fail {
+		return errors.New("boom")
+	}
+	if up {
+		m.runs = append(m.runs, migration.Version)
+		m.applied[migration.Version] = time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	} else {
+		m.runs = append(m.runs, -migration.Version)
+		delete(m.applied, migration.Version)
+	}
+	return nil
+}
+
+func (m *fakeMigrator) close() {}
+
+func Test_migrateTo(t *testing.T) {
+	m := newFakeMigrator(1, 2, 3)
+
+	assert.NoError(t, migrateTo(m, 2))
+	assert.Equal(t, []int{1, 2}, m.runs)
+	assert.NoError(t, migrateTo(m, 3))
+	assert.NoError(t, migrateTo(m, 0))
+	assert.Equal(t, []int{1, 2, 3, -3, -2, -1}, m.runs)
+
+	assert.EqualError(t, migrateTo(m, 4), "Unknown migration 4, expected a version from 0 to 3")
+	assert.EqualError(t, migrateTo(m, -1), "Unknown migration -1, expected a version from 0 to 3")
+
+	m.fail = 2
+	assert.EqualError(t, migrateTo(m, 3), "Unable to apply migration 2 step: boom")
+	assert.Len(t, m.applied, 1)
+}
+
+func Test_checkSchema(t *testing.T) {
+	m := newFakeMigrator(1, 2)
+	m.applied[1] = time.Now()
+
+	err := checkSchema(m, false)
+	var be *ErrorSchemaBehind
+	assert.True(t, errors.As(err, &be))
+	assert.Equal(t, 1, be.Current)
+	assert.Equal(t, 2, be.Latest)
+
+	assert.NoError(t, checkSchema(m, true))
+	assert.Equal(t, []int{2}, m.runs)
+	assert.NoError(t, checkSchema(m, false))
+
+	m.applied[3] = time.Now()
+	err = checkSchema(m, true)
+	var ae *ErrorSchemaAhead
+	assert.True(t, errors.As(err, &ae))
+	assert.Equal(t, 3, ae.Current)
+}
+
+func Test_runMigrate(t *testing.T) {
+	m := newFakeMigrator(1, 2, 3)
+
+	var out bytes.Buffer
+	assert.NoError(t, runMigrate(m, []string{"up"}, &out))
+	assert.NoError(t, runMigrate(m, []string{"down"}, &out))
+	assert.NoError(t, runMigrate(m, []string{"to", "1"}, &out))
+	assert.Equal(t, []int{1, 2, 3, -3, -2}, m.runs)
+	assert.Equal(t, "", out.String())
+
+	assert.NoError(t, runMigrate(m, []string{"status"}, &out))
+	assert.Equal(t, "VERSION  NAME  APPLIED\n"+
+		"1        step  2020-05-01T00:00:00Z\n"+
+		"2        step  pending\n"+
+		"3        step  pending\n", out.String())
+
+	assert.EqualError(t, runMigrate(m, nil, &out), "Missing migrate command, expected up, down, status or to")
+	assert.EqualError(t, runMigrate(m, []string{"sideways"}, &out), `Unknown migrate command "sideways", expected up, down, status or to`)
+	assert.EqualError(t, runMigrate(m, []string{"to", "x"}, &out), `Invalid migration version "x"`)
+	assert.EqualError(t, runMigrate(m, []string{"to"}, &out), "Unexpected arguments to migrate to")
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS `gorm_idempotency_keys`;
+DROP TABLE IF EXISTS `gorm_lists`;
+DROP TABLE IF EXISTS `item_tags`;
+DROP TABLE IF EXISTS `gorm_tags`;
+DROP TABLE IF EXISTS `gorm_items`;
@@ -0,0 +1,7 @@
+-- The schema gorm's AutoMigrate last created. Tables which a database made by an earlier AutoMigrate already has
+-- are given the columns and indexes they are missing before this runs, see adoptTables.
+CREATE TABLE IF NOT EXISTS `gorm_items` (`id` int unsigned AUTO_INCREMENT,`created_at` DATETIME NULL,`updated_at` DATETIME NULL,`deleted_at` DATETIME NULL,`description` varchar(255),`notes` text,`completed` boolean,`priority` int,`due_at` DATETIME NULL,`start_at` DATETIME NULL,`list_id` int unsigned,`parent_id` int unsigned,`position` int,`complete_with_subtasks` boolean,`version` int NOT NULL DEFAULT 1, PRIMARY KEY (`id`), INDEX idx_gorm_items_deleted_at (`deleted_at`), INDEX idx_gorm_items_list_id (`list_id`), INDEX idx_gorm_items_parent_id (`parent_id`));
+CREATE TABLE IF NOT EXISTS `gorm_tags` (`id` int unsigned AUTO_INCREMENT,`name` varchar(255), PRIMARY KEY (`id`), UNIQUE INDEX uix_gorm_tags_name (`name`));
+CREATE TABLE IF NOT EXISTS `item_tags` (`gorm_item_id` int unsigned,`gorm_tag_id` int unsigned, PRIMARY KEY (`gorm_item_id`,`gorm_tag_id`));
+CREATE TABLE IF NOT EXISTS `gorm_lists` (`id` int unsigned AUTO_INCREMENT,`created_at` DATETIME NULL,`updated_at` DATETIME NULL,`deleted_at` DATETIME NULL,`name` varchar(255), PRIMARY KEY (`id`), INDEX idx_gorm_lists_deleted_at (`deleted_at`));
+CREATE TABLE IF NOT EXISTS `gorm_idempotency_keys` (`idempotency_key` varchar(255),`fingerprint` varchar(255),`status` int,`e_tag` varchar(255),`body` longblob,`expires_at` DATETIME NULL, PRIMARY KEY (`idempotency_key`), INDEX idx_gorm_idempotency_keys_expires_at (`expires_at`));
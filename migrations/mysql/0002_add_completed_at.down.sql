@@ -0,0 +1 @@
+ALTER TABLE `gorm_items` DROP INDEX idx_gorm_items_completed_at, DROP COLUMN `completed_at`;
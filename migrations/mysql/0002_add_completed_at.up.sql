@@ -0,0 +1 @@
+ALTER TABLE `gorm_items` ADD COLUMN `completed_at` DATETIME NULL, ADD INDEX idx_gorm_items_completed_at (`completed_at`);
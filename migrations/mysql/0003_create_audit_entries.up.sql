@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS `gorm_audit_entries` (`id` int unsigned AUTO_INCREMENT,`item_id` varchar(255),`action` varchar(255),`version` int,`actor` varchar(255),`request_id` varchar(255),`changes` mediumtext,`recorded_at` DATETIME NULL, PRIMARY KEY (`id`), INDEX idx_gorm_audit_entries_item_id (`item_id`), INDEX idx_gorm_audit_entries_recorded_at (`recorded_at`));
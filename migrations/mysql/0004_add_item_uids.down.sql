@@ -0,0 +1 @@
+ALTER TABLE `gorm_items` DROP INDEX uix_gorm_items_uid, DROP COLUMN `uid`, DROP COLUMN `parent_uid`;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_gorm_items_completed_at;
+ALTER TABLE "gorm_items" DROP COLUMN "completed_at";
@@ -0,0 +1,2 @@
+ALTER TABLE "gorm_items" ADD COLUMN "completed_at" timestamp with time zone;
+CREATE INDEX idx_gorm_items_completed_at ON "gorm_items"(completed_at);
@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS "gorm_audit_entries" ("id" serial,"item_id" varchar(255),"action" varchar(255),"version" integer,"actor" varchar(255),"request_id" varchar(255),"changes" text,"recorded_at" timestamp with time zone, PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS idx_gorm_audit_entries_item_id ON "gorm_audit_entries"(item_id);
+CREATE INDEX IF NOT EXISTS idx_gorm_audit_entries_recorded_at ON "gorm_audit_entries"(recorded_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS uix_gorm_items_uid;
+ALTER TABLE "gorm_items" DROP COLUMN "uid", DROP COLUMN "parent_uid";
@@ -0,0 +1,2 @@
+ALTER TABLE "gorm_items" ADD COLUMN "uid" varchar(255), ADD COLUMN "parent_uid" varchar(255);
+CREATE UNIQUE INDEX uix_gorm_items_uid ON "gorm_items"(uid);
@@ -0,0 +1,13 @@
+-- The schema gorm's AutoMigrate last created. Tables which a database made by an earlier AutoMigrate already has
+-- are given the columns and indexes they are missing before this runs, see adoptTables.
+CREATE TABLE IF NOT EXISTS "gorm_items" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"description" varchar(255),"notes" text,"completed" bool,"priority" integer,"due_at" datetime,"start_at" datetime,"list_id" integer,"parent_id" integer,"position" integer,"complete_with_subtasks" bool,"version" integer NOT NULL DEFAULT 1);
+CREATE INDEX IF NOT EXISTS idx_gorm_items_deleted_at ON "gorm_items"(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_gorm_items_list_id ON "gorm_items"(list_id);
+CREATE INDEX IF NOT EXISTS idx_gorm_items_parent_id ON "gorm_items"(parent_id);
+CREATE TABLE IF NOT EXISTS "gorm_tags" ("id" integer primary key autoincrement,"name" varchar(255));
+CREATE UNIQUE INDEX IF NOT EXISTS uix_gorm_tags_name ON "gorm_tags"("name");
+CREATE TABLE IF NOT EXISTS "item_tags" ("gorm_item_id" integer,"gorm_tag_id" integer, PRIMARY KEY ("gorm_item_id","gorm_tag_id"));
+CREATE TABLE IF NOT EXISTS "gorm_lists" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"name" varchar(255));
+CREATE INDEX IF NOT EXISTS idx_gorm_lists_deleted_at ON "gorm_lists"(deleted_at);
+CREATE TABLE IF NOT EXISTS "gorm_idempotency_keys" ("idempotency_key" varchar(255),"fingerprint" varchar(255),"status" integer,"e_tag" varchar(255),"body" blob,"expires_at" datetime, PRIMARY KEY ("idempotency_key"));
+CREATE INDEX IF NOT EXISTS idx_gorm_idempotency_keys_expires_at ON "gorm_idempotency_keys"(expires_at);
@@ -0,0 +1,11 @@
+-- SQLite cannot drop a column, so the table is rebuilt without them. The search triggers are dropped along with
+-- the old table, and are created again when the server starts.
+DROP INDEX IF EXISTS uix_gorm_items_uid;
+CREATE TABLE "gorm_items_without_uids" ("id" integer primary key autoincrement,"created_at" datetime,"updated_at" datetime,"deleted_at" datetime,"description" varchar(255),"notes" text,"completed" bool,"priority" integer,"due_at" datetime,"start_at" datetime,"list_id" integer,"parent_id" integer,"position" integer,"complete_with_subtasks" bool,"version" integer NOT NULL DEFAULT 1,"completed_at" datetime);
+INSERT INTO "gorm_items_without_uids" SELECT "id","created_at","updated_at","deleted_at","description","notes","completed","priority","due_at","start_at","list_id","parent_id","position","complete_with_subtasks","version","completed_at" FROM "gorm_items";
+DROP TABLE "gorm_items";
+ALTER TABLE "gorm_items_without_uids" RENAME TO "gorm_items";
+CREATE INDEX IF NOT EXISTS idx_gorm_items_deleted_at ON "gorm_items"(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_gorm_items_list_id ON "gorm_items"(list_id);
+CREATE INDEX IF NOT EXISTS idx_gorm_items_parent_id ON "gorm_items"(parent_id);
+CREATE INDEX IF NOT EXISTS idx_gorm_items_completed_at ON "gorm_items"(completed_at);
@@ -0,0 +1,3 @@
+ALTER TABLE "gorm_items" ADD COLUMN "uid" varchar(255);
+ALTER TABLE "gorm_items" ADD COLUMN "parent_uid" varchar(255);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_gorm_items_uid ON "gorm_items"(uid);
@@ -2,20 +2,25 @@
 
 package main
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+	time "time"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // MockDatabase is an autogenerated mock type for the Database type
 type MockDatabase struct {
 	mock.Mock
 }
 
-// allItems provides a mock function with given fields:
-func (_m *MockDatabase) allItems() ([]Item, error) {
-	ret := _m.Called()
+// allItems provides a mock function with given fields: ctx
+func (_m *MockDatabase) allItems(ctx context.Context) ([]Item, error) {
+	ret := _m.Called(ctx)
 
 	var r0 []Item
-	if rf, ok := ret.Get(0).(func() []Item); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context) []Item); ok {
+		r0 = rf(ctx)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]Item)
@@ -23,8 +28,54 @@ func (_m *MockDatabase) allItems() ([]Item, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// allLists provides a mock function with given fields: ctx
+func (_m *MockDatabase) allLists(ctx context.Context) ([]List, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []List
+	if rf, ok := ret.Get(0).(func(context.Context) []List); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]List)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// allTags provides a mock function with given fields: ctx
+func (_m *MockDatabase) allTags(ctx context.Context) ([]TagCount, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []TagCount
+	if rf, ok := ret.Get(0).(func(context.Context) []TagCount); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]TagCount)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -37,20 +88,122 @@ func (_m *MockDatabase) close() {
 	_m.Called()
 }
 
-// createItem provides a mock function with given fields: item
-func (_m *MockDatabase) createItem(item Item) (Item, error) {
-	ret := _m.Called(item)
+// createItem provides a mock function with given fields: ctx, item
+func (_m *MockDatabase) createItem(ctx context.Context, item Item) (Item, error) {
+	ret := _m.Called(ctx, item)
+
+	var r0 Item
+	if rf, ok := ret.Get(0).(func(context.Context, Item) Item); ok {
+		r0 = rf(ctx, item)
+	} else {
+		r0 = ret.Get(0).(Item)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, Item) error); ok {
+		r1 = rf(ctx, item)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// createItems provides a mock function with given fields: ctx, items, atomic
+func (_m *MockDatabase) createItems(ctx context.Context, items []Item, atomic bool) ([]BatchResult, error) {
+	ret := _m.Called(ctx, items, atomic)
+
+	var r0 []BatchResult
+	if rf, ok := ret.Get(0).(func(context.Context, []Item, bool) []BatchResult); ok {
+		r0 = rf(ctx, items, atomic)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]BatchResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []Item, bool) error); ok {
+		r1 = rf(ctx, items, atomic)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// createList provides a mock function with given fields: ctx, list
+func (_m *MockDatabase) createList(ctx context.Context, list List) (List, error) {
+	ret := _m.Called(ctx, list)
+
+	var r0 List
+	if rf, ok := ret.Get(0).(func(context.Context, List) List); ok {
+		r0 = rf(ctx, list)
+	} else {
+		r0 = ret.Get(0).(List)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, List) error); ok {
+		r1 = rf(ctx, list)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// createSubtask provides a mock function with given fields: ctx, parentId, item
+func (_m *MockDatabase) createSubtask(ctx context.Context, parentId string, item Item) (Item, error) {
+	ret := _m.Called(ctx, parentId, item)
 
 	var r0 Item
-	if rf, ok := ret.Get(0).(func(Item) Item); ok {
-		r0 = rf(item)
+	if rf, ok := ret.Get(0).(func(context.Context, string, Item) Item); ok {
+		r0 = rf(ctx, parentId, item)
 	} else {
 		r0 = ret.Get(0).(Item)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(Item) error); ok {
-		r1 = rf(item)
+	if rf, ok := ret.Get(1).(func(context.Context, string, Item) error); ok {
+		r1 = rf(ctx, parentId, item)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// deleteItem provides a mock function with given fields: ctx, id, version
+func (_m *MockDatabase) deleteItem(ctx context.Context, id string, version int) error {
+	ret := _m.Called(ctx, id, version)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int) error); ok {
+		r0 = rf(ctx, id, version)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// deleteItems provides a mock function with given fields: ctx, query, atomic
+func (_m *MockDatabase) deleteItems(ctx context.Context, query ItemQuery, atomic bool) ([]BatchResult, error) {
+	ret := _m.Called(ctx, query, atomic)
+
+	var r0 []BatchResult
+	if rf, ok := ret.Get(0).(func(context.Context, ItemQuery, bool) []BatchResult); ok {
+		r0 = rf(ctx, query, atomic)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]BatchResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, ItemQuery, bool) error); ok {
+		r1 = rf(ctx, query, atomic)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -58,13 +211,13 @@ func (_m *MockDatabase) createItem(item Item) (Item, error) {
 	return r0, r1
 }
 
-// deleteItem provides a mock function with given fields: id
-func (_m *MockDatabase) deleteItem(id string) error {
-	ret := _m.Called(id)
+// deleteList provides a mock function with given fields: ctx, id, cascade
+func (_m *MockDatabase) deleteList(ctx context.Context, id string, cascade bool) error {
+	ret := _m.Called(ctx, id, cascade)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
-		r0 = rf(id)
+	if rf, ok := ret.Get(0).(func(context.Context, string, bool) error); ok {
+		r0 = rf(ctx, id, cascade)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -72,20 +225,85 @@ func (_m *MockDatabase) deleteItem(id string) error {
 	return r0
 }
 
-// getItem provides a mock function with given fields: id
-func (_m *MockDatabase) getItem(id string) (Item, error) {
-	ret := _m.Called(id)
+// findItemPage provides a mock function with given fields: ctx, query, page
+func (_m *MockDatabase) findItemPage(ctx context.Context, query ItemQuery, page PageRequest) (ItemPage, error) {
+	ret := _m.Called(ctx, query, page)
+
+	var r0 ItemPage
+	if rf, ok := ret.Get(0).(func(context.Context, ItemQuery, PageRequest) ItemPage); ok {
+		r0 = rf(ctx, query, page)
+	} else {
+		r0 = ret.Get(0).(ItemPage)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, ItemQuery, PageRequest) error); ok {
+		r1 = rf(ctx, query, page)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// findItems provides a mock function with given fields: ctx, query
+func (_m *MockDatabase) findItems(ctx context.Context, query ItemQuery) ([]Item, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []Item
+	if rf, ok := ret.Get(0).(func(context.Context, ItemQuery) []Item); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]Item)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, ItemQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// getItem provides a mock function with given fields: ctx, id
+func (_m *MockDatabase) getItem(ctx context.Context, id string) (Item, error) {
+	ret := _m.Called(ctx, id)
 
 	var r0 Item
-	if rf, ok := ret.Get(0).(func(string) Item); ok {
-		r0 = rf(id)
+	if rf, ok := ret.Get(0).(func(context.Context, string) Item); ok {
+		r0 = rf(ctx, id)
 	} else {
 		r0 = ret.Get(0).(Item)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(id)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// getList provides a mock function with given fields: ctx, id
+func (_m *MockDatabase) getList(ctx context.Context, id string) (List, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 List
+	if rf, ok := ret.Get(0).(func(context.Context, string) List); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Get(0).(List)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -98,13 +316,57 @@ func (_m *MockDatabase) init() {
 	_m.Called()
 }
 
-// ping provides a mock function with given fields:
-func (_m *MockDatabase) ping() error {
-	ret := _m.Called()
+// patchItem provides a mock function with given fields: ctx, id, td, fields
+func (_m *MockDatabase) patchItem(ctx context.Context, id string, td Item, fields []string) (Item, error) {
+	ret := _m.Called(ctx, id, td, fields)
+
+	var r0 Item
+	if rf, ok := ret.Get(0).(func(context.Context, string, Item, []string) Item); ok {
+		r0 = rf(ctx, id, td, fields)
+	} else {
+		r0 = ret.Get(0).(Item)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, Item, []string) error); ok {
+		r1 = rf(ctx, id, td, fields)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// patchItems provides a mock function with given fields: ctx, patches, atomic
+func (_m *MockDatabase) patchItems(ctx context.Context, patches []ItemPatch, atomic bool) ([]BatchResult, error) {
+	ret := _m.Called(ctx, patches, atomic)
+
+	var r0 []BatchResult
+	if rf, ok := ret.Get(0).(func(context.Context, []ItemPatch, bool) []BatchResult); ok {
+		r0 = rf(ctx, patches, atomic)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]BatchResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []ItemPatch, bool) error); ok {
+		r1 = rf(ctx, patches, atomic)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ping provides a mock function with given fields: ctx
+func (_m *MockDatabase) ping(ctx context.Context) error {
+	ret := _m.Called(ctx)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func() error); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -112,20 +374,187 @@ func (_m *MockDatabase) ping() error {
 	return r0
 }
 
-// updateItem provides a mock function with given fields: id, td
-func (_m *MockDatabase) updateItem(id string, td Item) (Item, error) {
-	ret := _m.Called(id, td)
+// purgeItem provides a mock function with given fields: ctx, id
+func (_m *MockDatabase) purgeItem(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// purgeTrash provides a mock function with given fields: ctx, before
+func (_m *MockDatabase) purgeTrash(ctx context.Context, before time.Time) (int, error) {
+	ret := _m.Called(ctx, before)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int); ok {
+		r0 = rf(ctx, before)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// restoreItem provides a mock function with given fields: ctx, id
+func (_m *MockDatabase) restoreItem(ctx context.Context, id string) (Item, error) {
+	ret := _m.Called(ctx, id)
 
 	var r0 Item
-	if rf, ok := ret.Get(0).(func(string, Item) Item); ok {
-		r0 = rf(id, td)
+	if rf, ok := ret.Get(0).(func(context.Context, string) Item); ok {
+		r0 = rf(ctx, id)
 	} else {
 		r0 = ret.Get(0).(Item)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, Item) error); ok {
-		r1 = rf(id, td)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// searchItems provides a mock function with given fields: ctx, q, limit
+func (_m *MockDatabase) searchItems(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	ret := _m.Called(ctx, q, limit)
+
+	var r0 []SearchResult
+	if rf, ok := ret.Get(0).(func(context.Context, string, int) []SearchResult); ok {
+		r0 = rf(ctx, q, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]SearchResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, int) error); ok {
+		r1 = rf(ctx, q, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// setItemTags provides a mock function with given fields: ctx, id, tags
+func (_m *MockDatabase) setItemTags(ctx context.Context, id string, tags []string) (Item, error) {
+	ret := _m.Called(ctx, id, tags)
+
+	var r0 Item
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string) Item); ok {
+		r0 = rf(ctx, id, tags)
+	} else {
+		r0 = ret.Get(0).(Item)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, []string) error); ok {
+		r1 = rf(ctx, id, tags)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// subtasks provides a mock function with given fields: ctx, parentId
+func (_m *MockDatabase) subtasks(ctx context.Context, parentId string) ([]Item, error) {
+	ret := _m.Called(ctx, parentId)
+
+	var r0 []Item
+	if rf, ok := ret.Get(0).(func(context.Context, string) []Item); ok {
+		r0 = rf(ctx, parentId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]Item)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, parentId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// trashedItems provides a mock function with given fields: ctx
+func (_m *MockDatabase) trashedItems(ctx context.Context) ([]Item, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []Item
+	if rf, ok := ret.Get(0).(func(context.Context) []Item); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]Item)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// updateItem provides a mock function with given fields: ctx, id, td
+func (_m *MockDatabase) updateItem(ctx context.Context, id string, td Item) (Item, error) {
+	ret := _m.Called(ctx, id, td)
+
+	var r0 Item
+	if rf, ok := ret.Get(0).(func(context.Context, string, Item) Item); ok {
+		r0 = rf(ctx, id, td)
+	} else {
+		r0 = ret.Get(0).(Item)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, Item) error); ok {
+		r1 = rf(ctx, id, td)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// updateList provides a mock function with given fields: ctx, id, list
+func (_m *MockDatabase) updateList(ctx context.Context, id string, list List) (List, error) {
+	ret := _m.Called(ctx, id, list)
+
+	var r0 List
+	if rf, ok := ret.Get(0).(func(context.Context, string, List) List); ok {
+		r0 = rf(ctx, id, list)
+	} else {
+		r0 = ret.Get(0).(List)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, List) error); ok {
+		r1 = rf(ctx, id, list)
 	} else {
 		r1 = ret.Error(1)
 	}
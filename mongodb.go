@@ -4,133 +4,1688 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Items created at the same time are told apart by their _id, which mongoSort ends every sort with.
+var mongoSortKeys = map[string]string{
+	SortById:          "_id",
+	SortByDescription: "description",
+	SortByPriority:    "priority",
+	SortByDue:         "dueat",
+	SortByStart:       "startat",
+	SortByCreated:     "createdat",
+	SortByUpdated:     "updatedat",
+	SortByCompletedAt: "completedat",
+}
+
+var mongoFilterFields = map[string]string{
+	SortById:          "_id",
+	SortByDescription: "description",
+	FilterByNotes:     "notes",
+	FilterByCompleted: "completed",
+	SortByPriority:    "priority",
+	SortByDue:         "dueat",
+	SortByStart:       "startat",
+	SortByCreated:     "createdat",
+	SortByUpdated:     "updatedat",
+	SortByCompletedAt: "completedat",
+	FilterByList:      "listid",
+	FilterByParent:    "parentid",
+	FilterByPosition:  "position",
+	FilterByTag:       "tags",
+}
+
+var mongoFilterOperators = map[string]string{
+	FilterEq: "$eq",
+	FilterNe: "$ne",
+	FilterLt: "$lt",
+	FilterLe: "$lte",
+	FilterGt: "$gt",
+	FilterGe: "$gte",
+}
+
 type mongodb struct {
+	auditor
 	client           *mongo.Client
 	collection       *mongo.Collection
+	lists            *mongo.Collection
+	keys             *mongo.Collection
+	trash            *mongo.Collection
+	audit            *mongo.Collection
 	connectionString string
+	// autoMigrate applies pending migrations on init, which otherwise refuses to use an out of date database.
+	autoMigrate bool
+	// ctx is set on the copies made by with for each call, and on those made by atomically, where it carries the
+	// session of a transaction.
+	ctx context.Context
+	// transactions is set when Mongo runs as a replica set or a sharded cluster, which support transactions.
+	transactions bool
+	// newID gives out the IDs of new items, which are kept in their uid field. Items are known by their ObjectID
+	// when it is nil.
+	newID idGenerator
+}
+
+// errNoTransactions is returned for atomic writes when Mongo does not run as a replica set, since the items of
+// a batch could only be written one after the other.
+var errNoTransactions = &ErrorNotSupported{Reason: "Atomic batches need Mongo to run as a replica set, use mode=" + batchBestEffort + " instead"}
+
+// mongoMigration changes the indexes, validators or documents of the todo database.
+type mongoMigration struct {
+	Migration
+	up, down func(m *mongodb) error
+}
+
+var mongoMigrations = []mongoMigration{
+	{Migration{Version: 1, Name: "create_indexes"}, (*mongodb).createIndexes, (*mongodb).dropIndexes},
+	{Migration{Version: 2, Name: "add_item_validator"}, (*mongodb).addItemValidator, (*mongodb).removeItemValidator},
+	{Migration{Version: 3, Name: "create_trash_indexes"}, (*mongodb).createTrashIndexes, (*mongodb).dropTrashIndexes},
+	{Migration{Version: 4, Name: "add_item_timestamps"}, (*mongodb).addItemTimestamps, (*mongodb).keepItemTimestamps},
+	{Migration{Version: 5, Name: "create_audit_indexes"}, (*mongodb).createAuditIndexes, (*mongodb).dropAuditIndexes},
+	{Migration{Version: 6, Name: "create_uid_indexes"}, (*mongodb).createUIDIndexes, (*mongodb).dropUIDIndexes},
+}
+
+// mongoItemIndexes are created by the first migration, with names so that it can drop them again.
+var mongoItemIndexes = []mongo.IndexModel{
+	{Keys: bson.M{"tags": 1}, Options: options.Index().SetName("tags_1")},
+	{Keys: bson.M{"listid": 1}, Options: options.Index().SetName("listid_1")},
+	{Keys: bson.D{{Key: "parentid", Value: 1}, {Key: "position", Value: 1}}, Options: options.Index().SetName("parentid_1_position_1")},
+	// Without a language, words are matched as they are, like the other backends.
+	{Keys: bson.D{{Key: "description", Value: "text"}, {Key: "notes", Value: "text"}}, Options: options.Index().SetName("description_text_notes_text").SetDefaultLanguage("none")},
+}
+
+// mongoTrashIndexes are created by the third migration, for purging the trash and finding trashed subtasks.
+var mongoTrashIndexes = []mongo.IndexModel{
+	{Keys: bson.M{"deletedat": 1}, Options: options.Index().SetName("deletedat_1")},
+	{Keys: bson.M{"parentid": 1}, Options: options.Index().SetName("parentid_1")},
+}
+
+// mongoTimestampIndexes are created by the fourth migration, for finding recently updated or completed items.
+var mongoTimestampIndexes = []mongo.IndexModel{
+	{Keys: bson.M{"updatedat": 1}, Options: options.Index().SetName("updatedat_1")},
+	{Keys: bson.M{"completedat": 1}, Options: options.Index().SetName("completedat_1")},
+}
+
+// mongoAuditIndexes are created by the fifth migration, for finding the history of an item and the entries
+// recorded since a given time.
+var mongoAuditIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "itemid", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName("itemid_1__id_1")},
+	{Keys: bson.M{"at": 1}, Options: options.Index().SetName("at_1")},
+}
+
+// mongoUIDIndexes are created by the sixth migration on both the items and the trash, for looking items up by
+// their generated IDs. Items without one are left out.
+var mongoUIDIndexes = []mongo.IndexModel{
+	{Keys: bson.M{"uid": 1}, Options: options.Index().SetName("uid_1").SetUnique(true).SetSparse(true)},
+}
+
+// mongoItemSchema is the validator for items. Fields which are left out when empty are not required.
+var mongoItemSchema = bson.M{
+	"bsonType": "object",
+	"required": bson.A{"description", "version"},
+	"properties": bson.M{
+		"description":          bson.M{"bsonType": "string"},
+		"notes":                bson.M{"bsonType": "string"},
+		"completed":            bson.M{"bsonType": "bool"},
+		"priority":             bson.M{"bsonType": bson.A{"int", "long"}, "minimum": PriorityNone, "maximum": PriorityUrgent},
+		"dueat":                bson.M{"bsonType": "date"},
+		"startat":              bson.M{"bsonType": "date"},
+		"listid":               bson.M{"bsonType": "objectId"},
+		"parentid":             bson.M{"bsonType": "objectId"},
+		"position":             bson.M{"bsonType": bson.A{"int", "long"}},
+		"completewithsubtasks": bson.M{"bsonType": "bool"},
+		"version":              bson.M{"bsonType": bson.A{"int", "long"}, "minimum": 1},
+		"tags":                 bson.M{"bsonType": "array", "items": bson.M{"bsonType": "string"}},
+	},
+}
+
+func (m *mongodb) context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.TODO()
+}
+
+// with returns a copy of the database which makes its calls with ctx, or the database itself when it is a copy
+// made by atomically, whose calls are made in its transaction.
+func (m *mongodb) with(ctx context.Context) *mongodb {
+	if _, ok := m.ctx.(mongo.SessionContext); ok {
+		return m
+	}
+	t := *m
+	t.ctx = ctx
+	return &t
 }
 
 func (m *mongodb) init() {
+	if m.client == nil {
+		m.open()
+	}
+	if err := checkSchema(m, m.autoMigrate); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (m *mongodb) open() {
 	clientOptions := options.Client().ApplyURI(m.connectionString)
 	var err error
-	m.client, err = mongo.Connect(context.TODO(), clientOptions)
+	m.client, err = mongo.Connect(m.context(), clientOptions)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	err = m.client.Ping(context.TODO(), nil)
+	err = m.client.Ping(m.context(), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	m.collection = m.client.Database("todo").Collection("todo_items")
+	m.lists = m.client.Database("todo").Collection("todo_lists")
+	m.keys = m.client.Database("todo").Collection("idempotency_keys")
+	m.trash = m.client.Database("todo").Collection("todo_trash")
+	m.audit = m.client.Database("todo").Collection("todo_audit")
+
+	var hello bson.M
+	if err := m.client.Database("admin").RunCommand(m.context(), bson.M{"isMaster": 1}).Decode(&hello); err != nil {
+		log.Fatal(err)
+	}
+	_, replicaSet := hello["setName"]
+	m.transactions = replicaSet || hello["msg"] == "isdbgrid"
+	if !m.transactions {
+		log.Print("Mongo is not running as a replica set, so audit entries are written after the changes they record instead of in the same transaction")
+	}
 }
 
-func (m *mongodb) ping() error {
-	return m.client.Ping(context.TODO(), nil)
+func (m *mongodb) migrations() []Migration {
+	migrations := make([]Migration, len(mongoMigrations))
+	for i, migration := range mongoMigrations {
+		migrations[i] = migration.Migration
+	}
+	return migrations
 }
 
-func (m *mongodb) createItem(item Item) (Item, error) {
-	insertResult, err := m.collection.InsertOne(context.TODO(), item)
+func (m *mongodb) appliedMigrations() (map[int]time.Time, error) {
+	cur, err := m.client.Database("todo").Collection("schema_migrations").Find(m.context(), bson.M{})
 	if err != nil {
-		return Item{}, errors.New("Unable to insert item into database.")
+		return nil, err
+	}
+	defer cur.Close(m.context())
+
+	applied := make(map[int]time.Time)
+	for cur.Next(m.context()) {
+		var result struct {
+			Version   int       `bson:"_id"`
+			AppliedAt time.Time `bson:"appliedat"`
+		}
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+		applied[result.Version] = result.AppliedAt
 	}
-	item.Id = insertResult.InsertedID.(primitive.ObjectID).Hex()
-	return item, nil
+	return applied, cur.Err()
 }
 
-func (m *mongodb) deleteItem(id string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
+// applyMigration runs a migration and then records it. Mongo cannot change indexes inside a transaction, so a
+// migration which fails part way through is left as it is, but each migration can be run again.
+func (m *mongodb) applyMigration(migration Migration, up bool) error {
+	for _, mm := range mongoMigrations {
+		if mm.Migration != migration {
+			continue
+		}
+		migrations := m.client.Database("todo").Collection("schema_migrations")
+		if !up {
+			if err := mm.down(m); err != nil {
+				return err
+			}
+			_, err := migrations.DeleteOne(m.context(), bson.M{"_id": migration.Version})
+			return err
+		}
+		if err := mm.up(m); err != nil {
+			return err
+		}
+		_, err := migrations.InsertOne(m.context(), bson.M{"_id": migration.Version, "name": migration.Name, "appliedat": time.Now().UTC()})
+		return err
+	}
+	return fmt.Errorf("Unknown migration %d", migration.Version)
+}
+
+func (m *mongodb) createIndexes() error {
+	if _, err := m.collection.Indexes().CreateMany(m.context(), mongoItemIndexes); err != nil {
+		return err
+	}
+	// Mongo removes expired idempotency keys by itself, although not straight away.
+	_, err := m.keys.Indexes().CreateOne(m.context(), mongo.IndexModel{
+		Keys:    bson.M{"expiresat": 1},
+		Options: options.Index().SetName("expiresat_1").SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (m *mongodb) dropIndexes() error {
+	for _, index := range mongoItemIndexes {
+		if _, err := m.collection.Indexes().DropOne(m.context(), *index.Options.Name); ignoreMongoNotFound(err) != nil {
+			return err
+		}
+	}
+	_, err := m.keys.Indexes().DropOne(m.context(), "expiresat_1")
+	return ignoreMongoNotFound(err)
+}
+
+func (m *mongodb) createTrashIndexes() error {
+	_, err := m.trash.Indexes().CreateMany(m.context(), mongoTrashIndexes)
+	return err
+}
+
+func (m *mongodb) dropTrashIndexes() error {
+	for _, index := range mongoTrashIndexes {
+		if _, err := m.trash.Indexes().DropOne(m.context(), *index.Options.Name); ignoreMongoNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createAuditIndexes creates the audit log along with its indexes, as Mongo cannot create a collection inside a
+// transaction.
+func (m *mongodb) createAuditIndexes() error {
+	_, err := m.audit.Indexes().CreateMany(m.context(), mongoAuditIndexes)
+	return err
+}
+
+// dropAuditIndexes only drops the indexes, leaving the log itself alone.
+func (m *mongodb) dropAuditIndexes() error {
+	for _, index := range mongoAuditIndexes {
+		if _, err := m.audit.Indexes().DropOne(m.context(), *index.Options.Name); ignoreMongoNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mongodb) createUIDIndexes() error {
+	for _, collection := range []*mongo.Collection{m.collection, m.trash} {
+		if _, err := collection.Indexes().CreateMany(m.context(), mongoUIDIndexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mongodb) dropUIDIndexes() error {
+	for _, collection := range []*mongo.Collection{m.collection, m.trash} {
+		for _, index := range mongoUIDIndexes {
+			if _, err := collection.Indexes().DropOne(m.context(), *index.Options.Name); ignoreMongoNotFound(err) != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addItemTimestamps gives items stored before they had timestamps the time their ObjectID was made as both
+// their creation and update time, and indexes them.
+func (m *mongodb) addItemTimestamps() error {
+	for _, collection := range []*mongo.Collection{m.collection, m.trash} {
+		mtds, err := m.findMongoItems(collection, bson.M{"createdat": bson.M{"$exists": false}}, nil)
+		if err != nil {
+			return err
+		}
+		for _, mtd := range mtds {
+			createdAt := mtd.ID.Timestamp().UTC()
+			_, err := collection.UpdateOne(m.context(), bson.M{"_id": mtd.ID}, bson.M{"$set": bson.M{"createdat": createdAt, "updatedat": createdAt}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err := m.collection.Indexes().CreateMany(m.context(), mongoTimestampIndexes)
+	return err
+}
+
+// keepItemTimestamps only drops the indexes, as the timestamps are ignored by older releases.
+func (m *mongodb) keepItemTimestamps() error {
+	for _, index := range mongoTimestampIndexes {
+		if _, err := m.collection.Indexes().DropOne(m.context(), *index.Options.Name); ignoreMongoNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addItemValidator makes Mongo reject items which the other backends could not store. Items stored before they
+// had versions start at version 1, so that they pass. Existing items which do not pass can still be updated.
+func (m *mongodb) addItemValidator() error {
+	_, err := m.collection.UpdateMany(m.context(), bson.M{"version": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"version": 1}})
 	if err != nil {
-		return errors.New("Invalid ID.")
+		return err
 	}
+	return m.client.Database("todo").RunCommand(m.context(), bson.D{
+		{Key: "collMod", Value: "todo_items"},
+		{Key: "validator", Value: bson.M{"$jsonSchema": mongoItemSchema}},
+		{Key: "validationLevel", Value: "moderate"},
+	}).Err()
+}
 
-	filter := bson.D{{"_id", objID}}
+func (m *mongodb) removeItemValidator() error {
+	return m.client.Database("todo").RunCommand(m.context(), bson.D{
+		{Key: "collMod", Value: "todo_items"},
+		{Key: "validator", Value: bson.M{}},
+	}).Err()
+}
 
-	_, err = m.collection.DeleteOne(context.TODO(), filter)
+// ignoreMongoNotFound treats dropping something which is not there as success.
+func ignoreMongoNotFound(err error) error {
+	var ce mongo.CommandError
+	// The codes are NamespaceNotFound and IndexNotFound.
+	if errors.As(err, &ce) && (ce.Code == 26 || ce.Code == 27) {
+		return nil
+	}
+	return err
+}
+
+func (m *mongodb) ping(ctx context.Context) error {
+	return m.client.Ping(ctx, nil)
+}
+
+func (m *mongodb) createItem(ctx context.Context, item Item) (Item, error) {
+	m = m.with(ctx)
+	listID, err := m.listID(item.ListId)
 	if err != nil {
-		return &ErrorItemNotFound{Id: id}
+		return Item{}, err
+	}
+	parentID, parentUID, err := m.parentID(item.ParentId)
+	if err != nil {
+		return Item{}, err
+	}
+	// Mongo stores times to the millisecond, so they are truncated to match what is read back.
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	mtd := MongoItem{ListID: listID, ParentID: parentID, ParentUID: parentUID, Position: item.Position, CompleteWithSubtasks: item.CompleteWithSubtasks, Description: item.Description, Notes: item.Notes, Completed: item.Completed, Priority: item.Priority, DueAt: utc(item.DueAt), StartAt: utc(item.StartAt), Tags: normaliseTags(item.Tags), Version: 1, CreatedAt: now, UpdatedAt: now}
+	if item.Completed {
+		mtd.CompletedAt = &now
+	}
+	if m.newID != nil {
+		mtd.UID = m.newID()
+	}
+	err = m.transaction(func(t *mongodb) error {
+		insertResult, err := t.collection.InsertOne(t.context(), mtd)
+		if err != nil {
+			return errors.New("Unable to insert item into database.")
+		}
+		mtd.ID = insertResult.InsertedID.(primitive.ObjectID)
+		return t.addAuditEntry(t.entry(AuditCreate, Item{}, mtd.toItem(), now))
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return mtd.toItem(), nil
+}
+
+func (m *mongodb) deleteItem(ctx context.Context, id string, version int) error {
+	m = m.with(ctx)
+	filter, ok := mongoItemFilter(id)
+	if !ok {
+		return errors.New("Invalid ID type.")
+	}
+	if version != 0 {
+		filter["version"] = version
+	}
+
+	deletedAt := time.Now().UTC().Truncate(time.Millisecond)
+	return m.transaction(func(t *mongodb) error {
+		// The item is only deleted if it has not changed since it was copied to the trash, and is read again
+		// otherwise.
+		var mtd MongoItem
+		for {
+			err := t.collection.FindOne(t.context(), filter).Decode(&mtd)
+			if err == mongo.ErrNoDocuments {
+				return t.writeError(id)
+			} else if err != nil {
+				return err
+			}
+			moved, err := t.trashItem(mtd, bson.M{"_id": mtd.ID, "version": mtd.Version}, deletedAt)
+			if err != nil {
+				return err
+			} else if moved {
+				break
+			}
+		}
+		subtasks, err := t.findMongoItems(t.collection, bson.M{"parentid": mtd.ID}, nil)
+		if err != nil {
+			return err
+		}
+		if err := t.moveToTrash(subtasks, deletedAt); err != nil {
+			return err
+		}
+		return t.addAuditEntry(t.entry(AuditDelete, mtd.toItem(), Item{}, deletedAt))
+	})
+}
+
+// moveToTrash moves items to the trash, followed by their subtasks, level by level. They are all given the same
+// deletion time, which Mongo stores to the millisecond, so that they can be restored together.
+func (m *mongodb) moveToTrash(items []MongoItem, deletedAt time.Time) error {
+	for len(items) > 0 {
+		parents := make([]primitive.ObjectID, 0, len(items))
+		for _, mtd := range items {
+			moved, err := m.trashItem(mtd, bson.M{"_id": mtd.ID}, deletedAt)
+			if err != nil {
+				return err
+			} else if moved {
+				parents = append(parents, mtd.ID)
+			}
+		}
+		children, err := m.findMongoItems(m.collection, bson.M{"parentid": bson.M{"$in": parents}}, nil)
+		if err != nil {
+			return err
+		}
+		items = children
 	}
 	return nil
 }
 
-func (m *mongodb) updateItem(id string, td Item) (Item, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+// trashItem copies an item to the trash before deleting the item matched by filter, so that it is not lost when
+// Mongo has no transactions and the delete fails. When filter matches nothing the copy is removed again and
+// false is returned.
+func (m *mongodb) trashItem(mtd MongoItem, filter bson.M, deletedAt time.Time) (bool, error) {
+	mtd.DeletedAt = &deletedAt
+	_, err := m.trash.ReplaceOne(m.context(), bson.M{"_id": mtd.ID}, mtd, options.Replace().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	result, err := m.collection.DeleteOne(m.context(), filter)
 	if err != nil {
-		return Item{}, errors.New("Invalid ID.")
+		return false, err
+	}
+	if result.DeletedCount == 0 {
+		_, err := m.trash.DeleteOne(m.context(), bson.M{"_id": mtd.ID, "deletedat": deletedAt})
+		return false, err
 	}
+	return true, nil
+}
 
-	filter := bson.D{{"_id", objID}}
-	update := bson.D{
-		{"$set", bson.M{"Description": td.Description, "Completed": td.Completed}},
+func (m *mongodb) updateItem(ctx context.Context, id string, td Item) (Item, error) {
+	return m.patchItem(ctx, id, td, updatableFields)
+}
+
+// patchItem only writes the fields of td named in the field mask, leaving the others as they are.
+func (m *mongodb) patchItem(ctx context.Context, id string, td Item, fields []string) (Item, error) {
+	m = m.with(ctx)
+	current, ok := mongoItemFilter(id)
+	if !ok {
+		return Item{}, errors.New("Invalid ID type.")
 	}
 
-	_, err = m.collection.UpdateOne(context.TODO(), filter, update)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	set := bson.M{"updatedat": now}
+	// Empty fields are removed rather than set to null, which the item validator rejects.
+	unset := bson.M{}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	for _, field := range fields {
+		switch field {
+		case FieldDescription:
+			set["description"] = td.Description
+		case FieldNotes:
+			set["notes"] = td.Notes
+		case FieldCompleted:
+			set["completed"] = td.Completed
+			if !td.Completed {
+				unset["completedat"] = ""
+			}
+		case FieldPriority:
+			set["priority"] = td.Priority
+		case FieldDueAt:
+			if td.DueAt != nil {
+				set["dueat"] = td.DueAt.UTC()
+			} else {
+				unset["dueat"] = ""
+			}
+		case FieldStartAt:
+			if td.StartAt != nil {
+				set["startat"] = td.StartAt.UTC()
+			} else {
+				unset["startat"] = ""
+			}
+		case FieldListId:
+			listID, err := m.listID(td.ListId)
+			if err != nil {
+				return Item{}, err
+			}
+			if listID != nil {
+				set["listid"] = listID
+			} else {
+				unset["listid"] = ""
+			}
+		case FieldParentId:
+			parentID, parentUID, err := m.parentID(td.ParentId)
+			if err != nil {
+				return Item{}, err
+			}
+			if parentID != nil {
+				set["parentid"] = parentID
+			} else {
+				unset["parentid"] = ""
+			}
+			if parentUID != "" {
+				set["parentuid"] = parentUID
+			} else {
+				unset["parentuid"] = ""
+			}
+		case FieldPosition:
+			set["position"] = td.Position
+		case FieldCompleteWithSubtasks:
+			set["completewithsubtasks"] = td.CompleteWithSubtasks
+		case FieldTags:
+			set["tags"] = normaliseTags(td.Tags)
+		default:
+			return Item{}, &ErrorUnknownField{Field: field}
+		}
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	// The version is checked and incremented by the same update, so concurrent writes cannot both succeed.
+	filter, _ := mongoItemFilter(id)
+	if td.Version != 0 {
+		filter["version"] = td.Version
+	}
+	var mtd MongoItem
+	err := m.transaction(func(t *mongodb) error {
+		var before MongoItem
+		err := t.collection.FindOne(t.context(), current).Decode(&before)
+		if err == mongo.ErrNoDocuments {
+			return &ErrorItemNotFound{Id: id}
+		} else if err != nil {
+			return err
+		}
+		err = t.collection.FindOneAndUpdate(t.context(), filter, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&mtd)
+		if err == mongo.ErrNoDocuments {
+			return t.writeError(id)
+		} else if err != nil {
+			return err
+		}
+		// An update cannot set a field depending on its current value, so the time an item was completed is set
+		// by a second update, which leaves items which were complete already alone.
+		if mtd.Completed && mtd.CompletedAt == nil {
+			result, err := t.collection.UpdateOne(t.context(), bson.M{"_id": mtd.ID, "completed": true, "completedat": nil},
+				bson.M{"$set": bson.M{"completedat": now}})
+			if err != nil {
+				return err
+			}
+			if result.ModifiedCount == 1 {
+				mtd.CompletedAt = &now
+			}
+		}
+		return t.addAuditEntry(t.entry(AuditUpdate, before.toItem(), mtd.toItem(), now))
+	})
 	if err != nil {
+		return Item{}, err
+	}
+	return mtd.toItem(), nil
+}
+
+// writeError tells apart the reasons for a conditional write to an item matching nothing: either the item does
+// not exist, or its version has changed.
+func (m *mongodb) writeError(id string) error {
+	filter, _ := mongoItemFilter(id)
+	count, err := m.collection.CountDocuments(m.context(), filter)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return &ErrorItemNotFound{Id: id}
+	}
+	return &ErrorVersionMismatch{Id: id}
+}
+
+func (m *mongodb) getItem(ctx context.Context, id string) (Item, error) {
+	m = m.with(ctx)
+	filter, ok := mongoItemFilter(id)
+	if !ok {
+		return Item{}, errors.New("Invalid ID type.")
+	}
+
+	var mtd MongoItem
+	err := m.collection.FindOne(m.context(), filter).Decode(&mtd)
+	if err == mongo.ErrNoDocuments {
 		return Item{}, &ErrorItemNotFound{Id: id}
+	} else if err != nil {
+		return Item{}, err
+	}
+
+	return mtd.toItem(), nil
+}
+
+// allItems returns every item in the order they were created, like the other backends.
+func (m *mongodb) allItems(ctx context.Context) ([]Item, error) {
+	m = m.with(ctx)
+	return m.find(bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+}
+
+func (m *mongodb) findItems(ctx context.Context, query ItemQuery) ([]Item, error) {
+	m = m.with(ctx)
+	filter, err := mongoFilter(query)
+	if err != nil {
+		return make([]Item, 0), err
+	}
+	return m.find(filter, options.Find().SetSort(mongoSort(query.Sort)))
+}
+
+func (m *mongodb) findItemPage(ctx context.Context, query ItemQuery, page PageRequest) (ItemPage, error) {
+	m = m.with(ctx)
+	filter, err := mongoFilter(query)
+	if err != nil {
+		return ItemPage{Items: make([]Item, 0)}, err
+	}
+	var result ItemPage
+	if page.Total {
+		total, err := m.collection.CountDocuments(m.context(), filter)
+		if err != nil {
+			return ItemPage{Items: make([]Item, 0)}, err
+		}
+		result.Total = int(total)
+	}
+
+	sort := mongoSort(query.Sort)
+	before := page.Cursor != nil && page.Cursor.Before
+	if page.Cursor != nil {
+		keyset, err := mongoKeyset(sort, *page.Cursor)
+		if err != nil {
+			return ItemPage{Items: make([]Item, 0)}, err
+		}
+		filter = bson.M{"$and": bson.A{filter, keyset}}
+	}
+	if before {
+		reversed := bson.D{}
+		for _, e := range sort {
+			reversed = append(reversed, bson.E{Key: e.Key, Value: -e.Value.(int)})
+		}
+		sort = reversed
+	}
+
+	// One item more than the limit is fetched to find out whether there is a further page.
+	cur, err := m.collection.Find(m.context(), filter, options.Find().SetSort(sort).SetLimit(int64(page.Limit+1)))
+	if err != nil {
+		return ItemPage{Items: make([]Item, 0)}, err
+	}
+	var mtds []MongoItem
+	if err := cur.All(m.context(), &mtds); err != nil {
+		return ItemPage{Items: make([]Item, 0)}, err
+	}
+	more := len(mtds) > page.Limit
+	if more {
+		mtds = mtds[:page.Limit]
+	}
+	if before {
+		for i, j := 0, len(mtds)-1; i < j; i, j = i+1, j-1 {
+			mtds[i], mtds[j] = mtds[j], mtds[i]
+		}
+	}
+
+	result.Items = make([]Item, len(mtds))
+	for i, mtd := range mtds {
+		result.Items[i] = mtd.toItem()
+	}
+	if len(mtds) > 0 {
+		if before || more {
+			result.Next = &Cursor{Keys: mongoCursorKeys(mtds[len(mtds)-1], sort)}
+		}
+		if before && more || !before && page.Cursor != nil {
+			result.Prev = &Cursor{Keys: mongoCursorKeys(mtds[0], sort), Before: true}
+		}
+	}
+	return result, nil
+}
+
+// mongoFilter builds a filter matching the items matched by the query.
+func mongoFilter(query ItemQuery) (bson.M, error) {
+	filter := bson.M{}
+	if query.ListId != "" {
+		listID, err := primitive.ObjectIDFromHex(query.ListId)
+		if err != nil {
+			return nil, &ErrorListNotFound{Id: query.ListId}
+		}
+		filter["listid"] = listID
+	}
+	if query.Completed != nil {
+		filter["completed"] = *query.Completed
+	}
+	if query.DueBefore != nil {
+		filter["dueat"] = bson.M{"$lt": query.DueBefore.UTC()}
+	}
+	tags := bson.M{}
+	if len(query.AllTags) > 0 {
+		tags["$all"] = normaliseTags(query.AllTags)
+	}
+	if len(query.AnyTags) > 0 {
+		tags["$in"] = normaliseTags(query.AnyTags)
+	}
+	if len(tags) > 0 {
+		filter["tags"] = tags
+	}
+	if query.Filter != nil {
+		filter["$and"] = bson.A{mongoFilterExpr(query.Filter)}
+	}
+	return filter, nil
+}
+
+// mongoFilterExpr translates a filter expression into a query document.
+func mongoFilterExpr(expr FilterExpr) bson.M {
+	switch e := expr.(type) {
+	case FilterLogical:
+		operands := bson.A{}
+		for _, operand := range e.Operands {
+			operands = append(operands, mongoFilterExpr(operand))
+		}
+		return bson.M{"$" + e.Op: operands}
+	case FilterNot:
+		return bson.M{"$nor": bson.A{mongoFilterExpr(e.Operand)}}
+	case FilterComparison:
+		return mongoComparison(e)
+	}
+	panic(fmt.Sprintf("unknown filter expression %T", expr))
+}
+
+func mongoComparison(c FilterComparison) bson.M {
+	field := filterFields[c.Field]
+	name := mongoFilterFields[c.Field]
+	value := c.Value
+	switch {
+	case value == nil:
+		return bson.M{name: bson.M{mongoFilterOperators[c.Op]: nil}}
+	case field.kind == filterID:
+		return mongoIDComparison(c, name)
+	case field.kind == filterString:
+		// Notes are missing from items without any, which compare as an empty string.
+		var condition bson.M
+		if c.Op == FilterContains {
+			condition = bson.M{name: bson.M{"$regex": regexp.QuoteMeta(value.(string)), "$options": "i"}}
+		} else if c.Op == FilterNe && value == "" {
+			return bson.M{name: bson.M{"$nin": bson.A{"", nil}}}
+		} else {
+			condition = bson.M{name: bson.M{mongoFilterOperators[c.Op]: value}}
+		}
+		if compareEmpty(c.Op, value.(string)) {
+			return bson.M{"$or": bson.A{condition, bson.M{name: nil}}}
+		}
+		return condition
+	}
+	return bson.M{name: bson.M{mongoFilterOperators[c.Op]: value}}
+}
+
+// mongoUIDFields hold the generated IDs of the items in the ID fields which are filtered on. Lists are never given
+// one.
+var mongoUIDFields = map[string]string{SortById: "uid", FilterByParent: "parentuid"}
+
+// mongoIDComparison compares an ID field with an ObjectID or a generated ID. ObjectIDs only match items which were
+// not given a generated ID, and IDs which cannot exist in this database never equal any item's.
+func mongoIDComparison(c FilterComparison, name string) bson.M {
+	uidName := mongoUIDFields[c.Field]
+	condition := bson.M{"_id": bson.M{"$exists": false}}
+	if id, err := primitive.ObjectIDFromHex(c.Value.(string)); err == nil {
+		condition = bson.M{name: id}
+		if uidName != "" {
+			condition[uidName] = bson.M{"$exists": false}
+		}
+	} else if isGeneratedID(c.Value.(string)) && uidName != "" {
+		condition = bson.M{uidName: c.Value}
+	}
+	if c.Op == FilterNe {
+		return bson.M{"$nor": bson.A{condition}}
+	}
+	return condition
+}
+
+// compareEmpty reports whether an empty string is matched by a string comparison.
+func compareEmpty(op string, value string) bool {
+	switch op {
+	case FilterEq, FilterGe, FilterContains:
+		return value == ""
+	case FilterNe, FilterLt:
+		return value != ""
+	case FilterLe:
+		return true
+	}
+	return false
+}
+
+// mongoKeyset builds a filter matching the items which come after the cursor in the given sort order, or before
+// it for a Before cursor. Missing values sort before any other value.
+func mongoKeyset(sort bson.D, cursor Cursor) (bson.M, error) {
+	if len(cursor.Keys) != len(sort) {
+		return nil, errInvalidCursor
+	}
+
+	clauses := bson.A{}
+	equal := bson.M{}
+	for i, e := range sort {
+		v, err := mongoCursorValue(e.Key, cursor.Keys[i])
+		if err != nil {
+			return nil, err
+		}
+
+		var after interface{}
+		descending := (e.Value.(int) < 0) != cursor.Before
+		switch {
+		case v == nil && !descending:
+			after = bson.M{e.Key: bson.M{"$ne": nil}}
+		case v == nil && descending:
+			// Nothing comes after a missing value in descending order.
+		case !descending:
+			after = bson.M{e.Key: bson.M{"$gt": v}}
+		default:
+			after = bson.M{"$or": bson.A{bson.M{e.Key: bson.M{"$lt": v}}, bson.M{e.Key: nil}}}
+		}
+		if after != nil {
+			clause := bson.A{after}
+			for k, ev := range equal {
+				clause = append(clause, bson.M{k: ev})
+			}
+			clauses = append(clauses, bson.M{"$and": clause})
+		}
+		equal[e.Key] = v
+	}
+	if len(clauses) == 0 {
+		return bson.M{"_id": bson.M{"$exists": false}}, nil
+	}
+	return bson.M{"$or": clauses}, nil
+}
+
+func mongoCursorValue(key string, value *string) (interface{}, error) {
+	switch key {
+	case "dueat", "startat", "completedat":
+		return parseCursorTime(value, true)
+	case "createdat", "updatedat":
+		return parseCursorTime(value, false)
+	}
+	if value == nil {
+		return nil, errInvalidCursor
+	}
+	switch key {
+	case "_id":
+		objID, err := primitive.ObjectIDFromHex(*value)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		return objID, nil
+	case "priority":
+		priority, err := strconv.Atoi(*value)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		return priority, nil
+	}
+	return *value, nil
+}
+
+func mongoCursorKeys(mtd MongoItem, sort bson.D) []*string {
+	values := make([]*string, len(sort))
+	for i, e := range sort {
+		var v string
+		switch e.Key {
+		case "_id":
+			v = mtd.ID.Hex()
+		case "description":
+			v = mtd.Description
+		case "priority":
+			v = strconv.Itoa(mtd.Priority)
+		case "dueat":
+			values[i] = cursorTime(mtd.DueAt)
+			continue
+		case "startat":
+			values[i] = cursorTime(mtd.StartAt)
+			continue
+		case "createdat":
+			values[i] = cursorTime(&mtd.CreatedAt)
+			continue
+		case "updatedat":
+			values[i] = cursorTime(&mtd.UpdatedAt)
+			continue
+		case "completedat":
+			values[i] = cursorTime(mtd.CompletedAt)
+			continue
+		}
+		values[i] = &v
+	}
+	return values
+}
+
+func (m *mongodb) setItemTags(ctx context.Context, id string, tags []string) (Item, error) {
+	m = m.with(ctx)
+	filter, ok := mongoItemFilter(id)
+	if !ok {
+		return Item{}, errors.New("Invalid ID type.")
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	update := bson.M{"$set": bson.M{"tags": normaliseTags(tags), "updatedat": now}, "$inc": bson.M{"version": 1}}
+
+	var mtd MongoItem
+	err := m.transaction(func(t *mongodb) error {
+		var before MongoItem
+		err := t.collection.FindOneAndUpdate(t.context(), filter, update).Decode(&before)
+		if err == mongo.ErrNoDocuments {
+			return &ErrorItemNotFound{Id: id}
+		} else if err != nil {
+			return err
+		}
+		mtd = before
+		mtd.Tags = normaliseTags(tags)
+		mtd.Version++
+		mtd.UpdatedAt = now
+		return t.addAuditEntry(t.entry(AuditUpdate, before.toItem(), mtd.toItem(), now))
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return mtd.toItem(), nil
+}
+
+func (m *mongodb) allTags(ctx context.Context) ([]TagCount, error) {
+	m = m.with(ctx)
+	pipeline := mongo.Pipeline{
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+	cur, err := m.collection.Aggregate(m.context(), pipeline)
+	if err != nil {
+		return make([]TagCount, 0), err
+	}
+	defer cur.Close(m.context())
+
+	tags := make([]TagCount, 0)
+	for cur.Next(m.context()) {
+		var result struct {
+			Name  string `bson:"_id"`
+			Count int    `bson:"count"`
+		}
+		if err := cur.Decode(&result); err != nil {
+			return make([]TagCount, 0), err
+		}
+		tags = append(tags, TagCount{Name: result.Name, Count: result.Count})
 	}
-	td.Id = id
-	return td, nil
+	return tags, cur.Err()
 }
 
-func (m *mongodb) getItem(id string) (Item, error) {
+func (m *mongodb) createList(ctx context.Context, list List) (List, error) {
+	m = m.with(ctx)
+	ml := MongoList{Name: list.Name}
+	insertResult, err := m.lists.InsertOne(m.context(), ml)
+	if err != nil {
+		return List{}, errors.New("Unable to insert list into database.")
+	}
+	ml.ID = insertResult.InsertedID.(primitive.ObjectID)
+	return ml.toList(), nil
+}
+
+func (m *mongodb) updateList(ctx context.Context, id string, list List) (List, error) {
+	m = m.with(ctx)
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return Item{}, errors.New("Invalid ID.")
+		return List{}, errors.New("Invalid ID type.")
 	}
 
-	filter := bson.D{{"_id", objID}}
+	result, err := m.lists.UpdateOne(m.context(), bson.M{"_id": objID}, bson.M{"$set": bson.M{"name": list.Name}})
+	if err != nil {
+		return List{}, err
+	}
+	if result.MatchedCount == 0 {
+		return List{}, &ErrorListNotFound{Id: id}
+	}
+	return List{Id: id, Name: list.Name}, nil
+}
 
-	var item Item
-	err = m.collection.FindOne(context.TODO(), filter).Decode(&item)
+// deleteList deletes a list along with its items when cascade is set, otherwise its items are moved to the inbox.
+func (m *mongodb) deleteList(ctx context.Context, id string, cascade bool) error {
+	m = m.with(ctx)
+	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return Item{}, &ErrorItemNotFound{Id: id}
+		return errors.New("Invalid ID type.")
+	}
+	if _, err := m.getList(ctx, id); err != nil {
+		return err
 	}
 
-	item.Id = id
-	return item, nil
+	items := bson.M{"listid": objID}
+	if !cascade {
+		_, err = m.collection.UpdateMany(m.context(), items, bson.M{"$unset": bson.M{"listid": ""}, "$set": bson.M{"updatedat": time.Now().UTC()}, "$inc": bson.M{"version": 1}})
+		if err != nil {
+			return err
+		}
+		_, err = m.lists.DeleteOne(m.context(), bson.M{"_id": objID})
+		return err
+	}
+	deletedAt := time.Now().UTC().Truncate(time.Millisecond)
+	return m.transaction(func(t *mongodb) error {
+		trashed, err := t.findMongoItems(t.collection, items, nil)
+		if err != nil {
+			return err
+		}
+		if err := t.moveToTrash(trashed, deletedAt); err != nil {
+			return err
+		}
+		for _, mtd := range trashed {
+			if err := t.addAuditEntry(t.entry(AuditDelete, mtd.toItem(), Item{}, deletedAt)); err != nil {
+				return err
+			}
+		}
+		_, err = t.lists.DeleteOne(t.context(), bson.M{"_id": objID})
+		return err
+	})
 }
 
-func (m *mongodb) allItems() ([]Item, error) {
-	findOptions := options.Find()
-	var results []Item
-	var emptyResults []Item
+func (m *mongodb) getList(ctx context.Context, id string) (List, error) {
+	m = m.with(ctx)
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return List{}, errors.New("Invalid ID type.")
+	}
 
-	cur, err := m.collection.Find(context.TODO(), bson.D{{}}, findOptions)
+	var ml MongoList
+	err = m.lists.FindOne(m.context(), bson.M{"_id": objID}).Decode(&ml)
+	if err == mongo.ErrNoDocuments {
+		return List{}, &ErrorListNotFound{Id: id}
+	} else if err != nil {
+		return List{}, err
+	}
+	return ml.toList(), nil
+}
+
+func (m *mongodb) allLists(ctx context.Context) ([]List, error) {
+	m = m.with(ctx)
+	cur, err := m.lists.Find(m.context(), bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
 	if err != nil {
-		return emptyResults, err
+		return make([]List, 0), err
 	}
+	defer cur.Close(m.context())
 
-	for cur.Next(context.TODO()) {
-		var elem Item
-		err := cur.Decode(&elem)
+	lists := make([]List, 0)
+	for cur.Next(m.context()) {
+		var ml MongoList
+		if err := cur.Decode(&ml); err != nil {
+			return make([]List, 0), err
+		}
+		lists = append(lists, ml.toList())
+	}
+	return lists, cur.Err()
+}
+
+// createSubtask adds an item to the end of the subtasks of its parent.
+func (m *mongodb) createSubtask(ctx context.Context, parentId string, item Item) (Item, error) {
+	m = m.with(ctx)
+	parentID, _, err := m.parentID(parentId)
+	if err != nil {
+		return Item{}, err
+	}
+
+	var last MongoItem
+	err = m.collection.FindOne(m.context(), bson.M{"parentid": parentID},
+		options.FindOne().SetSort(bson.M{"position": -1})).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		item.Position = 0
+	} else if err != nil {
+		return Item{}, err
+	} else {
+		item.Position = last.Position + 1
+	}
+	item.ParentId = parentId
+	return m.createItem(ctx, item)
+}
+
+func (m *mongodb) subtasks(ctx context.Context, parentId string) ([]Item, error) {
+	m = m.with(ctx)
+	parentID, _, err := m.parentID(parentId)
+	if err != nil {
+		return make([]Item, 0), err
+	}
+	sort := bson.D{{Key: "position", Value: 1}, {Key: "_id", Value: 1}}
+	return m.find(bson.M{"parentid": parentID}, options.Find().SetSort(sort))
+}
+
+// trashedItems returns the items in the trash, most recently deleted first.
+func (m *mongodb) trashedItems(ctx context.Context) ([]Item, error) {
+	m = m.with(ctx)
+	sort := bson.D{{Key: "deletedat", Value: -1}, {Key: "_id", Value: 1}}
+	mtds, err := m.findMongoItems(m.trash, bson.M{}, options.Find().SetSort(sort))
+	if err != nil {
+		return make([]Item, 0), err
+	}
+	items := make([]Item, len(mtds))
+	for i, mtd := range mtds {
+		items[i] = mtd.toItem()
+	}
+	return items, nil
+}
+
+// restoreItem moves an item back out of the trash along with the subtasks which were deleted with it. A subtask
+// cannot be restored while its parent is in the trash, but becomes a top level item once its parent has been
+// purged. Items in a list which has since been deleted are moved to the inbox. Each item is copied back before it
+// is removed from the trash, so none are lost if it fails.
+func (m *mongodb) restoreItem(ctx context.Context, id string) (Item, error) {
+	m = m.with(ctx)
+	root, err := m.trashedItem(id)
+	if err != nil {
+		return Item{}, err
+	}
+	before := root
+	err = m.transaction(func(t *mongodb) error {
+		if root.ParentID != nil {
+			count, err := t.trash.CountDocuments(t.context(), bson.M{"_id": *root.ParentID})
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return &ErrorItemTrashed{Id: root.parentId()}
+			}
+			count, err = t.collection.CountDocuments(t.context(), bson.M{"_id": *root.ParentID})
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				root.ParentID = nil
+				root.ParentUID = ""
+			}
+		}
+		restored, err := t.trashedSubtasks(root, true)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
+		for i := range restored {
+			mtd := &restored[i]
+			if mtd.ListID != nil {
+				if _, err := t.getList(ctx, mtd.ListID.Hex()); err != nil {
+					var le *ErrorListNotFound
+					if !errors.As(err, &le) {
+						return err
+					}
+					mtd.ListID = nil
+				}
+			}
+			mtd.DeletedAt = nil
+			mtd.Version++
+			mtd.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+			if _, err := t.collection.ReplaceOne(t.context(), bson.M{"_id": mtd.ID}, mtd, options.Replace().SetUpsert(true)); err != nil {
+				return err
+			}
+			if _, err := t.trash.DeleteOne(t.context(), bson.M{"_id": mtd.ID}); err != nil {
+				return err
+			}
+		}
+		return t.addAuditEntry(t.entry(AuditRestore, before.toItem(), restored[0].toItem(), restored[0].UpdatedAt))
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return m.getItem(ctx, id)
+}
 
-		elements, _ := cur.Current.Elements()
-		elem.Id = elements[0].Value().ObjectID().Hex()
+// purgeItem deletes an item in the trash for good, along with its subtasks.
+func (m *mongodb) purgeItem(ctx context.Context, id string) error {
+	m = m.with(ctx)
+	root, err := m.trashedItem(id)
+	if err != nil {
+		return err
+	}
+	purged, err := m.trashedSubtasks(root, false)
+	if err != nil {
+		return err
+	}
+	ids := make([]primitive.ObjectID, len(purged))
+	for i, mtd := range purged {
+		ids[i] = mtd.ID
+	}
+	_, err = m.trash.DeleteMany(m.context(), bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+// purgeTrash deletes the items which were moved to the trash before the given time for good.
+func (m *mongodb) purgeTrash(ctx context.Context, before time.Time) (int, error) {
+	m = m.with(ctx)
+	result, err := m.trash.DeleteMany(m.context(), bson.M{"deletedat": bson.M{"$lt": before.UTC()}})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
 
-		results = append(results, elem)
+func (m *mongodb) trashedItem(id string) (MongoItem, error) {
+	filter, ok := mongoItemFilter(id)
+	if !ok {
+		return MongoItem{}, errors.New("Invalid ID type.")
 	}
+	var mtd MongoItem
+	err := m.trash.FindOne(m.context(), filter).Decode(&mtd)
+	if err == mongo.ErrNoDocuments {
+		return MongoItem{}, &ErrorItemNotFound{Id: id}
+	}
+	return mtd, err
+}
+
+// trashedSubtasks returns an item in the trash followed by its subtasks in the trash, level by level. When
+// sameTime is set, only the subtasks which were deleted along with the item are returned.
+func (m *mongodb) trashedSubtasks(root MongoItem, sameTime bool) ([]MongoItem, error) {
+	items := []MongoItem{root}
+	parents := []primitive.ObjectID{root.ID}
+	for len(parents) > 0 {
+		filter := bson.M{"parentid": bson.M{"$in": parents}}
+		if sameTime {
+			filter["deletedat"] = root.DeletedAt
+		}
+		children, err := m.findMongoItems(m.trash, filter, nil)
+		if err != nil {
+			return nil, err
+		}
+		parents = make([]primitive.ObjectID, len(children))
+		for i, mtd := range children {
+			parents[i] = mtd.ID
+		}
+		items = append(items, children...)
+	}
+	return items, nil
+}
+
+// parentID resolves the parent item of a subtask, where an empty id means the item is not a subtask. The parent's
+// generated ID is returned along with its ObjectID, or an empty string when it has none.
+func (m *mongodb) parentID(id string) (*primitive.ObjectID, string, error) {
+	if id == "" {
+		return nil, "", nil
+	}
+	filter, ok := mongoItemFilter(id)
+	if !ok {
+		return nil, "", &ErrorItemNotFound{Id: id}
+	}
+	var parent MongoItem
+	err := m.collection.FindOne(m.context(), filter).Decode(&parent)
+	if err == mongo.ErrNoDocuments {
+		return nil, "", &ErrorItemNotFound{Id: id}
+	} else if err != nil {
+		return nil, "", err
+	}
+	return &parent.ID, parent.UID, nil
+}
+
+// mongoItemFilter matches the item with the given ID, reporting false when it is not an ID this database could
+// have given out. Items which were given a generated ID are only found by it, and not by their ObjectID.
+func mongoItemFilter(id string) (bson.M, bool) {
+	if isGeneratedID(id) {
+		return bson.M{"uid": id}, true
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, false
+	}
+	return bson.M{"_id": objID, "uid": bson.M{"$exists": false}}, true
+}
 
+// listID resolves the list an item refers to, where an empty id refers to the inbox.
+func (m *mongodb) listID(id string) (*primitive.ObjectID, error) {
+	if id == "" {
+		return nil, nil
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, &ErrorListNotFound{Id: id}
+	}
+	if _, err := m.getList(m.context(), id); err != nil {
+		return nil, err
+	}
+	return &objID, nil
+}
+
+// mongoSort converts sort fields into a sort document, ending with _id so that the order is stable. Mongo
+// rejects a sort document which names the same key twice.
+func mongoSort(fields []SortField) bson.D {
+	sort := bson.D{}
+	seen := make(map[string]bool)
+	fields = append(fields[:len(fields):len(fields)], SortField{Field: SortById})
+	for _, f := range fields {
+		key := mongoSortKeys[f.Field]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		direction := 1
+		if f.Descending {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: key, Value: direction})
+	}
+	return sort
+}
+
+func (m *mongodb) searchItems(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	m = m.with(ctx)
+	terms := searchTerms(q)
+	results := make([]SearchResult, 0)
+	if len(terms) == 0 {
+		return results, nil
+	}
+	// Each term is quoted so that items have to contain all of them.
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + t + `"`
+	}
+	score := bson.M{"$meta": "textScore"}
+	findOptions := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
+	cur, err := m.collection.Find(m.context(), bson.M{"$text": bson.M{"$search": strings.Join(quoted, " ")}}, findOptions)
+	if err != nil {
+		return results, err
+	}
+	defer cur.Close(m.context())
+	for cur.Next(m.context()) {
+		var elem struct {
+			MongoItem `bson:",inline"`
+			Score     float64 `bson:"score"`
+		}
+		if err := cur.Decode(&elem); err != nil {
+			return make([]SearchResult, 0), err
+		}
+		item := elem.toItem()
+		results = append(results, SearchResult{Item: item, Score: elem.Score, Snippet: itemSnippet(item, terms)})
+	}
 	if err := cur.Err(); err != nil {
+		return make([]SearchResult, 0), err
+	}
+	return results, nil
+}
+
+func (m *mongodb) find(filter bson.M, findOptions *options.FindOptions) ([]Item, error) {
+	results := make([]Item, 0)
+	var emptyResults []Item
+
+	cur, err := m.collection.Find(m.context(), filter, findOptions)
+	if err != nil {
 		return emptyResults, err
 	}
 
-	cur.Close(context.TODO())
-	return results, err
+	defer cur.Close(m.context())
+	for cur.Next(m.context()) {
+		var elem MongoItem
+		if err := cur.Decode(&elem); err != nil {
+			return emptyResults, err
+		}
+
+		results = append(results, elem.toItem())
+	}
+
+	if err := cur.Err(); err != nil {
+		return emptyResults, err
+	}
+	return results, nil
+}
+
+// findMongoItems returns the documents in a collection of items which match the filter.
+func (m *mongodb) findMongoItems(collection *mongo.Collection, filter bson.M, findOptions *options.FindOptions) ([]MongoItem, error) {
+	cur, err := collection.Find(m.context(), filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(m.context())
+
+	mtds := make([]MongoItem, 0)
+	for cur.Next(m.context()) {
+		var mtd MongoItem
+		if err := cur.Decode(&mtd); err != nil {
+			return nil, err
+		}
+		mtds = append(mtds, mtd)
+	}
+	return mtds, cur.Err()
+}
+
+// addAuditEntry appends an entry to the audit log.
+func (m *mongodb) addAuditEntry(entry AuditEntry) error {
+	mentry := MongoAuditEntry{
+		ItemID:    entry.ItemId,
+		Action:    entry.Action,
+		Version:   entry.Version,
+		Actor:     entry.Actor,
+		RequestID: entry.RequestId,
+		At:        entry.At,
+	}
+	for _, change := range entry.Changes {
+		mentry.Changes = append(mentry.Changes, MongoFieldChange{Field: change.Field, Old: string(change.Old), New: string(change.New)})
+	}
+	_, err := m.audit.InsertOne(m.context(), mentry)
+	return err
+}
+
+// auditedBy returns a copy of the database which records the writes it makes as made by actor.
+func (m *mongodb) auditedBy(actor, requestId string) Database {
+	t := *m
+	t.auditor = auditor{actor: actor, requestId: requestId}
+	return &t
+}
+
+func (m *mongodb) itemHistory(ctx context.Context, id string) ([]AuditEntry, error) {
+	m = m.with(ctx)
+	entries, err := m.findAuditEntries(bson.M{"itemid": id}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, &ErrorItemNotFound{Id: id}
+	}
+	return entries, nil
+}
+
+func (m *mongodb) auditEntries(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	m = m.with(ctx)
+	filter := bson.M{"at": bson.M{"$gte": query.Since.UTC()}}
+	if query.After != "" {
+		after, err := primitive.ObjectIDFromHex(query.After)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		filter["_id"] = bson.M{"$gt": after}
+	}
+	findOptions := options.Find().SetSort(bson.M{"_id": 1})
+	if query.Limit > 0 {
+		findOptions.SetLimit(int64(query.Limit))
+	}
+	return m.findAuditEntries(filter, findOptions)
+}
+
+func (m *mongodb) findAuditEntries(filter bson.M, findOptions *options.FindOptions) ([]AuditEntry, error) {
+	cur, err := m.audit.Find(m.context(), filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(m.context())
+
+	entries := make([]AuditEntry, 0)
+	for cur.Next(m.context()) {
+		var mentry MongoAuditEntry
+		if err := cur.Decode(&mentry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, mentry.toAuditEntry())
+	}
+	return entries, cur.Err()
+}
+
+// transaction runs fn in a transaction, or in the current one when there is one already. When Mongo does not
+// support transactions, fn makes its writes one after the other.
+func (m *mongodb) transaction(fn func(t *mongodb) error) error {
+	if !m.transactions {
+		return fn(m)
+	}
+	return m.atomically(m.context(), func(db Database) error {
+		return fn(db.(*mongodb))
+	})
+}
+
+// atomically runs fn with a copy of the database whose every method uses the same transaction, which is aborted
+// if fn fails. Transactions need Mongo to run as a replica set, and without one fn is not run at all.
+func (m *mongodb) atomically(ctx context.Context, fn func(db Database) error) error {
+	if _, ok := m.ctx.(mongo.SessionContext); ok {
+		return fn(m)
+	}
+	if !m.transactions {
+		return errNoTransactions
+	}
+	m = m.with(ctx)
+	session, err := m.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(m.context())
+	_, err = session.WithTransaction(m.context(), func(sc mongo.SessionContext) (interface{}, error) {
+		t := *m
+		t.ctx = sc
+		return nil, fn(&t)
+	})
+	return err
+}
+
+func (m *mongodb) createItems(ctx context.Context, items []Item, atomic bool) ([]BatchResult, error) {
+	return createItems(ctx, m, items, atomic, m.atomically)
+}
+
+func (m *mongodb) patchItems(ctx context.Context, patches []ItemPatch, atomic bool) ([]BatchResult, error) {
+	return patchItems(ctx, m, patches, atomic, m.atomically)
+}
+
+func (m *mongodb) deleteItems(ctx context.Context, query ItemQuery, atomic bool) ([]BatchResult, error) {
+	return deleteItems(ctx, m, query, atomic, m.atomically)
+}
+
+func (m *mongodb) reserveKey(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error) {
+	m = m.with(ctx)
+	for {
+		_, err := m.keys.InsertOne(m.context(), MongoIdempotencyKey{
+			Key:         record.Key,
+			Fingerprint: record.Fingerprint,
+			ExpiresAt:   record.ExpiresAt.UTC(),
+		})
+		if err == nil {
+			return nil, nil
+		} else if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+
+		var key MongoIdempotencyKey
+		err = m.keys.FindOne(m.context(), bson.M{"_id": record.Key}).Decode(&key)
+		if err == mongo.ErrNoDocuments {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if time.Now().Before(key.ExpiresAt) {
+			r := key.toRecord()
+			return &r, nil
+		}
+		// The key has expired but has not been removed yet.
+		_, err = m.keys.DeleteOne(m.context(), bson.M{"_id": record.Key, "expiresat": key.ExpiresAt})
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (m *mongodb) saveResponse(ctx context.Context, key string, status int, etag string, body []byte) error {
+	m = m.with(ctx)
+	_, err := m.keys.UpdateOne(m.context(), bson.M{"_id": key},
+		bson.M{"$set": bson.M{"status": status, "etag": etag, "body": body}})
+	return err
+}
+
+func (m *mongodb) releaseKey(ctx context.Context, key string) error {
+	m = m.with(ctx)
+	_, err := m.keys.DeleteOne(m.context(), bson.M{"_id": key})
+	return err
 }
 
 func (m *mongodb) close() {
-	m.client.Disconnect(context.TODO())
+	m.client.Disconnect(m.context())
+}
+
+func (m MongoIdempotencyKey) toRecord() IdempotencyRecord {
+	return IdempotencyRecord{
+		Key:         m.Key,
+		Fingerprint: m.Fingerprint,
+		Status:      m.Status,
+		ETag:        m.ETag,
+		Body:        m.Body,
+		ExpiresAt:   m.ExpiresAt,
+	}
+}
+
+// id returns the ID the item is known by, which is its generated ID when it was given one.
+func (m MongoItem) id() string {
+	if m.UID != "" {
+		return m.UID
+	}
+	return m.ID.Hex()
+}
+
+// parentId returns the ID the parent of a subtask is known by.
+func (m MongoItem) parentId() string {
+	if m.ParentUID != "" {
+		return m.ParentUID
+	} else if m.ParentID != nil {
+		return m.ParentID.Hex()
+	}
+	return ""
+}
+
+func (m MongoItem) toItem() Item {
+	listId := ""
+	if m.ListID != nil {
+		listId = m.ListID.Hex()
+	}
+	return Item{
+		Id:                   m.id(),
+		Description:          m.Description,
+		Notes:                m.Notes,
+		Completed:            m.Completed,
+		Priority:             m.Priority,
+		DueAt:                m.DueAt,
+		StartAt:              m.StartAt,
+		ListId:               listId,
+		Tags:                 normaliseTags(m.Tags),
+		ParentId:             m.parentId(),
+		Position:             m.Position,
+		CompleteWithSubtasks: m.CompleteWithSubtasks,
+		Version:              m.Version,
+		CreatedAt:            m.CreatedAt.UTC(),
+		UpdatedAt:            m.UpdatedAt.UTC(),
+		CompletedAt:          utc(m.CompletedAt),
+		DeletedAt:            utc(m.DeletedAt),
+	}
+}
+
+func (m MongoAuditEntry) toAuditEntry() AuditEntry {
+	entry := AuditEntry{
+		Id:        m.ID.Hex(),
+		ItemId:    m.ItemID,
+		Action:    m.Action,
+		Version:   m.Version,
+		Actor:     m.Actor,
+		RequestId: m.RequestID,
+		At:        m.At.UTC(),
+	}
+	for _, change := range m.Changes {
+		entry.Changes = append(entry.Changes, FieldChange{Field: change.Field, Old: json.RawMessage(change.Old), New: json.RawMessage(change.New)})
+	}
+	return entry
+}
+
+func (m MongoList) toList() List {
+	return List{Id: m.ID.Hex(), Name: m.Name}
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+)
+
+// sessionContext stands in for the context of a transaction, which only a server can start.
+type sessionContext struct {
+	mongo.SessionContext
+}
+
+func Test_mongodb_with(t *testing.T) {
+	db := &mongodb{connectionString: "mongodb://127.0.0.1:27017"}
+	key := struct{}{}
+	withCtx := db.with(context.WithValue(ctx, key, "request"))
+	assert.NotSame(t, db, withCtx)
+	assert.Equal(t, "request", withCtx.context().Value(key))
+	assert.Nil(t, db.ctx, "the database itself is left alone")
+	assert.Equal(t, db.connectionString, withCtx.connectionString)
+
+	again := withCtx.with(ctx)
+	assert.Equal(t, ctx, again.context(), "a copy can be given another context")
+	assert.Equal(t, "request", withCtx.context().Value(key))
+
+	inTransaction := &mongodb{ctx: sessionContext{}}
+	assert.Same(t, inTransaction, inTransaction.with(ctx), "calls in a transaction keep being made in it")
+}
+
+func Test_mongoSort(t *testing.T) {
+	assert.Equal(t, bson.D{{Key: "createdat", Value: -1}, {Key: "_id", Value: 1}},
+		mongoSort([]SortField{{Field: SortByCreated, Descending: true}}), "items created at the same time are ordered by _id")
+	assert.Equal(t, bson.D{{Key: "_id", Value: -1}}, mongoSort([]SortField{{Field: SortById, Descending: true}}))
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Patch media types accepted by PATCH /todo/{id}.
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// PatchError describes a patch which is well formed but cannot be applied to an item.
+type PatchError struct {
+	Msg string
+}
+
+func (e *PatchError) Error() string {
+	return e.Msg
+}
+
+var errPatchTestFailed = errors.New("Patch test operation failed")
+
+// errPathNotFound is returned when a JSON pointer does not point at an existing value.
+var errPathNotFound = errors.New("path not found")
+
+// readOnlyFields are the item fields which are managed by the database.
+var readOnlyFields = []string{"Id", "Version", "CreatedAt", "UpdatedAt", "CompletedAt", "Subtasks", "DeletedAt"}
+
+// patchField returns the name of the item field matching a member of a patch. Members are matched ignoring case,
+// like they are when decoding a whole item.
+func patchField(name string) (string, error) {
+	for _, field := range patchableFields {
+		if strings.EqualFold(name, field) {
+			return field, nil
+		}
+	}
+	for _, field := range readOnlyFields {
+		if strings.EqualFold(name, field) {
+			return "", &PatchError{Msg: fmt.Sprintf("Field %s cannot be patched", name)}
+		}
+	}
+	return "", &PatchError{Msg: fmt.Sprintf("Unknown field %q", name)}
+}
+
+// itemDocument returns an item as the JSON document which patches are applied to.
+func itemDocument(item Item) (map[string]interface{}, error) {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	delete(doc, "Subtasks")
+	return doc, nil
+}
+
+// documentItem decodes a patched document back into an item.
+func documentItem(doc map[string]interface{}) (Item, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return Item{}, err
+	}
+	var item Item
+	var te *json.UnmarshalTypeError
+	if err := json.Unmarshal(b, &item); errors.As(err, &te) {
+		return Item{}, &PatchError{Msg: fmt.Sprintf("Invalid value for %s", te.Field)}
+	} else if err != nil {
+		return Item{}, &PatchError{Msg: err.Error()}
+	}
+	return item, nil
+}
+
+// applyMergePatch applies an RFC 7396 merge patch to an item. It returns the patched item along with the fields
+// named by the patch. A null member resets a field to its zero value.
+func applyMergePatch(item Item, patch []byte) (Item, []string, error) {
+	var members map[string]json.RawMessage
+	var te *json.UnmarshalTypeError
+	if err := json.Unmarshal(patch, &members); errors.As(err, &te) {
+		return Item{}, nil, &PatchError{Msg: "Merge patch must be a JSON object"}
+	} else if err != nil {
+		return Item{}, nil, err
+	}
+	if members == nil {
+		return Item{}, nil, &PatchError{Msg: "Merge patch must be a JSON object"}
+	}
+	doc, err := itemDocument(item)
+	if err != nil {
+		return Item{}, nil, err
+	}
+
+	var fields []string
+	for name, raw := range members {
+		field, err := patchField(name)
+		if err != nil {
+			return Item{}, nil, err
+		}
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return Item{}, nil, err
+		}
+		// Item fields are never objects, so a member replaces the whole field.
+		if value == nil {
+			delete(doc, field)
+		} else {
+			doc[field] = value
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	patched, err := documentItem(doc)
+	if err != nil {
+		return Item{}, nil, err
+	}
+	return patched, fields, nil
+}
+
+type jsonPatchOperation struct {
+	Op    string
+	Path  string
+	From  string
+	Value interface{}
+	// hasValue tells a null value apart from a missing one.
+	hasValue bool
+}
+
+// applyJSONPatch applies an RFC 6902 JSON patch to an item. It returns the patched item along with the fields
+// changed by the patch. Operations are applied in turn, and the first one which fails stops the whole patch.
+func applyJSONPatch(item Item, patch []byte) (Item, []string, error) {
+	var raw []map[string]json.RawMessage
+	var te *json.UnmarshalTypeError
+	if err := json.Unmarshal(patch, &raw); errors.As(err, &te) {
+		return Item{}, nil, &PatchError{Msg: "JSON patch must be an array of operations"}
+	} else if err != nil {
+		return Item{}, nil, err
+	}
+	if raw == nil {
+		return Item{}, nil, &PatchError{Msg: "JSON patch must be an array of operations"}
+	}
+	doc, err := itemDocument(item)
+	if err != nil {
+		return Item{}, nil, err
+	}
+
+	var fields []string
+	touched := make(map[string]bool)
+	touch := func(tokens []string) {
+		if !touched[tokens[0]] {
+			touched[tokens[0]] = true
+			fields = append(fields, tokens[0])
+		}
+	}
+	var root interface{} = doc
+	for i, members := range raw {
+		op, err := parseJSONPatchOperation(members)
+		if err != nil {
+			return Item{}, nil, &PatchError{Msg: fmt.Sprintf("Operation %d: %s", i, err.Error())}
+		}
+		root, err = applyJSONPatchOperation(root, op, touch)
+		if err == errPatchTestFailed {
+			return Item{}, nil, err
+		} else if err != nil {
+			return Item{}, nil, &PatchError{Msg: fmt.Sprintf("Operation %d: %s", i, err.Error())}
+		}
+	}
+
+	patched, err := documentItem(root.(map[string]interface{}))
+	if err != nil {
+		return Item{}, nil, err
+	}
+	return patched, fields, nil
+}
+
+func parseJSONPatchOperation(members map[string]json.RawMessage) (jsonPatchOperation, error) {
+	var op jsonPatchOperation
+	for name, target := range map[string]*string{"op": &op.Op, "path": &op.Path, "from": &op.From} {
+		raw, ok := members[name]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(raw, target); err != nil {
+			return op, fmt.Errorf("%s must be a string", name)
+		}
+	}
+	if raw, ok := members["value"]; ok {
+		if err := json.Unmarshal(raw, &op.Value); err != nil {
+			return op, err
+		}
+		op.hasValue = true
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if !op.hasValue {
+			return op, fmt.Errorf("%s requires a value", op.Op)
+		}
+	case "move", "copy":
+		if _, ok := members["from"]; !ok {
+			return op, fmt.Errorf("%s requires from", op.Op)
+		}
+	case "remove":
+	default:
+		return op, fmt.Errorf("unknown op %q, expected one of add, remove, replace, move, copy or test", op.Op)
+	}
+	if _, ok := members["path"]; !ok {
+		return op, errors.New("path is missing")
+	}
+	return op, nil
+}
+
+func applyJSONPatchOperation(root interface{}, op jsonPatchOperation, touch func([]string)) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add":
+		touch(path)
+		root, err = addValue(root, path, op.Value)
+		return root, pathError(op.Path, err)
+	case "remove":
+		touch(path)
+		root, _, err = removeValue(root, path)
+		return root, pathError(op.Path, err)
+	case "replace":
+		touch(path)
+		if root, _, err = removeValue(root, path); err != nil {
+			return nil, pathError(op.Path, err)
+		}
+		root, err = addValue(root, path, op.Value)
+		return root, pathError(op.Path, err)
+	case "test":
+		value, err := getValue(root, path)
+		if err != nil {
+			return nil, pathError(op.Path, err)
+		}
+		if !reflect.DeepEqual(value, op.Value) {
+			return nil, errPatchTestFailed
+		}
+		return root, nil
+	}
+
+	from, err := parsePointer(op.From)
+	if err != nil {
+		return nil, err
+	}
+	value, err := getValue(root, from)
+	if err != nil {
+		return nil, pathError(op.From, err)
+	}
+	if op.Op == "move" {
+		if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+			return nil, errors.New("cannot move a value into itself")
+		}
+		touch(from)
+		if root, _, err = removeValue(root, from); err != nil {
+			return nil, pathError(op.From, err)
+		}
+	} else if value, err = copyValue(value); err != nil {
+		return nil, err
+	}
+	touch(path)
+	root, err = addValue(root, path, value)
+	return root, pathError(op.Path, err)
+}
+
+// pathError names the pointer in errPathNotFound errors.
+func pathError(pointer string, err error) error {
+	if err == errPathNotFound {
+		return fmt.Errorf("%s does not exist", pointer)
+	}
+	return err
+}
+
+// parsePointer parses an RFC 6901 JSON pointer to a member of an item. The first token is replaced with the name
+// of the item field it matches.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, errors.New("a patch cannot replace the whole item")
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid path %q, expected it to start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	field, err := patchField(tokens[0])
+	if err != nil {
+		return nil, err
+	}
+	tokens[0] = field
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token. The end of the array, given by "-" or its length, is only valid when
+// adding a value.
+func arrayIndex(token string, length int, end bool) (int, error) {
+	if end && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > length || i == length && !end || token != strconv.Itoa(i) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return i, nil
+}
+
+func getValue(doc interface{}, tokens []string) (interface{}, error) {
+	for _, t := range tokens {
+		switch c := doc.(type) {
+		case map[string]interface{}:
+			v, ok := c[t]
+			if !ok {
+				return nil, errPathNotFound
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, errPathNotFound
+		}
+	}
+	return doc, nil
+}
+
+// addValue adds a value at the location given by the tokens, returning the updated document.
+func addValue(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		if len(tokens) == 1 {
+			c[tokens[0]] = value
+			return c, nil
+		}
+		child, ok := c[tokens[0]]
+		if !ok {
+			return nil, errPathNotFound
+		}
+		child, err := addValue(child, tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		c[tokens[0]] = child
+		return c, nil
+	case []interface{}:
+		i, err := arrayIndex(tokens[0], len(c), len(tokens) == 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(tokens) == 1 {
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		}
+		child, err := addValue(c[i], tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		c[i] = child
+		return c, nil
+	}
+	return nil, errPathNotFound
+}
+
+// removeValue removes the value at the location given by the tokens, returning the updated document along with
+// the value which was removed.
+func removeValue(doc interface{}, tokens []string) (interface{}, interface{}, error) {
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		child, ok := c[tokens[0]]
+		if !ok {
+			return nil, nil, errPathNotFound
+		}
+		if len(tokens) == 1 {
+			delete(c, tokens[0])
+			return c, child, nil
+		}
+		child, removed, err := removeValue(child, tokens[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		c[tokens[0]] = child
+		return c, removed, nil
+	case []interface{}:
+		i, err := arrayIndex(tokens[0], len(c), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(tokens) == 1 {
+			removed := c[i]
+			return append(c[:i], c[i+1:]...), removed, nil
+		}
+		child, removed, err := removeValue(c[i], tokens[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		c[i] = child
+		return c, removed, nil
+	}
+	return nil, nil, errPathNotFound
+}
+
+func copyValue(value interface{}) (interface{}, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var copied interface{}
+	err = json.Unmarshal(b, &copied)
+	return copied, err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_applyMergePatch(t *testing.T) {
+	due := time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC)
+	item := Item{Id: "1", Description: "Buy milk", Completed: true, Priority: PriorityHigh, DueAt: &due, Tags: []string{"home"}}
+
+	patched, fields, err := applyMergePatch(item, []byte(`{"description": "Buy oat milk", "DueAt": null, "tags": ["home", "shop"]}`))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{FieldDescription, FieldDueAt, FieldTags}, fields)
+	assert.Equal(t, Item{Id: "1", Description: "Buy oat milk", Completed: true, Priority: PriorityHigh, Tags: []string{"home", "shop"}}, patched)
+}
+
+func Test_applyMergePatch_errors(t *testing.T) {
+	item := Item{Id: "1", Description: "Buy milk"}
+
+	var patchTests = []struct {
+		patch    string
+		expected string
+	}{
+		{`{"Size": 3}`, `Unknown field "Size"`},
+		{`{"Id": "2"}`, `Field Id cannot be patched`},
+		{`{"completedAt": null}`, `Field completedAt cannot be patched`},
+		{`{"Priority": "high"}`, `Invalid value for Priority`},
+		{`null`, `Merge patch must be a JSON object`},
+		{`["Description"]`, `Merge patch must be a JSON object`},
+	}
+
+	for _, tt := range patchTests {
+		t.Run(tt.patch, func(t *testing.T) {
+			_, _, err := applyMergePatch(item, []byte(tt.patch))
+			var pe *PatchError
+			assert.True(t, errors.As(err, &pe))
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+
+	_, _, err := applyMergePatch(item, []byte(`{"Description": `))
+	assert.Error(t, err)
+}
+
+func Test_applyJSONPatch(t *testing.T) {
+	due := time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC)
+	item := Item{Id: "1", Description: "Buy milk", Notes: "Semi-skimmed", Priority: PriorityHigh, DueAt: &due, Tags: []string{"home", "shop"}}
+
+	patched, fields, err := applyJSONPatch(item, []byte(`[
+		{"op": "test", "path": "/Priority", "value": 3},
+		{"op": "replace", "path": "/completed", "value": true},
+		{"op": "copy", "from": "/DueAt", "path": "/StartAt"},
+		{"op": "remove", "path": "/DueAt"},
+		{"op": "add", "path": "/Tags/-", "value": "urgent"},
+		{"op": "remove", "path": "/Tags/0"},
+		{"op": "move", "from": "/Notes", "path": "/Description"}
+	]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{FieldCompleted, FieldStartAt, FieldDueAt, FieldTags, FieldNotes, FieldDescription}, fields)
+	assert.Equal(t, Item{Id: "1", Description: "Semi-skimmed", Completed: true, Priority: PriorityHigh, StartAt: &due, Tags: []string{"shop", "urgent"}}, patched)
+}
+
+func Test_applyJSONPatch_errors(t *testing.T) {
+	item := Item{Id: "1", Description: "Buy milk", Tags: []string{"home"}}
+
+	var patchTests = []struct {
+		patch    string
+		expected string
+	}{
+		{`{"op": "add"}`, `JSON patch must be an array of operations`},
+		{`[{"op": "delete", "path": "/Description"}]`, `Operation 0: unknown op "delete", expected one of add, remove, replace, move, copy or test`},
+		{`[{"op": "add", "path": "/Description"}]`, `Operation 0: add requires a value`},
+		{`[{"op": "remove"}]`, `Operation 0: path is missing`},
+		{`[{"op": "move", "path": "/Notes"}]`, `Operation 0: move requires from`},
+		{`[{"op": "remove", "path": ""}]`, `Operation 0: a patch cannot replace the whole item`},
+		{`[{"op": "remove", "path": "Description"}]`, `Operation 0: invalid path "Description", expected it to start with /`},
+		{`[{"op": "remove", "path": "/Size"}]`, `Operation 0: Unknown field "Size"`},
+		{`[{"op": "replace", "path": "/Id", "value": "2"}]`, `Operation 0: Field Id cannot be patched`},
+		{`[{"op": "remove", "path": "/Description"}, {"op": "remove", "path": "/Description"}]`, `Operation 1: /Description does not exist`},
+		{`[{"op": "remove", "path": "/Tags/1"}]`, `Operation 0: invalid array index "1"`},
+		{`[{"op": "add", "path": "/Tags/01", "value": "work"}]`, `Operation 0: invalid array index "01"`},
+		{`[{"op": "move", "from": "/Tags", "path": "/Tags/0"}]`, `Operation 0: cannot move a value into itself`},
+		{`[{"op": "replace", "path": "/Completed", "value": "yes"}]`, `Invalid value for Completed`},
+	}
+
+	for _, tt := range patchTests {
+		t.Run(tt.patch, func(t *testing.T) {
+			_, _, err := applyJSONPatch(item, []byte(tt.patch))
+			var pe *PatchError
+			assert.True(t, errors.As(err, &pe))
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+
+	_, _, err := applyJSONPatch(item, []byte(`[{"op": "test", "path": "/Description", "value": "Buy eggs"}]`))
+	assert.Equal(t, errPatchTestFailed, err)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain runs the gormdb tests against Postgres instead of sqlite3 when TEST_POSTGRES is set, either to the
+// connection string of a database which the tests may wipe, or to "spawn" to start a throwaway server with the
+// initdb and pg_ctl found in PG_BIN or on the PATH. Postgres will not run as root.
+func TestMain(m *testing.M) {
+	connectionString := os.Getenv("TEST_POSTGRES")
+	stop := func() {}
+	if connectionString == "spawn" {
+		var err error
+		connectionString, stop, err = spawnPostgres()
+		if err != nil {
+			log.Fatalf("Unable to start Postgres: %v", err)
+		}
+	}
+	if connectionString != "" {
+		testDialect, testConnectionString = "postgres", connectionString
+	}
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+// spawnPostgres starts a Postgres server on a free port, with its data in a temporary directory, returning its
+// connection string and a function which stops it and removes its data.
+func spawnPostgres() (string, func(), error) {
+	initdb, pgCtl := "initdb", "pg_ctl"
+	if bin := os.Getenv("PG_BIN"); bin != "" {
+		initdb, pgCtl = filepath.Join(bin, initdb), filepath.Join(bin, pgCtl)
+	}
+	dir, err := ioutil.TempDir("", "todo-postgres")
+	if err != nil {
+		return "", nil, err
+	}
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "--auth=trust", "--no-sync").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("%v: %s", err, out)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	options := fmt.Sprintf("-h 127.0.0.1 -p %d -k %s -F", port, dir)
+	if out, err := exec.Command(pgCtl, "-D", data, "-o", options, "-l", filepath.Join(dir, "log"), "-w", "start").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("%v: %s", err, out)
+	}
+
+	stop := func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "-w", "stop").Run()
+		os.RemoveAll(dir)
+	}
+	return fmt.Sprintf("host=127.0.0.1 port=%d user=postgres dbname=postgres sslmode=disable", port), stop, nil
+}
+
+// resetPostgres drops every table, so that each test starts with an empty database and IDs start at 1.
+func resetPostgres(connectionString string) {
+	db, err := gorm.Open("postgres", connectionString)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	if err := db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public").Error; err != nil {
+		panic(err)
+	}
+}
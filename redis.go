@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisKeyPrefix is put in front of every key the cache keeps in Redis, so that it can share a server.
+const redisKeyPrefix = "todo:"
+
+// redisError is an error reply from Redis, after which the connection can still be used.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisCache keeps entries in Redis, where they are shared by every instance of the server and expire on their
+// own. Commands are sent one at a time over a single connection, which is dialled again after it fails.
+type redisCache struct {
+	address string
+	timeout time.Duration
+	mu      sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+func newRedisCache(address string) *redisCache {
+	return &redisCache{address: address, timeout: time.Second}
+}
+
+func (c *redisCache) ping() error {
+	_, err := c.do("PING")
+	return err
+}
+
+func (c *redisCache) get(key string) ([]byte, bool, error) {
+	reply, err := c.do("GET", redisKeyPrefix+key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	b, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("Unexpected reply to GET: %v", reply)
+	}
+	return b, true, nil
+}
+
+func (c *redisCache) set(key string, value []byte, ttl time.Duration) error {
+	_, err := c.do("SET", redisKeyPrefix+key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (c *redisCache) counter(key string) (int64, error) {
+	b, ok, err := c.get(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return strconv.ParseInt(string(b), 10, 64)
+}
+
+func (c *redisCache) incr(key string) (int64, error) {
+	reply, err := c.do("INCR", redisKeyPrefix+key)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("Unexpected reply to INCR: %v", reply)
+	}
+	return n, nil
+}
+
+// do sends a command and returns its reply, which is nil, a string, an int64, a []byte or a []interface{} of
+// them. The connection is closed when anything but an error reply goes wrong, and dialled again by the next
+// command.
+func (c *redisCache) do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.address, c.timeout)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+		c.reader = bufio.NewReader(conn)
+	}
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(c.conn, command.String())
+	var reply interface{}
+	if err == nil {
+		reply, err = readRedisReply(c.reader)
+	}
+	if _, ok := err.(redisError); err != nil && !ok {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+// readRedisReply reads a reply in the Redis serialization protocol.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("Invalid reply %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, redisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$', '*':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if kind == '$' {
+			b := make([]byte, n+2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return nil, err
+			}
+			return b[:n], nil
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			if replies[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("Invalid reply %q", line)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a Redis server which only knows the commands used by redisCache, for when there is no real one to
+// test against.
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	values   map[string]string
+	expires  map[string]time.Time
+	conns    []net.Conn
+}
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &fakeRedis{listener: listener, values: make(map[string]string), expires: make(map[string]time.Time)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		s.dropConnections()
+	})
+	return s
+}
+
+func (s *fakeRedis) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		command, err := readRedisReply(r)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		var args []string
+		for _, arg := range command.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+		fmt.Fprint(conn, s.reply(args))
+	}
+}
+
+func (s *fakeRedis) reply(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		if expires, ok := s.expires[args[1]]; ok && time.Now().After(expires) {
+			delete(s.values, args[1])
+			delete(s.expires, args[1])
+		}
+		value, ok := s.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "SET":
+		s.values[args[1]] = args[2]
+		ms, _ := strconv.Atoi(args[4])
+		s.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return "+OK\r\n"
+	case "INCR":
+		n, _ := strconv.ParseInt(s.values[args[1]], 10, 64)
+		s.values[args[1]] = strconv.FormatInt(n+1, 10)
+		return fmt.Sprintf(":%d\r\n", n+1)
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+func Test_redisCache(t *testing.T) {
+	server := startFakeRedis(t)
+	cache := newRedisCache(server.listener.Addr().String())
+	testCache(t, cache)
+	assert.Equal(t, "1", server.values["todo:test:counter"], "keys are prefixed")
+
+	_, err := cache.do("FLUSHALL")
+	assert.EqualError(t, err, "ERR unknown command 'FLUSHALL'")
+	assert.NoError(t, cache.ping(), "the connection is kept after an error reply")
+
+	server.dropConnections()
+	cache.ping()
+	assert.NoError(t, cache.ping(), "a connection which fails is dialled again")
+}
+
+// Test_redisCache_server runs against a real Redis server when TEST_REDIS is set to its address.
+func Test_redisCache_server(t *testing.T) {
+	address := os.Getenv("TEST_REDIS")
+	if address == "" {
+		t.Skip("TEST_REDIS is not set")
+	}
+	testCache(t, newRedisCache(address))
+}
+
+func Test_readRedisReply(t *testing.T) {
+	tests := map[string]interface{}{
+		"+OK\r\n":                 "OK",
+		":42\r\n":                 int64(42),
+		"$3\r\nabc\r\n":           []byte("abc"),
+		"$-1\r\n":                 nil,
+		"*2\r\n$1\r\na\r\n:1\r\n": []interface{}{[]byte("a"), int64(1)},
+		"$5\r\na\r\nbc\r\n":       []byte("a\r\nbc"),
+		"*0\r\n":                  []interface{}{},
+		"-ERR wrong type\r\n":     redisError("ERR wrong type"),
+		"?what\r\n":               fmt.Errorf("Invalid reply %q", "?what\r\n"),
+	}
+	for input, expected := range tests {
+		reply, err := readRedisReply(bufio.NewReader(strings.NewReader(input)))
+		if e, ok := expected.(error); ok {
+			assert.Equal(t, e, err, input)
+			continue
+		}
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, reply, input)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// snippetWords is the number of words either side of the first match which are kept in a snippet.
+	snippetWords = 8
+)
+
+// searchTerms splits a search query into lower case words, ignoring punctuation and repeated words. Every
+// backend matches items containing all of the words.
+func searchTerms(q string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(q), isSeparator) {
+		if !seen[w] {
+			seen[w] = true
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// textWord is a word in a piece of text, along with its position in the text.
+type textWord struct {
+	word       string
+	start, end int
+}
+
+func textWords(text string) []textWord {
+	var words []textWord
+	start := -1
+	for i, r := range text {
+		if isSeparator(r) {
+			if start >= 0 {
+				words = append(words, textWord{strings.ToLower(text[start:i]), start, i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, textWord{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return words
+}
+
+// countMatches returns how many times each term occurs in the text, for backends without a search index.
+func countMatches(text string, terms []string) map[string]int {
+	counts := make(map[string]int)
+	for _, w := range textWords(text) {
+		for _, t := range terms {
+			if w.word == t {
+				counts[t]++
+			}
+		}
+	}
+	return counts
+}
+
+// matchScore ranks an item for backends without a search index. It is zero unless every term occurs in the
+// description or notes, and otherwise the number of occurrences with those in the description counting double.
+func matchScore(item Item, terms []string) float64 {
+	description := countMatches(item.Description, terms)
+	notes := countMatches(item.Notes, terms)
+	score := 0
+	for _, t := range terms {
+		if description[t]+notes[t] == 0 {
+			return 0
+		}
+		score += 2*description[t] + notes[t]
+	}
+	return float64(score)
+}
+
+// itemSnippet returns a snippet of the description of an item, or of its notes when only they match the terms.
+func itemSnippet(item Item, terms []string) string {
+	if len(countMatches(item.Description, terms)) == 0 && len(countMatches(item.Notes, terms)) > 0 {
+		return snippet(item.Notes, terms)
+	}
+	return snippet(item.Description, terms)
+}
+
+// snippet returns the words around the first match in the text, with each matching word wrapped in a <mark>
+// element. Text which was cut off is replaced with an ellipsis. The text itself is escaped, so that the snippet can
+// be rendered as HTML without running any markup the item contains.
+func snippet(text string, terms []string) string {
+	words := textWords(text)
+	if len(words) == 0 {
+		return html.EscapeString(text)
+	}
+	first := 0
+	isTerm := make(map[string]bool)
+	for _, t := range terms {
+		isTerm[t] = true
+	}
+	for i, w := range words {
+		if isTerm[w.word] {
+			first = i
+			break
+		}
+	}
+
+	from, to := first-snippetWords, first+snippetWords
+	if from < 0 {
+		from = 0
+	}
+	if to > len(words)-1 {
+		to = len(words) - 1
+	}
+
+	var b strings.Builder
+	start, end := words[from].start, words[to].end
+	if from > 0 {
+		b.WriteString("…")
+	} else {
+		start = 0
+	}
+	if to == len(words)-1 {
+		end = len(text)
+	}
+	pos := start
+	for _, w := range words[from : to+1] {
+		if isTerm[w.word] {
+			b.WriteString(html.EscapeString(text[pos:w.start]))
+			b.WriteString("<mark>")
+			b.WriteString(html.EscapeString(text[w.start:w.end]))
+			b.WriteString("</mark>")
+			pos = w.end
+		}
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if to < len(words)-1 {
+		b.WriteString("…")
+	}
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_searchTerms(t *testing.T) {
+	assert.Equal(t, []string{"buy", "milk", "café"}, searchTerms(" Buy milk, MILK & Café!"))
+	assert.Nil(t, searchTerms(" -- "))
+}
+
+func Test_snippet(t *testing.T) {
+	terms := []string{"milk"}
+	assert.Equal(t, "Buy <mark>milk</mark>.", snippet("Buy milk.", terms))
+	assert.Equal(t, "No match", snippet("No match", terms))
+	assert.Equal(t, "Milkshake", snippet("Milkshake", terms))
+	assert.Equal(t, "&lt;img src=x onerror=alert(1)&gt; &amp; <mark>milk</mark>", snippet("<img src=x onerror=alert(1)> & milk", terms))
+	assert.Equal(t, "&lt;b&gt;", snippet("<b>", terms))
+
+	text := "one two three four five six seven eight nine ten Milk eleven twelve thirteen fourteen fifteen sixteen seventeen eighteen nineteen"
+	assert.Equal(t, "…three four five six seven eight nine ten <mark>Milk</mark> eleven twelve thirteen fourteen fifteen sixteen seventeen eighteen…", snippet(text, terms))
+}
+
+func Test_matchScore(t *testing.T) {
+	item := Item{Description: "Buy milk", Notes: "Milk and bread"}
+	assert.Equal(t, 3.0, matchScore(item, []string{"milk"}))
+	assert.Equal(t, 4.0, matchScore(item, []string{"milk", "bread"}))
+	assert.Equal(t, 0.0, matchScore(item, []string{"milk", "eggs"}))
+}
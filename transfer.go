@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// transferPageSize is how many items are read from the source database at a time.
+const transferPageSize = 100
+
+// exportVersion is the version of the export format, written in the header of each exported file.
+const exportVersion = 1
+
+// errMissingConnectionString is returned by newDatabase for a database which needs a connection string.
+var errMissingConnectionString = errors.New("Missing connection string")
+
+// newDatabase returns a database of the given type, which has not been opened yet. New items are given IDs by
+// newID, or legacy IDs when it is nil.
+func newDatabase(dbType string, connectionString string, autoMigrate bool, newID idGenerator) (Database, error) {
+	var db Database
+	switch dbType {
+	case "mongo":
+		db = &mongodb{connectionString: connectionString, autoMigrate: autoMigrate, newID: newID}
+	case "sqlite3", "mysql", "postgres":
+		db = &gormdb{dialect: dbType, connectionString: connectionString, autoMigrate: autoMigrate, newID: newID}
+	case "memory":
+		// The snapshot is optional.
+		return &memorydb{snapshot: connectionString, newID: newID}, nil
+	case "bolt":
+		db = &boltdb{path: connectionString, newID: newID}
+	case "events":
+		db = &eventdb{path: connectionString, newID: newID}
+	default:
+		return nil, fmt.Errorf("Unknown database %q", dbType)
+	}
+	if connectionString == "" {
+		return nil, errMissingConnectionString
+	}
+	return db, nil
+}
+
+// openDatabase opens a database given as its type and connection string separated by a colon, such as
+// sqlite3:todo.db or mongo:mongodb://127.0.0.1:27017.
+func openDatabase(spec string, autoMigrate bool, newID idGenerator) (Database, error) {
+	i := strings.Index(spec, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("Invalid database %q, expected <type>:<connection string>", spec)
+	}
+	db, err := newDatabase(spec[:i], spec[i+1:], autoMigrate, newID)
+	if err == errMissingConnectionString {
+		return nil, fmt.Errorf("Invalid database %q, expected <type>:<connection string>", spec)
+	} else if err != nil {
+		return nil, err
+	}
+	db.init()
+	return db, nil
+}
+
+// exportRecord is one line of an exported file, which starts with a header followed by the lists and then the
+// items.
+type exportRecord struct {
+	Type       string     `json:"type"`
+	Version    int        `json:"version,omitempty"`
+	ExportedAt *time.Time `json:"exported_at,omitempty"`
+	List       *List      `json:"list,omitempty"`
+	Item       *Item      `json:"item,omitempty"`
+}
+
+// transferSource gives the lists and then the items to be copied.
+type transferSource interface {
+	each(onList func(List) error, onItem func(Item) error) error
+}
+
+// databaseSource reads a database a page of items at a time.
+type databaseSource struct {
+	db Database
+}
+
+func (s databaseSource) each(onList func(List) error, onItem func(Item) error) error {
+	lists, err := s.db.allLists(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, list := range lists {
+		if err := onList(list); err != nil {
+			return err
+		}
+	}
+	page := PageRequest{Limit: transferPageSize}
+	for {
+		items, err := s.db.findItemPage(context.Background(), ItemQuery{Sort: []SortField{{Field: SortById}}}, page)
+		if err != nil {
+			return err
+		}
+		for _, item := range items.Items {
+			if err := onItem(item); err != nil {
+				return err
+			}
+		}
+		if items.Next == nil {
+			return nil
+		}
+		page.Cursor = items.Next
+	}
+}
+
+// fileSource reads an exported file.
+type fileSource struct {
+	r io.Reader
+}
+
+func (s fileSource) each(onList func(List) error, onItem func(Item) error) error {
+	scanner := bufio.NewScanner(s.r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var record exportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("Invalid record on line %d: %v", line, err)
+		}
+		if line == 1 {
+			if record.Type != "header" || record.Version < 1 || record.Version > exportVersion {
+				return fmt.Errorf("Not an export of version %d or older", exportVersion)
+			}
+			continue
+		}
+		var err error
+		switch {
+		case record.Type == "list" && record.List != nil:
+			err = onList(*record.List)
+		case record.Type == "item" && record.Item != nil:
+			err = onItem(*record.Item)
+		default:
+			err = fmt.Errorf("Invalid record on line %d", line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if line == 0 {
+		return fmt.Errorf("Not an export of version %d or older", exportVersion)
+	}
+	return nil
+}
+
+// exportData writes every list and item of a database to w, one JSON record per line.
+func exportData(db Database, w io.Writer) (int, int, error) {
+	encoder := json.NewEncoder(w)
+	now := time.Now().UTC()
+	if err := encoder.Encode(exportRecord{Type: "header", Version: exportVersion, ExportedAt: &now}); err != nil {
+		return 0, 0, err
+	}
+	lists, items := 0, 0
+	err := databaseSource{db}.each(func(list List) error {
+		lists++
+		return encoder.Encode(exportRecord{Type: "list", List: &list})
+	}, func(item Item) error {
+		items++
+		return encoder.Encode(exportRecord{Type: "item", Item: &item})
+	})
+	return lists, items, err
+}
+
+// transferState maps the IDs of the lists and items already copied to their IDs in the target database. It is
+// kept in a file with a line for each copy, which is appended to as soon as it is made, so that an interrupted
+// transfer can carry on where it left off.
+type transferState struct {
+	lists map[string]string
+	items map[string]string
+	file  *os.File
+}
+
+func openTransferState(path string) (*transferState, error) {
+	s := &transferState{lists: make(map[string]string), items: make(map[string]string)}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	// A line cut short by an interruption is dropped, along with the copy it was recording, so that the next
+	// line is not appended to it.
+	complete := bytes.LastIndexByte(data, '\n') + 1
+	if err := file.Truncate(int64(complete)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(int64(complete), io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.file = file
+	for _, line := range strings.Split(string(data[:complete]), "\n") {
+		var kind, from, to string
+		if n, _ := fmt.Sscanf(line, "%s %s %s", &kind, &from, &to); n != 3 {
+			continue
+		}
+		switch kind {
+		case "list":
+			s.lists[from] = to
+		case "item":
+			s.items[from] = to
+		}
+	}
+	return s, nil
+}
+
+// resumed tells whether anything has been copied already.
+func (s *transferState) resumed() bool {
+	return len(s.lists) > 0 || len(s.items) > 0
+}
+
+func (s *transferState) record(kind string, from string, to string) error {
+	if _, err := fmt.Fprintf(s.file, "%s %s %s\n", kind, from, to); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *transferState) close() {
+	s.file.Close()
+}
+
+// TransferReport gives how many lists and items were copied, along with a checksum of their contents which does
+// not depend on their IDs.
+type TransferReport struct {
+	Lists    int
+	Items    int
+	Checksum string
+}
+
+// copyData copies every list and item from the source to the target, skipping those the state says have been
+// copied already, and then checks the target holds exactly what was copied. Items are created after their
+// parents, so subtasks which come before their parent are held back until it has been copied.
+func copyData(src transferSource, dst Database, state *transferState) (TransferReport, error) {
+	if !state.resumed() {
+		if err := checkEmpty(dst); err != nil {
+			return TransferReport{}, err
+		}
+	}
+	expectedLists := make(map[string]string)
+	expectedItems := make(map[string]string)
+	var pending []Item
+	copyItem := func(item Item) error {
+		translated := item
+		translated.Id = ""
+		if item.ListId != "" {
+			if translated.ListId = state.lists[item.ListId]; translated.ListId == "" {
+				return fmt.Errorf("Item %s is in list %s, which is not in the source", item.Id, item.ListId)
+			}
+		}
+		if item.ParentId != "" {
+			translated.ParentId = state.items[item.ParentId]
+		}
+		to, ok := state.items[item.Id]
+		if !ok {
+			created, err := dst.createItem(context.Background(), translated)
+			if err != nil {
+				return fmt.Errorf("Unable to copy item %s: %v", item.Id, err)
+			}
+			to = created.Id
+			if err := state.record("item", item.Id, to); err != nil {
+				return err
+			}
+			state.items[item.Id] = to
+		}
+		expectedItems[to] = itemDigest(translated)
+		return nil
+	}
+
+	err := src.each(func(list List) error {
+		to, ok := state.lists[list.Id]
+		if !ok {
+			created, err := dst.createList(context.Background(), List{Name: list.Name})
+			if err != nil {
+				return fmt.Errorf("Unable to copy list %s: %v", list.Id, err)
+			}
+			to = created.Id
+			if err := state.record("list", list.Id, to); err != nil {
+				return err
+			}
+			state.lists[list.Id] = to
+		}
+		expectedLists[to] = list.Name
+		return nil
+	}, func(item Item) error {
+		if _, ok := state.items[item.ParentId]; item.ParentId != "" && !ok {
+			pending = append(pending, item)
+			return nil
+		}
+		return copyItem(item)
+	})
+	if err != nil {
+		return TransferReport{}, err
+	}
+	for len(pending) > 0 {
+		var waiting []Item
+		for _, item := range pending {
+			if _, ok := state.items[item.ParentId]; !ok {
+				waiting = append(waiting, item)
+			} else if err := copyItem(item); err != nil {
+				return TransferReport{}, err
+			}
+		}
+		if len(waiting) == len(pending) {
+			return TransferReport{}, fmt.Errorf("Item %s is a subtask of %s, which is not in the source", waiting[0].Id, waiting[0].ParentId)
+		}
+		pending = waiting
+	}
+	return verifyData(dst, expectedLists, expectedItems)
+}
+
+// checkEmpty makes sure a transfer does not start by mixing its copies with what is in the target already.
+func checkEmpty(db Database) error {
+	lists, err := db.allLists(context.Background())
+	if err != nil {
+		return err
+	}
+	items, err := db.findItemPage(context.Background(), ItemQuery{}, PageRequest{Limit: 1})
+	if err != nil {
+		return err
+	}
+	if len(lists) > 0 || len(items.Items) > 0 {
+		return errors.New("The target database is not empty, transfer into an empty database or resume with the state of an earlier transfer")
+	}
+	return nil
+}
+
+// verifyData checks that the target holds the expected lists and items, and nothing else, by comparing digests
+// of their contents.
+func verifyData(db Database, expectedLists map[string]string, expectedItems map[string]string) (TransferReport, error) {
+	lists, err := db.allLists(context.Background())
+	if err != nil {
+		return TransferReport{}, err
+	}
+	if len(lists) != len(expectedLists) {
+		return TransferReport{}, fmt.Errorf("The target has %d lists but %d were copied", len(lists), len(expectedLists))
+	}
+	var digests []string
+	for _, list := range lists {
+		name, ok := expectedLists[list.Id]
+		if !ok || name != list.Name {
+			return TransferReport{}, fmt.Errorf("List %s in the target does not match the source", list.Id)
+		}
+		digests = append(digests, "list "+list.Name)
+	}
+
+	items := 0
+	err = databaseSource{db}.each(func(List) error {
+		return nil
+	}, func(item Item) error {
+		items++
+		id := item.Id
+		digest, ok := expectedItems[id]
+		item.Id = ""
+		if !ok || digest != itemDigest(item) {
+			return fmt.Errorf("Item %s in the target does not match the source", id)
+		}
+		digests = append(digests, digest)
+		return nil
+	})
+	if err != nil {
+		return TransferReport{}, err
+	}
+	if items != len(expectedItems) {
+		return TransferReport{}, fmt.Errorf("The target has %d items but %d were copied", items, len(expectedItems))
+	}
+
+	sort.Strings(digests)
+	h := sha256.New()
+	for _, digest := range digests {
+		fmt.Fprintln(h, digest)
+	}
+	return TransferReport{Lists: len(lists), Items: items, Checksum: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// itemDigest hashes what an item holds, apart from its ID and version, which a copy does not keep. Times are
+// compared to the second, the precision every backend keeps.
+func itemDigest(item Item) string {
+	truncate := func(t *time.Time) *time.Time {
+		if t == nil {
+			return nil
+		}
+		truncated := t.UTC().Truncate(time.Second)
+		return &truncated
+	}
+	tags := append([]string{}, item.Tags...)
+	sort.Strings(tags)
+	var b bytes.Buffer
+	json.NewEncoder(&b).Encode([]interface{}{item.Description, item.Notes, item.Completed, item.Priority,
+		truncate(item.DueAt), truncate(item.StartAt), item.ListId, tags, item.ParentId, item.Position,
+		item.CompleteWithSubtasks})
+	sum := sha256.Sum256(b.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// runDataCommand carries out the transfer, export and import subcommands, which move data between databases
+// and files.
+func runDataCommand(command string, args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	from := flags.String("from", "", "Database to read from, as <type>:<connection string>")
+	to := flags.String("to", "", "Database to write to, as <type>:<connection string>")
+	file := flags.String("file", "-", "File to export to or import from, where - is standard output or input")
+	statePath := flags.String("state", "todo-transfer.state", "File keeping track of what has been copied, so that an interrupted transfer or import can be resumed")
+	autoMigrate := flags.Bool("auto-migrate", false, "Apply pending migrations to the database written to")
+	ids := flags.String("ids", IDFormatLegacy, "IDs to give the items written to. Options are: \"legacy\", \"ulid\" and \"uuidv7\"")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("Unexpected argument to %s: %s", command, flags.Arg(0))
+	}
+
+	if command == "export" {
+		if *from == "" {
+			return errors.New("Missing -from database to export")
+		}
+		src, err := openDatabase(*from, false, nil)
+		if err != nil {
+			return err
+		}
+		defer src.close()
+		w := stdout
+		if *file != "-" {
+			f, err := os.Create(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		buffered := bufio.NewWriter(w)
+		lists, items, err := exportData(src, buffered)
+		if err == nil {
+			err = buffered.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Exported %d lists and %d items", lists, items)
+		return nil
+	}
+
+	var src transferSource
+	switch command {
+	case "transfer":
+		if *from == "" || *to == "" {
+			return errors.New("Missing -from or -to database to transfer between")
+		}
+		db, err := openDatabase(*from, false, nil)
+		if err != nil {
+			return err
+		}
+		defer db.close()
+		src = databaseSource{db}
+	case "import":
+		if *to == "" {
+			return errors.New("Missing -to database to import into")
+		}
+		r := stdin
+		if *file != "-" {
+			f, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		src = fileSource{r}
+	default:
+		return fmt.Errorf("Unknown command %q", command)
+	}
+	newID, err := newIDGenerator(*ids)
+	if err != nil {
+		return err
+	}
+	dst, err := openDatabase(*to, *autoMigrate, newID)
+	if err != nil {
+		return err
+	}
+	defer dst.close()
+	state, err := openTransferState(*statePath)
+	if err != nil {
+		return err
+	}
+	report, err := copyData(src, dst, state)
+	state.close()
+	if err != nil {
+		return err
+	}
+	log.Printf("Copied and verified %d lists and %d items, checksum %s", report.Lists, report.Items, report.Checksum)
+	return os.Remove(*statePath)
+}
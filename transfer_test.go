@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// initTransferSource fills a database with lists, items and subtasks, including a subtask which comes before its
+// parent.
+func initTransferSource(t *testing.T) Database {
+	db := initMemoryDB()
+	due := time.Date(2020, 5, 1, 9, 30, 0, 0, time.UTC)
+	list, _ := db.createList(ctx, List{Name: "Shopping"})
+	db.createList(ctx, List{Name: "Empty"})
+	milk, _ := db.createItem(ctx, Item{Description: "Buy milk", ListId: list.Id, Tags: []string{"home", "dairy"}, DueAt: &due})
+	db.createItem(ctx, Item{Description: "Hoover", Notes: "Upstairs too", Priority: PriorityHigh, Completed: true})
+	move, _ := db.createItem(ctx, Item{Description: "Move house", CompleteWithSubtasks: true})
+	db.createSubtask(ctx, move.Id, Item{Description: "Pack"})
+	_, err := db.patchItem(ctx, milk.Id, Item{ParentId: move.Id, Position: 1}, []string{FieldParentId, FieldPosition})
+	assert.NoError(t, err)
+	return db
+}
+
+func initTransferState(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "todo")
+	assert.NoError(t, err)
+	return filepath.Join(dir, "transfer.state"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_copyData(t *testing.T) {
+	src := initTransferSource(t)
+	defer src.close()
+	dst, cleanup := initBoltDB(t)
+	defer cleanup()
+	path, remove := initTransferState(t)
+	defer remove()
+
+	state, err := openTransferState(path)
+	assert.NoError(t, err)
+	report, err := copyData(databaseSource{src}, dst, state)
+	state.close()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Lists)
+	assert.Equal(t, 4, report.Items)
+
+	lists, _ := dst.allLists(ctx)
+	items, _ := dst.findItems(ctx, ItemQuery{Sort: []SortField{{Field: SortByDescription}}})
+	assert.Equal(t, []string{"Buy milk", "Hoover", "Move house", "Pack"}, itemDescriptions(items))
+	milk, move := items[0], items[2]
+	assert.Equal(t, lists[0].Id, milk.ListId)
+	assert.Equal(t, move.Id, milk.ParentId)
+	assert.Equal(t, []string{"dairy", "home"}, milk.Tags)
+	assert.Equal(t, "2020-05-01T09:30:00Z", milk.DueAt.Format(time.RFC3339))
+	subtasks, _ := dst.subtasks(ctx, move.Id)
+	assert.Equal(t, []string{"Pack", "Buy milk"}, itemDescriptions(subtasks))
+
+	// A second copy of the same data has the same checksum, even though its IDs differ.
+	other := initMemoryDB()
+	defer other.close()
+	state, _ = openTransferState(path + "2")
+	again, err := copyData(databaseSource{dst}, other, state)
+	state.close()
+	assert.NoError(t, err)
+	assert.Equal(t, report, again)
+}
+
+func Test_copyData_not_empty(t *testing.T) {
+	src := initTransferSource(t)
+	defer src.close()
+	dst := initMemoryDB()
+	defer dst.close()
+	path, remove := initTransferState(t)
+	defer remove()
+
+	dst.createItem(ctx, Item{Description: "Already here"})
+	state, _ := openTransferState(path)
+	defer state.close()
+	_, err := copyData(databaseSource{src}, dst, state)
+	assert.EqualError(t, err, "The target database is not empty, transfer into an empty database or resume with the state of an earlier transfer")
+}
+
+// interruptedSource stops with an error after giving a number of items.
+type interruptedSource struct {
+	transferSource
+	items int
+}
+
+func (s interruptedSource) each(onList func(List) error, onItem func(Item) error) error {
+	n := 0
+	return s.transferSource.each(onList, func(item Item) error {
+		if n == s.items {
+			return errors.New("interrupted")
+		}
+		n++
+		return onItem(item)
+	})
+}
+
+func Test_copyData_resume(t *testing.T) {
+	src := initTransferSource(t)
+	defer src.close()
+	dst := initMemoryDB()
+	defer dst.close()
+	path, remove := initTransferState(t)
+	defer remove()
+
+	state, _ := openTransferState(path)
+	_, err := copyData(interruptedSource{databaseSource{src}, 3}, dst, state)
+	state.close()
+	assert.EqualError(t, err, "interrupted")
+	items, _ := dst.allItems(ctx)
+	assert.Len(t, items, 2)
+
+	// The last line was cut short, so the item it recorded is copied again.
+	data, _ := ioutil.ReadFile(path)
+	assert.NoError(t, ioutil.WriteFile(path, data[:len(data)-2], 0600))
+	dst.deleteItem(ctx, items[1].Id, 0)
+
+	state, err = openTransferState(path)
+	assert.NoError(t, err)
+	report, err := copyData(databaseSource{src}, dst, state)
+	state.close()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, report.Items)
+	data, _ = ioutil.ReadFile(path)
+	assert.Equal(t, 6, strings.Count(string(data), "\n"), "every copy is recorded once")
+}
+
+func Test_exportData(t *testing.T) {
+	src := initTransferSource(t)
+	defer src.close()
+	path, remove := initTransferState(t)
+	defer remove()
+
+	var b bytes.Buffer
+	lists, items, err := exportData(src, &b)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, lists)
+	assert.Equal(t, 4, items)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Len(t, lines, 7)
+	assert.Contains(t, lines[0], `"type":"header","version":1`)
+	assert.Equal(t, `{"type":"list","list":{"Id":"1","Name":"Shopping"}}`, lines[1])
+
+	dst := initMemoryDB()
+	defer dst.close()
+	state, _ := openTransferState(path)
+	imported, err := copyData(fileSource{&b}, dst, state)
+	state.close()
+	assert.NoError(t, err)
+
+	other := initMemoryDB()
+	defer other.close()
+	state, _ = openTransferState(path + "2")
+	copied, _ := copyData(databaseSource{src}, other, state)
+	state.close()
+	assert.Equal(t, copied, imported)
+}
+
+func Test_fileSource_invalid(t *testing.T) {
+	each := func(data string) error {
+		return fileSource{strings.NewReader(data)}.each(func(List) error {
+			return nil
+		}, func(Item) error {
+			return nil
+		})
+	}
+	assert.EqualError(t, each(""), "Not an export of version 1 or older")
+	assert.EqualError(t, each(`{"type":"header","version":2}`), "Not an export of version 1 or older")
+	assert.EqualError(t, each("{\"type\":\"header\",\"version\":1}\n{\"type\":\"thing\"}"), "Invalid record on line 2")
+	assert.EqualError(t, each("{\"type\":\"header\",\"version\":1}\nnot json"), "Invalid record on line 2: invalid character 'o' in literal null (expecting 'u')")
+}
+
+func Test_openDatabase(t *testing.T) {
+	_, err := openDatabase("todo.db", false, nil)
+	assert.EqualError(t, err, `Invalid database "todo.db", expected <type>:<connection string>`)
+	_, err = openDatabase("sqlite3:", false, nil)
+	assert.EqualError(t, err, `Invalid database "sqlite3:", expected <type>:<connection string>`)
+	_, err = openDatabase("oracle:todo", false, nil)
+	assert.EqualError(t, err, `Unknown database "oracle"`)
+	db, err := openDatabase("memory:", false, nil)
+	assert.NoError(t, err)
+	db.close()
+}
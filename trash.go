@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// defaultTrashRetention is how long deleted items are kept in the trash before they are purged.
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// maxPurgeInterval is the longest the purger waits between purges.
+const maxPurgeInterval = time.Hour
+
+// purger permanently removes items from the trash once they have been in it for longer than the retention period.
+type purger struct {
+	db        Database
+	retention time.Duration
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// startPurger purges the trash straight away, then again every retention period or every maxPurgeInterval,
+// whichever is shorter, until it is stopped.
+func startPurger(db Database, retention time.Duration) *purger {
+	p := &purger{db: db, retention: retention, stop: make(chan struct{}), done: make(chan struct{})}
+	interval := retention
+	if interval > maxPurgeInterval {
+		interval = maxPurgeInterval
+	}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.purge()
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return p
+}
+
+func (p *purger) purge() {
+	purged, err := p.db.purgeTrash(context.Background(), time.Now().Add(-p.retention))
+	if err != nil {
+		log.Printf("Unable to purge the trash: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d items from the trash", purged)
+	}
+}
+
+// close stops the purger, waiting for a purge in progress to finish.
+func (p *purger) close() {
+	close(p.stop)
+	<-p.done
+}
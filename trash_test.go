@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_purger(t *testing.T) {
+	db := initMemoryDB()
+	defer db.close()
+	item, _ := db.createItem(ctx, Item{Description: "Buy milk"})
+	db.deleteItem(ctx, item.Id, 0)
+
+	p := startPurger(db, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		trash, _ := db.trashedItems(ctx)
+		return len(trash) == 0
+	}, time.Second, 5*time.Millisecond)
+	p.close()
+
+	item, _ = db.createItem(ctx, Item{Description: "Buy bread"})
+	db.deleteItem(ctx, item.Id, 0)
+	time.Sleep(20 * time.Millisecond)
+	trash, _ := db.trashedItems(ctx)
+	assert.Len(t, trash, 1, "nothing is purged once the purger has stopped")
+}